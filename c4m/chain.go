@@ -121,3 +121,111 @@ func ResolvePatchChain(sections []*PatchSection, stopAt int) *Manifest {
 
 	return m
 }
+
+// ChainStates resolves a patch chain one section at a time and returns the
+// manifest state after each section. states[i] is the result of applying
+// sections[0] through sections[i].
+func ChainStates(sections []*PatchSection) []*Manifest {
+	states := make([]*Manifest, 0, len(sections))
+	var m *Manifest
+	for i, sec := range sections {
+		if i == 0 {
+			m = &Manifest{Version: "1.0", Entries: sec.Entries}
+		} else {
+			m = ApplyPatch(m, &Manifest{Version: "1.0", Entries: sec.Entries})
+		}
+		states = append(states, m)
+	}
+	return states
+}
+
+// CommonAncestor finds the most recent state shared by two patch chains.
+// States are matched by C4 ID, so the chains only need to share history,
+// not bytes. It returns the index of that state in each chain (as in
+// ChainStates), or ErrNoCommonAncestor if the chains never agree.
+func CommonAncestor(a, b []*PatchSection) (ai, bi int, err error) {
+	return commonAncestor(ChainStates(a), ChainStates(b))
+}
+
+// commonAncestor is CommonAncestor over already-resolved chain states.
+func commonAncestor(a, b []*Manifest) (ai, bi int, err error) {
+	aIDs := chainIDs(a)
+	bIDs := chainIDs(b)
+
+	// Last occurrence wins so a state revisited later in a is preferred.
+	aIndex := make(map[c4.ID]int, len(aIDs))
+	for i, id := range aIDs {
+		aIndex[id] = i
+	}
+	for j := len(bIDs) - 1; j >= 0; j-- {
+		if i, ok := aIndex[bIDs[j]]; ok {
+			return i, j, nil
+		}
+	}
+	return -1, -1, ErrNoCommonAncestor
+}
+
+// ChainMerge is the result of merging two patch chains.
+type ChainMerge struct {
+	BaseID    c4.ID           // C4 ID of the common ancestor state
+	LocalID   c4.ID           // C4 ID of the local chain head
+	RemoteID  c4.ID           // C4 ID of the remote chain head
+	Merged    *Manifest       // Merged state, including .conflict entries
+	Conflicts []Conflict      // Paths both sides changed incompatibly
+	Sections  []*PatchSection // Local chain followed by the merge patch
+}
+
+// MergeChains performs a three-way merge of two patch chains that share
+// history. The common ancestor is discovered from the chain state IDs, the
+// heads of both chains are merged against it with Merge, and the result is
+// returned as the local chain extended by one patch that takes the local
+// head to the merged state. When the merged state equals the local head
+// no patch is appended. Options are passed through to Merge.
+func MergeChains(local, remote []*PatchSection, opts ...MergeOption) (*ChainMerge, error) {
+	if len(local) == 0 || len(remote) == 0 {
+		return nil, ErrNoCommonAncestor
+	}
+	localStates := ChainStates(local)
+	remoteStates := ChainStates(remote)
+	li, _, err := commonAncestor(localStates, remoteStates)
+	if err != nil {
+		return nil, err
+	}
+
+	base := localStates[li]
+	localHead := localStates[len(localStates)-1]
+	remoteHead := remoteStates[len(remoteStates)-1]
+
+	merged, conflicts, err := Merge(base, localHead, remoteHead, opts...)
+	if err != nil {
+		return nil, err
+	}
+	merged.SortEntries()
+
+	result := &ChainMerge{
+		BaseID:    base.ComputeC4ID(),
+		Merged:    merged,
+		Conflicts: conflicts,
+		Sections:  append([]*PatchSection(nil), local...),
+	}
+
+	diff := PatchDiff(localHead, merged)
+	result.LocalID = diff.OldID
+	result.RemoteID = remoteHead.ComputeC4ID()
+	if !diff.IsEmpty() {
+		result.Sections = append(result.Sections, &PatchSection{
+			BaseID:  diff.OldID,
+			Entries: diff.Patch.Entries,
+		})
+	}
+	return result, nil
+}
+
+// chainIDs returns the C4 ID of each chain state.
+func chainIDs(states []*Manifest) []c4.ID {
+	ids := make([]c4.ID, len(states))
+	for i, m := range states {
+		ids[i] = m.ComputeC4ID()
+	}
+	return ids
+}
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/Avalanche-io/c4"
 )
@@ -137,3 +138,106 @@ func TestResolvePatchChainEmpty(t *testing.T) {
 		t.Fatalf("expected 0 entries for empty chain, got %d", len(m.Entries))
 	}
 }
+
+func TestCommonAncestor(t *testing.T) {
+	aID := c4.Identify(strings.NewReader("hello"))
+	bID := c4.Identify(strings.NewReader("world"))
+	cID := c4.Identify(strings.NewReader("other"))
+
+	shared := []*PatchSection{
+		{Entries: []*Entry{{Name: "a.txt", Size: 5, C4ID: aID}}},
+		{Entries: []*Entry{{Name: "b.txt", Size: 5, C4ID: bID}}},
+	}
+	local := append(append([]*PatchSection(nil), shared...),
+		&PatchSection{Entries: []*Entry{{Name: "c.txt", Size: 5, C4ID: cID}}})
+
+	li, ri, err := CommonAncestor(local, shared)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if li != 1 || ri != 1 {
+		t.Fatalf("expected ancestor at (1, 1), got (%d, %d)", li, ri)
+	}
+
+	unrelated := []*PatchSection{
+		{Entries: []*Entry{{Name: "z.txt", Size: 5, C4ID: cID}}},
+	}
+	if _, _, err := CommonAncestor(local, unrelated); err != ErrNoCommonAncestor {
+		t.Fatalf("expected ErrNoCommonAncestor, got %v", err)
+	}
+}
+
+func TestMergeChains(t *testing.T) {
+	ts := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	entry := func(name, content string, ts time.Time) *Entry {
+		return &Entry{Mode: 0644, Name: name, Size: int64(len(content)), Timestamp: ts, C4ID: testID(content)}
+	}
+
+	base := makeTestManifest(entry("shared.txt", "v1", ts))
+	local := makeTestManifest(entry("local.txt", "l", ts), entry("shared.txt", "local edit", ts.Add(time.Hour)))
+	remote := makeTestManifest(entry("remote.txt", "r", ts), entry("shared.txt", "remote edit", ts.Add(2*time.Hour)))
+
+	chain := func(head *Manifest) []*PatchSection {
+		diff := PatchDiff(base, head)
+		return []*PatchSection{
+			{Entries: base.Copy().Entries},
+			{BaseID: diff.OldID, Entries: diff.Patch.Entries},
+		}
+	}
+
+	cm, err := MergeChains(chain(local), chain(remote))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cm.BaseID != base.ComputeC4ID() {
+		t.Fatalf("base ID mismatch: got %s, want %s", cm.BaseID, base.ComputeC4ID())
+	}
+	if cm.LocalID != local.ComputeC4ID() {
+		t.Fatalf("local ID mismatch: got %s, want %s", cm.LocalID, local.ComputeC4ID())
+	}
+	if len(cm.Conflicts) != 1 || cm.Conflicts[0].Path != "shared.txt" {
+		t.Fatalf("expected one conflict on shared.txt, got %+v", cm.Conflicts)
+	}
+
+	names := entryNames(cm.Merged)
+	for _, n := range []string{"local.txt", "remote.txt", "shared.txt", "shared.txt.conflict"} {
+		if !names[n] {
+			t.Errorf("merged state missing %s", n)
+		}
+	}
+
+	// The merged chain is the local chain plus one merge patch, and
+	// resolving it reproduces the merged state.
+	if len(cm.Sections) != 3 {
+		t.Fatalf("expected 3 sections, got %d", len(cm.Sections))
+	}
+	if cm.Sections[2].BaseID != cm.LocalID {
+		t.Fatalf("merge patch should apply to local head")
+	}
+	resolved := ResolvePatchChain(cm.Sections, 0)
+	if resolved.ComputeC4ID() != cm.Merged.ComputeC4ID() {
+		t.Fatalf("resolved chain does not match merged state")
+	}
+}
+
+func TestMergeChainsFastForward(t *testing.T) {
+	aID := c4.Identify(strings.NewReader("hello"))
+	bID := c4.Identify(strings.NewReader("world"))
+
+	base := []*PatchSection{
+		{Entries: []*Entry{{Mode: 0644, Name: "a.txt", Size: 5, C4ID: aID}}},
+	}
+	ahead := append(append([]*PatchSection(nil), base...),
+		&PatchSection{Entries: []*Entry{{Mode: 0644, Name: "b.txt", Size: 5, C4ID: bID}}})
+
+	cm, err := MergeChains(base, ahead)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cm.Conflicts) != 0 {
+		t.Fatalf("expected no conflicts, got %d", len(cm.Conflicts))
+	}
+	if cm.Merged.ComputeC4ID() != cm.RemoteID {
+		t.Fatalf("fast-forward merge should equal the remote head")
+	}
+}
@@ -22,4 +22,7 @@ var (
 
 	// ErrEmptyPatch indicates a patch section contains no entries.
 	ErrEmptyPatch = errors.New("c4m: empty patch section")
+
+	// ErrNoCommonAncestor indicates two patch chains share no state.
+	ErrNoCommonAncestor = errors.New("c4m: no common ancestor")
 )
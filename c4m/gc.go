@@ -0,0 +1,266 @@
+package c4m
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/Avalanche-io/c4"
+	"github.com/Avalanche-io/c4/store"
+)
+
+// Marker collects the set of C4 IDs reachable from a set of root manifests.
+// Directory entries whose IDs are stored as c4m are loaded and followed, as
+// the Resolver does, and sequence entries contribute every ID in their ID
+// list. Each stored manifest is loaded at most once.
+type Marker struct {
+	src store.Store
+
+	// live maps each marked ID to whether it must be present: file and
+	// root IDs must, while directory and manifest IDs are often only
+	// computed, never stored.
+	live     map[c4.ID]bool
+	expanded map[c4.ID]bool
+}
+
+// NewMarker creates a Marker that loads directory manifests and ID lists
+// from src.
+func NewMarker(src store.Store) *Marker {
+	return &Marker{
+		src:      src,
+		live:     make(map[c4.ID]bool),
+		expanded: make(map[c4.ID]bool),
+	}
+}
+
+// MarkID marks a root stored in the store. If its content is a c4m
+// manifest, everything reachable from it is marked as well.
+func (mk *Marker) MarkID(id c4.ID) error {
+	if id.IsNil() {
+		return fmt.Errorf("nil root ID")
+	}
+	if !mk.src.Has(id) {
+		return fmt.Errorf("root %s not found in store", id)
+	}
+	mk.mark(id, true)
+	return mk.expand(id)
+}
+
+// MarkManifest marks m's own ID and everything reachable from its entries.
+func (mk *Marker) MarkManifest(m *Manifest) error {
+	mk.mark(m.ComputeC4ID(), false)
+	for _, e := range m.Entries {
+		if e.C4ID.IsNil() {
+			continue
+		}
+		mk.mark(e.C4ID, !e.IsDir() && !e.IsSequence && !e.IsSymlink())
+		switch {
+		case e.IsSequence:
+			if err := mk.markSequence(m, e.C4ID); err != nil {
+				return err
+			}
+		case e.IsDir():
+			if !mk.src.Has(e.C4ID) {
+				// Not stored: the children are listed inline.
+				continue
+			}
+			if err := mk.expand(e.C4ID); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Mark marks a single ID without following it.
+func (mk *Marker) Mark(id c4.ID) {
+	mk.mark(id, true)
+}
+
+// Live reports whether id has been marked.
+func (mk *Marker) Live(id c4.ID) bool {
+	_, ok := mk.live[id]
+	return ok
+}
+
+// Len returns the number of distinct IDs marked.
+func (mk *Marker) Len() int {
+	return len(mk.live)
+}
+
+// IDs returns the marked IDs that belong in a store holding the roots:
+// every ID that must be present, plus the directory manifests and ID
+// lists that were loaded from the store.
+func (mk *Marker) IDs() []c4.ID {
+	ids := make([]c4.ID, 0, len(mk.live))
+	for id, req := range mk.live {
+		if req || mk.expanded[id] {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// expand loads id and, if it is a manifest, marks its contents.
+func (mk *Marker) expand(id c4.ID) error {
+	if mk.expanded[id] {
+		return nil
+	}
+	mk.expanded[id] = true
+	data, err := mk.read(id)
+	if err != nil {
+		return err
+	}
+	m, err := Unmarshal(data)
+	if err != nil {
+		// Plain content, not a manifest.
+		return nil
+	}
+	return mk.MarkManifest(m)
+}
+
+// markSequence marks the IDs in a sequence's ID list, taken from the
+// manifest's inline range data or, failing that, from the store.
+func (mk *Marker) markSequence(m *Manifest, listID c4.ID) error {
+	data, ok := m.RangeData[listID]
+	if !ok {
+		if mk.expanded[listID] || !mk.src.Has(listID) {
+			return nil
+		}
+		mk.expanded[listID] = true
+		b, err := mk.read(listID)
+		if err != nil {
+			return err
+		}
+		data = string(b)
+	}
+	list, err := parseIDListFromString(data)
+	if err != nil {
+		return fmt.Errorf("ID list %s: %w", listID, err)
+	}
+	for _, id := range list.ids {
+		mk.mark(id, true)
+	}
+	return nil
+}
+
+func (mk *Marker) mark(id c4.ID, required bool) {
+	mk.live[id] = mk.live[id] || required
+}
+
+// required counts the marked IDs that must be present in the store.
+func (mk *Marker) required() int {
+	n := 0
+	for _, req := range mk.live {
+		if req {
+			n++
+		}
+	}
+	return n
+}
+
+func (mk *Marker) read(id c4.ID) ([]byte, error) {
+	rc, err := mk.src.Open(id)
+	if err != nil {
+		return nil, fmt.Errorf("loading %s: %w", id, err)
+	}
+	defer rc.Close()
+	var buf bytes.Buffer
+	if _, err := io.Copy(&buf, rc); err != nil {
+		return nil, fmt.Errorf("reading %s: %w", id, err)
+	}
+	return buf.Bytes(), nil
+}
+
+// GCOptions configures CollectGarbage.
+type GCOptions struct {
+	// DryRun reports what would be removed without removing anything.
+	DryRun bool
+
+	// GracePeriod protects unreachable objects modified more recently than
+	// this, such as content written while the roots were being marked.
+	// When it is non-zero, objects whose store does not report a
+	// modification time are kept as well.
+	GracePeriod time.Duration
+
+	// OnSweep, if set, is called for each object removed (or, in a dry
+	// run, each object that would be removed).
+	OnSweep func(store.Object)
+}
+
+// GCReport summarizes a garbage collection.
+type GCReport struct {
+	Live       int   // distinct reachable IDs
+	Missing    int   // reachable file IDs not present in the store
+	Chunks     int   // chunks kept because reachable objects are built from them
+	Scanned    int   // objects listed
+	Swept      int   // unreachable objects removed
+	SweptBytes int64 // bytes removed
+	Kept       int   // unreachable objects protected by the grace period
+	KeptBytes  int64
+	DryRun     bool
+}
+
+// CollectGarbage removes every object in s that mk has not marked. The
+// store must implement store.Lister and support Remove. Reachable objects
+// stored as chunk recipes keep their chunks; finding them means opening
+// every reachable object.
+func CollectGarbage(s store.Store, mk *Marker, opts GCOptions) (*GCReport, error) {
+	rep := &GCReport{Live: mk.Len(), DryRun: opts.DryRun}
+	objs, err := store.ListAll(s)
+	if err == store.ErrNotImplemented {
+		return nil, fmt.Errorf("store cannot list its contents")
+	}
+	if err != nil {
+		return rep, err
+	}
+	rep.Scanned = len(objs)
+
+	found := 0
+	chunks := make(map[c4.ID]bool)
+	for _, o := range objs {
+		req, ok := mk.live[o.ID]
+		if !ok {
+			continue
+		}
+		if req {
+			found++
+		}
+		list, err := store.Chunks(s, o.ID)
+		if err != nil {
+			return rep, fmt.Errorf("reading %s: %w", o.ID, err)
+		}
+		for _, ch := range list {
+			chunks[ch.ID] = true
+		}
+	}
+	rep.Missing = mk.required() - found
+
+	now := time.Now()
+	for _, o := range objs {
+		if _, ok := mk.live[o.ID]; ok {
+			continue
+		}
+		if chunks[o.ID] {
+			rep.Chunks++
+			continue
+		}
+		if opts.GracePeriod > 0 && (o.ModTime.IsZero() || now.Sub(o.ModTime) < opts.GracePeriod) {
+			rep.Kept++
+			rep.KeptBytes += o.Size
+			continue
+		}
+		if !opts.DryRun {
+			if err := s.Remove(o.ID); err != nil {
+				return rep, fmt.Errorf("removing %s: %w", o.ID, err)
+			}
+		}
+		rep.Swept++
+		rep.SweptBytes += o.Size
+		if opts.OnSweep != nil {
+			opts.OnSweep(o)
+		}
+	}
+	return rep, nil
+}
@@ -0,0 +1,225 @@
+package c4m
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Avalanche-io/c4"
+	"github.com/Avalanche-io/c4/store"
+)
+
+func putString(t *testing.T, s store.Store, data string) c4.ID {
+	t.Helper()
+	id, err := s.Put(strings.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+// gcFixture stores a root manifest with a stored sub-directory manifest,
+// a sequence whose ID list lives in the store, and some garbage.
+func gcFixture(t *testing.T, s store.Store) (root *Manifest, live, garbage []c4.ID) {
+	t0 := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	file := func(name, data string) *Entry {
+		return &Entry{Name: name, Mode: 0644, Size: int64(len(data)), Timestamp: t0, C4ID: putString(t, s, data)}
+	}
+
+	// Sub-directory manifest, stored as content.
+	sub := NewManifest()
+	nested := file("nested.txt", "nested content")
+	sub.AddEntry(nested)
+	subID := putString(t, s, sub.Canonical())
+
+	// Sequence ID list, stored as content.
+	f1, f2 := putString(t, s, "frame 1"), putString(t, s, "frame 2")
+	list := newIDList()
+	list.Add(f1)
+	list.Add(f2)
+	listID := putString(t, s, list.Canonical())
+
+	top := file("top.txt", "top content")
+	root = NewManifest()
+	root.AddEntry(top)
+	root.AddEntry(&Entry{Name: "sub/", Mode: os.ModeDir | 0755, Size: nested.Size, Timestamp: t0, C4ID: subID})
+	root.AddEntry(&Entry{Name: "shot.[0001-0002].exr", Mode: 0644, Size: 14, Timestamp: t0, C4ID: listID, IsSequence: true})
+
+	live = []c4.ID{top.C4ID, subID, nested.C4ID, listID, f1, f2}
+	garbage = []c4.ID{putString(t, s, "old version"), putString(t, s, "scratch")}
+	return root, live, garbage
+}
+
+func TestCollectGarbage(t *testing.T) {
+	s := store.NewRAM()
+	root, live, garbage := gcFixture(t, s)
+
+	mk := NewMarker(s)
+	if err := mk.MarkManifest(root); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range live {
+		if !mk.Live(id) {
+			t.Errorf("%s should be reachable", id)
+		}
+	}
+
+	// Dry run removes nothing.
+	var swept []c4.ID
+	rep, err := CollectGarbage(s, mk, GCOptions{DryRun: true, OnSweep: func(o store.Object) { swept = append(swept, o.ID) }})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rep.Swept != len(garbage) || len(swept) != len(garbage) || rep.Scanned != len(live)+len(garbage) {
+		t.Fatalf("dry run report %+v", rep)
+	}
+	for _, id := range garbage {
+		if !s.Has(id) {
+			t.Fatalf("dry run removed %s", id)
+		}
+	}
+
+	rep, err = CollectGarbage(s, mk, GCOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rep.Swept != len(garbage) || rep.SweptBytes != int64(len("old version")+len("scratch")) || rep.Missing != 0 {
+		t.Fatalf("report %+v", rep)
+	}
+	for _, id := range garbage {
+		if s.Has(id) {
+			t.Errorf("garbage %s not removed", id)
+		}
+	}
+	for _, id := range live {
+		if !s.Has(id) {
+			t.Errorf("live %s removed", id)
+		}
+	}
+}
+
+func TestCollectGarbageMarkID(t *testing.T) {
+	s := store.NewRAM()
+	root, live, _ := gcFixture(t, s)
+	rootID := putString(t, s, root.Canonical())
+
+	mk := NewMarker(s)
+	if err := mk.MarkID(rootID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := CollectGarbage(s, mk, GCOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range append(live, rootID) {
+		if !s.Has(id) {
+			t.Errorf("live %s removed", id)
+		}
+	}
+	objs, _ := store.ListAll(s)
+	if len(objs) != len(live)+1 {
+		t.Errorf("%d objects left, want %d", len(objs), len(live)+1)
+	}
+
+	if err := NewMarker(s).MarkID(c4.Identify(strings.NewReader("absent"))); err == nil {
+		t.Error("expected error for root not in store")
+	}
+}
+
+func TestCollectGarbageGracePeriod(t *testing.T) {
+	dir := t.TempDir()
+	s := store.Folder(dir)
+	oldID := putString(t, s, "old garbage")
+	newID := putString(t, s, "new garbage")
+	old := time.Now().Add(-48 * time.Hour)
+	os.Chtimes(filepath.Join(dir, oldID.String()), old, old)
+
+	// A missing file counts as missing, not as live content in the store.
+	m := NewManifest()
+	m.AddEntry(&Entry{Name: "gone.txt", Mode: 0644, Size: 1, Timestamp: NullTimestamp(), C4ID: c4.Identify(strings.NewReader("x"))})
+	mk := NewMarker(s)
+	if err := mk.MarkManifest(m); err != nil {
+		t.Fatal(err)
+	}
+
+	rep, err := CollectGarbage(s, mk, GCOptions{GracePeriod: 24 * time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rep.Swept != 1 || rep.Kept != 1 || rep.Missing != 1 {
+		t.Fatalf("report %+v", rep)
+	}
+	if s.Has(oldID) || !s.Has(newID) {
+		t.Error("grace period not honored")
+	}
+}
+
+func TestCollectGarbageNeedsLister(t *testing.T) {
+	s := struct{ store.Store }{store.NewRAM()}
+	if _, err := CollectGarbage(s, NewMarker(s), GCOptions{}); err == nil {
+		t.Fatal("expected error for store without Lister")
+	}
+}
+
+func TestMarkerIDs(t *testing.T) {
+	s := store.NewRAM()
+	root, live, garbage := gcFixture(t, s)
+
+	mk := NewMarker(s)
+	if err := mk.MarkManifest(root); err != nil {
+		t.Fatal(err)
+	}
+	ids := make(map[c4.ID]bool)
+	for _, id := range mk.IDs() {
+		ids[id] = true
+	}
+	for _, id := range live {
+		if !ids[id] {
+			t.Errorf("IDs missing reachable %s", id)
+		}
+	}
+	for _, id := range garbage {
+		if ids[id] {
+			t.Errorf("IDs includes garbage %s", id)
+		}
+	}
+	// The root is only computed, never stored, so it is not copied.
+	if ids[root.ComputeC4ID()] || len(ids) != len(live) {
+		t.Errorf("IDs = %d entries, want %d", len(ids), len(live))
+	}
+}
+
+func TestCollectGarbageKeepsChunks(t *testing.T) {
+	ram := store.NewRAM()
+	s, err := store.NewChunkingSize(ram, 1<<10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := strings.Repeat("frame data that varies: ", 2000)
+	for i := 0; i < 100; i++ {
+		data += string(rune('a' + i%26))
+	}
+	id := putString(t, s, data)
+	putString(t, s, "garbage")
+
+	mk := NewMarker(s)
+	mk.Mark(id)
+	rep, err := CollectGarbage(s, mk, GCOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rep.Chunks == 0 || rep.Swept != 1 {
+		t.Errorf("report %+v: want chunks kept and only the garbage swept", rep)
+	}
+	rc, err := s.Open(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+	got, err := io.ReadAll(rc)
+	if err != nil || string(got) != data {
+		t.Errorf("chunked object damaged by gc: %v", err)
+	}
+}
@@ -0,0 +1,175 @@
+//go:build ignore
+// +build ignore
+
+// gen_normtables generates normtables.go from the Unicode Character
+// Database. Usage:
+//
+//	go run gen_normtables.go -ucd /path/to/ucd -o normtables.go
+//
+// The directory must contain UnicodeData.txt and CompositionExclusions.txt.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"go/format"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+func main() {
+	ucd := flag.String("ucd", ".", "directory containing the UCD files")
+	version := flag.String("version", "", "Unicode version recorded in the output")
+	output := flag.String("o", "", "output file (default stdout)")
+	flag.Parse()
+
+	var buf bytes.Buffer
+
+	type decomp struct{ c, a, b rune }
+	var decomps []decomp
+	classes := make(map[rune]uint8)
+
+	eachLine(filepath.Join(*ucd, "UnicodeData.txt"), func(fields []string) {
+		if len(fields) < 6 {
+			return
+		}
+		c := parseRune(fields[0])
+		if n, _ := strconv.Atoi(fields[3]); n != 0 {
+			classes[c] = uint8(n)
+		}
+		d := fields[5]
+		if d == "" || strings.HasPrefix(d, "<") {
+			return // no mapping, or a compatibility mapping
+		}
+		parts := strings.Fields(d)
+		e := decomp{c: c, a: parseRune(parts[0])}
+		if len(parts) > 1 {
+			e.b = parseRune(parts[1])
+		}
+		decomps = append(decomps, e)
+	})
+
+	excluded := make(map[rune]bool)
+	eachLine(filepath.Join(*ucd, "CompositionExclusions.txt"), func(fields []string) {
+		excluded[parseRune(fields[0])] = true
+	})
+
+	fmt.Fprintln(&buf, "// Code generated by gen_normtables.go. DO NOT EDIT.")
+	fmt.Fprintln(&buf)
+	fmt.Fprintln(&buf, "package c4m")
+	fmt.Fprintln(&buf)
+	if *version != "" {
+		fmt.Fprintf(&buf, "// normTablesVersion is the Unicode version the tables were built from.\n")
+		fmt.Fprintf(&buf, "const normTablesVersion = %q\n\n", *version)
+	}
+
+	fmt.Fprintln(&buf, "// canonicalDecompositions lists every canonical decomposition as")
+	fmt.Fprintln(&buf, "// {char, first, second}; second is 0 for singleton decompositions.")
+	fmt.Fprintln(&buf, "var canonicalDecompositions = [...][3]rune{")
+	for _, d := range decomps {
+		fmt.Fprintf(&buf, "\t{0x%04X, 0x%04X, 0x%04X},\n", d.c, d.a, d.b)
+	}
+	fmt.Fprintln(&buf, "}")
+	fmt.Fprintln(&buf)
+
+	// Full composition exclusions: the explicit list, singletons and
+	// non-starter decompositions.
+	var excl []rune
+	for _, d := range decomps {
+		if excluded[d.c] || d.b == 0 || classes[d.c] != 0 || classes[d.a] != 0 {
+			excl = append(excl, d.c)
+		}
+	}
+	fmt.Fprintln(&buf, "// compositionExclusions lists characters with a canonical decomposition")
+	fmt.Fprintln(&buf, "// that canonical composition must not produce.")
+	fmt.Fprintln(&buf, "var compositionExclusions = [...]rune{")
+	for i, c := range excl {
+		if i%8 == 0 {
+			fmt.Fprint(&buf, "\t")
+		}
+		fmt.Fprintf(&buf, "0x%04X,", c)
+		if i%8 == 7 || i == len(excl)-1 {
+			fmt.Fprintln(&buf)
+		} else {
+			fmt.Fprint(&buf, " ")
+		}
+	}
+	fmt.Fprintln(&buf, "}")
+	fmt.Fprintln(&buf)
+
+	var runes []rune
+	for r := range classes {
+		runes = append(runes, r)
+	}
+	sort.Slice(runes, func(i, j int) bool { return runes[i] < runes[j] })
+	fmt.Fprintln(&buf, "// combiningClassRanges lists runs of consecutive characters sharing a")
+	fmt.Fprintln(&buf, "// non-zero canonical combining class, as {first, last, class}.")
+	fmt.Fprintln(&buf, "var combiningClassRanges = [...]struct {")
+	fmt.Fprintln(&buf, "\tlo, hi rune")
+	fmt.Fprintln(&buf, "\tclass  uint8")
+	fmt.Fprintln(&buf, "}{")
+	for i := 0; i < len(runes); {
+		j := i
+		for j+1 < len(runes) && runes[j+1] == runes[j]+1 && classes[runes[j+1]] == classes[runes[i]] {
+			j++
+		}
+		fmt.Fprintf(&buf, "\t{0x%04X, 0x%04X, %d},\n", runes[i], runes[j], classes[runes[i]])
+		i = j + 1
+	}
+	fmt.Fprintln(&buf, "}")
+
+	src, err := format.Source(buf.Bytes())
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *output == "" {
+		os.Stdout.Write(src)
+		return
+	}
+	if err := os.WriteFile(*output, src, 0644); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// eachLine calls fn with the ';'-separated fields of every non-comment
+// line in the named file.
+func eachLine(path string, fn func(fields []string)) {
+	f, err := os.Open(path)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line := sc.Text()
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		fields := strings.Split(line, ";")
+		for i := range fields {
+			fields[i] = strings.TrimSpace(fields[i])
+		}
+		fn(fields)
+	}
+	if err := sc.Err(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func parseRune(s string) rune {
+	n, err := strconv.ParseUint(s, 16, 32)
+	if err != nil {
+		log.Fatalf("bad code point %q", s)
+	}
+	return rune(n)
+}
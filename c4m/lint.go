@@ -0,0 +1,445 @@
+package c4m
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// LintTarget selects the platforms whose naming rules Lint enforces.
+type LintTarget uint8
+
+const (
+	// LintWindows checks reserved names and characters, trailing dots and
+	// spaces, case-insensitive collisions and MAX_PATH.
+	LintWindows LintTarget = 1 << iota
+	// LintMacOS checks case-insensitive and Unicode normalization
+	// collisions (APFS and HFS+ treat NFC and NFD spellings as one name).
+	LintMacOS
+
+	// LintAll checks every supported platform.
+	LintAll = LintWindows | LintMacOS
+)
+
+// ParseLintTarget parses a comma-separated list of platforms: "windows",
+// "macos" (or "darwin", "mac") and "all".
+func ParseLintTarget(s string) (LintTarget, error) {
+	var t LintTarget
+	for _, name := range strings.Split(s, ",") {
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case "windows", "win":
+			t |= LintWindows
+		case "macos", "mac", "darwin", "osx":
+			t |= LintMacOS
+		case "all":
+			t |= LintAll
+		case "":
+		default:
+			return 0, fmt.Errorf("unknown lint target %q", name)
+		}
+	}
+	if t == 0 {
+		return 0, fmt.Errorf("no lint target in %q", s)
+	}
+	return t, nil
+}
+
+// Default limits used by Lint when LintOptions leaves them zero.
+const (
+	DefaultMaxNameBytes = 255  // per path component, in UTF-8 bytes
+	DefaultMaxPathWin   = 260  // Windows MAX_PATH, in UTF-16 code units
+	DefaultMaxPathMac   = 1024 // macOS PATH_MAX, in UTF-8 bytes
+
+	// MinMaxNameBytes is the smallest name limit that leaves room for an
+	// extension and a uniqueness suffix in suggested renames.
+	MinMaxNameBytes = 16
+)
+
+// LintOptions configures Lint. The zero value checks all platforms with
+// the default limits.
+type LintOptions struct {
+	Targets LintTarget // Platforms to check; 0 means LintAll
+	MaxName int        // Component limit in UTF-8 bytes; 0 means 255, at least 16
+	MaxPath int        // Full path limit; 0 means the platform default
+}
+
+// LintKind identifies the kind of portability problem.
+type LintKind int
+
+const (
+	LintReservedName           LintKind = iota // Windows device name (CON, NUL, COM1, ...)
+	LintReservedChar                           // Character not allowed on Windows
+	LintTrailingDotSpace                       // Name ends in "." or " "
+	LintCaseCollision                          // Sibling differs only by case
+	LintNormalizationCollision                 // Sibling differs only by NFC/NFD form
+	LintNameTooLong                            // Component exceeds MaxName
+	LintPathTooLong                            // Full path exceeds MaxPath
+)
+
+func (k LintKind) String() string {
+	switch k {
+	case LintReservedName:
+		return "reserved-name"
+	case LintReservedChar:
+		return "reserved-char"
+	case LintTrailingDotSpace:
+		return "trailing-dot-space"
+	case LintCaseCollision:
+		return "case-collision"
+	case LintNormalizationCollision:
+		return "unicode-collision"
+	case LintNameTooLong:
+		return "name-too-long"
+	case LintPathTooLong:
+		return "path-too-long"
+	}
+	return "unknown"
+}
+
+// LintIssue describes one portability problem with an entry.
+type LintIssue struct {
+	Kind    LintKind
+	Path    string // Full path of the entry (directories end in "/")
+	Other   string // Colliding sibling path, for collisions
+	Message string
+	Rename  string // Suggested new name for the entry ("" if none)
+}
+
+// String formats the issue as "path: kind: message", with names escaped
+// by SafeName so control characters stay visible.
+func (li LintIssue) String() string {
+	s := SafeName(li.Path) + ": " + li.Kind.String() + ": " + li.Message
+	if li.Rename != "" {
+		s += " (rename to " + strconv.Quote(SafeName(li.Rename)) + ")"
+	}
+	return s
+}
+
+// windowsReserved lists device names Windows reserves with or without an
+// extension.
+var windowsReserved = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"CONIN$": true, "CONOUT$": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true,
+	"COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"COM¹": true, "COM²": true, "COM³": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true,
+	"LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+	"LPT¹": true, "LPT²": true, "LPT³": true,
+}
+
+// isWindowsReservedChar reports whether r may not appear in a Windows name.
+func isWindowsReservedChar(r rune) bool {
+	if r < 0x20 {
+		return true
+	}
+	return strings.ContainsRune(`<>:"\|?*`, r)
+}
+
+// isWindowsReservedName reports whether name is a reserved device name.
+// Windows ignores any extension and trailing spaces, so "con.txt" and
+// "NUL " are reserved too.
+func isWindowsReservedName(name string) bool {
+	stem := name
+	if i := strings.IndexByte(stem, '.'); i >= 0 {
+		stem = stem[:i]
+	}
+	return windowsReserved[strings.ToUpper(strings.TrimRight(stem, " "))]
+}
+
+// Lint checks every entry in m against the naming rules of the target
+// platforms. Each problem is reported once per entry and kind. Entries
+// that need renaming carry a suggested Rename that fixes all of their
+// problems and is unique among its siblings; ApplyLintRenames applies them.
+// Sequence entries are skipped because their names are patterns.
+func Lint(m *Manifest, opts LintOptions) []LintIssue {
+	l := &linter{m: m, opts: opts}
+	if l.opts.Targets == 0 {
+		l.opts.Targets = LintAll
+	}
+	if l.opts.MaxName <= 0 {
+		l.opts.MaxName = DefaultMaxNameBytes
+	} else if l.opts.MaxName < MinMaxNameBytes {
+		l.opts.MaxName = MinMaxNameBytes
+	}
+	l.lintDir("", m.Root(), false)
+	return l.issues
+}
+
+type linter struct {
+	m      *Manifest
+	opts   LintOptions
+	issues []LintIssue
+}
+
+func (l *linter) windows() bool { return l.opts.Targets&LintWindows != 0 }
+func (l *linter) macOS() bool   { return l.opts.Targets&LintMacOS != 0 }
+
+// lintDir checks a set of siblings under the directory path prefix.
+// pathReported suppresses path-length issues below a reported ancestor.
+func (l *linter) lintDir(prefix string, siblings []*Entry, pathReported bool) {
+	type sibling struct {
+		e      *Entry
+		name   string // bare name without the directory slash
+		fixed  string // suggested name
+		rename bool
+		issues []LintIssue
+	}
+	var sibs []*sibling
+	for _, e := range siblings {
+		if e.IsSequence {
+			continue
+		}
+		s := &sibling{e: e, name: strings.TrimSuffix(e.Name, "/")}
+		s.fixed, s.issues = l.lintName(prefix+e.Name, s.name)
+		s.rename = s.fixed != s.name
+		sibs = append(sibs, s)
+	}
+
+	// Names that need no fixing claim their collision key first, in
+	// manifest order; a later sibling with the same key collides.
+	taken := make(map[string]bool)
+	first := make(map[string]*sibling)
+	for _, s := range sibs {
+		if s.rename {
+			continue
+		}
+		key := l.foldKey(s.name)
+		f, ok := first[key]
+		if !ok {
+			first[key] = s
+			taken[key] = true
+			continue
+		}
+		kind, msg := LintCaseCollision, "differs only by case from "
+		if NFC(s.name) == NFC(f.name) {
+			kind, msg = LintNormalizationCollision, "differs only by Unicode normalization from "
+		}
+		s.issues = append(s.issues, LintIssue{
+			Kind:    kind,
+			Path:    prefix + s.e.Name,
+			Other:   prefix + f.e.Name,
+			Message: msg + strconv.Quote(SafeName(f.e.Name)),
+		})
+		s.rename = true
+	}
+
+	// Entries being renamed take the first free variant of their name.
+	for _, s := range sibs {
+		if s.rename {
+			s.fixed = l.uniqueName(s.fixed, taken)
+			taken[l.foldKey(s.fixed)] = true
+		}
+	}
+
+	for _, s := range sibs {
+		full := prefix + s.e.Name
+		rename := ""
+		if s.rename {
+			rename = s.fixed
+			if s.e.IsDir() {
+				rename += "/"
+			}
+		}
+		for _, is := range s.issues {
+			is.Rename = rename
+			l.issues = append(l.issues, is)
+		}
+
+		reported := pathReported
+		if !reported {
+			if msg := l.checkPathLength(full); msg != "" {
+				l.issues = append(l.issues, LintIssue{Kind: LintPathTooLong, Path: full, Message: msg})
+				reported = true
+			}
+		}
+		if s.e.IsDir() {
+			l.lintDir(full, l.m.Children(s.e), reported)
+		}
+	}
+}
+
+// lintName checks a single name and returns a name with its problems
+// fixed, along with one issue per problem found.
+func (l *linter) lintName(full, name string) (string, []LintIssue) {
+	var issues []LintIssue
+	add := func(kind LintKind, msg string) {
+		issues = append(issues, LintIssue{Kind: kind, Path: full, Message: msg})
+	}
+	fixed := name
+
+	if l.windows() {
+		var bad []string
+		fixed = strings.Map(func(r rune) rune {
+			if isWindowsReservedChar(r) {
+				if q := strconv.QuoteRune(r); !containsString(bad, q) {
+					bad = append(bad, q)
+				}
+				return '_'
+			}
+			return r
+		}, fixed)
+		if len(bad) > 0 {
+			add(LintReservedChar, "contains characters not allowed on Windows: "+strings.Join(bad, " "))
+		}
+		if strings.HasSuffix(name, ".") || strings.HasSuffix(name, " ") {
+			add(LintTrailingDotSpace, "ends in a dot or space, which Windows strips")
+			fixed = trimDotSpace(fixed)
+		}
+		if isWindowsReservedName(name) {
+			add(LintReservedName, "is a reserved device name on Windows")
+		}
+		if isWindowsReservedName(fixed) {
+			fixed = "_" + fixed
+		}
+	}
+
+	if n := len(name); n > l.opts.MaxName {
+		add(LintNameTooLong, fmt.Sprintf("name is %d bytes, limit is %d", n, l.opts.MaxName))
+	}
+	if len(fixed) > l.opts.MaxName {
+		stem, ext := splitExt(fixed)
+		fixed = l.fitName(stem, ext)
+	}
+	return fixed, issues
+}
+
+// foldKey returns the key under which two sibling names are the same name
+// on the target platforms.
+func (l *linter) foldKey(name string) string {
+	if l.macOS() {
+		name = NFC(name)
+	}
+	return strings.ToLower(name)
+}
+
+// uniqueName returns name, or the first of name_2, name_3, ... (suffix
+// before the extension) whose key is not taken.
+func (l *linter) uniqueName(name string, taken map[string]bool) string {
+	if !taken[l.foldKey(name)] {
+		return name
+	}
+	stem, ext := splitExt(name)
+	for n := 2; ; n++ {
+		candidate := l.fitName(stem+"_"+strconv.Itoa(n), ext)
+		if !taken[l.foldKey(candidate)] {
+			return candidate
+		}
+	}
+}
+
+// fitName joins stem and ext, truncating the stem at a rune boundary so
+// the result fits MaxName. A suffix added by uniqueName is kept unless it
+// leaves no room for the stem. An extension longer than half the limit is
+// treated as part of the stem.
+func (l *linter) fitName(stem, ext string) string {
+	max := l.opts.MaxName
+	if len(stem)+len(ext) <= max {
+		return stem + ext
+	}
+	if len(ext) > max/2 {
+		stem, ext = stem+ext, ""
+	}
+	suffix := ""
+	if i := strings.LastIndexByte(stem, '_'); i >= 0 && isDigits(stem[i+1:]) && len(stem[i:])+len(ext) < max {
+		stem, suffix = stem[:i], stem[i:]
+	}
+	keep := max - len(ext) - len(suffix)
+	if keep < 0 {
+		keep = 0
+	}
+	for keep > 0 && !utf8.RuneStart(stem[keep]) {
+		keep--
+	}
+	stem = stem[:keep]
+	if l.windows() {
+		stem = trimDotSpace(stem)
+	}
+	return stem + suffix + ext
+}
+
+// checkPathLength returns a message if full exceeds the path limit of a
+// target platform.
+func (l *linter) checkPathLength(full string) string {
+	p := strings.TrimSuffix(full, "/")
+	if l.windows() {
+		limit := l.opts.MaxPath
+		if limit <= 0 {
+			limit = DefaultMaxPathWin
+		}
+		if n := len(utf16.Encode([]rune(p))); n > limit {
+			return fmt.Sprintf("path is %d UTF-16 units, Windows limit is %d", n, limit)
+		}
+	}
+	if l.macOS() {
+		limit := l.opts.MaxPath
+		if limit <= 0 {
+			limit = DefaultMaxPathMac
+		}
+		if n := len(p); n > limit {
+			return fmt.Sprintf("path is %d bytes, macOS limit is %d", n, limit)
+		}
+	}
+	return ""
+}
+
+// ApplyLintRenames returns a copy of m with the suggested renames from
+// issues applied. Renaming a directory renames its whole subtree. Use
+// PatchDiff(m, renamed) to express the renames as a patch.
+func ApplyLintRenames(m *Manifest, issues []LintIssue) *Manifest {
+	cp := m.Copy()
+	byPath := EntryPaths(cp.Entries)
+	for _, is := range issues {
+		if is.Rename == "" {
+			continue
+		}
+		if e := byPath[is.Path]; e != nil {
+			e.Name = is.Rename
+		}
+	}
+	cp.SortEntries()
+	return cp
+}
+
+// splitExt splits name into stem and extension (including the dot). A
+// leading dot, as in ".profile", does not start an extension.
+func splitExt(name string) (string, string) {
+	i := strings.LastIndexByte(name, '.')
+	if i <= 0 {
+		return name, ""
+	}
+	return name[:i], name[i:]
+}
+
+// trimDotSpace strips trailing dots and spaces, returning "_" if nothing
+// is left.
+func trimDotSpace(s string) string {
+	s = strings.TrimRight(s, ". ")
+	if s == "" {
+		return "_"
+	}
+	return s
+}
+
+func isDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func containsString(list []string, s string) bool {
+	for _, x := range list {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
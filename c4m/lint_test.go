@@ -0,0 +1,167 @@
+package c4m
+
+import (
+	"strings"
+	"testing"
+)
+
+func lintManifest(names ...string) *Manifest {
+	m := NewManifest()
+	for _, n := range names {
+		depth := strings.Count(strings.TrimSuffix(n, "/"), "/")
+		name := n[strings.LastIndex(strings.TrimSuffix(n, "/"), "/")+1:]
+		m.AddEntry(&Entry{Name: name, Depth: depth, Size: -1, Timestamp: NullTimestamp()})
+	}
+	return m
+}
+
+func lintByPath(issues []LintIssue) map[string]LintIssue {
+	out := make(map[string]LintIssue)
+	for _, is := range issues {
+		out[is.Path+" "+is.Kind.String()] = is
+	}
+	return out
+}
+
+func TestLintWindows(t *testing.T) {
+	m := lintManifest("AUX", "com1.log", "a<b>.txt", "trailing. ", "ok.txt", "con.d/", "con.d/file")
+	issues := lintByPath(Lint(m, LintOptions{Targets: LintWindows}))
+
+	tests := []struct {
+		key, rename string
+	}{
+		{"AUX reserved-name", "_AUX"},
+		{"com1.log reserved-name", "_com1.log"},
+		{"a<b>.txt reserved-char", "a_b_.txt"},
+		{"trailing.  trailing-dot-space", "trailing"},
+		{"con.d/ reserved-name", "_con.d/"},
+	}
+	for _, tt := range tests {
+		is, ok := issues[tt.key]
+		if !ok {
+			t.Errorf("missing issue %q", tt.key)
+			continue
+		}
+		if is.Rename != tt.rename {
+			t.Errorf("%s: rename %q, want %q", tt.key, is.Rename, tt.rename)
+		}
+	}
+	if len(issues) != len(tests) {
+		t.Errorf("got %d issues, want %d: %v", len(issues), len(tests), issues)
+	}
+}
+
+func TestLintCollisions(t *testing.T) {
+	m := lintManifest("README", "readme", "Readme_2", "café", "café")
+	issues := lintByPath(Lint(m, LintOptions{}))
+
+	is, ok := issues["readme case-collision"]
+	if !ok || is.Other != "README" {
+		t.Fatalf("expected case collision with README, got %v", issues)
+	}
+	// Readme_2 is taken (case-insensitively), so the next free suffix is used.
+	if is.Rename != "readme_3" {
+		t.Errorf("rename %q, want readme_3", is.Rename)
+	}
+	is, ok = issues["café unicode-collision"]
+	if !ok || is.Rename != "café_2" {
+		t.Errorf("expected unicode collision renamed to café_2, got %v", is)
+	}
+
+	// Windows alone does not normalize names.
+	issues = lintByPath(Lint(m, LintOptions{Targets: LintWindows}))
+	if _, ok := issues["café unicode-collision"]; ok {
+		t.Error("unicode collision reported for Windows-only target")
+	}
+}
+
+func TestLintLengths(t *testing.T) {
+	long := strings.Repeat("é", 15) + ".exr" // 34 bytes
+	m := lintManifest("deep/", "deep/"+strings.Repeat("x", 30)+"/", "deep/"+strings.Repeat("x", 30)+"/f", long)
+	issues := Lint(m, LintOptions{MaxName: 16, MaxPath: 34})
+
+	var nameIssue, pathIssues int
+	for _, is := range issues {
+		switch is.Kind {
+		case LintNameTooLong:
+			nameIssue++
+			if len(strings.TrimSuffix(is.Rename, "/")) > 16 || (is.Path == long && !strings.HasSuffix(is.Rename, ".exr")) {
+				t.Errorf("bad truncation %q", is.Rename)
+			}
+		case LintPathTooLong:
+			pathIssues++
+			if is.Path != "deep/"+strings.Repeat("x", 30)+"/" {
+				t.Errorf("path issue reported for %q, want only the topmost", is.Path)
+			}
+		}
+	}
+	if pathIssues != 1 {
+		t.Errorf("got %d path issues, want 1", pathIssues)
+	}
+	if nameIssue != 2 {
+		t.Errorf("got %d name issues, want 2", nameIssue)
+	}
+}
+
+// A name whose stem ends in a long "_<digits>" run is truncated like any
+// other, rather than keeping a suffix that does not fit. Limits below
+// MinMaxNameBytes are raised to it.
+func TestLintLongDigitSuffix(t *testing.T) {
+	name := "a_" + strings.Repeat("1", 300) + ".txt"
+	for _, max := range []int{0, MinMaxNameBytes, 1} {
+		limit := max
+		if limit == 0 {
+			limit = DefaultMaxNameBytes
+		} else if limit < MinMaxNameBytes {
+			limit = MinMaxNameBytes
+		}
+		issues := Lint(lintManifest(name, "a_2.txt"), LintOptions{MaxName: max})
+		found := false
+		for _, is := range issues {
+			if is.Kind != LintNameTooLong || is.Path != name {
+				continue
+			}
+			found = true
+			if len(is.Rename) > limit {
+				t.Errorf("max %d: rename %q is %d bytes", max, is.Rename, len(is.Rename))
+			}
+		}
+		if !found {
+			t.Errorf("max %d: long name not reported", max)
+		}
+	}
+}
+
+func TestApplyLintRenames(t *testing.T) {
+	m := lintManifest("NUL/", "NUL/inner.txt", "x:y", "keep")
+	issues := Lint(m, LintOptions{})
+	renamed := ApplyLintRenames(m, issues)
+
+	paths := EntryPaths(renamed.Entries)
+	for _, p := range []string{"_NUL/", "_NUL/inner.txt", "x_y", "keep"} {
+		if paths[p] == nil {
+			t.Errorf("missing %q after renames", p)
+		}
+	}
+	if len(Lint(renamed, LintOptions{})) != 0 {
+		t.Errorf("renamed manifest still has issues: %v", Lint(renamed, LintOptions{}))
+	}
+
+	// The rename patch applied to the original reproduces the result.
+	pr := PatchDiff(m, renamed)
+	if got := ApplyPatch(m, pr.Patch).ComputeC4ID(); got != pr.NewID {
+		t.Errorf("patched ID %s, want %s", got, pr.NewID)
+	}
+}
+
+func TestParseLintTarget(t *testing.T) {
+	if tg, err := ParseLintTarget("windows,macos"); err != nil || tg != LintAll {
+		t.Errorf("got %v, %v", tg, err)
+	}
+	if tg, err := ParseLintTarget("mac"); err != nil || tg != LintMacOS {
+		t.Errorf("got %v, %v", tg, err)
+	}
+	if _, err := ParseLintTarget("amiga"); err == nil {
+		t.Error("expected error for unknown target")
+	}
+}
@@ -222,9 +222,23 @@ func (m *Manifest) HasNullValues() bool {
 // The path is reconstructed from the parent chain — for example, a file
 // "main.go" inside directory "src/" has the full path "src/main.go".
 // Root-level entries are keyed by their bare name (e.g., "readme.txt").
-func (m *Manifest) GetEntry(path string) *Entry {
+// With NormalizedPaths, a canonically equivalent spelling of the path also
+// matches.
+func (m *Manifest) GetEntry(path string, opts ...PathOption) *Entry {
 	idx := m.ensureIndex()
-	return idx.byPath[path]
+	if e, ok := idx.byPath[path]; ok {
+		return e
+	}
+	if !newPathConfig(opts).normalize {
+		return nil
+	}
+	if idx.byNFCPath == nil {
+		idx.byNFCPath = make(map[string]*Entry, len(idx.byPath))
+		for _, e := range m.Entries {
+			idx.byNFCPath[NFC(idx.pathOf[e])] = e
+		}
+	}
+	return idx.byNFCPath[NFC(path)]
 }
 
 // GetEntryByName returns an entry by its bare Name field (O(1) after index build).
@@ -635,6 +649,8 @@ type treeIndex struct {
 	children map[*Entry][]*Entry // parent -> direct children
 	parent   map[*Entry]*Entry   // child -> parent
 	root     []*Entry            // depth-0 entries
+
+	byNFCPath map[string]*Entry // NFC full path -> entry, built on demand
 }
 
 // invalidateIndex marks the tree index as stale
@@ -12,6 +12,8 @@ type Conflict struct {
 	Path        string // Full path (e.g., "footage/shot01.mov")
 	LocalEntry  *Entry
 	RemoteEntry *Entry
+	Resolution  Resolution // How the conflict was settled
+	Strategy    string     // Name of the deciding strategy ("" for default)
 }
 
 // Merge performs a three-way merge of c4m manifests.
@@ -26,15 +28,27 @@ type Conflict struct {
 // neither side loses data. The returned conflicts list identifies
 // which paths had genuine conflicts.
 //
+// Options select a Strategy to settle conflicts instead (globally or per
+// path pattern). Every conflict is still returned, with the Resolution
+// and Strategy that decided it, so callers get a full report.
+//
 // If base is nil, local is used as the base (first sync).
-func Merge(base, local, remote *Manifest) (*Manifest, []Conflict, error) {
+func Merge(base, local, remote *Manifest, opts ...MergeOption) (*Manifest, []Conflict, error) {
 	if base == nil {
 		base = NewManifest()
 	}
+	cfg := &mergeConfig{}
+	for _, o := range opts {
+		o(cfg)
+	}
 
-	baseMap := EntryPaths(base.Entries)
-	localMap := EntryPaths(local.Entries)
-	remoteMap := EntryPaths(remote.Entries)
+	var popts []PathOption
+	if cfg.paths.normalize {
+		popts = append(popts, NormalizedPaths())
+	}
+	baseMap := EntryPaths(base.Entries, popts...)
+	localMap := EntryPaths(local.Entries, popts...)
+	remoteMap := EntryPaths(remote.Entries, popts...)
 
 	// Collect all unique paths.
 	allSet := make(map[string]struct{})
@@ -74,7 +88,7 @@ func Merge(base, local, remote *Manifest) (*Manifest, []Conflict, error) {
 			if mergeEqual(l, r) {
 				merged[p] = cloneEntry(l)
 			} else {
-				addConflict(merged, &conflicts, p, l, r)
+				addConflict(cfg, merged, &conflicts, p, l, r)
 			}
 
 		// --- All three exist ---
@@ -92,7 +106,7 @@ func Merge(base, local, remote *Manifest) (*Manifest, []Conflict, error) {
 				if mergeEqual(l, r) {
 					merged[p] = cloneEntry(l) // converged
 				} else {
-					addConflict(merged, &conflicts, p, l, r)
+					addConflict(cfg, merged, &conflicts, p, l, r)
 				}
 			}
 
@@ -102,7 +116,7 @@ func Merge(base, local, remote *Manifest) (*Manifest, []Conflict, error) {
 				// Unchanged locally, remote deleted → delete
 			} else {
 				// Local modified after remote deleted → conflict
-				addConflict(merged, &conflicts, p, l, nil)
+				addConflict(cfg, merged, &conflicts, p, l, nil)
 			}
 
 		// --- Local deleted ---
@@ -111,7 +125,7 @@ func Merge(base, local, remote *Manifest) (*Manifest, []Conflict, error) {
 				// Unchanged remotely, local deleted → delete
 			} else {
 				// Remote modified after local deleted → conflict
-				addConflict(merged, &conflicts, p, nil, r)
+				addConflict(cfg, merged, &conflicts, p, nil, r)
 			}
 
 		// --- Both deleted ---
@@ -120,6 +134,10 @@ func Merge(base, local, remote *Manifest) (*Manifest, []Conflict, error) {
 		}
 	}
 
+	if cfg.paths.normalize {
+		merged = originalPaths(merged, conflicts, paths, localMap, remoteMap, baseMap)
+	}
+
 	// Ensure parent directories exist for all entries in the merged set.
 	ensureDirs(merged)
 
@@ -127,16 +145,35 @@ func Merge(base, local, remote *Manifest) (*Manifest, []Conflict, error) {
 	return result, conflicts, nil
 }
 
-// addConflict adds both versions to the merged map and records the conflict.
-// The version with the newer timestamp keeps the original name; the other
-// gets a ".conflict" suffix. If one side is nil (delete-vs-modify), only
-// the surviving version is included.
-func addConflict(merged map[string]*Entry, conflicts *[]Conflict, path string, local, remote *Entry) {
-	*conflicts = append(*conflicts, Conflict{
+// addConflict records the conflict and settles it with the configured
+// strategy. Without one (or when it returns KeepBoth) both versions are
+// added: the version with the newer timestamp keeps the original name; the
+// other gets a ".conflict" suffix. If one side is nil (delete-vs-modify),
+// only the surviving version is included.
+func addConflict(cfg *mergeConfig, merged map[string]*Entry, conflicts *[]Conflict, path string, local, remote *Entry) {
+	c := Conflict{
 		Path:        path,
 		LocalEntry:  local,
 		RemoteEntry: remote,
-	})
+	}
+	if s := cfg.strategyFor(path); s != nil {
+		c.Resolution = s.Resolve(c)
+		c.Strategy = strategyName(s)
+	}
+	*conflicts = append(*conflicts, c)
+
+	switch c.Resolution {
+	case KeepLocal:
+		if local != nil {
+			merged[path] = cloneEntry(local)
+		}
+		return
+	case KeepRemote:
+		if remote != nil {
+			merged[path] = cloneEntry(remote)
+		}
+		return
+	}
 
 	if local == nil {
 		merged[path] = cloneEntry(remote)
@@ -160,6 +197,45 @@ func addConflict(merged map[string]*Entry, conflicts *[]Conflict, path string, l
 	merged[conflictPath] = cloneEntry(loser)
 }
 
+// originalPaths rekeys a merged map built from normalized path keys by
+// the entries' original spellings, so the merged manifest keeps the bytes
+// it was given. Each component takes its spelling from the entry at that
+// path (local first, then remote, then base), and conflict paths are
+// updated to match.
+func originalPaths(merged map[string]*Entry, conflicts []Conflict, keys []string, maps ...map[string]*Entry) map[string]*Entry {
+	orig := make(map[string]string, len(keys))
+	for _, k := range keys { // sorted, so parents come first
+		var e *Entry
+		for _, m := range maps {
+			if e = m[k]; e != nil {
+				break
+			}
+		}
+		parent := ""
+		if i := strings.LastIndex(strings.TrimSuffix(k, "/"), "/"); i >= 0 {
+			var ok bool
+			if parent, ok = orig[k[:i+1]]; !ok {
+				parent = k[:i+1]
+			}
+		}
+		orig[k] = parent + e.Name
+	}
+	for i := range conflicts {
+		k := conflicts[i].Path
+		orig[conflictName(k)] = conflictName(orig[k])
+		conflicts[i].Path = orig[k]
+	}
+
+	out := make(map[string]*Entry, len(merged))
+	for k, e := range merged {
+		if p, ok := orig[k]; ok {
+			k = p
+		}
+		out[k] = e
+	}
+	return out
+}
+
 // conflictName appends ".conflict" to a path, preserving directory trailing slash.
 func conflictName(path string) string {
 	if strings.HasSuffix(path, "/") {
@@ -193,8 +269,11 @@ func cloneEntry(e *Entry) *Entry {
 }
 
 // EntryPaths builds a map from full path to entry by walking the manifest
-// tree. Paths use forward slashes; directories end with "/".
-func EntryPaths(entries []*Entry) map[string]*Entry {
+// tree. Paths use forward slashes; directories end with "/". With
+// NormalizedPaths the map is keyed by PathKey (NFC); the entries are
+// unchanged.
+func EntryPaths(entries []*Entry, opts ...PathOption) map[string]*Entry {
+	cfg := newPathConfig(opts)
 	result := make(map[string]*Entry, len(entries))
 	stack := make([]string, 0, 8)
 
@@ -210,7 +289,7 @@ func EntryPaths(entries []*Entry) map[string]*Entry {
 		sb.WriteString(e.Name)
 		fullPath := sb.String()
 
-		result[fullPath] = e
+		result[cfg.key(fullPath)] = e
 
 		if e.IsDir() {
 			stack = append(stack, e.Name)
@@ -282,3 +282,47 @@ func entryNames(m *Manifest) map[string]bool {
 	}
 	return names
 }
+
+func TestMerge_NormalizedPaths(t *testing.T) {
+	ts := time.Now().UTC()
+	id := testID("photo")
+	nfc, nfd := "café/", "café/"
+
+	base := makeTestManifest(
+		&Entry{Mode: 0755, Name: nfc, Size: -1, Timestamp: ts},
+		&Entry{Mode: 0644, Name: "a.jpg", Depth: 1, Size: 5, C4ID: id, Timestamp: ts},
+	)
+	local := base.Copy()
+	// Remote came back from macOS decomposed, with one new file.
+	remote := makeTestManifest(
+		&Entry{Mode: 0755, Name: nfd, Size: -1, Timestamp: ts},
+		&Entry{Mode: 0644, Name: "a.jpg", Depth: 1, Size: 5, C4ID: id, Timestamp: ts},
+		&Entry{Mode: 0644, Name: "b.jpg", Depth: 1, Size: 5, C4ID: testID("b"), Timestamp: ts},
+	)
+
+	merged, conflicts, err := Merge(base, local, remote)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Exact matching sees remote delete the NFC tree and add an NFD one.
+	if EntryPaths(merged.Entries)[nfc] != nil {
+		t.Fatal("exact merge should have dropped the local spelling")
+	}
+
+	merged, conflicts, err = Merge(base, local, remote, WithPathOptions(NormalizedPaths()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(conflicts) != 0 {
+		t.Fatalf("unexpected conflicts: %v", conflicts)
+	}
+	paths := EntryPaths(merged.Entries)
+	for _, p := range []string{nfc, nfc + "a.jpg", nfc + "b.jpg"} {
+		if paths[p] == nil {
+			t.Errorf("missing %+q (local spelling should win)", p)
+		}
+	}
+	if len(paths) != 3 {
+		t.Errorf("expected 3 entries, got %d", len(paths))
+	}
+}
@@ -0,0 +1,303 @@
+package c4m
+
+import (
+	"sort"
+	"sync"
+	"unicode/utf8"
+)
+
+//go:generate go run gen_normtables.go -ucd ucd -version 14.0.0 -o normtables.go
+
+// Unicode normalization (NFC and NFD) for file names.
+//
+// Filesystems disagree on how names are normalized: macOS stores names
+// decomposed (NFD), while Linux and Windows keep whatever bytes they were
+// given, usually precomposed (NFC). The same visible name can therefore
+// reach a manifest as two different byte strings. These helpers let callers
+// compare names by canonical equivalence without an external dependency.
+// Only canonical mappings are applied; compatibility mappings are not.
+
+// Hangul syllable constants (Unicode §3.12).
+const (
+	hangulSBase  = 0xAC00
+	hangulLBase  = 0x1100
+	hangulVBase  = 0x1161
+	hangulTBase  = 0x11A7
+	hangulLCount = 19
+	hangulVCount = 21
+	hangulTCount = 28
+	hangulNCount = hangulVCount * hangulTCount
+	hangulSCount = hangulLCount * hangulNCount
+)
+
+var (
+	normOnce   sync.Once
+	decompMap  map[rune][]rune
+	composeMap map[[2]rune]rune
+)
+
+// initNormTables builds the lookup maps from the generated tables.
+func initNormTables() {
+	raw := make(map[rune][2]rune, len(canonicalDecompositions))
+	for _, d := range canonicalDecompositions {
+		raw[d[0]] = [2]rune{d[1], d[2]}
+	}
+
+	// Fully expand each decomposition so lookups need no recursion.
+	decompMap = make(map[rune][]rune, len(raw))
+	var expand func(r rune, out []rune) []rune
+	expand = func(r rune, out []rune) []rune {
+		d, ok := raw[r]
+		if !ok {
+			return append(out, r)
+		}
+		out = expand(d[0], out)
+		if d[1] != 0 {
+			out = expand(d[1], out)
+		}
+		return out
+	}
+	for r := range raw {
+		decompMap[r] = expand(r, nil)
+	}
+
+	excluded := make(map[rune]bool, len(compositionExclusions))
+	for _, r := range compositionExclusions {
+		excluded[r] = true
+	}
+	composeMap = make(map[[2]rune]rune, len(raw))
+	for r, d := range raw {
+		if d[1] != 0 && !excluded[r] {
+			composeMap[d] = r
+		}
+	}
+}
+
+// combiningClass returns the canonical combining class of r.
+func combiningClass(r rune) uint8 {
+	i := sort.Search(len(combiningClassRanges), func(i int) bool {
+		return combiningClassRanges[i].hi >= r
+	})
+	if i < len(combiningClassRanges) && combiningClassRanges[i].lo <= r {
+		return combiningClassRanges[i].class
+	}
+	return 0
+}
+
+// IsNFC reports whether s is already in Normalization Form C.
+func IsNFC(s string) bool {
+	return isASCII(s) || NFC(s) == s
+}
+
+// IsNFD reports whether s is already in Normalization Form D.
+func IsNFD(s string) bool {
+	return isASCII(s) || NFD(s) == s
+}
+
+// NFC returns s in Normalization Form C (canonical composition). Invalid
+// UTF-8 bytes are passed through unchanged.
+func NFC(s string) string {
+	if isASCII(s) {
+		return s
+	}
+	return string(compose(decompose(s)))
+}
+
+// NFD returns s in Normalization Form D (canonical decomposition). Invalid
+// UTF-8 bytes are passed through unchanged.
+func NFD(s string) string {
+	if isASCII(s) {
+		return s
+	}
+	return string(decompose(s))
+}
+
+// NormalizationEqual reports whether a and b are canonically equivalent,
+// i.e. they are the same name under NFC.
+func NormalizationEqual(a, b string) bool {
+	return a == b || NFC(a) == NFC(b)
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
+
+// decompose returns the canonical decomposition of s with combining marks
+// in canonical order, encoded as UTF-8. Invalid bytes are kept verbatim.
+func decompose(s string) []byte {
+	normOnce.Do(initNormTables)
+	var runes []rune
+	out := make([]byte, 0, len(s)+8)
+	flush := func() {
+		sortCombining(runes)
+		for _, r := range runes {
+			out = appendRune(out, r)
+		}
+		runes = runes[:0]
+	}
+	for i := 0; i < len(s); {
+		r, size := utf8.DecodeRuneInString(s[i:])
+		if r == utf8.RuneError && size <= 1 {
+			flush()
+			out = append(out, s[i])
+			i++
+			continue
+		}
+		i += size
+		switch {
+		case r >= hangulSBase && r < hangulSBase+hangulSCount:
+			si := r - hangulSBase
+			runes = append(runes, hangulLBase+si/hangulNCount, hangulVBase+(si%hangulNCount)/hangulTCount)
+			if t := si % hangulTCount; t != 0 {
+				runes = append(runes, hangulTBase+t)
+			}
+		default:
+			if d, ok := decompMap[r]; ok {
+				runes = append(runes, d...)
+			} else {
+				runes = append(runes, r)
+			}
+		}
+	}
+	flush()
+	return out
+}
+
+// sortCombining applies the canonical ordering algorithm: each run of
+// non-starters is stably sorted by combining class.
+func sortCombining(runes []rune) {
+	for i := 0; i < len(runes); {
+		if combiningClass(runes[i]) == 0 {
+			i++
+			continue
+		}
+		j := i
+		for j < len(runes) && combiningClass(runes[j]) != 0 {
+			j++
+		}
+		run := runes[i:j]
+		sort.SliceStable(run, func(a, b int) bool {
+			return combiningClass(run[a]) < combiningClass(run[b])
+		})
+		i = j
+	}
+}
+
+// compose applies canonical composition to decomposed UTF-8. Invalid bytes
+// break composition and are kept verbatim.
+func compose(b []byte) []byte {
+	out := make([]byte, 0, len(b))
+	var seg []rune
+	flush := func() {
+		seg = composeSegment(seg)
+		for _, r := range seg {
+			out = appendRune(out, r)
+		}
+		seg = seg[:0]
+	}
+	for i := 0; i < len(b); {
+		r, size := utf8.DecodeRune(b[i:])
+		if r == utf8.RuneError && size <= 1 {
+			flush()
+			out = append(out, b[i])
+			i++
+			continue
+		}
+		seg = append(seg, r)
+		i += size
+	}
+	flush()
+	return out
+}
+
+// composeSegment composes a run of decoded runes in place. A character
+// combines with the last starter unless it is blocked by an intervening
+// character of equal or higher combining class, or by another starter.
+func composeSegment(runes []rune) []rune {
+	out := runes[:0]
+	starter := -1
+	lastClass := -1 // class of the last rune kept after the starter; -1 if none
+	for _, r := range runes {
+		cc := int(combiningClass(r))
+		if starter >= 0 && (lastClass == -1 || (cc != 0 && lastClass < cc)) {
+			if c, ok := composePair(out[starter], r); ok {
+				out[starter] = c
+				continue
+			}
+		}
+		if cc == 0 {
+			starter = len(out)
+			lastClass = -1
+		} else {
+			lastClass = cc
+		}
+		out = append(out, r)
+	}
+	return out
+}
+
+// appendRune appends the UTF-8 encoding of r to b.
+func appendRune(b []byte, r rune) []byte {
+	var buf [utf8.UTFMax]byte
+	n := utf8.EncodeRune(buf[:], r)
+	return append(b, buf[:n]...)
+}
+
+// composePair returns the primary composite of a and b, if any.
+func composePair(a, b rune) (rune, bool) {
+	// Hangul LV and LVT syllables.
+	if a >= hangulLBase && a < hangulLBase+hangulLCount && b >= hangulVBase && b < hangulVBase+hangulVCount {
+		return hangulSBase + ((a-hangulLBase)*hangulVCount+(b-hangulVBase))*hangulTCount, true
+	}
+	if a >= hangulSBase && a < hangulSBase+hangulSCount && (a-hangulSBase)%hangulTCount == 0 &&
+		b > hangulTBase && b < hangulTBase+hangulTCount {
+		return a + (b - hangulTBase), true
+	}
+	c, ok := composeMap[[2]rune{a, b}]
+	return c, ok
+}
+
+// PathOption configures how c4m compares paths (EntryPaths, GetEntry,
+// Diff and, through WithPathOptions, Merge).
+type PathOption func(*pathConfig)
+
+type pathConfig struct {
+	normalize bool
+}
+
+// NormalizedPaths treats canonically equivalent spellings of a path (for
+// example NFC from Linux and NFD from macOS) as the same path. Entries
+// keep their original bytes; only the comparison is affected.
+func NormalizedPaths() PathOption {
+	return func(c *pathConfig) {
+		c.normalize = true
+	}
+}
+
+func newPathConfig(opts []PathOption) pathConfig {
+	var c pathConfig
+	for _, o := range opts {
+		o(&c)
+	}
+	return c
+}
+
+// key returns the comparison key for p.
+func (c pathConfig) key(p string) string {
+	if c.normalize {
+		return NFC(p)
+	}
+	return p
+}
+
+// PathKey returns the key under which p is compared with the given
+// options: NFC(p) with NormalizedPaths, otherwise p itself. Two paths
+// match when their keys are equal.
+func PathKey(p string, opts ...PathOption) string {
+	return newPathConfig(opts).key(p)
+}
@@ -0,0 +1,111 @@
+package c4m
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNormalizationForms(t *testing.T) {
+	tests := []struct {
+		in, nfc, nfd string
+	}{
+		{"plain.txt", "plain.txt", "plain.txt"},
+		{"café", "café", "café"},
+		{"café", "café", "café"},
+		// Marks are put in canonical order before composing.
+		{"ậ", "ậ", "ậ"},
+		{"ậ", "ậ", "ậ"},
+		// Singleton decompositions never recompose (OHM SIGN -> OMEGA).
+		{"Ω", "Ω", "Ω"},
+		// Composition exclusions stay decomposed.
+		{"क़", "क़", "क़"},
+		// Hangul syllables are decomposed and composed algorithmically.
+		{"한", "한", "한"},
+		{"한", "한", "한"},
+		// Invalid UTF-8 passes through.
+		{"bad\xffé", "bad\xffé", "bad\xffé"},
+	}
+	for _, tt := range tests {
+		if got := NFC(tt.in); got != tt.nfc {
+			t.Errorf("NFC(%+q) = %+q, want %+q", tt.in, got, tt.nfc)
+		}
+		if got := NFD(tt.in); got != tt.nfd {
+			t.Errorf("NFD(%+q) = %+q, want %+q", tt.in, got, tt.nfd)
+		}
+		if !IsNFC(tt.nfc) || !IsNFD(tt.nfd) {
+			t.Errorf("%+q: IsNFC/IsNFD disagree with NFC/NFD", tt.in)
+		}
+	}
+}
+
+func TestNormalizationEqual(t *testing.T) {
+	if !NormalizationEqual("café.txt", "café.txt") {
+		t.Error("NFC and NFD spellings should be equal")
+	}
+	if NormalizationEqual("cafe.txt", "café.txt") {
+		t.Error("different names should not be equal")
+	}
+}
+
+const (
+	nfcName = "caf\u00e9.txt"
+	nfdName = "cafe\u0301.txt"
+)
+
+func TestEntryPathsNormalized(t *testing.T) {
+	m := NewManifest()
+	m.AddEntry(&Entry{Name: "caf\u00e9/", Size: -1})
+	m.AddEntry(&Entry{Name: nfdName, Depth: 1, Size: -1})
+
+	if _, ok := EntryPaths(m.Entries)["caf\u00e9/"+nfcName]; ok {
+		t.Error("exact EntryPaths matched a different spelling")
+	}
+	e, ok := EntryPaths(m.Entries, NormalizedPaths())["caf\u00e9/"+nfcName]
+	if !ok {
+		t.Fatal("normalized EntryPaths did not match")
+	}
+	if e.Name != nfdName {
+		t.Errorf("entry name changed to %+q", e.Name)
+	}
+
+	if m.GetEntry("caf\u00e9/"+nfcName) != nil {
+		t.Error("GetEntry without options matched a different spelling")
+	}
+	if got := m.GetEntry("cafe\u0301/"+nfcName, NormalizedPaths()); got != e {
+		t.Errorf("GetEntry(NormalizedPaths) = %v", got)
+	}
+}
+
+func TestDiffNormalized(t *testing.T) {
+	ts := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	id := testID("same")
+	a := NewManifest()
+	a.AddEntry(&Entry{Name: nfcName, Size: 4, C4ID: id, Timestamp: ts})
+	a.AddEntry(&Entry{Name: "changed.txt", Size: 1, C4ID: testID("a"), Timestamp: ts})
+	b := NewManifest()
+	b.AddEntry(&Entry{Name: nfdName, Size: 4, C4ID: id, Timestamp: ts})
+	b.AddEntry(&Entry{Name: "changed.txt", Size: 1, C4ID: testID("b"), Timestamp: ts})
+
+	// Without normalization the pair is a spurious removal and addition.
+	dr, err := Diff(ManifestSource{a}, ManifestSource{b})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dr.Added.Entries) != 1 || len(dr.Removed.Entries) != 1 {
+		t.Fatalf("expected add/remove pair, got +%d -%d", len(dr.Added.Entries), len(dr.Removed.Entries))
+	}
+
+	dr, err = Diff(ManifestSource{a}, ManifestSource{b}, NormalizedPaths())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dr.Added.Entries) != 0 || len(dr.Removed.Entries) != 0 {
+		t.Fatalf("unexpected add/remove: +%d -%d", len(dr.Added.Entries), len(dr.Removed.Entries))
+	}
+	if len(dr.Normalized.Entries) != 1 || dr.Normalized.Entries[0].Name != nfdName {
+		t.Fatalf("expected the NFD entry in Normalized, got %v", dr.Normalized.Entries)
+	}
+	if len(dr.Modified.Entries) != 1 {
+		t.Errorf("expected 1 modified entry, got %d", len(dr.Modified.Entries))
+	}
+}
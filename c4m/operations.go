@@ -299,7 +299,11 @@ func flattenPatchTree(node *patchNode, depth int, result *[]*Entry) {
 // Diff compares two sources and returns a categorized diff result.
 // For patch-format output, prefer PatchDiff which produces properly
 // nested entries suitable for direct serialization.
-func Diff(a, b Source) (*DiffResult, error) {
+//
+// With NormalizedPaths, entries whose names differ only by Unicode
+// normalization are matched. If nothing else changed they are reported in
+// Normalized rather than as a removal and an addition.
+func Diff(a, b Source, opts ...PathOption) (*DiffResult, error) {
 	manifestA, err := a.ToManifest()
 	if err != nil {
 		return nil, fmt.Errorf("failed to get manifest A: %w", err)
@@ -310,30 +314,35 @@ func Diff(a, b Source) (*DiffResult, error) {
 		return nil, fmt.Errorf("failed to get manifest B: %w", err)
 	}
 
+	cfg := newPathConfig(opts)
 	result := &DiffResult{
-		Added:    NewManifest(),
-		Removed:  NewManifest(),
-		Modified: NewManifest(),
-		Same:     NewManifest(),
+		Added:      NewManifest(),
+		Removed:    NewManifest(),
+		Modified:   NewManifest(),
+		Same:       NewManifest(),
+		Normalized: NewManifest(),
 	}
 
 	// Build maps for efficient lookup
 	aMap := make(map[string]*Entry)
 	for _, entry := range manifestA.Entries {
-		aMap[entry.Name] = entry
+		aMap[cfg.key(entry.Name)] = entry
 	}
 
 	bMap := make(map[string]*Entry)
 	for _, entry := range manifestB.Entries {
-		bMap[entry.Name] = entry
+		bMap[cfg.key(entry.Name)] = entry
 	}
 
 	// Check entries in A
 	for name, entryA := range aMap {
 		if entryB, exists := bMap[name]; exists {
-			if entriesEqual(entryA, entryB) {
+			switch {
+			case entriesEqual(entryA, entryB):
 				result.Same.AddEntry(entryA)
-			} else {
+			case entryA.Name != entryB.Name && entriesEquivalent(entryA, entryB):
+				result.Normalized.AddEntry(entryB)
+			default:
 				result.Modified.AddEntry(entryB)
 			}
 		} else {
@@ -352,6 +361,7 @@ func Diff(a, b Source) (*DiffResult, error) {
 	result.Removed.SortEntries()
 	result.Modified.SortEntries()
 	result.Same.SortEntries()
+	result.Normalized.SortEntries()
 
 	return result, nil
 }
@@ -362,9 +372,15 @@ type DiffResult struct {
 	Removed  *Manifest
 	Modified *Manifest
 	Same     *Manifest
+
+	// Normalized holds entries (from b) that match an entry in a only by
+	// Unicode normalization and are otherwise unchanged. It is only
+	// populated with NormalizedPaths.
+	Normalized *Manifest
 }
 
-// IsEmpty returns true if there are no differences
+// IsEmpty returns true if there are no differences. Entries that only
+// changed Unicode normalization (Normalized) do not count.
 func (dr *DiffResult) IsEmpty() bool {
 	return len(dr.Added.Entries) == 0 &&
 		len(dr.Removed.Entries) == 0 &&
@@ -389,6 +405,14 @@ func entriesEqual(a, b *Entry) bool {
 		a.Target == b.Target
 }
 
+// entriesEquivalent compares two entries for equality ignoring the bytes
+// of their names.
+func entriesEquivalent(a, b *Entry) bool {
+	bb := *b
+	bb.Name = a.Name
+	return entriesEqual(a, &bb)
+}
+
 // PathList returns just the paths from a manifest
 func (m *Manifest) PathList() []string {
 	paths := make([]string, 0, len(m.Entries))
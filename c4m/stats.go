@@ -0,0 +1,326 @@
+package c4m
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Avalanche-io/c4"
+	"github.com/Avalanche-io/c4/store"
+)
+
+// StatsTopN is the number of entries kept in the ranked lists of
+// ManifestStats (deepest paths, largest directories, extensions).
+const StatsTopN = 10
+
+// ManifestStats summarizes the contents of a manifest. Sizes only count
+// entries whose size is known; directory sizes are computed from the files
+// below them rather than read from the directory entries.
+type ManifestStats struct {
+	Entries   int `json:"entries"`
+	Files     int `json:"files"`
+	Dirs      int `json:"dirs"`
+	Symlinks  int `json:"symlinks"`
+	Sequences int `json:"sequences"`
+	Special   int `json:"special"` // devices, pipes and sockets
+	HardLinks int `json:"hard_links"`
+	MaxDepth  int `json:"max_depth"`
+
+	TotalBytes  int64   `json:"total_bytes"`  // sum of file sizes
+	UniqueBytes int64   `json:"unique_bytes"` // sum of sizes of distinct C4 IDs
+	UniqueIDs   int     `json:"unique_ids"`
+	DedupRatio  float64 `json:"dedup_ratio"` // TotalBytes / UniqueBytes (1 if nothing repeats)
+
+	Oldest     time.Time `json:"oldest,omitempty"`
+	OldestPath string    `json:"oldest_path,omitempty"`
+	Newest     time.Time `json:"newest,omitempty"`
+	NewestPath string    `json:"newest_path,omitempty"`
+
+	Nulls NullCounts `json:"nulls"`
+
+	SizeHistogram []SizeBucket `json:"size_histogram"`
+	Extensions    []ExtStat    `json:"extensions"`
+	Deepest       []PathDepth  `json:"deepest"`
+	LargestDirs   []DirSize    `json:"largest_dirs"`
+
+	// Coverage is set by StoreCoverage.
+	Coverage *Coverage `json:"coverage,omitempty"`
+}
+
+// NullCounts counts entries with null metadata fields.
+type NullCounts struct {
+	Entries   int `json:"entries"` // entries with at least one null field
+	Mode      int `json:"mode"`
+	Timestamp int `json:"timestamp"`
+	Size      int `json:"size"`
+	C4ID      int `json:"c4id"` // files without a C4 ID
+}
+
+// SizeBucket counts files with Min <= size < Max. Max is -1 for the last,
+// unbounded bucket.
+type SizeBucket struct {
+	Min   int64 `json:"min"`
+	Max   int64 `json:"max"`
+	Files int   `json:"files"`
+	Bytes int64 `json:"bytes"`
+}
+
+// ExtStat is the file count and size for one file extension. Files with
+// no extension are grouped under "".
+type ExtStat struct {
+	Ext   string `json:"ext"`
+	Files int    `json:"files"`
+	Bytes int64  `json:"bytes"`
+}
+
+// PathDepth is a path and its nesting depth (0 for root entries).
+type PathDepth struct {
+	Path  string `json:"path"`
+	Depth int    `json:"depth"`
+}
+
+// DirSize is the total size of the files under a directory.
+type DirSize struct {
+	Path  string `json:"path"`
+	Files int    `json:"files"`
+	Bytes int64  `json:"bytes"`
+}
+
+// Coverage reports how much of a manifest's content is present in a store.
+// Counts are of distinct C4 IDs.
+type Coverage struct {
+	StoredIDs    int   `json:"stored_ids"`
+	StoredBytes  int64 `json:"stored_bytes"`
+	MissingIDs   int   `json:"missing_ids"`
+	MissingBytes int64 `json:"missing_bytes"`
+
+	// Stored IDs kept as chunk recipes, their total size, and the size of
+	// the distinct chunks they share. ChunkedBytes - ChunkBytes is the
+	// space chunk-level dedup saves.
+	ChunkedIDs   int   `json:"chunked_ids,omitempty"`
+	ChunkedBytes int64 `json:"chunked_bytes,omitempty"`
+	ChunkBytes   int64 `json:"chunk_bytes,omitempty"`
+}
+
+// sizeBucketLimits are the histogram bucket boundaries: empty files, then
+// powers of 16 from 1 KiB.
+var sizeBucketLimits = []int64{
+	1,
+	1 << 10, 16 << 10, 256 << 10,
+	4 << 20, 64 << 20,
+	1 << 30, 16 << 30, 256 << 30,
+}
+
+// Stats computes statistics for m.
+func Stats(m *Manifest) *ManifestStats {
+	st := &ManifestStats{}
+	st.SizeHistogram = make([]SizeBucket, len(sizeBucketLimits)+1)
+	var lo int64
+	for i, hi := range sizeBucketLimits {
+		st.SizeHistogram[i] = SizeBucket{Min: lo, Max: hi}
+		lo = hi
+	}
+	st.SizeHistogram[len(sizeBucketLimits)] = SizeBucket{Min: lo, Max: -1}
+
+	uniqueSize := make(map[c4.ID]int64)
+	exts := make(map[string]*ExtStat)
+	var dirs []*DirSize
+	var deepest []PathDepth
+
+	type frame struct {
+		name string
+		dir  *DirSize
+	}
+	var stack []frame
+	null := NullTimestamp()
+
+	for _, e := range m.Entries {
+		for len(stack) > e.Depth {
+			stack = stack[:len(stack)-1]
+		}
+		var sb strings.Builder
+		for _, f := range stack {
+			sb.WriteString(f.name)
+		}
+		sb.WriteString(e.Name)
+		p := sb.String()
+
+		st.Entries++
+		if e.Depth > st.MaxDepth {
+			st.MaxDepth = e.Depth
+		}
+		deepest = append(deepest, PathDepth{Path: p, Depth: e.Depth})
+
+		// Null fields.
+		hasNull := false
+		if e.Mode == 0 {
+			st.Nulls.Mode++
+			hasNull = true
+		}
+		if e.Timestamp.Equal(null) {
+			st.Nulls.Timestamp++
+			hasNull = true
+		} else {
+			if st.Oldest.IsZero() || e.Timestamp.Before(st.Oldest) {
+				st.Oldest, st.OldestPath = e.Timestamp, p
+			}
+			if e.Timestamp.After(st.Newest) {
+				st.Newest, st.NewestPath = e.Timestamp, p
+			}
+		}
+		if e.Size < 0 {
+			st.Nulls.Size++
+			hasNull = true
+		}
+		if hasNull {
+			st.Nulls.Entries++
+		}
+		if e.HardLink != 0 {
+			st.HardLinks++
+		}
+
+		switch {
+		case e.IsDir():
+			st.Dirs++
+			d := &DirSize{Path: p}
+			dirs = append(dirs, d)
+			stack = append(stack, frame{e.Name, d})
+			continue
+		case e.IsSymlink():
+			st.Symlinks++
+			continue
+		case e.IsDevice() || e.IsPipe() || e.IsSocket():
+			st.Special++
+			continue
+		case e.IsSequence:
+			st.Sequences++
+		default:
+			st.Files++
+		}
+
+		if e.C4ID.IsNil() {
+			st.Nulls.C4ID++
+		} else if _, ok := uniqueSize[e.C4ID]; !ok {
+			uniqueSize[e.C4ID] = e.Size
+		}
+
+		if e.Size < 0 {
+			continue
+		}
+		st.TotalBytes += e.Size
+		for _, f := range stack {
+			f.dir.Files++
+			f.dir.Bytes += e.Size
+		}
+		if !e.IsSequence {
+			b := &st.SizeHistogram[sizeBucket(e.Size)]
+			b.Files++
+			b.Bytes += e.Size
+		}
+		ext := fileExt(e.Name)
+		es := exts[ext]
+		if es == nil {
+			es = &ExtStat{Ext: ext}
+			exts[ext] = es
+		}
+		es.Files++
+		es.Bytes += e.Size
+	}
+
+	st.UniqueIDs = len(uniqueSize)
+	for _, size := range uniqueSize {
+		if size > 0 {
+			st.UniqueBytes += size
+		}
+	}
+	st.DedupRatio = 1
+	if st.UniqueBytes > 0 {
+		st.DedupRatio = float64(st.TotalBytes) / float64(st.UniqueBytes)
+	}
+
+	for _, es := range exts {
+		st.Extensions = append(st.Extensions, *es)
+	}
+	sort.Slice(st.Extensions, func(i, j int) bool {
+		a, b := st.Extensions[i], st.Extensions[j]
+		if a.Bytes != b.Bytes {
+			return a.Bytes > b.Bytes
+		}
+		return a.Ext < b.Ext
+	})
+	if len(st.Extensions) > StatsTopN {
+		st.Extensions = st.Extensions[:StatsTopN]
+	}
+
+	sort.SliceStable(deepest, func(i, j int) bool { return deepest[i].Depth > deepest[j].Depth })
+	if len(deepest) > StatsTopN {
+		deepest = deepest[:StatsTopN]
+	}
+	st.Deepest = deepest
+
+	sort.SliceStable(dirs, func(i, j int) bool { return dirs[i].Bytes > dirs[j].Bytes })
+	if len(dirs) > StatsTopN {
+		dirs = dirs[:StatsTopN]
+	}
+	for _, d := range dirs {
+		st.LargestDirs = append(st.LargestDirs, *d)
+	}
+	return st
+}
+
+// StoreCoverage checks which of the manifest's content IDs (files and
+// sequences) are present in s, and how those stored as chunks deduplicate.
+func StoreCoverage(m *Manifest, s store.Store) *Coverage {
+	cov := &Coverage{}
+	seen := make(map[c4.ID]bool)
+	chunks := make(map[c4.ID]bool)
+	for _, e := range m.Entries {
+		if e.IsDir() || e.IsSymlink() || e.C4ID.IsNil() || seen[e.C4ID] {
+			continue
+		}
+		seen[e.C4ID] = true
+		size := e.Size
+		if size < 0 {
+			size = 0
+		}
+		if s.Has(e.C4ID) {
+			cov.StoredIDs++
+			cov.StoredBytes += size
+			list, _ := store.Chunks(s, e.C4ID)
+			if len(list) > 0 {
+				cov.ChunkedIDs++
+				cov.ChunkedBytes += size
+			}
+			for _, ch := range list {
+				if !chunks[ch.ID] {
+					chunks[ch.ID] = true
+					cov.ChunkBytes += ch.Size
+				}
+			}
+		} else {
+			cov.MissingIDs++
+			cov.MissingBytes += size
+		}
+	}
+	return cov
+}
+
+// sizeBucket returns the histogram index for a file size.
+func sizeBucket(size int64) int {
+	for i, hi := range sizeBucketLimits {
+		if size < hi {
+			return i
+		}
+	}
+	return len(sizeBucketLimits)
+}
+
+// fileExt returns the lower-cased extension of a file name, without the
+// dot. Hidden files like ".profile" have no extension.
+func fileExt(name string) string {
+	i := strings.LastIndexByte(name, '.')
+	if i <= 0 || i == len(name)-1 {
+		return ""
+	}
+	return strings.ToLower(name[i+1:])
+}
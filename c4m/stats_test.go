@@ -0,0 +1,104 @@
+package c4m
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Avalanche-io/c4"
+	"github.com/Avalanche-io/c4/store"
+)
+
+func statsManifest() *Manifest {
+	t0 := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	a := c4.Identify(strings.NewReader("aaaa"))
+	b := c4.Identify(strings.NewReader("bb"))
+	m := NewManifest()
+	m.AddEntry(&Entry{Name: "docs/", Mode: 0755 | 1<<31, Size: 6, Timestamp: t0})
+	m.AddEntry(&Entry{Name: "a.txt", Depth: 1, Mode: 0644, Size: 4, Timestamp: t0.Add(time.Hour), C4ID: a})
+	m.AddEntry(&Entry{Name: "copy.TXT", Depth: 1, Mode: 0644, Size: 4, Timestamp: t0.Add(2 * time.Hour), C4ID: a})
+	m.AddEntry(&Entry{Name: "deep/", Depth: 1, Mode: 0755 | 1<<31, Size: 2, Timestamp: t0})
+	m.AddEntry(&Entry{Name: "b", Depth: 2, Mode: 0644, Size: 2, Timestamp: NullTimestamp(), C4ID: b})
+	m.AddEntry(&Entry{Name: "empty.log", Mode: 0, Size: 0, Timestamp: t0.Add(-time.Hour)})
+	return m
+}
+
+func TestStats(t *testing.T) {
+	st := Stats(statsManifest())
+
+	if st.Entries != 6 || st.Files != 4 || st.Dirs != 2 || st.MaxDepth != 2 {
+		t.Fatalf("counts: %+v", st)
+	}
+	if st.TotalBytes != 10 || st.UniqueBytes != 6 || st.UniqueIDs != 2 {
+		t.Errorf("bytes: total %d unique %d ids %d", st.TotalBytes, st.UniqueBytes, st.UniqueIDs)
+	}
+	if st.DedupRatio < 1.66 || st.DedupRatio > 1.67 {
+		t.Errorf("dedup ratio %f", st.DedupRatio)
+	}
+	if st.OldestPath != "empty.log" || st.NewestPath != "docs/copy.TXT" {
+		t.Errorf("oldest %q newest %q", st.OldestPath, st.NewestPath)
+	}
+	if st.Nulls.Entries != 2 || st.Nulls.Mode != 1 || st.Nulls.Timestamp != 1 || st.Nulls.C4ID != 1 {
+		t.Errorf("nulls: %+v", st.Nulls)
+	}
+	if h := st.SizeHistogram[0]; h.Files != 1 || h.Bytes != 0 {
+		t.Errorf("empty bucket: %+v", h)
+	}
+	if h := st.SizeHistogram[1]; h.Files != 3 || h.Bytes != 10 {
+		t.Errorf("small bucket: %+v", h)
+	}
+	if len(st.Extensions) == 0 || st.Extensions[0].Ext != "txt" || st.Extensions[0].Files != 2 {
+		t.Errorf("extensions: %+v", st.Extensions)
+	}
+	if len(st.Deepest) == 0 || st.Deepest[0].Path != "docs/deep/b" {
+		t.Errorf("deepest: %+v", st.Deepest)
+	}
+	if len(st.LargestDirs) != 2 || st.LargestDirs[0] != (DirSize{Path: "docs/", Files: 3, Bytes: 10}) {
+		t.Errorf("largest dirs: %+v", st.LargestDirs)
+	}
+}
+
+func TestStoreCoverage(t *testing.T) {
+	m := statsManifest()
+	s := store.NewRAM()
+	if _, err := s.Put(strings.NewReader("aaaa")); err != nil {
+		t.Fatal(err)
+	}
+	cov := StoreCoverage(m, s)
+	want := Coverage{StoredIDs: 1, StoredBytes: 4, MissingIDs: 1, MissingBytes: 2}
+	if *cov != want {
+		t.Errorf("coverage = %+v, want %+v", *cov, want)
+	}
+}
+
+func TestStoreCoverageChunked(t *testing.T) {
+	ram := store.NewRAM()
+	s, err := store.NewChunkingSize(ram, 1<<10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v1 := make([]byte, 64<<10)
+	rand.New(rand.NewSource(1)).Read(v1)
+	v2 := append(append([]byte(nil), v1...), "appended"...)
+
+	m := NewManifest()
+	for i, data := range [][]byte{v1, v2} {
+		id, err := s.Put(bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		m.AddEntry(&Entry{Name: fmt.Sprintf("v%d.bin", i+1), Mode: 0644, Size: int64(len(data)), C4ID: id})
+	}
+
+	cov := StoreCoverage(m, s)
+	if cov.ChunkedIDs != 2 || cov.ChunkedBytes != int64(len(v1)+len(v2)) {
+		t.Fatalf("coverage = %+v", *cov)
+	}
+	// The second version shares all but its last chunk with the first.
+	if saved := cov.ChunkedBytes - cov.ChunkBytes; saved < int64(len(v1))*3/4 {
+		t.Errorf("chunk dedup saved %d bytes, want most of %d", saved, len(v1))
+	}
+}
@@ -0,0 +1,221 @@
+package c4m
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// Resolution is the outcome a Strategy chooses for a merge conflict.
+type Resolution int
+
+const (
+	// KeepBoth keeps both versions: the newer one at the original path and
+	// the other as "{name}.conflict". This is Merge's default behavior.
+	KeepBoth Resolution = iota
+	// KeepLocal keeps the local version. If local deleted the entry, the
+	// entry is removed.
+	KeepLocal
+	// KeepRemote keeps the remote version. If remote deleted the entry,
+	// the entry is removed.
+	KeepRemote
+)
+
+func (r Resolution) String() string {
+	switch r {
+	case KeepLocal:
+		return "local"
+	case KeepRemote:
+		return "remote"
+	default:
+		return "both"
+	}
+}
+
+// Strategy decides how a merge conflict is resolved. Returning KeepBoth
+// defers to the default conflict handling.
+type Strategy interface {
+	Resolve(c Conflict) Resolution
+}
+
+// StrategyFunc adapts an ordinary function to the Strategy interface, for
+// custom resolution callbacks.
+type StrategyFunc func(c Conflict) Resolution
+
+// Resolve calls f(c).
+func (f StrategyFunc) Resolve(c Conflict) Resolution { return f(c) }
+
+// namedStrategy is a built-in Strategy that reports its name in conflict
+// decisions.
+type namedStrategy struct {
+	name string
+	fn   func(c Conflict) Resolution
+}
+
+func (s namedStrategy) Resolve(c Conflict) Resolution { return s.fn(c) }
+func (s namedStrategy) String() string                { return s.name }
+
+// Built-in conflict resolution strategies. Newest, Largest and NonNull
+// return KeepBoth when the two sides tie.
+var (
+	// Ours always keeps the local version.
+	Ours Strategy = namedStrategy{"ours", func(Conflict) Resolution { return KeepLocal }}
+
+	// Theirs always keeps the remote version.
+	Theirs Strategy = namedStrategy{"theirs", func(Conflict) Resolution { return KeepRemote }}
+
+	// Newest keeps the version with the later timestamp. A deletion has no
+	// timestamp, so the surviving version wins.
+	Newest Strategy = namedStrategy{"newest", resolveNewest}
+
+	// Largest keeps the version with the larger size. A deletion counts as
+	// smaller than any entry.
+	Largest Strategy = namedStrategy{"largest", resolveLargest}
+
+	// NonNull keeps the version with no null metadata fields, so complete
+	// entries win over partial ones (e.g. structure-only scans).
+	NonNull Strategy = namedStrategy{"nonnull", resolveNonNull}
+)
+
+// ParseStrategy returns the built-in strategy with the given name: "ours",
+// "theirs", "newest", "largest", "nonnull" or "both".
+func ParseStrategy(name string) (Strategy, error) {
+	switch strings.ToLower(name) {
+	case "ours", "local":
+		return Ours, nil
+	case "theirs", "remote":
+		return Theirs, nil
+	case "newest":
+		return Newest, nil
+	case "largest":
+		return Largest, nil
+	case "nonnull", "non-null":
+		return NonNull, nil
+	case "both":
+		return nil, nil
+	}
+	return nil, fmt.Errorf("unknown merge strategy %q", name)
+}
+
+// strategyName returns the name recorded in conflict decisions.
+func strategyName(s Strategy) string {
+	if s == nil {
+		return ""
+	}
+	if ns, ok := s.(fmt.Stringer); ok {
+		return ns.String()
+	}
+	return "custom"
+}
+
+func resolveNewest(c Conflict) Resolution {
+	l, r := c.LocalEntry, c.RemoteEntry
+	switch {
+	case l == nil:
+		return KeepRemote
+	case r == nil:
+		return KeepLocal
+	case l.Timestamp.After(r.Timestamp):
+		return KeepLocal
+	case r.Timestamp.After(l.Timestamp):
+		return KeepRemote
+	}
+	return KeepBoth
+}
+
+func resolveLargest(c Conflict) Resolution {
+	ls, rs := int64(-2), int64(-2)
+	if c.LocalEntry != nil {
+		ls = c.LocalEntry.Size
+	}
+	if c.RemoteEntry != nil {
+		rs = c.RemoteEntry.Size
+	}
+	switch {
+	case ls > rs:
+		return KeepLocal
+	case rs > ls:
+		return KeepRemote
+	}
+	return KeepBoth
+}
+
+func resolveNonNull(c Conflict) Resolution {
+	lok := c.LocalEntry != nil && !c.LocalEntry.HasNullValues()
+	rok := c.RemoteEntry != nil && !c.RemoteEntry.HasNullValues()
+	switch {
+	case lok && !rok:
+		return KeepLocal
+	case rok && !lok:
+		return KeepRemote
+	}
+	return KeepBoth
+}
+
+// MergeOption configures Merge.
+type MergeOption func(*mergeConfig)
+
+type mergeConfig struct {
+	rules    []strategyRule
+	fallback Strategy
+	paths    pathConfig
+}
+
+// strategyRule binds a Strategy to a path pattern.
+type strategyRule struct {
+	pattern  string
+	strategy Strategy
+}
+
+// WithStrategy sets the strategy used for conflicts that no
+// WithPathStrategy pattern matches.
+func WithStrategy(s Strategy) MergeOption {
+	return func(c *mergeConfig) {
+		c.fallback = s
+	}
+}
+
+// WithPathStrategy uses s for conflicts whose path matches pattern. Patterns
+// are tried in the order given and the first match wins. A pattern ending
+// in "/" matches everything under that directory; a pattern without "/"
+// matches the base name (e.g. "*.exr"); any other pattern is matched
+// against the full path with path.Match.
+func WithPathStrategy(pattern string, s Strategy) MergeOption {
+	return func(c *mergeConfig) {
+		c.rules = append(c.rules, strategyRule{pattern, s})
+	}
+}
+
+// WithPathOptions sets how Merge matches paths across the three
+// manifests. With NormalizedPaths, NFC and NFD spellings of a path are
+// merged as one entry, which keeps the local spelling (or the remote one
+// if only remote has it).
+func WithPathOptions(opts ...PathOption) MergeOption {
+	return func(c *mergeConfig) {
+		c.paths = newPathConfig(opts)
+	}
+}
+
+// strategyFor returns the strategy that applies to p, or nil.
+func (c *mergeConfig) strategyFor(p string) Strategy {
+	for _, r := range c.rules {
+		if matchStrategyPattern(r.pattern, p) {
+			return r.strategy
+		}
+	}
+	return c.fallback
+}
+
+// matchStrategyPattern implements the pattern rules of WithPathStrategy.
+func matchStrategyPattern(pattern, p string) bool {
+	if strings.HasSuffix(pattern, "/") {
+		return strings.HasPrefix(p, pattern)
+	}
+	clean := strings.TrimSuffix(p, "/")
+	if !strings.Contains(pattern, "/") {
+		ok, _ := path.Match(pattern, path.Base(clean))
+		return ok
+	}
+	ok, _ := path.Match(pattern, clean)
+	return ok
+}
@@ -0,0 +1,157 @@
+package c4m
+
+import (
+	"testing"
+	"time"
+)
+
+// conflictFixture returns base/local/remote manifests where doc.txt was
+// modified on both sides (local newer and smaller) and gone.txt was
+// modified locally but deleted remotely.
+func conflictFixture() (base, local, remote *Manifest) {
+	ts := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	base = makeTestManifest(
+		&Entry{Mode: 0644, Name: "doc.txt", Size: 10, C4ID: testID("base"), Timestamp: ts},
+		&Entry{Mode: 0644, Name: "gone.txt", Size: 10, C4ID: testID("gone"), Timestamp: ts},
+	)
+	local = makeTestManifest(
+		&Entry{Mode: 0644, Name: "doc.txt", Size: 5, C4ID: testID("local"), Timestamp: ts.Add(2 * time.Hour)},
+		&Entry{Mode: 0644, Name: "gone.txt", Size: 11, C4ID: testID("gone-edit"), Timestamp: ts.Add(time.Hour)},
+	)
+	remote = makeTestManifest(
+		&Entry{Mode: 0644, Name: "doc.txt", Size: 50, C4ID: testID("remote"), Timestamp: ts.Add(time.Hour)},
+	)
+	return
+}
+
+func TestMergeStrategies(t *testing.T) {
+	tests := []struct {
+		name     string
+		strategy Strategy
+		doc      string // expected doc.txt content ID source
+		gone     bool   // expect gone.txt to survive
+	}{
+		{"ours", Ours, "local", true},
+		{"theirs", Theirs, "remote", false},
+		{"newest", Newest, "local", true},
+		{"largest", Largest, "remote", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			base, local, remote := conflictFixture()
+			merged, conflicts, err := Merge(base, local, remote, WithStrategy(tt.strategy))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(conflicts) != 2 {
+				t.Fatalf("expected 2 conflicts in report, got %d", len(conflicts))
+			}
+			for _, c := range conflicts {
+				if c.Strategy != tt.name {
+					t.Errorf("%s: strategy %q, want %q", c.Path, c.Strategy, tt.name)
+				}
+			}
+
+			paths := EntryPaths(merged.Entries)
+			if paths["doc.txt"] == nil || paths["doc.txt"].C4ID != testID(tt.doc) {
+				t.Errorf("doc.txt should be the %s version", tt.doc)
+			}
+			if _, ok := paths["doc.txt.conflict"]; ok {
+				t.Error("resolved conflict should not leave a .conflict entry")
+			}
+			if _, ok := paths["gone.txt"]; ok != tt.gone {
+				t.Errorf("gone.txt present = %v, want %v", ok, tt.gone)
+			}
+		})
+	}
+}
+
+func TestMergeStrategyNonNull(t *testing.T) {
+	base := makeTestManifest(&Entry{Mode: 0644, Name: "a.txt", Size: 1, C4ID: testID("a"), Timestamp: time.Unix(100, 0).UTC()})
+	local := makeTestManifest(&Entry{Mode: 0644, Name: "a.txt", Size: -1, C4ID: testID("l"), Timestamp: NullTimestamp()})
+	remote := makeTestManifest(&Entry{Mode: 0644, Name: "a.txt", Size: 2, C4ID: testID("r"), Timestamp: time.Unix(200, 0).UTC()})
+
+	merged, conflicts, err := Merge(base, local, remote, WithStrategy(NonNull))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(conflicts) != 1 || conflicts[0].Resolution != KeepRemote {
+		t.Fatalf("expected remote resolution, got %+v", conflicts)
+	}
+	if e := EntryPaths(merged.Entries)["a.txt"]; e == nil || e.C4ID != testID("r") {
+		t.Fatal("a.txt should be the complete remote version")
+	}
+}
+
+func TestMergePathStrategy(t *testing.T) {
+	base, local, remote := conflictFixture()
+
+	var seen []string
+	custom := StrategyFunc(func(c Conflict) Resolution {
+		seen = append(seen, c.Path)
+		return KeepBoth
+	})
+
+	merged, conflicts, err := Merge(base, local, remote,
+		WithPathStrategy("doc.*", Theirs),
+		WithStrategy(custom),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	byPath := make(map[string]Conflict)
+	for _, c := range conflicts {
+		byPath[c.Path] = c
+	}
+	if c := byPath["doc.txt"]; c.Strategy != "theirs" || c.Resolution != KeepRemote {
+		t.Errorf("doc.txt: got %s/%s, want theirs/remote", c.Strategy, c.Resolution)
+	}
+	if c := byPath["gone.txt"]; c.Strategy != "custom" || c.Resolution != KeepBoth {
+		t.Errorf("gone.txt: got %s/%s, want custom/both", c.Strategy, c.Resolution)
+	}
+	if len(seen) != 1 || seen[0] != "gone.txt" {
+		t.Errorf("custom strategy called for %v, want [gone.txt]", seen)
+	}
+	if _, ok := EntryPaths(merged.Entries)["gone.txt"]; !ok {
+		t.Error("KeepBoth on delete-vs-modify should keep the surviving version")
+	}
+}
+
+func TestMatchStrategyPattern(t *testing.T) {
+	tests := []struct {
+		pattern, path string
+		want          bool
+	}{
+		{"*.exr", "shots/a/frame.0001.exr", true},
+		{"*.exr", "shots/a/frame.exr.bak", false},
+		{"shots/", "shots/a/frame.exr", true},
+		{"shots/", "plates/a.exr", false},
+		{"shots/*/edit.txt", "shots/a/edit.txt", true},
+		{"shots/*/edit.txt", "shots/a/b/edit.txt", false},
+		{"cache", "render/cache/", true},
+	}
+	for _, tt := range tests {
+		if got := matchStrategyPattern(tt.pattern, tt.path); got != tt.want {
+			t.Errorf("match(%q, %q) = %v, want %v", tt.pattern, tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestParseStrategy(t *testing.T) {
+	for _, name := range []string{"ours", "theirs", "newest", "largest", "nonnull"} {
+		s, err := ParseStrategy(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strategyName(s) != name {
+			t.Errorf("ParseStrategy(%q) named %q", name, strategyName(s))
+		}
+	}
+	if s, err := ParseStrategy("both"); err != nil || s != nil {
+		t.Errorf("both: got %v, %v", s, err)
+	}
+	if _, err := ParseStrategy("bogus"); err == nil {
+		t.Error("expected error for unknown strategy")
+	}
+}
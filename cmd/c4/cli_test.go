@@ -1,12 +1,19 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
+	"encoding/json"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"runtime"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 )
@@ -460,6 +467,169 @@ func TestMergeMixed(t *testing.T) {
 	}
 }
 
+func TestMergeStrategy(t *testing.T) {
+	bin := buildC4(t)
+	dir := t.TempDir()
+
+	dir1 := filepath.Join(dir, "a")
+	dir2 := filepath.Join(dir, "b")
+	os.MkdirAll(dir1, 0755)
+	os.MkdirAll(dir2, 0755)
+	os.WriteFile(filepath.Join(dir1, "same.txt"), []byte("from a"), 0644)
+	os.WriteFile(filepath.Join(dir2, "same.txt"), []byte("from b, longer"), 0644)
+
+	if _, _, code := runC4(t, bin, "merge", dir1, dir2); code == 0 {
+		t.Fatal("expected conflict to fail without a strategy")
+	}
+
+	out, stderr, code := runC4(t, bin, "merge", "--strategy", "*.txt=largest", dir1, dir2)
+	if code != 0 {
+		t.Fatalf("exit %d: %s", code, stderr)
+	}
+	if !strings.Contains(stderr, "resolved: same.txt (largest: keep remote)") {
+		t.Fatalf("expected resolution report, got: %s", stderr)
+	}
+	if !strings.Contains(out, " 14 same.txt ") || strings.Contains(out, ".conflict") {
+		t.Fatalf("expected only the larger same.txt: %s", out)
+	}
+}
+
+func TestMergeNormalize(t *testing.T) {
+	bin := buildC4(t)
+	dir := t.TempDir()
+
+	a := filepath.Join(dir, "a.c4m")
+	b := filepath.Join(dir, "b.c4m")
+	os.WriteFile(a, []byte("-rw-r--r-- 2025-01-01T00:00:00Z 3 caf\u00e9.txt -\n"), 0644)
+	os.WriteFile(b, []byte("-rw-r--r-- 2025-01-01T00:00:00Z 3 cafe\u0301.txt -\n"), 0644)
+
+	out, _, code := runC4(t, bin, "merge", a, b)
+	if code != 0 || !strings.Contains(out, "cafe\u0301.txt") {
+		t.Fatalf("without -u both spellings should be kept (exit %d): %q", code, out)
+	}
+	out, stderr, code := runC4(t, bin, "merge", "-u", a, b)
+	if code != 0 {
+		t.Fatalf("exit %d: %s", code, stderr)
+	}
+	if !strings.Contains(out, "caf\u00e9.txt") || strings.Contains(out, "cafe\u0301.txt") {
+		t.Fatalf("expected one entry under the first spelling: %q", out)
+	}
+}
+
+func TestMergeChain(t *testing.T) {
+	bin := buildC4(t)
+	dir := t.TempDir()
+
+	projectDir := filepath.Join(dir, "project")
+	os.MkdirAll(projectDir, 0755)
+	os.WriteFile(filepath.Join(projectDir, "shared.txt"), []byte("v1"), 0644)
+	base, _, _ := runC4(t, bin, "id", projectDir)
+	basePath := filepath.Join(dir, "base.c4m")
+	os.WriteFile(basePath, []byte(base), 0644)
+
+	// Each site appends its own patch to a copy of the shared chain.
+	branch := func(name, file string) string {
+		os.WriteFile(filepath.Join(projectDir, file), []byte(name), 0644)
+		state, _, _ := runC4(t, bin, "id", projectDir)
+		os.Remove(filepath.Join(projectDir, file))
+		statePath := filepath.Join(dir, name+"-state.c4m")
+		os.WriteFile(statePath, []byte(state), 0644)
+		diff, _, _ := runC4(t, bin, "diff", basePath, statePath)
+		chainPath := filepath.Join(dir, name+".c4m")
+		os.WriteFile(chainPath, []byte(base+diff), 0644)
+		return chainPath
+	}
+	a := branch("a", "from_a.txt")
+	b := branch("b", "from_b.txt")
+
+	out, stderr, code := runC4(t, bin, "merge", "--chain", a, b)
+	if code != 0 {
+		t.Fatalf("exit %d: %s", code, stderr)
+	}
+	mergedPath := filepath.Join(dir, "merged.c4m")
+	os.WriteFile(mergedPath, []byte(out), 0644)
+
+	resolved, _, code := runC4(t, bin, "patch", mergedPath)
+	if code != 0 {
+		t.Fatalf("patch exit %d", code)
+	}
+	for _, name := range []string{"shared.txt", "from_a.txt", "from_b.txt"} {
+		if !strings.Contains(resolved, name) {
+			t.Fatalf("expected %s in resolved merge: %s", name, resolved)
+		}
+	}
+
+	log, _, _ := runC4(t, bin, "log", mergedPath)
+	if n := len(strings.Split(strings.TrimSpace(log), "\n")); n != 3 {
+		t.Fatalf("expected base, local patch and merge patch, got %d: %s", n, log)
+	}
+}
+
+func TestLint(t *testing.T) {
+	bin := buildC4(t)
+	dir := t.TempDir()
+
+	srcDir := filepath.Join(dir, "src")
+	os.MkdirAll(srcDir, 0755)
+	os.WriteFile(filepath.Join(srcDir, "aux.txt"), []byte("a"), 0644)
+	os.WriteFile(filepath.Join(srcDir, "what?.txt"), []byte("b"), 0644)
+	os.WriteFile(filepath.Join(srcDir, "fine.txt"), []byte("c"), 0644)
+
+	out, _, code := runC4(t, bin, "lint", "-t", "windows", srcDir)
+	if code != 1 {
+		t.Fatalf("expected exit 1 with issues, got %d", code)
+	}
+	if !strings.Contains(out, `aux.txt: reserved-name`) || !strings.Contains(out, `(rename to "what_.txt")`) {
+		t.Fatalf("unexpected lint output: %s", out)
+	}
+	if strings.Contains(out, "fine.txt") {
+		t.Fatalf("fine.txt should not be reported: %s", out)
+	}
+
+	// The rename patch, appended to the manifest, resolves to clean names.
+	base, _, _ := runC4(t, bin, "id", "-m", "s", srcDir)
+	basePath := filepath.Join(dir, "base.c4m")
+	os.WriteFile(basePath, []byte(base), 0644)
+	patch, _, _ := runC4(t, bin, "lint", "-p", "-t", "windows", basePath)
+	chainPath := filepath.Join(dir, "chain.c4m")
+	os.WriteFile(chainPath, []byte(base+patch), 0644)
+	fixed, stderr, code := runC4(t, bin, "patch", chainPath)
+	if code != 0 {
+		t.Fatalf("patch exit %d: %s", code, stderr)
+	}
+	fixedPath := filepath.Join(dir, "fixed.c4m")
+	os.WriteFile(fixedPath, []byte(fixed), 0644)
+	if out, _, code := runC4(t, bin, "lint", "-t", "windows", fixedPath); code != 0 {
+		t.Fatalf("renamed manifest still has issues: %s", out)
+	}
+
+	if _, stderr, code := runC4(t, bin, "lint", "--max-name", "4", srcDir); code == 0 || !strings.Contains(stderr, "--max-name") {
+		t.Fatalf("tiny --max-name accepted: exit %d: %s", code, stderr)
+	}
+}
+
+func TestIntersectPathNormalize(t *testing.T) {
+	bin := buildC4(t)
+	dir := t.TempDir()
+
+	a := filepath.Join(dir, "a.c4m")
+	b := filepath.Join(dir, "b.c4m")
+	os.WriteFile(a, []byte("-rw-r--r-- 2025-01-01T00:00:00Z 3 caf\u00e9.txt -\n"), 0644)
+	os.WriteFile(b, []byte("-rw-r--r-- 2025-01-01T00:00:00Z 3 cafe\u0301.txt -\n"), 0644)
+
+	out, _, _ := runC4(t, bin, "intersect", "path", a, b)
+	if strings.Contains(out, "caf") {
+		t.Fatalf("exact match should not find the NFD name: %s", out)
+	}
+	out, stderr, code := runC4(t, bin, "intersect", "path", "-u", a, b)
+	if code != 0 {
+		t.Fatalf("exit %d: %s", code, stderr)
+	}
+	if !strings.Contains(out, "cafe\u0301.txt") {
+		t.Fatalf("expected the NFD entry from b: %q", out)
+	}
+}
+
 func TestDiffDirectories(t *testing.T) {
 	bin := buildC4(t)
 	dir := t.TempDir()
@@ -832,3 +1002,711 @@ func TestCatRecursiveErgonomic(t *testing.T) {
 		t.Fatalf("cat -r -e should include root.txt: %s", catOut)
 	}
 }
+
+func TestStat(t *testing.T) {
+	bin := buildC4(t)
+	dir := t.TempDir()
+
+	srcDir := filepath.Join(dir, "src")
+	os.MkdirAll(filepath.Join(srcDir, "sub"), 0755)
+	os.WriteFile(filepath.Join(srcDir, "a.txt"), []byte("same"), 0644)
+	os.WriteFile(filepath.Join(srcDir, "sub", "b.txt"), []byte("same"), 0644)
+	os.WriteFile(filepath.Join(srcDir, "sub", "c.go"), []byte("other"), 0644)
+
+	out, stderr, code := runC4(t, bin, "stat", srcDir)
+	if code != 0 {
+		t.Fatalf("stat exit %d: %s", code, stderr)
+	}
+	for _, want := range []string{"Files:      3", "Dirs:       1", "Dedup ratio:  1.44x", ".txt", "sub/"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("missing %q in output:\n%s", want, out)
+		}
+	}
+
+	out, stderr, code = runC4(t, bin, "stat", "--json", srcDir)
+	if code != 0 {
+		t.Fatalf("stat --json exit %d: %s", code, stderr)
+	}
+	var st struct {
+		Files       int   `json:"files"`
+		TotalBytes  int64 `json:"total_bytes"`
+		UniqueBytes int64 `json:"unique_bytes"`
+	}
+	if err := json.Unmarshal([]byte(out), &st); err != nil {
+		t.Fatalf("invalid JSON: %v\n%s", err, out)
+	}
+	if st.Files != 3 || st.TotalBytes != 13 || st.UniqueBytes != 9 {
+		t.Errorf("unexpected stats: %+v", st)
+	}
+}
+
+func TestGC(t *testing.T) {
+	bin := buildC4(t)
+	dir := t.TempDir()
+	storeDir := filepath.Join(dir, "store")
+	env := map[string]string{"C4_STORE": storeDir}
+
+	srcDir := filepath.Join(dir, "src")
+	os.MkdirAll(filepath.Join(srcDir, "sub"), 0755)
+	os.WriteFile(filepath.Join(srcDir, "keep.txt"), []byte("keep me"), 0644)
+	os.WriteFile(filepath.Join(srcDir, "sub", "deep.txt"), []byte("deep"), 0644)
+	out, stderr, code := runC4WithEnv(t, bin, env, "id", "-s", srcDir)
+	if code != 0 {
+		t.Fatalf("id -s exit %d: %s", code, stderr)
+	}
+	rootPath := filepath.Join(dir, "root.c4m")
+	os.WriteFile(rootPath, []byte(out), 0644)
+
+	garbage := filepath.Join(dir, "garbage.txt")
+	os.WriteFile(garbage, []byte("unreferenced"), 0644)
+	gid, _, _ := runC4WithEnv(t, bin, env, "id", "-s", garbage)
+	gid = strings.Fields(gid)[len(strings.Fields(gid))-1]
+
+	// Fresh objects are protected by the default grace period.
+	out, _, code = runC4WithEnv(t, bin, env, "gc", "-n", rootPath)
+	if code != 0 || strings.Contains(out, gid) {
+		t.Fatalf("grace period should protect new garbage: %s", out)
+	}
+
+	out, _, code = runC4WithEnv(t, bin, env, "gc", "-n", "-g", "0s", rootPath)
+	if code != 0 || !strings.Contains(out, "Would remove "+gid) || strings.Count(out, "\n") != 1 {
+		t.Fatalf("dry run should list only the garbage: %s", out)
+	}
+	if _, _, code := runC4WithEnv(t, bin, env, "cat", gid); code != 0 {
+		t.Fatal("dry run removed content")
+	}
+
+	_, stderr, code = runC4WithEnv(t, bin, env, "gc", "-g", "0s", rootPath)
+	if code != 0 || !strings.Contains(stderr, "Removed 1 object") {
+		t.Fatalf("gc exit %d: %s", code, stderr)
+	}
+	if _, _, code := runC4WithEnv(t, bin, env, "cat", gid); code == 0 {
+		t.Fatal("garbage still in store")
+	}
+	for _, data := range []string{"keep me", "deep"} {
+		id, _, _ := runC4WithStdin(t, bin, data, "id")
+		if catOut, _, _ := runC4WithEnv(t, bin, env, "cat", strings.TrimSpace(id)); catOut != data {
+			t.Errorf("reachable content %q lost", data)
+		}
+	}
+}
+
+func TestFsck(t *testing.T) {
+	bin := buildC4(t)
+	dir := t.TempDir()
+	primary := filepath.Join(dir, "primary")
+	archive := filepath.Join(dir, "archive")
+
+	file := filepath.Join(dir, "precious.txt")
+	os.WriteFile(file, []byte("precious data"), 0644)
+	for _, storeDir := range []string{primary, archive} {
+		if _, stderr, code := runC4WithEnv(t, bin, map[string]string{"C4_STORE": storeDir}, "id", "-s", file); code != 0 {
+			t.Fatalf("id -s: %s", stderr)
+		}
+	}
+
+	// Rot the primary copy.
+	var stored string
+	filepath.Walk(primary, func(p string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() && strings.HasPrefix(info.Name(), "c4") {
+			stored = p
+		}
+		return nil
+	})
+	if stored == "" {
+		t.Fatal("stored object not found")
+	}
+	os.WriteFile(stored, []byte("precious dada"), 0644)
+
+	out, _, code := runC4WithEnv(t, bin, map[string]string{"C4_STORE": primary}, "fsck")
+	if code != 1 || !strings.Contains(out, "corrupt "+filepath.Base(stored)) {
+		t.Fatalf("expected corruption report, exit %d: %s", code, out)
+	}
+
+	env := map[string]string{"C4_STORE": primary + "," + archive}
+	out, stderr, code := runC4WithEnv(t, bin, env, "fsck", "-r")
+	if code != 0 || !strings.Contains(out, "(restored)") {
+		t.Fatalf("repair exit %d: %s %s", code, out, stderr)
+	}
+	if data, _ := os.ReadFile(stored); string(data) != "precious data" {
+		t.Fatalf("primary copy not restored: %q", data)
+	}
+}
+
+func TestPushPull(t *testing.T) {
+	bin := buildC4(t)
+	dir := t.TempDir()
+	local := filepath.Join(dir, "local")
+	remote := filepath.Join(dir, "remote")
+	env := map[string]string{"C4_STORE": local}
+
+	srcDir := filepath.Join(dir, "src")
+	os.MkdirAll(filepath.Join(srcDir, "sub"), 0755)
+	os.WriteFile(filepath.Join(srcDir, "a.txt"), []byte("push me"), 0644)
+	os.WriteFile(filepath.Join(srcDir, "sub", "b.txt"), []byte("and me"), 0644)
+	out, stderr, code := runC4WithEnv(t, bin, env, "id", "-s", srcDir)
+	if code != 0 {
+		t.Fatalf("id -s exit %d: %s", code, stderr)
+	}
+	rootPath := filepath.Join(dir, "root.c4m")
+	os.WriteFile(rootPath, []byte(out), 0644)
+
+	other := filepath.Join(dir, "other.txt")
+	os.WriteFile(other, []byte("stays local"), 0644)
+	otherID, _, _ := runC4WithEnv(t, bin, env, "id", "-s", other)
+	otherID = strings.Fields(otherID)[len(strings.Fields(otherID))-1]
+
+	out, _, code = runC4WithEnv(t, bin, env, "push", "-n", remote, rootPath)
+	if code != 0 || !strings.Contains(out, "Would copy") {
+		t.Fatalf("dry run: %s", out)
+	}
+	_, stderr, code = runC4WithEnv(t, bin, env, "push", remote, rootPath)
+	if code != 0 || !strings.Contains(stderr, "Copied") {
+		t.Fatalf("push exit %d: %s", code, stderr)
+	}
+	remoteEnv := map[string]string{"C4_STORE": remote}
+	for _, data := range []string{"push me", "and me"} {
+		id, _, _ := runC4WithStdin(t, bin, data, "id")
+		if catOut, _, _ := runC4WithEnv(t, bin, remoteEnv, "cat", strings.TrimSpace(id)); catOut != data {
+			t.Errorf("%q not pushed", data)
+		}
+	}
+	if _, _, code := runC4WithEnv(t, bin, remoteEnv, "cat", otherID); code == 0 {
+		t.Error("unreachable content was pushed")
+	}
+
+	// Pushing again copies nothing.
+	_, stderr, _ = runC4WithEnv(t, bin, env, "push", remote, rootPath)
+	if !strings.Contains(stderr, "Copied 0 objects") {
+		t.Errorf("second push: %s", stderr)
+	}
+
+	// Pull everything into a fresh store, resumably.
+	fresh := map[string]string{"C4_STORE": filepath.Join(dir, "fresh")}
+	logPath := filepath.Join(dir, "pull.log")
+	_, stderr, code = runC4WithEnv(t, bin, fresh, "pull", "-l", logPath, remote)
+	if code != 0 {
+		t.Fatalf("pull exit %d: %s", code, stderr)
+	}
+	id, _, _ := runC4WithStdin(t, bin, "and me", "id")
+	if catOut, _, _ := runC4WithEnv(t, bin, fresh, "cat", strings.TrimSpace(id)); catOut != "and me" {
+		t.Error("pull did not copy content")
+	}
+	_, stderr, _ = runC4WithEnv(t, bin, fresh, "pull", "-l", logPath, remote)
+	if !strings.Contains(stderr, "Copied 0 objects") {
+		t.Errorf("resumed pull: %s", stderr)
+	}
+
+	// A root the source cannot supply fails.
+	_, _, code = runC4WithEnv(t, bin, env, "pull", filepath.Join(dir, "empty"), otherID)
+	if code == 0 {
+		t.Error("pull of unknown root should fail")
+	}
+}
+
+func TestStatChunked(t *testing.T) {
+	bin := buildC4(t)
+	dir := t.TempDir()
+	env := map[string]string{"C4_STORE": filepath.Join(dir, "store"), "C4_CHUNKING": "4K"}
+
+	srcDir := filepath.Join(dir, "cache")
+	os.MkdirAll(srcDir, 0755)
+	v1 := make([]byte, 256<<10)
+	rand.New(rand.NewSource(1)).Read(v1)
+	v2 := append(append([]byte(nil), v1[:100<<10]...), v1[101<<10:]...)
+	os.WriteFile(filepath.Join(srcDir, "v1.bgeo"), v1, 0644)
+	os.WriteFile(filepath.Join(srcDir, "v2.bgeo"), v2, 0644)
+
+	out, stderr, code := runC4WithEnv(t, bin, env, "id", "-s", srcDir)
+	if code != 0 {
+		t.Fatalf("id -s exit %d: %s", code, stderr)
+	}
+	c4mPath := filepath.Join(dir, "cache.c4m")
+	os.WriteFile(c4mPath, []byte(out), 0644)
+
+	out, stderr, code = runC4WithEnv(t, bin, env, "stat", "-s", c4mPath)
+	if code != 0 || !strings.Contains(out, "Chunked:    2 IDs") {
+		t.Fatalf("stat exit %d: %s%s", code, out, stderr)
+	}
+
+	// Content reads back whole.
+	id, _, _ := runC4WithStdin(t, bin, string(v2), "id")
+	if catOut, _, _ := runC4WithEnv(t, bin, env, "cat", strings.TrimSpace(id)); catOut != string(v2) {
+		t.Error("chunked content did not read back")
+	}
+}
+
+func TestRepack(t *testing.T) {
+	bin := buildC4(t)
+	dir := t.TempDir()
+	env := map[string]string{"C4_STORE": "pack://" + filepath.Join(dir, "store")}
+
+	keepDir := filepath.Join(dir, "keep")
+	dropDir := filepath.Join(dir, "drop")
+	os.MkdirAll(keepDir, 0755)
+	os.MkdirAll(dropDir, 0755)
+	os.WriteFile(filepath.Join(keepDir, "a.txt"), []byte("keep me\n"), 0644)
+	os.WriteFile(filepath.Join(dropDir, "b.txt"), []byte("drop me\n"), 0644)
+
+	out, stderr, code := runC4WithEnv(t, bin, env, "id", "-s", keepDir)
+	if code != 0 {
+		t.Fatalf("id -s exit %d: %s", code, stderr)
+	}
+	keepC4m := filepath.Join(dir, "keep.c4m")
+	os.WriteFile(keepC4m, []byte(out), 0644)
+	if _, stderr, code := runC4WithEnv(t, bin, env, "id", "-s", dropDir); code != 0 {
+		t.Fatalf("id -s exit %d: %s", code, stderr)
+	}
+	if _, stderr, code := runC4WithEnv(t, bin, env, "gc", "-g", "0s", keepC4m); code != 0 {
+		t.Fatalf("gc exit %d: %s", code, stderr)
+	}
+
+	_, stderr, code = runC4WithEnv(t, bin, env, "repack")
+	if code != 0 || !strings.Contains(stderr, "Repacked") {
+		t.Fatalf("repack exit %d: %s", code, stderr)
+	}
+
+	keepID, _, _ := runC4WithStdin(t, bin, "keep me\n", "id")
+	if catOut, _, _ := runC4WithEnv(t, bin, env, "cat", strings.TrimSpace(keepID)); catOut != "keep me\n" {
+		t.Errorf("kept content after repack: %q", catOut)
+	}
+	dropID, _, _ := runC4WithStdin(t, bin, "drop me\n", "id")
+	if _, _, code := runC4WithEnv(t, bin, env, "cat", strings.TrimSpace(dropID)); code == 0 {
+		t.Error("collected content still readable after repack")
+	}
+
+	if _, _, code := runC4WithEnv(t, bin, map[string]string{"C4_STORE": filepath.Join(dir, "tree")}, "repack"); code == 0 {
+		t.Error("repack without a pack store succeeded")
+	}
+}
+
+func TestPin(t *testing.T) {
+	bin := buildC4(t)
+	dir := t.TempDir()
+	cacheDir := filepath.Join(dir, "cache")
+	env := map[string]string{"C4_STORE": "cache://" + cacheDir + "?size=1K," + filepath.Join(dir, "store")}
+
+	srcDir := filepath.Join(dir, "shot")
+	os.MkdirAll(srcDir, 0755)
+	os.WriteFile(filepath.Join(srcDir, "plate.exr"), bytes.Repeat([]byte("p"), 800), 0644)
+	os.WriteFile(filepath.Join(srcDir, "comp.nk"), bytes.Repeat([]byte("c"), 800), 0644)
+	out, stderr, code := runC4WithEnv(t, bin, env, "id", "-s", srcDir)
+	if code != 0 {
+		t.Fatalf("id -s exit %d: %s", code, stderr)
+	}
+	c4mPath := filepath.Join(dir, "shot.c4m")
+	os.WriteFile(c4mPath, []byte(out), 0644)
+
+	// Both files together are over the 1K budget, but pinned.
+	_, stderr, code = runC4WithEnv(t, bin, env, "pin", c4mPath)
+	if code != 0 || !strings.Contains(stderr, "Pinned 2 IDs") {
+		t.Fatalf("pin exit %d: %s", code, stderr)
+	}
+	cached := func() int64 {
+		var total int64
+		filepath.Walk(filepath.Join(cacheDir, "objects"), func(path string, info os.FileInfo, err error) error {
+			if err == nil && info.Mode().IsRegular() && !strings.HasPrefix(info.Name(), ".") {
+				total += info.Size()
+			}
+			return nil
+		})
+		return total
+	}
+	if n := cached(); n != 1600 {
+		t.Errorf("%d bytes cached after pinning, want 1600", n)
+	}
+
+	_, stderr, code = runC4WithEnv(t, bin, env, "pin", "-u", c4mPath)
+	if code != 0 || !strings.Contains(stderr, "Unpinned 2 IDs") {
+		t.Fatalf("pin -u exit %d: %s", code, stderr)
+	}
+	if n := cached(); n > 1024 {
+		t.Errorf("%d bytes cached after unpinning, over the 1K budget", n)
+	}
+
+	if _, _, code := runC4WithEnv(t, bin, map[string]string{"C4_STORE": filepath.Join(dir, "store")}, "pin", c4mPath); code == 0 {
+		t.Error("pin without a cache succeeded")
+	}
+}
+
+// fakeBucket is an S3 bucket in memory, for path-style requests. It stores
+// what is PUT and serves GET and HEAD, ignoring signatures.
+type fakeBucket struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+}
+
+func (b *fakeBucket) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch r.Method {
+	case "PUT":
+		data, _ := io.ReadAll(r.Body)
+		b.objects[r.URL.Path] = data
+	case "GET", "HEAD":
+		data, ok := b.objects[r.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write(data)
+	default:
+		w.WriteHeader(http.StatusNotImplemented)
+	}
+}
+
+func TestShare(t *testing.T) {
+	bin := buildC4(t)
+	bucket := &fakeBucket{objects: make(map[string][]byte)}
+	srv := httptest.NewServer(bucket)
+	defer srv.Close()
+	env := map[string]string{
+		"C4_STORE":              "s3://bucket/c4",
+		"C4_S3_ENDPOINT":        srv.URL,
+		"AWS_ACCESS_KEY_ID":     "AKID",
+		"AWS_SECRET_ACCESS_KEY": "SECRET",
+	}
+
+	dir := t.TempDir()
+	srcDir := filepath.Join(dir, "delivery")
+	os.MkdirAll(filepath.Join(srcDir, "sh 010"), 0755)
+	files := map[string]string{
+		"readme.txt":     "notes",
+		"sh 010/comp.nk": "comp",
+		"sh 010/copy.nk": "comp",
+	}
+	for p, content := range files {
+		os.WriteFile(filepath.Join(srcDir, p), []byte(content), 0644)
+	}
+	out, stderr, code := runC4WithEnv(t, bin, env, "id", "-s", srcDir)
+	if code != 0 {
+		t.Fatalf("id -s exit %d: %s", code, stderr)
+	}
+	c4mPath := filepath.Join(dir, "delivery.c4m")
+	os.WriteFile(c4mPath, []byte(out), 0644)
+
+	out, stderr, code = runC4WithEnv(t, bin, env, "share", "-t", "1h", c4mPath)
+	if code != 0 {
+		t.Fatalf("share exit %d: %s", code, stderr)
+	}
+	if !strings.Contains(stderr, "Shared 3 files") {
+		t.Errorf("share summary: %s", stderr)
+	}
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("share printed %d lines, want 3:\n%s", len(lines), out)
+	}
+	for _, line := range lines {
+		fields := strings.SplitN(line, " ", 3)
+		if len(fields) != 3 {
+			t.Fatalf("bad share line %q", line)
+		}
+		want, ok := files[fields[2]]
+		if !ok {
+			t.Errorf("unexpected path %q", fields[2])
+			continue
+		}
+		if !strings.Contains(fields[1], "X-Amz-Signature=") {
+			t.Errorf("%s: URL is not presigned: %s", fields[2], fields[1])
+		}
+		resp, err := http.Get(fields[1])
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if string(got) != want {
+			t.Errorf("%s: fetched %q, want %q", fields[2], got, want)
+		}
+	}
+
+	// Files with the same content share a URL.
+	out, _, code = runC4WithEnv(t, bin, env, "share", "-u", c4mPath)
+	if n := len(strings.Fields(out)); code != 0 || n != 2 {
+		t.Errorf("share -u exit %d, %d URLs; want 2", code, n)
+	}
+
+	if _, _, code := runC4WithEnv(t, bin, env, "share", "-t", "200h", c4mPath); code == 0 {
+		t.Error("share accepted a TTL over 7 days")
+	}
+
+	// A file missing from S3 is reported.
+	bucket.mu.Lock()
+	for p, data := range bucket.objects {
+		if string(data) == "notes" {
+			delete(bucket.objects, p)
+		}
+	}
+	bucket.mu.Unlock()
+	_, stderr, code = runC4WithEnv(t, bin, env, "share", c4mPath)
+	if code != 1 || !strings.Contains(stderr, "readme.txt") {
+		t.Errorf("share with a file missing: exit %d: %s", code, stderr)
+	}
+
+	if _, _, code := runC4WithEnv(t, bin, map[string]string{"C4_STORE": filepath.Join(dir, "store")}, "share", c4mPath); code == 0 {
+		t.Error("share without an S3 store succeeded")
+	}
+
+	// Links into an encrypted store would give ciphertext.
+	env["C4_KEY"] = strings.Repeat("ab", 32)
+	if _, stderr, code := runC4WithEnv(t, bin, env, "share", c4mPath); code == 0 || !strings.Contains(stderr, "encrypted") {
+		t.Errorf("share with an encrypted store: exit %d: %s", code, stderr)
+	}
+}
+
+func TestServe(t *testing.T) {
+	bin := buildC4(t)
+	storeDir := t.TempDir()
+
+	cmd := exec.Command(bin, "serve", "-a", "127.0.0.1:0", storeDir)
+	cmd.Env = append(os.Environ(), "C4_TOKEN=letmein")
+	// The address is printed to stderr; read it through the stdout pipe.
+	pipe, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd.Stderr = cmd.Stdout
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	}()
+	line, err := bufio.NewReader(pipe).ReadString('\n')
+	if err != nil {
+		t.Fatalf("reading the server's address: %v", err)
+	}
+	i := strings.Index(line, "http://")
+	if !strings.HasPrefix(line, "Serving ") || i < 0 {
+		t.Fatalf("unexpected first line: %q", line)
+	}
+	url := strings.TrimSpace(line[i:])
+
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "plate.exr"), []byte("pixels"), 0644)
+	env := map[string]string{"C4_STORE": url, "C4_TOKEN": "letmein"}
+	out, errOut, code := runC4WithEnv(t, bin, env, "id", "-s", filepath.Join(dir, "plate.exr"))
+	if code != 0 {
+		t.Fatalf("id -s exit %d: %s", code, errOut)
+	}
+	id := strings.Fields(out)[len(strings.Fields(out))-1]
+	if !looksLikeC4ID(id) {
+		t.Fatalf("no ID in %q", out)
+	}
+
+	out, errOut, code = runC4WithEnv(t, bin, env, "cat", id)
+	if code != 0 || out != "pixels" {
+		t.Fatalf("cat exit %d: %q %s", code, out, errOut)
+	}
+	stored := false
+	filepath.Walk(storeDir, func(path string, info os.FileInfo, err error) error {
+		stored = stored || err == nil && info.Name() == id
+		return nil
+	})
+	if !stored {
+		t.Error("content not in the served store")
+	}
+
+	env["C4_TOKEN"] = "wrong"
+	if _, _, code = runC4WithEnv(t, bin, env, "cat", id); code == 0 {
+		t.Error("cat with the wrong token succeeded")
+	}
+}
+
+func TestIDIndex(t *testing.T) {
+	bin := buildC4(t)
+	dir := t.TempDir()
+	srcDir := filepath.Join(dir, "plates")
+	os.MkdirAll(filepath.Join(srcDir, "sh010"), 0755)
+	os.WriteFile(filepath.Join(srcDir, "sh010", "plate.exr"), []byte("pixels"), 0644)
+	os.WriteFile(filepath.Join(dir, "notes.txt"), []byte("notes"), 0644)
+	storeDir := filepath.Join(dir, "store")
+	env := map[string]string{"C4_STORE": storeDir + ",ref://" + filepath.Join(dir, "ref")}
+
+	out, stderr, code := runC4WithEnv(t, bin, env, "id", "--index", srcDir)
+	if code != 0 {
+		t.Fatalf("id --index exit %d: %s", code, stderr)
+	}
+	var plateID string
+	for _, line := range strings.Split(out, "\n") {
+		if strings.Contains(line, "plate.exr") {
+			f := strings.Fields(line)
+			plateID = f[len(f)-1]
+		}
+	}
+	if _, _, code := runC4WithEnv(t, bin, env, "id", "--index", filepath.Join(dir, "notes.txt")); code != 0 {
+		t.Fatal("id --index of a file failed")
+	}
+
+	out, stderr, code = runC4WithEnv(t, bin, env, "cat", plateID)
+	if code != 0 || out != "pixels" {
+		t.Fatalf("cat exit %d: %q %s", code, out, stderr)
+	}
+	notesID, _, _ := runC4WithStdin(t, bin, "notes", "id")
+	notesID = strings.TrimSpace(notesID)
+	if out, _, code = runC4WithEnv(t, bin, env, "cat", notesID); code != 0 || out != "notes" {
+		t.Errorf("cat of an indexed file: exit %d, %q", code, out)
+	}
+	if entries, _ := os.ReadDir(storeDir); len(entries) > 1 {
+		t.Error("indexing copied content into the store")
+	}
+
+	// A changed file is no longer served.
+	os.WriteFile(filepath.Join(srcDir, "sh010", "plate.exr"), []byte("repainted"), 0644)
+	if _, _, code = runC4WithEnv(t, bin, env, "cat", plateID); code == 0 {
+		t.Error("cat of a changed file succeeded")
+	}
+
+	// Without a ref:// store there is nothing to index into.
+	_, stderr, code = runC4WithEnv(t, bin, map[string]string{"C4_STORE": storeDir}, "id", "--index", srcDir)
+	if code == 0 || !strings.Contains(stderr, "ref://") {
+		t.Errorf("id --index without a reference store: exit %d, %s", code, stderr)
+	}
+}
+
+func TestIDStoreHardlink(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("hardlink ingest is not detected on Windows")
+	}
+	bin := buildC4(t)
+	dir := t.TempDir()
+	srcDir := filepath.Join(dir, "card")
+	os.MkdirAll(srcDir, 0755)
+	src := filepath.Join(srcDir, "A001.mov")
+	os.WriteFile(src, []byte("original footage"), 0644)
+	storeDir := filepath.Join(dir, "store")
+	env := map[string]string{"C4_STORE": storeDir, "C4_INGEST": "hardlink"}
+
+	if _, stderr, code := runC4WithEnv(t, bin, env, "id", "-s", srcDir); code != 0 {
+		t.Fatalf("id -s exit %d: %s", code, stderr)
+	}
+	id, _, _ := runC4WithStdin(t, bin, "original footage", "id")
+	id = strings.TrimSpace(id)
+	srcInfo, _ := os.Stat(src)
+	linked := false
+	filepath.Walk(storeDir, func(path string, info os.FileInfo, err error) error {
+		linked = linked || err == nil && info.Name() == id && os.SameFile(info, srcInfo)
+		return nil
+	})
+	if !linked {
+		t.Fatal("the file was not hardlinked into the store")
+	}
+
+	// A later change to the file is reported, not served.
+	os.WriteFile(src, []byte("ORIGINAL FOOTAGE"), 0644)
+	_, stderr, code := runC4WithEnv(t, bin, env, "cat", id)
+	if code == 0 || !strings.Contains(stderr, "changed") {
+		t.Errorf("cat of a changed hardlink: exit %d, %s", code, stderr)
+	}
+
+	env["C4_INGEST"] = "symlink"
+	if _, stderr, _ := runC4WithEnv(t, bin, env, "id", "-s", srcDir); !strings.Contains(stderr, "unknown ingest mode") {
+		t.Errorf("an unknown ingest mode was accepted: %s", stderr)
+	}
+}
+
+func TestIDStoreMirrored(t *testing.T) {
+	bin := buildC4(t)
+	dir := t.TempDir()
+	src := filepath.Join(dir, "shots")
+	os.MkdirAll(src, 0755)
+	os.WriteFile(filepath.Join(src, "sh010.exr"), []byte("mirrored pixels"), 0644)
+	a, b := filepath.Join(dir, "disk1"), filepath.Join(dir, "disk2")
+	env := map[string]string{
+		"C4_STORE": a + " write=sync," + b + " write=sync queue=" + filepath.Join(dir, "queue"),
+	}
+
+	if _, stderr, code := runC4WithEnv(t, bin, env, "id", "-s", src); code != 0 {
+		t.Fatalf("id -s exit %d: %s", code, stderr)
+	}
+	id, _, _ := runC4WithStdin(t, bin, "mirrored pixels", "id")
+	id = strings.TrimSpace(id)
+	for _, storeDir := range []string{a, b} {
+		found := false
+		filepath.Walk(storeDir, func(path string, info os.FileInfo, err error) error {
+			found = found || err == nil && info.Name() == id
+			return nil
+		})
+		if !found {
+			t.Errorf("%s was not written", storeDir)
+		}
+	}
+
+	env["C4_STORE"] = a + " read=verify," + b + " read=fastest"
+	if _, stderr, code := runC4WithEnv(t, bin, env, "cat", id); code == 0 || !strings.Contains(stderr, "conflicts") {
+		t.Errorf("conflicting options: exit %d, %s", code, stderr)
+	}
+}
+
+func TestPushDrain(t *testing.T) {
+	bin := buildC4(t)
+	dir := t.TempDir()
+	src := filepath.Join(dir, "shots")
+	os.MkdirAll(src, 0755)
+	os.WriteFile(filepath.Join(src, "sh020.exr"), []byte("archived pixels"), 0644)
+	a, b := filepath.Join(dir, "disk"), filepath.Join(dir, "archive")
+	env := map[string]string{
+		"C4_STORE": a + "," + b + " write=async queue=" + filepath.Join(dir, "queue"),
+	}
+
+	if _, stderr, code := runC4WithEnv(t, bin, env, "id", "-s", src); code != 0 {
+		t.Fatalf("id -s exit %d: %s", code, stderr)
+	}
+	_, stderr, code := runC4WithEnv(t, bin, env, "push", "--drain")
+	if code != 0 || !strings.Contains(stderr, "0 objects left queued") {
+		t.Fatalf("push --drain exit %d: %s", code, stderr)
+	}
+	id, _, _ := runC4WithStdin(t, bin, "archived pixels", "id")
+	id = strings.TrimSpace(id)
+	found := false
+	filepath.Walk(b, func(path string, info os.FileInfo, err error) error {
+		found = found || err == nil && info.Name() == id
+		return nil
+	})
+	if !found {
+		t.Error("the async store was not written")
+	}
+
+	if _, _, code := runC4WithEnv(t, bin, env, "push", "--drain", b); code == 0 {
+		t.Error("push --drain accepted a destination")
+	}
+}
+
+func TestErasureRebuild(t *testing.T) {
+	bin := buildC4(t)
+	dir := t.TempDir()
+	src := filepath.Join(dir, "plates")
+	os.MkdirAll(src, 0755)
+	os.WriteFile(filepath.Join(src, "plate.exr"), []byte(strings.Repeat("erasure coded pixels ", 1000)), 0644)
+	vols := []string{filepath.Join(dir, "vol1"), filepath.Join(dir, "vol2"), filepath.Join(dir, "vol3")}
+	env := map[string]string{"C4_STORE": "erasure://?data=2&parity=1," + strings.Join(vols, ",")}
+
+	if _, stderr, code := runC4WithEnv(t, bin, env, "id", "-s", src); code != 0 {
+		t.Fatalf("id -s exit %d: %s", code, stderr)
+	}
+	id, _, _ := runC4WithStdin(t, bin, strings.Repeat("erasure coded pixels ", 1000), "id")
+	id = strings.TrimSpace(id)
+
+	// A volume is lost; content is still readable.
+	os.RemoveAll(vols[1])
+	out, stderr, code := runC4WithEnv(t, bin, env, "cat", id)
+	if code != 0 || out != strings.Repeat("erasure coded pixels ", 1000) {
+		t.Fatalf("cat with a volume lost: exit %d, %s", code, stderr)
+	}
+
+	if _, stderr, code = runC4WithEnv(t, bin, env, "rebuild", "2"); code != 0 || !strings.Contains(stderr, "Rebuilt") {
+		t.Fatalf("rebuild: exit %d, %s", code, stderr)
+	}
+	found := false
+	filepath.Walk(vols[1], func(path string, info os.FileInfo, err error) error {
+		found = found || err == nil && info.Name() == id
+		return nil
+	})
+	if !found {
+		t.Error("rebuild did not write the shard")
+	}
+	if _, stderr, code = runC4WithEnv(t, bin, env, "rebuild", "4"); code == 0 {
+		t.Errorf("rebuild of a backend that does not exist succeeded: %s", stderr)
+	}
+}
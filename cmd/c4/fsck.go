@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/Avalanche-io/c4/store"
+)
+
+func runFsck(args []string) {
+	fs := newFlags("fsck")
+	quarantine := fs.boolFlag("quarantine", 'q', false, "Move damaged objects into the store's .quarantine directory")
+	repair := fs.boolFlag("repair", 'r', false, "Restore damaged objects from other configured stores")
+	workers := fs.intFlag("workers", 'j', 0, "Objects to hash in parallel (default: number of CPUs)")
+	tempAge := fs.stringFlag("temp-age", 0, "1h", "Age at which temp files count as orphaned")
+	fs.parse(args)
+
+	if len(fs.args) != 0 {
+		fmt.Fprintf(os.Stderr, "Usage: c4 fsck [-q] [-r] [-j N] [--temp-age duration]\n")
+		fmt.Fprintf(os.Stderr, "\nRe-hash every object in the configured store and report corrupt,\n")
+		fmt.Fprintf(os.Stderr, "truncated, misnamed and orphaned temp files.\n")
+		fmt.Fprintf(os.Stderr, "  -q  Quarantine damaged objects\n")
+		fmt.Fprintf(os.Stderr, "  -r  Repair from other stores in C4_STORE\n")
+		os.Exit(1)
+	}
+
+	age, err := time.ParseDuration(*tempAge)
+	if err != nil || age <= 0 {
+		fatalf("Error: invalid temp age %q", *tempAge)
+	}
+
+	s, err := store.OpenStore()
+	if err != nil {
+		fatalf("Error opening store: %v", err)
+	}
+	if s == nil {
+		fatalf("Error: no content store configured.\nSet C4_STORE=/path/to/store or s3://bucket/prefix")
+	}
+
+	unfixed := 0
+	rep, err := store.FsckContext(rootContext(), s, store.FsckOptions{
+		Workers:    *workers,
+		Quarantine: *quarantine,
+		Repair:     *repair,
+		TempAge:    age,
+		OnProblem: func(p store.Problem) {
+			fmt.Println(p)
+			if !p.Fixed() {
+				unfixed++
+			}
+		},
+	})
+	if err != nil && rootContext().Err() == nil {
+		fatalf("Error: %v", err)
+	}
+
+	fmt.Fprintf(os.Stderr, "Checked %s (%s), %s found",
+		pluralize(rep.Checked, "object"), formatBytes(rep.Bytes), pluralize(len(rep.Problems), "problem"))
+	if fixed := len(rep.Problems) - unfixed; fixed > 0 {
+		fmt.Fprintf(os.Stderr, ", %d fixed", fixed)
+	}
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		fatalf("Interrupted")
+	}
+	if unfixed > 0 {
+		os.Exit(1)
+	}
+}
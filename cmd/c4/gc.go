@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/Avalanche-io/c4"
+	"github.com/Avalanche-io/c4/c4m"
+	"github.com/Avalanche-io/c4/store"
+)
+
+func runGC(args []string) {
+	fs := newFlags("gc")
+	dryRun := fs.boolFlag("dry-run", 'n', false, "Report what would be removed without removing it")
+	graceFlag := fs.stringFlag("grace", 'g', "24h", "Keep unreachable objects younger than this")
+	verbose := fs.boolFlag("verbose", 'v', false, "List each object removed")
+	fs.parse(args)
+
+	if len(fs.args) == 0 {
+		fmt.Fprintf(os.Stderr, "Usage: c4 gc [-n] [-g duration] [-v] <root>...\n")
+		fmt.Fprintf(os.Stderr, "\nRemove content not reachable from the given roots.\n")
+		fmt.Fprintf(os.Stderr, "Each root is a c4m file (every state in its patch chain is kept)\n")
+		fmt.Fprintf(os.Stderr, "or the C4 ID of a manifest in the store.\n")
+		fmt.Fprintf(os.Stderr, "  -n  Dry run: list what would be removed\n")
+		fmt.Fprintf(os.Stderr, "  -g  Grace period (default 24h); younger objects are kept\n")
+		os.Exit(1)
+	}
+
+	grace, err := time.ParseDuration(*graceFlag)
+	if err != nil || grace < 0 {
+		fatalf("Error: invalid grace period %q", *graceFlag)
+	}
+
+	s, err := store.OpenStore()
+	if err != nil {
+		fatalf("Error opening store: %v", err)
+	}
+	if s == nil {
+		fatalf("Error: no content store configured.\nSet C4_STORE=/path/to/store or s3://bucket/prefix")
+	}
+
+	mk := c4m.NewMarker(s)
+	for _, root := range fs.args {
+		if err := markRoot(mk, root); err != nil {
+			fatalf("Error marking %s: %v", root, err)
+		}
+	}
+
+	verb := "Removed"
+	if *dryRun {
+		verb = "Would remove"
+	}
+	opts := c4m.GCOptions{DryRun: *dryRun, GracePeriod: grace}
+	if *verbose || *dryRun {
+		opts.OnSweep = func(o store.Object) {
+			fmt.Printf("%s %s %s\n", verb, o.ID, formatBytes(o.Size))
+		}
+	}
+	rep, err := c4m.CollectGarbage(s, mk, opts)
+	if err != nil {
+		fatalf("Error: %v", err)
+	}
+
+	fmt.Fprintf(os.Stderr, "Marked %s", pluralize(rep.Live, "reachable ID"))
+	if rep.Missing > 0 {
+		fmt.Fprintf(os.Stderr, " (%d missing from store)", rep.Missing)
+	}
+	fmt.Fprintf(os.Stderr, "\nScanned %s\n", pluralize(rep.Scanned, "object"))
+	fmt.Fprintf(os.Stderr, "%s %s (%s)\n", verb, pluralize(rep.Swept, "object"), formatBytes(rep.SweptBytes))
+	if rep.Kept > 0 {
+		fmt.Fprintf(os.Stderr, "Kept %s (%s) younger than %s\n", pluralize(rep.Kept, "unreachable object"), formatBytes(rep.KeptBytes), grace)
+	}
+
+	// Incomplete multipart uploads hold storage too.
+	before := time.Now().Add(-grace)
+	for _, s3 := range store.S3Stores(s) {
+		var stale []store.Upload
+		if *dryRun {
+			uploads, err := s3.Uploads()
+			if err != nil {
+				fatalf("Error listing uploads: %v", err)
+			}
+			for _, u := range uploads {
+				if u.Initiated.Before(before) {
+					stale = append(stale, u)
+				}
+			}
+		} else if stale, err = s3.AbortUploads(before); err != nil {
+			fatalf("Error aborting uploads: %v", err)
+		}
+		if len(stale) > 0 {
+			verb := "Aborted"
+			if *dryRun {
+				verb = "Would abort"
+			}
+			fmt.Fprintf(os.Stderr, "%s %s\n", verb, pluralize(len(stale), "incomplete upload"))
+		}
+	}
+}
+
+// markRoot marks a c4m file on disk, or a C4 ID in the store.
+func markRoot(mk *c4m.Marker, root string) error {
+	if _, err := os.Stat(root); err != nil && looksLikeC4ID(root) {
+		id, err := c4.Parse(root)
+		if err != nil {
+			return err
+		}
+		return mk.MarkID(id)
+	}
+
+	data, err := os.ReadFile(root)
+	if err != nil {
+		return err
+	}
+	full, err := c4m.NewDecoder(bytes.NewReader(data)).Decode()
+	if err != nil {
+		return err
+	}
+	sections, err := c4m.DecodePatchChain(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	for _, m := range c4m.ChainStates(sections) {
+		// Inline ID lists are shared by the whole file.
+		m.RangeData = full.RangeData
+		if err := mk.MarkManifest(m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
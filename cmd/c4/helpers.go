@@ -99,6 +99,9 @@ func isDirectory(path string) bool {
 // from the reference. Only files with changed metadata are hashed.
 func guidedScan(dirPath string, ref *c4m.Manifest, mode scan.ScanMode) *c4m.Manifest {
 	refPaths := c4m.EntryPaths(ref.Entries)
+	// IDs are also reused across NFC/NFD spellings of a name, since files
+	// copied through macOS often come back decomposed.
+	refNorm := c4m.EntryPaths(ref.Entries, c4m.NormalizedPaths())
 
 	// Scan in metadata mode (fast — no hashing).
 	gen := scan.NewGeneratorWithOptions(scan.WithMode(scan.ModeMetadata))
@@ -117,6 +120,9 @@ func guidedScan(dirPath string, ref *c4m.Manifest, mode scan.ScanMode) *c4m.Mani
 			continue
 		}
 		refEntry, ok := refPaths[path]
+		if !ok {
+			refEntry, ok = refNorm[c4m.PathKey(path, c4m.NormalizedPaths())]
+		}
 		// Compare timestamps at second precision — c4m truncates to seconds.
 		if ok && !refEntry.C4ID.IsNil() &&
 			entry.Size == refEntry.Size &&
@@ -228,29 +234,48 @@ func identifyC4mFile(path string) (c4.ID, []byte) {
 // storeC4mAware stores content in the store with c4m canonicalization.
 // If the content is c4m, stores the canonical form. Returns the C4 ID.
 func storeC4mAware(s store.Store, path string) c4.ID {
-	data, err := os.ReadFile(path)
+	id, err := putFile(s, path)
 	if err != nil {
-		fatalf("Error reading %s: %v", path, err)
+		fatalf("Error storing %s: %v", path, err)
 	}
+	return id
+}
 
-	// Try c4m detection.
-	if strings.HasSuffix(path, ".c4m") || looksLikeC4m(data) {
-		canonical, _ := canonicalizeC4mBytes(data)
-		if canonical != nil {
-			id, err := s.Put(bytes.NewReader(canonical))
-			if err != nil {
-				fatalf("Error storing %s: %v", path, err)
-			}
-			return id
+// putFile stores the file at path, or the canonical form of c4m content.
+// Other files are passed to the store as files, without reading them into
+// memory, so a TreeStore can link rather than copy them (see C4_INGEST).
+func putFile(s store.Store, path string) (c4.ID, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return c4.ID{}, err
+	}
+	defer f.Close()
+
+	// The first non-blank line tells whether the content may be c4m.
+	head := make([]byte, 4096)
+	n, err := io.ReadFull(f, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return c4.ID{}, err
+	}
+	head = head[:n]
+	undecided := n == len(head) && len(bytes.Trim(head, " \n")) == 0
+	if strings.HasSuffix(path, ".c4m") || looksLikeC4m(head) || undecided {
+		rest, err := io.ReadAll(f)
+		if err != nil {
+			return c4.ID{}, err
 		}
+		data := append(head, rest...)
+		if canonical, _ := canonicalizeC4mBytes(data); canonical != nil {
+			return s.Put(bytes.NewReader(canonical))
+		}
+		return s.Put(bytes.NewReader(data))
 	}
 
-	// Not c4m — store raw bytes.
-	id, err := s.Put(bytes.NewReader(data))
-	if err != nil {
-		fatalf("Error storing %s: %v", path, err)
+	// Not c4m — store the file itself.
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return c4.ID{}, err
 	}
-	return id
+	return s.Put(f)
 }
 
 // storeContentC4mAware stores content from a reader in the store with c4m
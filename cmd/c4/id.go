@@ -8,6 +8,7 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/Avalanche-io/c4"
 	"github.com/Avalanche-io/c4/c4m"
@@ -25,6 +26,7 @@ func runID(args []string) {
 	excludeFileFlag := fs.stringFlag("exclude-file", 0, "", "File of exclude patterns (one per line)")
 	modeFlag := fs.stringFlag("mode", 'm', "f", "Scan mode: s/1=structure, m/2=metadata, f/3=full")
 	continueFlag := fs.stringFlag("continue", 'c', "", "Continue from existing c4m (use as guide)")
+	indexFlag := fs.boolFlag("index", 0, false, "Index files in place in the configured ref:// store")
 	fs.parse(args)
 
 	paths := fs.args
@@ -51,6 +53,14 @@ func runID(args []string) {
 		shouldStore = false
 	}
 
+	var ref *store.Reference
+	if *indexFlag {
+		if mode != scan.ModeFull {
+			fatalf("Error: --index needs the full scan mode")
+		}
+		ref = openReferenceStore()
+	}
+
 	// If -s is requested, ensure the store is configured before scanning.
 	// This prompts the user immediately rather than after a long scan.
 	if shouldStore {
@@ -83,7 +93,7 @@ func runID(args []string) {
 		}
 
 		if info.IsDir() {
-			m := scanDirectory(p, mode, *seqFlag, shouldStore, scanExcludes, excludeFile, guide)
+			m := scanDirectory(p, mode, *seqFlag, shouldStore, ref, scanExcludes, excludeFile, guide)
 			if !*quiet {
 				outputManifest(m, *ergonomic)
 			}
@@ -111,6 +121,9 @@ func runID(args []string) {
 
 		// Regular file → single-entry c4m
 		entry := identifyFile(p, info, mode, shouldStore)
+		if ref != nil {
+			indexFile(ref, p, entry.C4ID, info.Size(), info.ModTime())
+		}
 		combined.AddEntry(entry)
 	}
 
@@ -149,7 +162,7 @@ func doStdin(storeFlag bool) {
 	fmt.Println(id)
 }
 
-func scanDirectory(dirPath string, mode scan.ScanMode, seqFlag, shouldStore bool, excludes []string, excludeFile string, guide *c4m.Manifest) *c4m.Manifest {
+func scanDirectory(dirPath string, mode scan.ScanMode, seqFlag, shouldStore bool, ref *store.Reference, excludes []string, excludeFile string, guide *c4m.Manifest) *c4m.Manifest {
 	opts := []scan.GeneratorOption{scan.WithMode(mode)}
 	if seqFlag {
 		opts = append(opts, scan.WithSequenceDetection(true))
@@ -169,6 +182,11 @@ func scanDirectory(dirPath string, mode scan.ScanMode, seqFlag, shouldStore bool
 		fatalf("Error scanning %s: %v", dirPath, err)
 	}
 
+	// Index before storing, which may replace the IDs of c4m files with
+	// those of their canonical form.
+	if ref != nil {
+		indexManifestFiles(ref, manifest, dirPath)
+	}
 	if shouldStore {
 		storeManifestContent(manifest, dirPath)
 	}
@@ -176,6 +194,63 @@ func scanDirectory(dirPath string, mode scan.ScanMode, seqFlag, shouldStore bool
 	return manifest
 }
 
+// openReferenceStore returns the first Reference in the configured store.
+func openReferenceStore() *store.Reference {
+	s, err := store.OpenStore()
+	if err != nil {
+		fatalf("Error opening store: %v", err)
+	}
+	var refs []*store.Reference
+	if s != nil {
+		refs = store.References(s)
+	}
+	if len(refs) == 0 {
+		fatalf("Error: no reference store configured.\nAdd ref:///path/to/index to C4_STORE")
+	}
+	return refs[0]
+}
+
+// indexManifestFiles adds the files of a manifest scanned from baseDir to
+// ref.
+func indexManifestFiles(ref *store.Reference, manifest *c4m.Manifest, baseDir string) {
+	var dirStack []string
+	for _, entry := range manifest.Entries {
+		if entry.Depth < len(dirStack) {
+			dirStack = dirStack[:entry.Depth]
+		}
+		if entry.IsDir() {
+			for len(dirStack) <= entry.Depth {
+				dirStack = append(dirStack, "")
+			}
+			dirStack[entry.Depth] = entry.Name
+			continue
+		}
+		if entry.C4ID.IsNil() || !entry.Mode.IsRegular() {
+			continue
+		}
+		path := filepath.Join(baseDir, strings.Join(dirStack, "")+entry.Name)
+		indexFile(ref, path, entry.C4ID, entry.Size, entry.Timestamp)
+	}
+}
+
+// indexFile adds the file at path, identified as id when it had the given
+// size and modification time, to ref. A file that has changed since, or a
+// c4m file, whose ID may be that of its canonical form, is identified
+// again.
+func indexFile(ref *store.Reference, path string, id c4.ID, size int64, modTime time.Time) {
+	info, err := os.Stat(path)
+	if err == nil {
+		if !id.IsNil() && !strings.HasSuffix(path, ".c4m") && info.Size() == size && info.ModTime().Equal(modTime) {
+			err = ref.Add(path, id, info)
+		} else {
+			_, err = ref.Index(path)
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: failed to index %s: %v\n", path, err)
+	}
+}
+
 func identifyFile(path string, info os.FileInfo, mode scan.ScanMode, shouldStore bool) *c4m.Entry {
 	entry := &c4m.Entry{
 		Name: filepath.Base(path),
@@ -241,21 +316,10 @@ func storeManifestContent(manifest *c4m.Manifest, baseDir string) {
 
 		// Use c4m-aware storage: c4m files within directories get
 		// canonicalized before storing.
-		data, err := os.ReadFile(fullPath)
-		if err != nil {
+		if _, err := os.Stat(fullPath); err != nil {
 			continue // skip files we can't open
 		}
-		var storeData []byte
-		if strings.HasSuffix(entry.Name, ".c4m") || looksLikeC4m(data) {
-			canonical, _ := canonicalizeC4mBytes(data)
-			if canonical != nil {
-				storeData = canonical
-			}
-		}
-		if storeData == nil {
-			storeData = data
-		}
-		newID, err := s.Put(bytes.NewReader(storeData))
+		newID, err := putFile(s, fullPath)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "Warning: failed to store %s: %v\n", relPath, err)
 			continue
@@ -44,6 +44,8 @@ type Generator struct {
 	excludeFile     string // explicit exclude file path
 	excludeFileName string // filename to look for in scanned dirs (from env)
 	guide           map[string]bool // paths from guide c4m (nil = no guide)
+	guideSrc        *Manifest          // guide manifest, kept to rebuild the set
+	pathOpts        []c4m.PathOption   // how guide paths are matched
 	scanRoot        string
 }
 
@@ -119,12 +121,27 @@ func WithExcludeFile(path string) GeneratorOption {
 // scan-filter-continue workflow.
 func WithGuide(m *Manifest) GeneratorOption {
 	return func(g *Generator) {
-		g.guide = buildGuideSet(m)
+		g.guideSrc = m
+		g.guide = buildGuideSet(m, g.pathOpts...)
 	}
 }
 
-// buildGuideSet extracts all paths from a manifest into a lookup set.
-func buildGuideSet(m *Manifest) map[string]bool {
+// WithPathOptions sets how scanned paths are matched against the guide.
+// With c4m.NormalizedPaths, a file whose name is the NFD spelling of a
+// guide entry's NFC name (or vice versa) is still included. Scanned names
+// are always recorded with their on-disk bytes.
+func WithPathOptions(opts ...c4m.PathOption) GeneratorOption {
+	return func(g *Generator) {
+		g.pathOpts = opts
+		if g.guideSrc != nil {
+			g.guide = buildGuideSet(g.guideSrc, opts...)
+		}
+	}
+}
+
+// buildGuideSet extracts all paths from a manifest into a lookup set,
+// keyed by c4m.PathKey.
+func buildGuideSet(m *Manifest, opts ...c4m.PathOption) map[string]bool {
 	set := make(map[string]bool)
 	var dirStack []string
 	for _, entry := range m.Entries {
@@ -141,7 +158,7 @@ func buildGuideSet(m *Manifest) map[string]bool {
 		} else {
 			fullPath = entry.Name
 		}
-		set[fullPath] = true
+		set[c4m.PathKey(fullPath, opts...)] = true
 		if entry.IsDir() {
 			for len(dirStack) <= entry.Depth {
 				dirStack = append(dirStack, "")
@@ -171,6 +188,8 @@ func (g *Generator) clone() *Generator {
 		excludeFile:     g.excludeFile,
 		excludeFileName: g.excludeFileName,
 		guide:           g.guide,
+		guideSrc:        g.guideSrc,
+		pathOpts:        g.pathOpts,
 	}
 	if len(g.excludePatterns) > 0 {
 		clone.excludePatterns = make([]string, len(g.excludePatterns))
@@ -310,7 +329,7 @@ func (g *Generator) generateDir(manifest *Manifest, dirPath, dirName string, dep
 			if entry.IsDir() {
 				guideName += "/"
 			}
-			if !g.guide[guideName] {
+			if !g.guide[c4m.PathKey(guideName, g.pathOpts...)] {
 				continue
 			}
 		}
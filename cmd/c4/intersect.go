@@ -34,7 +34,7 @@ func intersectUsage() {
 
 Usage:
   c4 intersect id <a> <b>      Match by C4 ID (content identity)
-  c4 intersect path <a> <b>    Match by full path
+  c4 intersect path <a> <b>    Match by full path (-u: ignore NFC/NFD differences)
 
 Both arguments can be c4m files or directories.
 Output is a valid c4m from the second argument's perspective.
@@ -88,10 +88,11 @@ func runIntersectID(args []string) {
 func runIntersectPath(args []string) {
 	fs := newFlags("intersect path")
 	modeFlag := fs.stringFlag("mode", 'm', "f", "Scan mode for directories: s/m/f")
+	normalize := fs.boolFlag("normalize", 'u', false, "Match paths that differ only by Unicode normalization (NFC/NFD)")
 	fs.parse(args)
 
 	if len(fs.args) != 2 {
-		fmt.Fprintf(os.Stderr, "Usage: c4 intersect path <a> <b>\n")
+		fmt.Fprintf(os.Stderr, "Usage: c4 intersect path [-u] <a> <b>\n")
 		os.Exit(1)
 	}
 
@@ -103,8 +104,13 @@ func runIntersectPath(args []string) {
 	aManifest := resolveManifestOrDir(fs.args[0], mode)
 	bManifest := resolveManifestOrDir(fs.args[1], mode)
 
+	var popts []c4m.PathOption
+	if *normalize {
+		popts = append(popts, c4m.NormalizedPaths())
+	}
+
 	// Build set of full paths from manifest A.
-	aPaths := c4m.EntryPaths(aManifest.Entries)
+	aPaths := c4m.EntryPaths(aManifest.Entries, popts...)
 	aPathSet := make(map[string]bool, len(aPaths))
 	for p, e := range aPaths {
 		if e.IsDir() {
@@ -113,14 +119,15 @@ func runIntersectPath(args []string) {
 		aPathSet[p] = true
 	}
 
-	// Walk manifest B and collect entries whose path is in the set.
+	// Walk manifest B and collect entries whose path is in the set. B's
+	// spelling of each path is kept.
 	bPaths := c4m.EntryPaths(bManifest.Entries)
 	matchedPaths := make(map[string]*c4m.Entry)
 	for path, e := range bPaths {
 		if e.IsDir() {
 			continue
 		}
-		if aPathSet[path] {
+		if aPathSet[c4m.PathKey(path, popts...)] {
 			matchedPaths[path] = e
 		}
 	}
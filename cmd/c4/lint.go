@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/Avalanche-io/c4/c4m"
+	"github.com/Avalanche-io/c4/scan"
+)
+
+func runLint(args []string) {
+	fs := newFlags("lint")
+	targetFlag := fs.stringFlag("target", 't', "all", "Platforms to check: windows, macos, all (comma-separated)")
+	maxName := fs.intFlag("max-name", 0, 0, "Maximum name length in bytes (default 255)")
+	maxPath := fs.intFlag("max-path", 0, 0, "Maximum path length (default 260 Windows, 1024 macOS)")
+	patchFlag := fs.boolFlag("patch", 'p', false, "Output a patch applying the suggested renames")
+	ergonomic := fs.boolFlag("ergonomic", 'e', false, "Output ergonomic form")
+	modeFlag := fs.stringFlag("mode", 'm', "s", "Scan mode for directories: s/m/f")
+	fs.parse(args)
+
+	if len(fs.args) != 1 {
+		fmt.Fprintf(os.Stderr, "Usage: c4 lint [-t target] [--max-name N] [--max-path N] [-p] <c4m|dir>\n")
+		fmt.Fprintf(os.Stderr, "\nCheck names for portability to Windows and macOS.\n")
+		fmt.Fprintf(os.Stderr, "Reports reserved names and characters, trailing dots and spaces,\n")
+		fmt.Fprintf(os.Stderr, "case and Unicode normalization collisions, and length limits.\n")
+		fmt.Fprintf(os.Stderr, "  -p  Print issues to stderr and a rename patch to stdout\n")
+		os.Exit(1)
+	}
+
+	if *maxName != 0 && *maxName < c4m.MinMaxNameBytes {
+		fatalf("Error: --max-name must be at least %d", c4m.MinMaxNameBytes)
+	}
+	targets, err := c4m.ParseLintTarget(*targetFlag)
+	if err != nil {
+		fatalf("Error: %v", err)
+	}
+	mode, err := scan.ParseScanMode(*modeFlag)
+	if err != nil {
+		fatalf("Error: %v", err)
+	}
+
+	m := resolveManifestOrDir(fs.args[0], mode)
+	issues := c4m.Lint(m, c4m.LintOptions{
+		Targets: targets,
+		MaxName: *maxName,
+		MaxPath: *maxPath,
+	})
+
+	report := os.Stdout
+	if *patchFlag {
+		report = os.Stderr
+	}
+	for _, is := range issues {
+		fmt.Fprintln(report, is)
+	}
+
+	if *patchFlag {
+		outputDiff(m, c4m.ApplyLintRenames(m, issues), *ergonomic)
+	}
+	if len(issues) > 0 {
+		os.Exit(1)
+	}
+}
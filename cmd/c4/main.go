@@ -1,12 +1,37 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"os"
+	"os/signal"
+	"sync"
+	"syscall"
 )
 
 const version = "1.0.13"
 
+var (
+	rootOnce sync.Once
+	rootCtx  context.Context
+)
+
+// rootContext returns the context of a command that can stop cleanly part
+// way, such as patch or push. The first interrupt or SIGTERM cancels it; a
+// second one kills the process as usual. Commands that never call it are
+// killed by the first.
+func rootContext() context.Context {
+	rootOnce.Do(func() {
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		go func() {
+			<-ctx.Done()
+			stop()
+		}()
+		rootCtx = ctx
+	})
+	return rootCtx
+}
+
 func main() {
 	if len(os.Args) > 1 {
 		switch os.Args[1] {
@@ -37,6 +62,39 @@ func main() {
 		case "intersect":
 			runIntersect(os.Args[2:])
 			return
+		case "stat":
+			runStat(os.Args[2:])
+			return
+		case "gc":
+			runGC(os.Args[2:])
+			return
+		case "fsck":
+			runFsck(os.Args[2:])
+			return
+		case "pin":
+			runPin(os.Args[2:])
+			return
+		case "repack":
+			runRepack(os.Args[2:])
+			return
+		case "rebuild":
+			runRebuild(os.Args[2:])
+			return
+		case "push":
+			runPush(os.Args[2:])
+			return
+		case "pull":
+			runPull(os.Args[2:])
+			return
+		case "share":
+			runShare(os.Args[2:])
+			return
+		case "serve":
+			runServe(os.Args[2:])
+			return
+		case "lint":
+			runLint(os.Args[2:])
+			return
 		case "explain":
 			runExplain(os.Args[2:])
 			return
@@ -97,6 +155,18 @@ Usage:
   c4 paths [<file.c4m> | -]       Convert between c4m and path lists
   c4 intersect <id|path> <a> <b> Find common entries between c4m files
   c4 log <file.c4m>...            List patches in a chain
+  c4 lint [-t target] <c4m|dir>   Check names for Windows/macOS portability
+  c4 stat [--json] <c4m|dir>      Manifest statistics (sizes, dedup, histograms)
+  c4 gc [-n] <root>...            Remove store content unreachable from roots
+  c4 fsck [-q] [-r]               Verify every object in the store
+  c4 repack                       Compact pack files, dropping removed objects
+  c4 rebuild [-n] [-c] <backend>  Rebuild the shards of a replaced erasure backend
+  c4 pin [-u] <root>...           Keep reachable content in the local cache
+  c4 push <dest> [root...]        Copy missing content to another store
+  c4 push --drain                 Copy content queued for async stores
+  c4 pull <src> [root...]         Copy missing content from another store
+  c4 share [-t ttl] <c4m|id>...   Presigned S3 URLs for a delivery's files
+  c4 serve [-a addr] [<store>]    Serve a content store over HTTP
   c4 explain <command> [args]       Human-readable command narration
   c4 split <file.c4m> <N> <before.c4m> <after.c4m>
                                   Split chain at patch N
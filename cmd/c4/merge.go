@@ -1,8 +1,10 @@
 package main
 
 import (
+	"bytes"
 	"fmt"
 	"os"
+	"strings"
 
 	"github.com/Avalanche-io/c4/c4m"
 	"github.com/Avalanche-io/c4/scan"
@@ -12,15 +14,38 @@ func runMerge(args []string) {
 	fs := newFlags("merge")
 	ergonomic := fs.boolFlag("ergonomic", 'e', false, "Output ergonomic form")
 	modeFlag := fs.stringFlag("mode", 'm', "f", "Scan mode for directories: s/m/f")
+	chainFlag := fs.boolFlag("chain", 0, false, "Three-way merge two patch chains at their common ancestor")
+	strategyFlags := fs.stringArrayFlag("strategy", "Conflict strategy, or pattern=strategy (repeatable)")
+	normalize := fs.boolFlag("normalize", 'u', false, "Match paths that differ only by Unicode normalization (NFC/NFD)")
 	fs.parse(args)
 
 	if len(fs.args) < 2 {
-		fmt.Fprintf(os.Stderr, "Usage: c4 merge [-e] [-m mode] <path>...\n")
+		fmt.Fprintf(os.Stderr, "Usage: c4 merge [-e] [-u] [-m mode] <path>...\n")
+		fmt.Fprintf(os.Stderr, "       c4 merge --chain [-u] <a.c4m> <b.c4m>\n")
 		fmt.Fprintf(os.Stderr, "\nCombine two or more filesystem trees into one c4m.\n")
 		fmt.Fprintf(os.Stderr, "Each path can be a c4m file or a real directory.\n")
+		fmt.Fprintf(os.Stderr, "With --chain, merge two patch chains that share history.\n")
+		fmt.Fprintf(os.Stderr, "\nStrategies: ours, theirs, newest, largest, nonnull, both\n")
+		fmt.Fprintf(os.Stderr, "  --strategy newest --strategy '*.exr=theirs'\n")
 		os.Exit(1)
 	}
 
+	opts, err := parseMergeStrategies(*strategyFlags)
+	if err != nil {
+		fatalf("Error: %v", err)
+	}
+	if *normalize {
+		opts = append(opts, c4m.WithPathOptions(c4m.NormalizedPaths()))
+	}
+
+	if *chainFlag {
+		if len(fs.args) != 2 {
+			fatalf("Error: --chain takes exactly two c4m files")
+		}
+		runMergeChain(fs.args[0], fs.args[1], opts)
+		return
+	}
+
 	mode, err := scan.ParseScanMode(*modeFlag)
 	if err != nil {
 		fatalf("Error: %v", err)
@@ -36,15 +61,12 @@ func runMerge(args []string) {
 	// Merge all manifests. Use three-way merge with nil base (union).
 	result := manifests[0]
 	for i := 1; i < len(manifests); i++ {
-		merged, conflicts, err := c4m.Merge(nil, result, manifests[i])
+		merged, conflicts, err := c4m.Merge(nil, result, manifests[i], opts...)
 		if err != nil {
 			fatalf("Error merging: %v", err)
 		}
-		if len(conflicts) > 0 {
-			for _, c := range conflicts {
-				fmt.Fprintf(os.Stderr, "conflict: %s\n", c.Path)
-			}
-			fatalf("Error: %d conflicts", len(conflicts))
+		if n := reportConflicts(conflicts); n > 0 {
+			fatalf("Error: %d conflicts", n)
 		}
 		result = merged
 	}
@@ -52,6 +74,80 @@ func runMerge(args []string) {
 	outputManifest(result, *ergonomic)
 }
 
+// runMergeChain merges two patch chains and writes the merged chain to
+// stdout: the local chain followed by a merge patch and its closing ID.
+// Conflicting entries are kept (the loser as "{name}.conflict") and
+// reported on stderr.
+func runMergeChain(localPath, remotePath string, opts []c4m.MergeOption) {
+	local := readPatchChain(localPath)
+	remote := readPatchChain(remotePath)
+
+	cm, err := c4m.MergeChains(local, remote, opts...)
+	if err != nil {
+		fatalf("Error merging %s and %s: %v", localPath, remotePath, err)
+	}
+
+	writeSections(os.Stdout, cm.Sections)
+	if len(cm.Sections) > len(local) {
+		fmt.Println(cm.Merged.ComputeC4ID())
+	}
+
+	if n := reportConflicts(cm.Conflicts); n > 0 {
+		fmt.Fprintf(os.Stderr, "%d conflicts preserved as .conflict entries\n", n)
+	}
+}
+
+// parseMergeStrategies converts --strategy values into merge options.
+// A bare name sets the default strategy; "pattern=name" applies to
+// matching paths only.
+func parseMergeStrategies(values []string) ([]c4m.MergeOption, error) {
+	var opts []c4m.MergeOption
+	for _, v := range values {
+		pattern, name := "", v
+		if idx := strings.LastIndexByte(v, '='); idx >= 0 {
+			pattern, name = v[:idx], v[idx+1:]
+		}
+		s, err := c4m.ParseStrategy(name)
+		if err != nil {
+			return nil, err
+		}
+		if pattern == "" {
+			opts = append(opts, c4m.WithStrategy(s))
+		} else {
+			opts = append(opts, c4m.WithPathStrategy(pattern, s))
+		}
+	}
+	return opts, nil
+}
+
+// reportConflicts prints each merge decision to stderr and returns the
+// number of conflicts left for the default keep-both handling.
+func reportConflicts(conflicts []c4m.Conflict) int {
+	unresolved := 0
+	for _, c := range conflicts {
+		if c.Resolution == c4m.KeepBoth {
+			fmt.Fprintf(os.Stderr, "conflict: %s\n", c.Path)
+			unresolved++
+			continue
+		}
+		fmt.Fprintf(os.Stderr, "resolved: %s (%s: keep %s)\n", c.Path, c.Strategy, c.Resolution)
+	}
+	return unresolved
+}
+
+// readPatchChain reads a c4m file as a patch chain.
+func readPatchChain(path string) []*c4m.PatchSection {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		fatalf("Error reading %s: %v", path, err)
+	}
+	sections, err := c4m.DecodePatchChain(bytes.NewReader(data))
+	if err != nil {
+		fatalf("Error decoding %s: %v", path, err)
+	}
+	return sections
+}
+
 // resolveManifestOrDir loads a c4m file, reads stdin, or scans a directory.
 func resolveManifestOrDir(path string, mode scan.ScanMode) *c4m.Manifest {
 	if path == "-" {
@@ -22,6 +22,7 @@ func runPatch(args []string) {
 	sourceFlags := fs.stringArrayFlag("source", "Additional content source paths (repeatable)")
 	noStore := fs.boolFlag("no-store", 0, false, "Suppress content storage")
 	modeFlag := fs.stringFlag("mode", 'm', "f", "Scan mode for directory arguments: s/m/f")
+	normalize := fs.boolFlag("normalize", 'u', false, "Match names that differ only by Unicode normalization (NFC/NFD)")
 	fs.parse(args)
 
 	if len(fs.args) == 0 {
@@ -48,7 +49,7 @@ func runPatch(args []string) {
 	case 1:
 		runPatchSingle(fs.args[0], mode, *n, *ergonomic, *noStore)
 	case 2:
-		runPatchPair(fs.args[0], fs.args[1], mode, *ergonomic, *dryRun, *noStore, *storeFlag, *quiet, *sourceFlags)
+		runPatchPair(fs.args[0], fs.args[1], mode, *ergonomic, *dryRun, *noStore, *storeFlag, *quiet, *normalize, *sourceFlags)
 	default:
 		// 3+ args: multi-file chain resolution (existing behavior).
 		runPatchChain(fs.args, *n, *ergonomic)
@@ -73,7 +74,7 @@ func runPatchSingle(path string, mode scan.ScanMode, n int, ergonomic, noStore b
 	if isDirectory(path) {
 		// Directory: scan, store, output c4m.
 		shouldStore := !noStore && mode == scan.ModeFull
-		m := scanDirectory(path, mode, false, shouldStore, nil, "", nil)
+		m := scanDirectory(path, mode, false, shouldStore, nil, nil, "", nil)
 		outputManifest(m, ergonomic)
 		return
 	}
@@ -95,7 +96,7 @@ func runPatchSingle(path string, mode scan.ScanMode, n int, ergonomic, noStore b
 }
 
 // runPatchPair handles two-argument patch with dispatch based on argument types.
-func runPatchPair(target, dest string, mode scan.ScanMode, ergonomic, dryRun, noStore, storeRemovals, quiet bool, sources []string) {
+func runPatchPair(target, dest string, mode scan.ScanMode, ergonomic, dryRun, noStore, storeRemovals, quiet, normalize bool, sources []string) {
 	targetIsDir := isDirectory(target)
 	destIsDir := isDirectory(dest)
 
@@ -103,11 +104,11 @@ func runPatchPair(target, dest string, mode scan.ScanMode, ergonomic, dryRun, no
 	case !targetIsDir && !destIsDir:
 		runPatchC4mToC4m(target, dest, ergonomic)
 	case !targetIsDir && destIsDir:
-		runPatchC4mToDir(target, dest, mode, dryRun, storeRemovals, quiet, sources)
+		runPatchC4mToDir(target, dest, mode, dryRun, storeRemovals, quiet, normalize, sources)
 	case targetIsDir && !destIsDir:
 		runPatchDirToC4m(target, dest, mode, noStore)
 	default:
-		runPatchDirToDir(target, dest, mode, dryRun, noStore, storeRemovals, quiet, sources)
+		runPatchDirToDir(target, dest, mode, dryRun, noStore, storeRemovals, quiet, normalize, sources)
 	}
 }
 
@@ -134,7 +135,7 @@ func runPatchC4mToC4m(target, dest string, ergonomic bool) {
 
 // runPatchC4mToDir reconciles a directory to match a c4m target state.
 // Outputs the computed diff to stdout.
-func runPatchC4mToDir(target, dirPath string, mode scan.ScanMode, dryRun, storeRemovals, quiet bool, sources []string) {
+func runPatchC4mToDir(target, dirPath string, mode scan.ScanMode, dryRun, storeRemovals, quiet, normalize bool, sources []string) {
 	targetManifest := resolveC4m(target)
 
 	// Scan current state using target as a guide — only hash changed files.
@@ -162,14 +163,15 @@ func runPatchC4mToDir(target, dirPath string, mode scan.ScanMode, dryRun, storeR
 	}
 
 	// Build content sources.
-	var opts []reconcile.Option
+	ctx := rootContext()
+	opts := []reconcile.Option{reconcile.WithContext(ctx)}
 	opts = append(opts, reconcile.WithSource(reconcile.NewDirSource(currentManifest, dirPath)))
 
 	if s != nil {
-		opts = append(opts, reconcile.WithSource(s))
+		opts = append(opts, reconcile.WithSource(store.Bind(ctx, s)))
 	}
 	if storeRemovals && s != nil {
-		opts = append(opts, reconcile.WithStoreRemovals(s))
+		opts = append(opts, reconcile.WithStoreRemovals(store.Bind(ctx, s)))
 	}
 
 	for _, src := range sources {
@@ -178,6 +180,9 @@ func runPatchC4mToDir(target, dirPath string, mode scan.ScanMode, dryRun, storeR
 			opts = append(opts, reconcile.WithSource(reconcile.NewDirSource(srcManifest, src)))
 		}
 	}
+	if normalize {
+		opts = append(opts, reconcile.WithPathOptions(c4m.NormalizedPaths()))
+	}
 
 	r := reconcile.New(opts...)
 	plan, err := r.Plan(targetManifest, dirPath)
@@ -193,6 +198,8 @@ func runPatchC4mToDir(target, dirPath string, mode scan.ScanMode, dryRun, storeR
 		os.Exit(1)
 	}
 
+	reportNormalized(plan)
+
 	if dryRun {
 		fmt.Fprintf(os.Stderr, "%d operations planned\n", len(plan.Operations))
 		for _, op := range plan.Operations {
@@ -202,17 +209,20 @@ func runPatchC4mToDir(target, dirPath string, mode scan.ScanMode, dryRun, storeR
 	}
 
 	result, err := r.Apply(plan, dirPath)
-	if err != nil {
+	if result == nil {
 		fatalf("Error applying reconciliation: %v", err)
 	}
 
 	reportResult(dirPath, result)
+	if err != nil {
+		fatalf("Interrupted")
+	}
 }
 
 // runPatchDirToC4m scans a directory, stores content, and writes a c4m file.
 func runPatchDirToC4m(dirPath, destPath string, mode scan.ScanMode, noStore bool) {
 	shouldStore := !noStore && mode == scan.ModeFull
-	m := scanDirectory(dirPath, mode, false, shouldStore, nil, "", nil)
+	m := scanDirectory(dirPath, mode, false, shouldStore, nil, nil, "", nil)
 
 	f, err := os.Create(destPath)
 	if err != nil {
@@ -230,9 +240,9 @@ func runPatchDirToC4m(dirPath, destPath string, mode scan.ScanMode, noStore bool
 
 // runPatchDirToDir scans source directory and reconciles dest to match.
 // Outputs the computed diff to stdout.
-func runPatchDirToDir(srcDir, destDir string, mode scan.ScanMode, dryRun, noStore, storeRemovals, quiet bool, sources []string) {
+func runPatchDirToDir(srcDir, destDir string, mode scan.ScanMode, dryRun, noStore, storeRemovals, quiet, normalize bool, sources []string) {
 	shouldStore := !noStore && mode == scan.ModeFull
-	targetManifest := scanDirectory(srcDir, mode, false, shouldStore, nil, "", nil)
+	targetManifest := scanDirectory(srcDir, mode, false, shouldStore, nil, nil, "", nil)
 
 	// Scan dest for diff output and content source.
 	var destManifest *c4m.Manifest
@@ -258,15 +268,16 @@ func runPatchDirToDir(srcDir, destDir string, mode scan.ScanMode, dryRun, noStor
 		storeManifestAsContent(destManifest, s)
 	}
 
-	var opts []reconcile.Option
+	ctx := rootContext()
+	opts := []reconcile.Option{reconcile.WithContext(ctx)}
 	opts = append(opts, reconcile.WithSource(reconcile.NewDirSource(targetManifest, srcDir)))
 	opts = append(opts, reconcile.WithSource(reconcile.NewDirSource(destManifest, destDir)))
 
 	if s != nil {
-		opts = append(opts, reconcile.WithSource(s))
+		opts = append(opts, reconcile.WithSource(store.Bind(ctx, s)))
 	}
 	if storeRemovals && s != nil {
-		opts = append(opts, reconcile.WithStoreRemovals(s))
+		opts = append(opts, reconcile.WithStoreRemovals(store.Bind(ctx, s)))
 	}
 
 	for _, src := range sources {
@@ -275,6 +286,9 @@ func runPatchDirToDir(srcDir, destDir string, mode scan.ScanMode, dryRun, noStor
 			opts = append(opts, reconcile.WithSource(reconcile.NewDirSource(srcManifest, src)))
 		}
 	}
+	if normalize {
+		opts = append(opts, reconcile.WithPathOptions(c4m.NormalizedPaths()))
+	}
 
 	r := reconcile.New(opts...)
 	plan, err := r.Plan(targetManifest, destDir)
@@ -290,6 +304,8 @@ func runPatchDirToDir(srcDir, destDir string, mode scan.ScanMode, dryRun, noStor
 		os.Exit(1)
 	}
 
+	reportNormalized(plan)
+
 	if dryRun {
 		fmt.Fprintf(os.Stderr, "%d operations planned\n", len(plan.Operations))
 		for _, op := range plan.Operations {
@@ -299,11 +315,14 @@ func runPatchDirToDir(srcDir, destDir string, mode scan.ScanMode, dryRun, noStor
 	}
 
 	result, err := r.Apply(plan, destDir)
-	if err != nil {
+	if result == nil {
 		fatalf("Error applying reconciliation: %v", err)
 	}
 
 	reportResult(destDir, result)
+	if err != nil {
+		fatalf("Interrupted")
+	}
 }
 
 // runPatchChain handles 3+ args: multi-file chain resolution (original behavior).
@@ -359,6 +378,14 @@ func resolveC4m(path string) *c4m.Manifest {
 	return c4m.ResolvePatchChain(sections, 0)
 }
 
+// reportNormalized lists target paths that matched existing files only by
+// Unicode normalization. Those files are kept under their on-disk names.
+func reportNormalized(plan *reconcile.Plan) {
+	for _, np := range plan.Normalized {
+		fmt.Fprintf(os.Stderr, "  normalized %s (on disk: %s)\n", c4m.SafeName(np.Path), c4m.SafeName(np.Current))
+	}
+}
+
 // opName returns a human-readable name for a reconcile operation type.
 func opName(op reconcile.Op) string {
 	switch op {
@@ -462,11 +489,12 @@ func runPatchReverse(changesetPath, dirPath string, storeRemovals bool, dryRun,
 	}
 
 	// Build content sources and reconcile.
-	var opts []reconcile.Option
+	ctx := rootContext()
+	opts := []reconcile.Option{reconcile.WithContext(ctx)}
 	opts = append(opts, reconcile.WithSource(reconcile.NewDirSource(currentManifest, dirPath)))
-	opts = append(opts, reconcile.WithSource(s))
+	opts = append(opts, reconcile.WithSource(store.Bind(ctx, s)))
 	if storeRemovals {
-		opts = append(opts, reconcile.WithStoreRemovals(s))
+		opts = append(opts, reconcile.WithStoreRemovals(store.Bind(ctx, s)))
 	}
 
 	for _, src := range sources {
@@ -499,11 +527,14 @@ func runPatchReverse(changesetPath, dirPath string, storeRemovals bool, dryRun,
 	}
 
 	result, err := r.Apply(plan, dirPath)
-	if err != nil {
+	if result == nil {
 		fatalf("Error applying reconciliation: %v", err)
 	}
 
 	reportResult(dirPath, result)
+	if err != nil {
+		fatalf("Interrupted")
+	}
 }
 
 // storeManifestAsContent stores a manifest's canonical c4m as content in the store.
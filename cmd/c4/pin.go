@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/Avalanche-io/c4"
+	"github.com/Avalanche-io/c4/c4m"
+	"github.com/Avalanche-io/c4/store"
+)
+
+func runPin(args []string) {
+	fs := newFlags("pin")
+	unpin := fs.boolFlag("unpin", 'u', false, "Unpin, letting the content be evicted again")
+	fs.parse(args)
+
+	if len(fs.args) == 0 {
+		fmt.Fprintf(os.Stderr, "Usage: c4 pin [-u] <root>...\n")
+		fmt.Fprintf(os.Stderr, "\nKeep the content reachable from the given roots in the local cache,\n")
+		fmt.Fprintf(os.Stderr, "fetching what it lacks. Roots are c4m files or manifest IDs, as for c4 gc.\n")
+		fmt.Fprintf(os.Stderr, "  -u  Unpin instead\n")
+		os.Exit(1)
+	}
+
+	s, err := store.OpenStore()
+	if err != nil {
+		fatalf("Error opening store: %v", err)
+	}
+	if s == nil {
+		fatalf("Error: no content store configured.\nSet C4_STORE=/path/to/store or s3://bucket/prefix")
+	}
+	caches := store.Caches(s)
+	if len(caches) == 0 {
+		fatalf("Error: no cache configured.\nSet C4_STORE=cache:///path/to/cache?size=100G,<store>")
+	}
+
+	mk := c4m.NewMarker(s)
+	for _, root := range fs.args {
+		if err := markRoot(mk, root); err != nil {
+			fatalf("Error marking %s: %v", root, err)
+		}
+	}
+	ids := mk.IDs()
+	// With chunking, the cache holds chunks, so those are what to pin.
+	var chunks []c4.ID
+	for _, id := range ids {
+		if cs, err := store.Chunks(s, id); err == nil {
+			for _, ch := range cs {
+				chunks = append(chunks, ch.ID)
+			}
+		}
+	}
+	ids = append(ids, chunks...)
+
+	missing := false
+	for _, c := range caches {
+		if *unpin {
+			if err := c.Unpin(ids); err != nil {
+				fatalf("Error: %v", err)
+			}
+			fmt.Fprintf(os.Stderr, "Unpinned %s\n", pluralize(len(ids), "ID"))
+			continue
+		}
+		rep, err := c.Pin(ids)
+		if err != nil {
+			fatalf("Error: %v", err)
+		}
+		fmt.Fprintf(os.Stderr, "Pinned %s, fetched %s (%s)\n",
+			pluralize(rep.Pinned, "ID"), pluralize(rep.Fetched, "object"), formatBytes(rep.Bytes))
+		if len(rep.Missing) > 0 {
+			fmt.Fprintf(os.Stderr, "%d not in any store\n", len(rep.Missing))
+			missing = true
+		}
+	}
+	if missing {
+		os.Exit(1)
+	}
+}
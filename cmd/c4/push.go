@@ -0,0 +1,181 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/Avalanche-io/c4"
+	"github.com/Avalanche-io/c4/c4m"
+	"github.com/Avalanche-io/c4/store"
+)
+
+func runPush(args []string) {
+	runReplicate("push", args)
+}
+
+func runPull(args []string) {
+	runReplicate("pull", args)
+}
+
+// runReplicate copies content between the configured store and the store
+// named by the first argument: push sends to it, pull fetches from it.
+func runReplicate(cmd string, args []string) {
+	fs := newFlags(cmd)
+	dryRun := fs.boolFlag("dry-run", 'n', false, "Report what would be copied without copying it")
+	workers := fs.intFlag("workers", 'j', 0, "Objects to copy in parallel (default: twice the number of CPUs)")
+	logPath := fs.stringFlag("log", 'l', "", "Progress log; rerun with the same log to resume")
+	verbose := fs.boolFlag("verbose", 'v', false, "List each object copied")
+	serverCopy := fs.boolFlag("server-copy", 'S', false, "Let S3 copy between buckets on the same service")
+	drain := fs.boolFlag("drain", 'D', false, "Copy what is queued for the configured stores, then exit")
+	fs.parse(args)
+
+	if *drain {
+		if cmd != "push" || len(fs.args) > 0 {
+			fatalf("Usage: c4 push --drain")
+		}
+		drainQueues()
+		return
+	}
+
+	if len(fs.args) == 0 {
+		other := "dest"
+		if cmd == "pull" {
+			other = "src"
+		}
+		fmt.Fprintf(os.Stderr, "Usage: c4 %s [-n] [-j N] [-l log] [-v] [-S] <%s> [root...]\n", cmd, other)
+		if cmd == "push" {
+			fmt.Fprintf(os.Stderr, "       c4 push --drain\n")
+		}
+		if cmd == "push" {
+			fmt.Fprintf(os.Stderr, "\nCopy content from the configured store to <dest>.\n")
+		} else {
+			fmt.Fprintf(os.Stderr, "\nCopy content from <src> into the configured store.\n")
+		}
+		fmt.Fprintf(os.Stderr, "Stores use the same syntax as C4_STORE. Each root is a c4m file or\n")
+		fmt.Fprintf(os.Stderr, "the C4 ID of a manifest; everything reachable from it is copied.\n")
+		fmt.Fprintf(os.Stderr, "With no roots, the whole store is copied.\n")
+		fmt.Fprintf(os.Stderr, "  -n  Dry run: list what would be copied\n")
+		fmt.Fprintf(os.Stderr, "  -l  Progress log for resuming an interrupted copy\n")
+		fmt.Fprintf(os.Stderr, "  -S  Let S3 copy between buckets on the same service\n")
+		if cmd == "push" {
+			fmt.Fprintf(os.Stderr, "  -D  Copy what is queued for the configured stores, then exit\n")
+		}
+		os.Exit(1)
+	}
+
+	local, err := store.OpenStore()
+	if err != nil {
+		fatalf("Error opening store: %v", err)
+	}
+	if local == nil {
+		fatalf("Error: no content store configured.\nSet C4_STORE=/path/to/store or s3://bucket/prefix")
+	}
+	remote, err := store.OpenURI(fs.args[0])
+	if err != nil {
+		fatalf("Error opening %s: %v", fs.args[0], err)
+	}
+	src, dst := local, remote
+	if cmd == "pull" {
+		src, dst = remote, local
+	}
+
+	ids, err := replicationSet(src, fs.args[1:])
+	if err != nil {
+		fatalf("Error: %v", err)
+	}
+
+	verb := "Copied"
+	if *dryRun {
+		verb = "Would copy"
+	}
+	opts := store.ReplicateOptions{Workers: *workers, Log: *logPath, DryRun: *dryRun, ServerCopy: *serverCopy}
+	if *verbose || *dryRun {
+		opts.OnCopy = func(id c4.ID, size int64) {
+			if *dryRun {
+				fmt.Printf("%s %s\n", verb, id)
+				return
+			}
+			fmt.Printf("%s %s %s\n", verb, id, formatBytes(size))
+		}
+	}
+	rep, err := store.ReplicateContext(rootContext(), src, dst, ids, opts)
+	if err != nil && rootContext().Err() == nil {
+		fatalf("Error: %v", err)
+	}
+
+	for _, id := range rep.Missing {
+		fmt.Fprintf(os.Stderr, "missing %s\n", id)
+	}
+	for id, err := range rep.Failed {
+		fmt.Fprintf(os.Stderr, "failed %s: %v\n", id, err)
+	}
+	fmt.Fprintf(os.Stderr, "%s %s", verb, pluralize(rep.Copied, "object"))
+	if !*dryRun {
+		fmt.Fprintf(os.Stderr, " (%s)", formatBytes(rep.Bytes))
+	}
+	fmt.Fprintf(os.Stderr, ", %d already present", rep.Present+rep.Resumed)
+	if len(rep.Missing) > 0 {
+		fmt.Fprintf(os.Stderr, ", %d missing from source", len(rep.Missing))
+	}
+	if len(rep.Failed) > 0 {
+		fmt.Fprintf(os.Stderr, ", %d failed", len(rep.Failed))
+	}
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		fatalf("Interrupted")
+	}
+	if len(rep.Missing) > 0 || len(rep.Failed) > 0 {
+		os.Exit(1)
+	}
+}
+
+// drainQueues copies the content queued for the members of the configured
+// stores, as each c4 process does in the background while it runs, and
+// exits 1 if any is left queued.
+func drainQueues() {
+	s, err := store.OpenStore()
+	if err != nil {
+		fatalf("Error opening store: %v", err)
+	}
+	if s == nil {
+		fatalf("Error: no content store configured.\nSet C4_STORE=/path/to/store or s3://bucket/prefix")
+	}
+	left := 0
+	for _, m := range store.MultiStores(s) {
+		if err := m.Drain(rootContext()); err != nil && rootContext().Err() == nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		}
+		left += m.Queued()
+		m.Close()
+	}
+	fmt.Fprintf(os.Stderr, "%s left queued\n", pluralize(left, "object"))
+	if rootContext().Err() != nil {
+		fatalf("Interrupted")
+	}
+	if left > 0 {
+		os.Exit(1)
+	}
+}
+
+// replicationSet returns the IDs reachable from roots in src, or every ID
+// in src when there are no roots.
+func replicationSet(src store.Store, roots []string) ([]c4.ID, error) {
+	if len(roots) == 0 {
+		var ids []c4.ID
+		err := store.List(src, func(o store.Object) bool {
+			ids = append(ids, o.ID)
+			return true
+		})
+		if err == store.ErrNotImplemented {
+			return nil, fmt.Errorf("source store cannot list its contents; name the roots to copy")
+		}
+		return ids, err
+	}
+	mk := c4m.NewMarker(src)
+	for _, root := range roots {
+		if err := markRoot(mk, root); err != nil {
+			return nil, fmt.Errorf("marking %s: %w", root, err)
+		}
+	}
+	return mk.IDs(), nil
+}
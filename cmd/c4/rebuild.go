@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/Avalanche-io/c4"
+	"github.com/Avalanche-io/c4/store"
+)
+
+func runRebuild(args []string) {
+	fs := newFlags("rebuild")
+	dryRun := fs.boolFlag("dry-run", 'n', false, "Report what would be rebuilt without writing it")
+	check := fs.boolFlag("check", 'c', false, "Read existing shards and rewrite damaged ones")
+	verbose := fs.boolFlag("verbose", 'v', false, "List each object rebuilt")
+	fs.parse(args)
+
+	if len(fs.args) != 1 {
+		fmt.Fprintf(os.Stderr, "Usage: c4 rebuild [-n] [-c] [-v] <backend>\n")
+		fmt.Fprintf(os.Stderr, "\nWrite the shards of backend <backend>, counting from 1 in the order they\n")
+		fmt.Fprintf(os.Stderr, "follow the erasure:// store, reconstructing them from the other backends.\n")
+		fmt.Fprintf(os.Stderr, "Use it after replacing a failed backend with an empty one.\n")
+		fmt.Fprintf(os.Stderr, "  -n  Dry run: list what would be rebuilt\n")
+		fmt.Fprintf(os.Stderr, "  -c  Also check the shards the backend has, rewriting damaged ones\n")
+		os.Exit(1)
+	}
+	n, err := strconv.Atoi(fs.args[0])
+	if err != nil || n < 1 {
+		fatalf("Error: invalid backend %q (want a number from 1)", fs.args[0])
+	}
+
+	s, err := store.OpenStore()
+	if err != nil {
+		fatalf("Error opening store: %v", err)
+	}
+	if s == nil {
+		fatalf("Error: no content store configured.\nSet C4_STORE=/path/to/store or s3://bucket/prefix")
+	}
+	stores := store.ErasureStores(s)
+	if len(stores) == 0 {
+		fatalf("Error: no erasure store configured.\nSet C4_STORE=erasure://?data=4&parity=2,<backends>")
+	}
+	e := stores[0]
+	if data, parity := e.Shards(); n > data+parity {
+		fatalf("Error: backend %d: the erasure store has %d", n, data+parity)
+	}
+
+	verb := "Rebuilt"
+	if *dryRun {
+		verb = "Would rebuild"
+	}
+	opts := store.RebuildOptions{Verify: *check, DryRun: *dryRun}
+	if *verbose || *dryRun {
+		opts.OnRebuild = func(id c4.ID) {
+			fmt.Printf("%s %s\n", verb, id)
+		}
+	}
+	rep, err := e.Rebuild(n-1, opts)
+	if err != nil {
+		fatalf("Error: %v", err)
+	}
+	for id, err := range rep.Failed {
+		fmt.Fprintf(os.Stderr, "Failed %s: %v\n", id, err)
+	}
+	fmt.Fprintf(os.Stderr, "%s %s on backend %d, %d already present", verb, pluralize(rep.Rebuilt, "shard"), n, rep.Present)
+	if len(rep.Failed) > 0 {
+		fmt.Fprintf(os.Stderr, ", %d failed\n", len(rep.Failed))
+		os.Exit(1)
+	}
+	fmt.Fprintln(os.Stderr)
+}
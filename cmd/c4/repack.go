@@ -0,0 +1,42 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/Avalanche-io/c4/store"
+)
+
+func runRepack(args []string) {
+	fs := newFlags("repack")
+	fs.parse(args)
+
+	if len(fs.args) != 0 {
+		fmt.Fprintf(os.Stderr, "Usage: c4 repack\n")
+		fmt.Fprintf(os.Stderr, "\nRewrite the pack files of the configured pack:// stores, dropping\n")
+		fmt.Fprintf(os.Stderr, "removed objects and duplicates.\n")
+		os.Exit(1)
+	}
+
+	s, err := store.OpenStore()
+	if err != nil {
+		fatalf("Error opening store: %v", err)
+	}
+	if s == nil {
+		fatalf("Error: no content store configured.\nSet C4_STORE=/path/to/store or s3://bucket/prefix")
+	}
+	packs := store.PackStores(s)
+	if len(packs) == 0 {
+		fatalf("Error: no pack store configured.\nSet C4_STORE=pack:///path/to/store")
+	}
+
+	for _, ps := range packs {
+		rep, err := ps.Repack()
+		if err != nil {
+			fatalf("Error: %v", err)
+		}
+		fmt.Fprintf(os.Stderr, "Repacked %s (%s) from %s into %s, %s reclaimed\n",
+			pluralize(rep.Objects, "object"), formatBytes(rep.Bytes),
+			pluralize(rep.PacksBefore, "pack"), pluralize(rep.PacksAfter, "pack"), formatBytes(rep.Reclaimed))
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Avalanche-io/c4/store"
+)
+
+// Limits on a client of c4 serve that is not sending or receiving content.
+const (
+	serveHeaderTimeout = 30 * time.Second
+	serveIdleTimeout   = 2 * time.Minute
+)
+
+func runServe(args []string) {
+	fs := newFlags("serve")
+	addr := fs.stringFlag("addr", 'a', ":7474", "Address to listen on")
+	readOnly := fs.boolFlag("read-only", 'r', false, "Refuse writes and removals")
+	tokenFile := fs.stringFlag("token-file", 't', "", "File holding the token clients must send (default: $C4_TOKEN)")
+	certFile := fs.stringFlag("cert", 'c', "", "TLS certificate file")
+	keyFile := fs.stringFlag("key", 'k', "", "TLS key file")
+	help := fs.boolFlag("help", 'h', false, "Show usage")
+	fs.parse(args)
+
+	if *help || len(fs.args) > 1 {
+		fmt.Fprintf(os.Stderr, "Usage: c4 serve [-a addr] [-r] [-t token-file] [-c cert -k key] [<store>]\n")
+		fmt.Fprintf(os.Stderr, "\nServe a content store over HTTP, for C4_STORE=http://host:port. The\n")
+		fmt.Fprintf(os.Stderr, "store uses the same syntax as C4_STORE; by default it is the configured one.\n")
+		fmt.Fprintf(os.Stderr, "  -a  Address to listen on (default :7474)\n")
+		fmt.Fprintf(os.Stderr, "  -r  Read-only: refuse writes and removals\n")
+		fmt.Fprintf(os.Stderr, "  -t  File holding the token clients must send (default: $C4_TOKEN)\n")
+		fmt.Fprintf(os.Stderr, "  -c  TLS certificate file\n")
+		fmt.Fprintf(os.Stderr, "  -k  TLS key file\n")
+		os.Exit(1)
+	}
+	if (*certFile == "") != (*keyFile == "") {
+		fatalf("Error: -c and -k must be given together")
+	}
+
+	var s store.Store
+	var err error
+	name := "the configured store"
+	if len(fs.args) == 1 {
+		name = fs.args[0]
+		s, err = store.OpenURI(name)
+	} else {
+		s, err = store.OpenStore()
+	}
+	if err != nil {
+		fatalf("Error opening store: %v", err)
+	}
+	if s == nil {
+		fatalf("Error: no content store configured.\nSet C4_STORE=/path/to/store or name one")
+	}
+
+	token := os.Getenv("C4_TOKEN")
+	if *tokenFile != "" {
+		data, err := os.ReadFile(*tokenFile)
+		if err != nil {
+			fatalf("Error reading token: %v", err)
+		}
+		token = strings.TrimSpace(string(data))
+		if token == "" {
+			fatalf("Error: %s is empty", *tokenFile)
+		}
+	}
+
+	h := store.NewHTTPHandler(s)
+	h.SetReadOnly(*readOnly)
+	h.SetToken(token)
+
+	ln, err := net.Listen("tcp", *addr)
+	if err != nil {
+		fatalf("Error: %v", err)
+	}
+	scheme := "http"
+	if *certFile != "" {
+		scheme = "https"
+	}
+	if token == "" && !*readOnly {
+		fmt.Fprintf(os.Stderr, "Warning: anyone who can connect can write and remove content; set C4_TOKEN or use -r\n")
+	}
+	fmt.Fprintf(os.Stderr, "Serving %s on %s://%s\n", name, scheme, ln.Addr())
+
+	// Objects may take hours to send, so only the request headers and idle
+	// connections are limited: a client that connects and says nothing
+	// would otherwise hold a connection open forever.
+	srv := &http.Server{
+		Handler:           h,
+		ReadHeaderTimeout: serveHeaderTimeout,
+		IdleTimeout:       serveIdleTimeout,
+	}
+	if *certFile != "" {
+		err = srv.ServeTLS(ln, *certFile, *keyFile)
+	} else {
+		err = srv.Serve(ln)
+	}
+	fatalf("Error: %v", err)
+}
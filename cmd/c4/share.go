@@ -0,0 +1,250 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Avalanche-io/c4"
+	"github.com/Avalanche-io/c4/c4m"
+	"github.com/Avalanche-io/c4/store"
+)
+
+// sharedFile is one file of a delivery and the link to its content.
+type sharedFile struct {
+	Path string `json:"path"`
+	ID   c4.ID  `json:"id"`
+	Size int64  `json:"size"`
+	URL  string `json:"url,omitempty"`
+}
+
+func runShare(args []string) {
+	fs := newFlags("share")
+	ttlFlag := fs.stringFlag("ttl", 't', "24h", "How long the links stay valid (at most 168h)")
+	urlsOnly := fs.boolFlag("urls", 'u', false, "Print only the URLs, one per object")
+	jsonFlag := fs.boolFlag("json", 'j', false, "Output JSON")
+	fs.parse(args)
+
+	if len(fs.args) == 0 {
+		fmt.Fprintf(os.Stderr, "Usage: c4 share [-t ttl] [-u] [-j] <c4m|id>...\n")
+		fmt.Fprintf(os.Stderr, "\nPrint a presigned S3 URL for every file in the given manifests, so the\n")
+		fmt.Fprintf(os.Stderr, "content can be fetched without credentials. Manifests are c4m files or\n")
+		fmt.Fprintf(os.Stderr, "manifest IDs in the store.\n")
+		fmt.Fprintf(os.Stderr, "  -t  How long the links stay valid (default 24h, at most 168h)\n")
+		fmt.Fprintf(os.Stderr, "  -u  Print only the URLs, one per object\n")
+		fmt.Fprintf(os.Stderr, "  -j  Output JSON\n")
+		os.Exit(1)
+	}
+	ttl, err := time.ParseDuration(*ttlFlag)
+	if err != nil || ttl < time.Second || ttl > store.MaxPresignTTL {
+		fatalf("Error: -t must be a duration from 1s to %s", store.MaxPresignTTL)
+	}
+
+	s, err := store.OpenStore()
+	if err != nil {
+		fatalf("Error opening store: %v", err)
+	}
+	if s == nil {
+		fatalf("Error: no content store configured.\nSet C4_STORE=s3://bucket/prefix")
+	}
+	s3s, err := store.PlainS3Stores(s)
+	if err != nil {
+		fatalf("Error: %v; links would not give the files", err)
+	}
+	if len(s3s) == 0 {
+		fatalf("Error: no S3 store configured.\nSet C4_STORE=s3://bucket/prefix")
+	}
+
+	var files []sharedFile
+	for _, root := range fs.args {
+		m, err := shareRoot(s, root)
+		if err != nil {
+			fatalf("Error: %v", err)
+		}
+		files = append(files, manifestFiles(s, m, "")...)
+	}
+
+	// Find each object in the first S3 store that has it.
+	var ids []c4.ID
+	seen := make(map[c4.ID]bool)
+	for _, f := range files {
+		if !seen[f.ID] {
+			seen[f.ID] = true
+			ids = append(ids, f.ID)
+		}
+	}
+	urls := make(map[c4.ID]string)
+	for _, s3 := range s3s {
+		var pending []c4.ID
+		for _, id := range ids {
+			if _, ok := urls[id]; !ok {
+				pending = append(pending, id)
+			}
+		}
+		found, err := s3.HasMany(rootContext(), pending)
+		if err != nil {
+			fatalf("Error: %v", err)
+		}
+		for i, id := range pending {
+			if !found[i] {
+				continue
+			}
+			if urls[id], err = s3.PresignGet(id, ttl); err != nil {
+				fatalf("Error: %v", err)
+			}
+		}
+	}
+
+	var shared []sharedFile
+	var size int64
+	missing := 0
+	for _, f := range files {
+		f.URL = urls[f.ID]
+		if f.URL == "" {
+			fmt.Fprintf(os.Stderr, "Not in S3: %s %s\n", f.ID, f.Path)
+			missing++
+			continue
+		}
+		if f.Size > 0 {
+			size += f.Size
+		}
+		shared = append(shared, f)
+	}
+
+	switch {
+	case *jsonFlag:
+		if shared == nil {
+			shared = []sharedFile{}
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(shared); err != nil {
+			fatalf("Error encoding JSON: %v", err)
+		}
+	case *urlsOnly:
+		for _, id := range ids {
+			if u := urls[id]; u != "" {
+				fmt.Println(u)
+			}
+		}
+	default:
+		for _, f := range shared {
+			fmt.Printf("%s %s %s\n", f.ID, f.URL, f.Path)
+		}
+	}
+
+	fmt.Fprintf(os.Stderr, "Shared %s (%s); links expire %s\n",
+		pluralize(len(shared), "file"), formatBytes(size),
+		time.Now().Add(ttl).Format(time.RFC3339))
+	if missing > 0 {
+		fmt.Fprintf(os.Stderr, "%s not in S3\n", pluralize(missing, "file"))
+		os.Exit(1)
+	}
+}
+
+// shareRoot loads a c4m file, or a manifest by its ID from the store.
+func shareRoot(s store.Store, root string) (*c4m.Manifest, error) {
+	if _, err := os.Stat(root); err != nil && looksLikeC4ID(root) {
+		id, err := c4.Parse(root)
+		if err != nil {
+			return nil, err
+		}
+		m := fetchManifestFromStore(s, id)
+		if m == nil {
+			return nil, fmt.Errorf("%s is not a manifest in the store", root)
+		}
+		return m, nil
+	}
+	m := resolveC4m(root)
+	// Inline ID lists are shared by the whole file.
+	if full, err := loadManifest(root); err == nil {
+		m.RangeData = full.RangeData
+	}
+	return m, nil
+}
+
+// manifestFiles lists the files of m under prefix, expanding sequences and
+// directories whose manifests are in the store.
+func manifestFiles(s store.Store, m *c4m.Manifest, prefix string) []sharedFile {
+	paths := c4m.EntryPaths(m.Entries)
+	names := make([]string, 0, len(paths))
+	listed := make(map[string]bool) // directories with children inline
+	for p := range paths {
+		names = append(names, p)
+		if i := strings.LastIndex(strings.TrimSuffix(p, "/"), "/"); i >= 0 {
+			listed[p[:i+1]] = true
+		}
+	}
+	sort.Strings(names)
+
+	var files []sharedFile
+	for _, p := range names {
+		e := paths[p]
+		switch {
+		case e.C4ID.IsNil() || e.IsSymlink():
+		case e.IsDir():
+			if listed[p] {
+				continue
+			}
+			if sub := fetchManifestFromStore(s, e.C4ID); sub != nil {
+				files = append(files, manifestFiles(s, sub, prefix+p)...)
+			}
+		case e.IsSequence:
+			frames, err := sequenceFiles(s, m, e)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: cannot expand %s%s: %v\n", prefix, p, err)
+				continue
+			}
+			dir := prefix + p[:len(p)-len(e.Name)]
+			for _, f := range frames {
+				f.Path = dir + f.Path
+				files = append(files, f)
+			}
+		default:
+			files = append(files, sharedFile{Path: prefix + p, ID: e.C4ID, Size: e.Size})
+		}
+	}
+	return files
+}
+
+// sequenceFiles returns the frames of a sequence entry, named relative to
+// the sequence's directory, from its ID list inline in m or in the store.
+func sequenceFiles(s store.Store, m *c4m.Manifest, e *c4m.Entry) ([]sharedFile, error) {
+	seq, err := c4m.ParseSequence(e.Name)
+	if err != nil {
+		return nil, err
+	}
+	data, ok := m.RangeData[e.C4ID]
+	if !ok {
+		rc, err := s.Open(e.C4ID)
+		if err != nil {
+			return nil, fmt.Errorf("ID list %s not found", e.C4ID)
+		}
+		b, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			return nil, err
+		}
+		data = string(b)
+	}
+
+	// An ID list is its members' IDs written one after another.
+	data = strings.TrimSpace(data)
+	names := seq.Expand()
+	if len(data) != len(names)*90 {
+		return nil, fmt.Errorf("ID list %s does not match the %s", e.C4ID, pluralize(len(names), "frame"))
+	}
+	frames := make([]sharedFile, len(names))
+	for i, name := range names {
+		id, err := c4.Parse(data[i*90 : (i+1)*90])
+		if err != nil {
+			return nil, fmt.Errorf("ID list %s: %v", e.C4ID, err)
+		}
+		frames[i] = sharedFile{Path: name, ID: id, Size: -1}
+	}
+	return frames, nil
+}
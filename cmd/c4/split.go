@@ -3,6 +3,7 @@ package main
 import (
 	"bytes"
 	"fmt"
+	"io"
 	"os"
 	"strconv"
 
@@ -73,11 +74,17 @@ func writeRawSections(path string, sections []*c4m.PatchSection) {
 	}
 	defer f.Close()
 
-	enc := c4m.NewEncoder(f)
+	writeSections(f, sections)
+}
+
+// writeSections writes patch sections in chain form: each section's base
+// ID line (if present) followed by its entries.
+func writeSections(w io.Writer, sections []*c4m.PatchSection) {
+	enc := c4m.NewEncoder(w)
 	for _, sec := range sections {
 		// Write the base ID line if present.
 		if !sec.BaseID.IsNil() {
-			fmt.Fprintln(f, sec.BaseID)
+			fmt.Fprintln(w, sec.BaseID)
 		}
 		// Write entries.
 		m := &c4m.Manifest{Version: "1.0", Entries: sec.Entries}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/Avalanche-io/c4/c4m"
+	"github.com/Avalanche-io/c4/scan"
+	"github.com/Avalanche-io/c4/store"
+)
+
+func runStat(args []string) {
+	fs := newFlags("stat")
+	jsonFlag := fs.boolFlag("json", 'j', false, "Output JSON")
+	storeFlag := fs.boolFlag("store", 's', false, "Report how much content is in the configured store")
+	modeFlag := fs.stringFlag("mode", 'm', "f", "Scan mode for directories: s/m/f")
+	fs.parse(args)
+
+	if len(fs.args) != 1 {
+		fmt.Fprintf(os.Stderr, "Usage: c4 stat [--json] [-s] [-m mode] <c4m|dir>\n")
+		fmt.Fprintf(os.Stderr, "\nSummarize a manifest: counts, sizes, deduplication, histograms.\n")
+		fmt.Fprintf(os.Stderr, "  -s  Also check which content is already in the store\n")
+		os.Exit(1)
+	}
+
+	mode, err := scan.ParseScanMode(*modeFlag)
+	if err != nil {
+		fatalf("Error: %v", err)
+	}
+
+	m := resolveManifestOrDir(fs.args[0], mode)
+	st := c4m.Stats(m)
+
+	if *storeFlag {
+		s, err := store.OpenStore()
+		if err != nil {
+			fatalf("Error opening store: %v", err)
+		}
+		if s == nil {
+			fatalf("Error: no content store configured (set C4_STORE or ~/.c4/config)")
+		}
+		st.Coverage = c4m.StoreCoverage(m, s)
+	}
+
+	if *jsonFlag {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(st); err != nil {
+			fatalf("Error encoding JSON: %v", err)
+		}
+		return
+	}
+	writeStats(os.Stdout, st)
+}
+
+// writeStats prints the text form of c4 stat.
+func writeStats(w io.Writer, st *c4m.ManifestStats) {
+	fmt.Fprintf(w, "Entries:      %s\n", commaFormat(int64(st.Entries)))
+	fmt.Fprintf(w, "  Files:      %s\n", commaFormat(int64(st.Files)))
+	fmt.Fprintf(w, "  Dirs:       %s\n", commaFormat(int64(st.Dirs)))
+	if st.Symlinks > 0 {
+		fmt.Fprintf(w, "  Symlinks:   %s\n", commaFormat(int64(st.Symlinks)))
+	}
+	if st.Sequences > 0 {
+		fmt.Fprintf(w, "  Sequences:  %s\n", commaFormat(int64(st.Sequences)))
+	}
+	if st.Special > 0 {
+		fmt.Fprintf(w, "  Special:    %s\n", commaFormat(int64(st.Special)))
+	}
+	if st.HardLinks > 0 {
+		fmt.Fprintf(w, "  Hard links: %s\n", commaFormat(int64(st.HardLinks)))
+	}
+	fmt.Fprintf(w, "Max depth:    %d\n", st.MaxDepth)
+	fmt.Fprintf(w, "Total size:   %s\n", formatBytes(st.TotalBytes))
+	fmt.Fprintf(w, "Unique size:  %s (%s)\n", formatBytes(st.UniqueBytes), pluralize(st.UniqueIDs, "unique ID"))
+	fmt.Fprintf(w, "Dedup ratio:  %.2fx\n", st.DedupRatio)
+	if !st.Oldest.IsZero() {
+		fmt.Fprintf(w, "Oldest:       %s  %s\n", st.Oldest.UTC().Format(time.RFC3339), c4m.SafeName(st.OldestPath))
+		fmt.Fprintf(w, "Newest:       %s  %s\n", st.Newest.UTC().Format(time.RFC3339), c4m.SafeName(st.NewestPath))
+	}
+	if n := st.Nulls; n.Entries > 0 || n.C4ID > 0 {
+		fmt.Fprintf(w, "Null fields:  %s (mode %d, timestamp %d, size %d); %s without C4 ID\n",
+			pluralize(n.Entries, "entry", "entries"), n.Mode, n.Timestamp, n.Size, pluralize(n.C4ID, "file"))
+	}
+
+	if c := st.Coverage; c != nil {
+		fmt.Fprintln(w)
+		fmt.Fprintln(w, "Store coverage:")
+		fmt.Fprintf(w, "  Stored:     %s, %s\n", pluralize(c.StoredIDs, "ID"), formatBytes(c.StoredBytes))
+		fmt.Fprintf(w, "  Missing:    %s, %s\n", pluralize(c.MissingIDs, "ID"), formatBytes(c.MissingBytes))
+		if c.ChunkedIDs > 0 {
+			fmt.Fprintf(w, "  Chunked:    %s, %s in %s of distinct chunks (%s saved)\n",
+				pluralize(c.ChunkedIDs, "ID"), formatBytes(c.ChunkedBytes), formatBytes(c.ChunkBytes),
+				formatBytes(c.ChunkedBytes-c.ChunkBytes))
+		}
+	}
+
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "File sizes:")
+	for _, b := range st.SizeHistogram {
+		if b.Files == 0 {
+			continue
+		}
+		fmt.Fprintf(w, "  %-18s %10s  %s\n", bucketLabel(b), commaFormat(int64(b.Files)), formatBytes(b.Bytes))
+	}
+
+	if len(st.Extensions) > 0 {
+		fmt.Fprintln(w)
+		fmt.Fprintln(w, "Extensions:")
+		for _, e := range st.Extensions {
+			ext := "." + e.Ext
+			if e.Ext == "" {
+				ext = "(none)"
+			}
+			fmt.Fprintf(w, "  %-18s %10s  %s\n", c4m.SafeName(ext), commaFormat(int64(e.Files)), formatBytes(e.Bytes))
+		}
+	}
+
+	if len(st.LargestDirs) > 0 {
+		fmt.Fprintln(w)
+		fmt.Fprintln(w, "Largest directories:")
+		for _, d := range st.LargestDirs {
+			fmt.Fprintf(w, "  %20s  %s\n", formatBytes(d.Bytes), c4m.SafeName(d.Path))
+		}
+	}
+
+	if len(st.Deepest) > 0 {
+		fmt.Fprintln(w)
+		fmt.Fprintln(w, "Deepest paths:")
+		for _, d := range st.Deepest {
+			fmt.Fprintf(w, "  %3d  %s\n", d.Depth, c4m.SafeName(d.Path))
+		}
+	}
+}
+
+// bucketLabel describes a size histogram bucket, e.g. "1 KiB - 16 KiB".
+func bucketLabel(b c4m.SizeBucket) string {
+	switch {
+	case b.Max == 1:
+		return "empty"
+	case b.Max < 0:
+		return ">= " + binarySize(b.Min)
+	case b.Min <= 1:
+		return "< " + binarySize(b.Max)
+	}
+	return binarySize(b.Min) + " - " + binarySize(b.Max)
+}
+
+// binarySize formats a power-of-two size with a binary unit.
+func binarySize(n int64) string {
+	units := []string{"B", "KiB", "MiB", "GiB", "TiB"}
+	i := 0
+	for n >= 1024 && n%1024 == 0 && i < len(units)-1 {
+		n /= 1024
+		i++
+	}
+	return fmt.Sprintf("%d %s", n, units[i])
+}
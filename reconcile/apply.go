@@ -1,6 +1,7 @@
 package reconcile
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"os"
@@ -28,6 +29,9 @@ func (r *Reconciler) Apply(plan *Plan, dirPath string) (*Result, error) {
 	var dirOps []Operation
 
 	for _, op := range plan.Operations {
+		if err := r.ctx.Err(); err != nil {
+			return res, err
+		}
 		switch op.Type {
 		case OpMkdir:
 			if err := r.applyMkdir(op, res); err != nil {
@@ -130,7 +134,8 @@ func (r *Reconciler) applyCreate(op Operation, res *Result) error {
 	if err != nil {
 		return err
 	}
-	if _, err := io.Copy(dw, rc); err != nil {
+	if _, err := io.Copy(dw, &ctxReader{r.ctx, rc}); err != nil {
+		dw.Abort()
 		return err
 	}
 	if err := dw.Close(); err != nil {
@@ -342,3 +347,16 @@ func isEXDEV(err error) bool {
 	errno, ok := lerr.Err.(syscall.Errno)
 	return ok && errno == syscall.EXDEV
 }
+
+// ctxReader fails reads once its context is done.
+type ctxReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r *ctxReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
+}
@@ -21,13 +21,15 @@ func (r *Reconciler) Plan(target *c4m.Manifest, dirPath string) (*Plan, error) {
 		return nil, err
 	}
 
-	// 1. Build target path map from manifest entries.
-	targetPaths := c4m.EntryPaths(target.Entries)
+	// 1. Build target path map from manifest entries. Keys are path keys
+	//    (c4m.PathKey), which equal the relative paths unless normalizing.
+	targetPaths := c4m.EntryPaths(target.Entries, r.pathOpts...)
 
 	// 2. Scan current directory state.
-	currentFiles := make(map[string]os.FileInfo) // relative path -> info
-	currentIDs := make(map[string]c4.ID)          // relative path -> C4 ID
-	idToCurrentPaths := make(map[c4.ID][]string)  // C4 ID -> relative paths
+	currentFiles := make(map[string]os.FileInfo) // path key -> info
+	currentRel := make(map[string]string)        // path key -> relative path on disk
+	currentIDs := make(map[string]c4.ID)         // relative path -> C4 ID
+	idToCurrentPaths := make(map[c4.ID][]string) // C4 ID -> relative paths
 
 	if info, err := os.Stat(dirPath); err == nil && info.IsDir() {
 		filepath.Walk(dirPath, func(path string, info os.FileInfo, err error) error {
@@ -43,7 +45,9 @@ func (r *Reconciler) Plan(target *c4m.Manifest, dirPath string) (*Plan, error) {
 			if info.IsDir() {
 				rel += "/"
 			}
-			currentFiles[rel] = info
+			key := c4m.PathKey(rel, r.pathOpts...)
+			currentFiles[key] = info
+			currentRel[key] = rel
 
 			// Compute C4 ID for regular files.
 			if info.Mode().IsRegular() && info.Size() >= 0 {
@@ -75,11 +79,25 @@ func (r *Reconciler) Plan(target *c4m.Manifest, dirPath string) (*Plan, error) {
 	needsContent := make(map[c4.ID]bool)
 	// Track which current paths are accounted for by the target.
 	targetAccountedFor := make(map[string]bool)
+	// Where each target entry lives on disk: an existing file or directory
+	// that matches it keeps its own spelling.
+	diskPaths := diskSpellings(targetPaths, currentRel)
+	targetRel := make(map[*c4m.Entry]string, len(targetPaths))
+	for rel, e := range c4m.EntryPaths(target.Entries) {
+		targetRel[e] = rel
+	}
+	var normalized []NormalizedPath
 
 	// Process target entries.
 	for relPath, entry := range targetPaths {
-		absPath := filepath.Join(dirPath, filepath.FromSlash(relPath))
+		absPath := filepath.Join(dirPath, filepath.FromSlash(diskPaths[relPath]))
 		targetAccountedFor[relPath] = true
+		if rel, ok := currentRel[relPath]; ok && rel != targetRel[entry] {
+			normalized = append(normalized, NormalizedPath{
+				Path:    filepath.Join(dirPath, filepath.FromSlash(targetRel[entry])),
+				Current: absPath,
+			})
+		}
 
 		if entry.IsDir() {
 			curInfo, exists := currentFiles[relPath]
@@ -115,7 +133,7 @@ func (r *Reconciler) Plan(target *c4m.Manifest, dirPath string) (*Plan, error) {
 			curInfo, exists := currentFiles[relPath]
 			if exists && curInfo.Mode()&os.ModeSymlink != 0 {
 				// Check if symlink target matches.
-				curTarget, lerr := os.Readlink(filepath.Join(dirPath, filepath.FromSlash(relPath)))
+				curTarget, lerr := os.Readlink(absPath)
 				if lerr == nil && curTarget == entry.Target {
 					continue // already correct
 				}
@@ -131,7 +149,7 @@ func (r *Reconciler) Plan(target *c4m.Manifest, dirPath string) (*Plan, error) {
 		// Regular file.
 		curInfo, exists := currentFiles[relPath]
 		if exists {
-			curID := currentIDs[relPath]
+			curID := currentIDs[currentRel[relPath]]
 			sameContent := !entry.C4ID.IsNil() && curID == entry.C4ID
 
 			if sameContent {
@@ -178,7 +196,7 @@ func (r *Reconciler) Plan(target *c4m.Manifest, dirPath string) (*Plan, error) {
 		if targetAccountedFor[relPath] {
 			continue
 		}
-		absPath := filepath.Join(dirPath, filepath.FromSlash(relPath))
+		absPath := filepath.Join(dirPath, filepath.FromSlash(currentRel[relPath]))
 		if info.IsDir() {
 			rmdirs = append(rmdirs, Operation{
 				Type: OpRmdir,
@@ -260,7 +278,7 @@ func (r *Reconciler) Plan(target *c4m.Manifest, dirPath string) (*Plan, error) {
 	}
 
 	if len(missingIDs) > 0 {
-		return &Plan{Missing: missingIDs}, nil
+		return &Plan{Missing: missingIDs, Normalized: normalized}, nil
 	}
 
 	// 7. Order operations.
@@ -287,7 +305,36 @@ func (r *Reconciler) Plan(target *c4m.Manifest, dirPath string) (*Plan, error) {
 	ops = append(ops, removes...)
 	ops = append(ops, rmdirs...)
 
-	return &Plan{Operations: ops}, nil
+	sort.Slice(normalized, func(i, j int) bool {
+		return normalized[i].Path < normalized[j].Path
+	})
+	return &Plan{Operations: ops, Normalized: normalized}, nil
+}
+
+// diskSpellings returns, for each target path key, the relative path the
+// entry has (or will have) on disk. A path that already exists keeps its
+// on-disk spelling, and new entries are created inside their parent's
+// on-disk directory.
+func diskSpellings(target map[string]*c4m.Entry, current map[string]string) map[string]string {
+	keys := make([]string, 0, len(target))
+	for k := range target {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys) // parents sort before their children
+
+	disk := make(map[string]string, len(keys))
+	for _, k := range keys {
+		if rel, ok := current[k]; ok {
+			disk[k] = rel
+			continue
+		}
+		parent := ""
+		if i := strings.LastIndex(strings.TrimSuffix(k, "/"), "/"); i >= 0 {
+			parent = disk[k[:i+1]]
+		}
+		disk[k] = parent + target[k].Name
+	}
+	return disk
 }
 
 // depthOf counts path separators to determine nesting depth.
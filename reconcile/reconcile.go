@@ -1,6 +1,7 @@
 package reconcile
 
 import (
+	"context"
 	"io"
 	"os"
 	"path/filepath"
@@ -112,6 +113,18 @@ type Operation struct {
 type Plan struct {
 	Operations []Operation
 	Missing    []c4.ID
+
+	// Normalized lists target paths matched to an on-disk path that
+	// differs only by Unicode normalization (see WithPathOptions).
+	// Operations use the on-disk spelling; Apply does not rename them.
+	Normalized []NormalizedPath
+}
+
+// NormalizedPath pairs a target path with the on-disk path it matched by
+// Unicode normalization. Both are absolute.
+type NormalizedPath struct {
+	Path    string // target spelling
+	Current string // on-disk spelling
 }
 
 // IsComplete returns true when all required content is available.
@@ -135,9 +148,11 @@ type Saver interface {
 
 // Reconciler orchestrates filesystem reconciliation.
 type Reconciler struct {
+	ctx           context.Context
 	sources       []ContentSource
 	dryRun        bool
 	storeRemovals Saver // if set, store content before removing files
+	pathOpts      []c4m.PathOption
 }
 
 // Option configures a Reconciler.
@@ -166,9 +181,30 @@ func WithStoreRemovals(s Saver) Option {
 	}
 }
 
+// WithPathOptions sets how target paths are matched against the files on
+// disk. With c4m.NormalizedPaths, a file whose name is the NFD spelling of
+// a target entry's NFC name (or vice versa) is treated as that entry
+// instead of being removed and recreated.
+func WithPathOptions(opts ...c4m.PathOption) Option {
+	return func(r *Reconciler) {
+		r.pathOpts = opts
+	}
+}
+
+// WithContext sets a context for Apply. Once it is done, Apply stops
+// before the next operation, and a file being written is abandoned, so
+// that the directory is left as if the remaining operations had not run.
+// Content sources that are stores should be bound to the same context
+// with store.Bind.
+func WithContext(ctx context.Context) Option {
+	return func(r *Reconciler) {
+		r.ctx = ctx
+	}
+}
+
 // New creates a Reconciler with the given options.
 func New(opts ...Option) *Reconciler {
-	r := &Reconciler{}
+	r := &Reconciler{ctx: context.Background()}
 	for _, o := range opts {
 		o(r)
 	}
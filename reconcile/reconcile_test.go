@@ -2,6 +2,7 @@ package reconcile
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -272,6 +273,28 @@ func TestApplyCreatesFiles(t *testing.T) {
 	}
 }
 
+func TestApplyCancelled(t *testing.T) {
+	srcDir := t.TempDir()
+	dstDir := t.TempDir()
+
+	id := writeFile(t, srcDir, "a.txt", "alpha")
+	m := buildManifest(t, []testEntry{{name: "a.txt", content: "alpha", id: id, mode: 0644}})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	rec := New(WithSource(NewDirSource(m, srcDir)), WithContext(ctx))
+	plan, err := rec.Plan(m, dstDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+	if _, err := rec.Apply(plan, dstDir); err != context.Canceled {
+		t.Fatalf("Apply after cancel: got %v, want context.Canceled", err)
+	}
+	if names, _ := os.ReadDir(dstDir); len(names) != 0 {
+		t.Errorf("cancelled Apply left %d files", len(names))
+	}
+}
+
 func TestApplyIdempotent(t *testing.T) {
 	srcDir := t.TempDir()
 	dstDir := t.TempDir()
@@ -659,3 +682,42 @@ func TestOpenContentSkipsFailedSources(t *testing.T) {
 		t.Fatalf("got %q, want %q", data, content)
 	}
 }
+
+func TestPlanNormalizedPaths(t *testing.T) {
+	dir := t.TempDir()
+	nfd := "café.txt"
+	id := writeFile(t, dir, nfd, "coffee")
+	ts := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	os.Chtimes(filepath.Join(dir, nfd), ts, ts)
+
+	target := buildManifest(t, []testEntry{
+		{name: "café.txt", content: "coffee", id: id, mode: 0644},
+	})
+
+	if runtime.GOOS == "linux" {
+		// Exact matching treats the NFD file as unrelated: it is moved.
+		plan, err := New().Plan(target, dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(plan.Operations) == 0 {
+			t.Fatal("expected operations without normalization")
+		}
+	}
+
+	plan, err := New(WithPathOptions(c4m.NormalizedPaths())).Plan(target, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, op := range plan.Operations {
+		if op.Type != OpChmod {
+			t.Errorf("unexpected op: type=%d path=%s", op.Type, op.Path)
+		}
+	}
+	if len(plan.Normalized) != 1 {
+		t.Fatalf("expected 1 normalized path, got %v", plan.Normalized)
+	}
+	if got := filepath.Base(plan.Normalized[0].Current); got != nfd {
+		t.Errorf("Current = %+q, want on-disk spelling", got)
+	}
+}
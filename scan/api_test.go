@@ -303,3 +303,27 @@ func TestDirContentIdentity(t *testing.T) {
 		}
 	}
 }
+
+// TestDirWithGuideNormalized tests guide matching across NFC/NFD spellings.
+func TestDirWithGuideNormalized(t *testing.T) {
+	dir := t.TempDir()
+	nfd := "café.txt"
+	os.WriteFile(filepath.Join(dir, nfd), []byte("coffee"), 0644)
+
+	guide := c4m.NewManifest()
+	guide.AddEntry(&c4m.Entry{Name: "café.txt", Size: 6, Depth: 0})
+
+	m, err := Dir(dir, WithGuide(guide), WithPathOptions(c4m.NormalizedPaths()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Entries) != 1 || m.Entries[0].Name != nfd {
+		t.Fatalf("expected the on-disk NFD name, got %v", m.Entries)
+	}
+
+	// Option order does not matter.
+	m, err = Dir(dir, WithPathOptions(c4m.NormalizedPaths()), WithGuide(guide))
+	if err != nil || len(m.Entries) != 1 {
+		t.Fatalf("expected 1 entry, got %v (%v)", m, err)
+	}
+}
@@ -0,0 +1,159 @@
+package store
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// appendLog keeps the state of a Cache, Reference or MultiStore write
+// queue in dir/name, an append-only file of lines shared by every process
+// using it. Each change is applied and appended under the lock file in dir,
+// after applying what other processes have appended. When the file has
+// grown to well over the lines needed to describe the state, it is
+// rewritten with just those. A line cut short by a crash is completed with
+// a newline before appending.
+//
+// Without a dir the state is kept in memory only. An appendLog is not safe
+// for concurrent use; its owner serializes calls.
+type appendLog struct {
+	dir, name string
+	state     logState
+
+	file    *os.File
+	applied int64 // how much of file has been applied
+	lines   int
+	torn    bool
+}
+
+// logState is the state an appendLog describes.
+type logState interface {
+	// resetLog empties the state, before the log is read from the start.
+	resetLog()
+	// applyLine applies one line. Lines it cannot parse are ignored.
+	applyLine(line string)
+	// logLines returns how many lines writeLog writes.
+	logLines() int
+	// writeLog writes lines describing the whole state.
+	writeLog(w io.Writer)
+}
+
+func newAppendLog(dir, name string, state logState) *appendLog {
+	return &appendLog{dir: dir, name: name, state: state}
+}
+
+func (l *appendLog) path() string { return filepath.Join(l.dir, l.name) }
+
+// update runs fn, holding the lock file of a persistent log and with what
+// other processes have appended applied first.
+func (l *appendLog) update(fn func() error) error {
+	if l.dir == "" {
+		return fn()
+	}
+	lock, err := lockFile(filepath.Join(l.dir, treeLockName), true)
+	if err != nil {
+		return err
+	}
+	defer lock.Close()
+	if err := l.catchUp(); err != nil {
+		return err
+	}
+	if err := fn(); err != nil {
+		return err
+	}
+	if l.lines > 2*l.state.logLines()+1024 {
+		return l.compact()
+	}
+	return nil
+}
+
+// catchUp applies the lines appended since the log was last read,
+// starting over if another process has compacted it.
+func (l *appendLog) catchUp() error {
+	if l.file != nil {
+		cur, err1 := l.file.Stat()
+		disk, err2 := os.Stat(l.path())
+		if err1 != nil || err2 != nil || !os.SameFile(cur, disk) {
+			l.file.Close()
+			l.file = nil
+		}
+	}
+	if l.file == nil {
+		f, err := os.OpenFile(l.path(), os.O_CREATE|os.O_RDWR|os.O_APPEND, 0644)
+		if err != nil {
+			return err
+		}
+		l.file, l.applied, l.lines, l.torn = f, 0, 0, false
+		l.state.resetLog()
+	}
+	br := bufio.NewReader(io.NewSectionReader(l.file, l.applied, 1<<62))
+	for {
+		line, err := br.ReadString('\n')
+		if err == io.EOF {
+			l.torn = line != ""
+			l.applied += int64(len(line))
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		l.applied += int64(len(line))
+		l.lines++
+		l.state.applyLine(strings.TrimSuffix(line, "\n"))
+	}
+}
+
+// record applies a line and, for a persistent log, appends it. It must be
+// called from within update.
+func (l *appendLog) record(line string) error {
+	l.state.applyLine(line)
+	if l.file == nil {
+		return nil
+	}
+	line += "\n"
+	if l.torn {
+		line = "\n" + line
+		l.torn = false
+	}
+	n, err := l.file.WriteString(line)
+	l.applied += int64(n)
+	l.lines++
+	return err
+}
+
+// compact rewrites a persistent log with the lines describing the state.
+// It must be called from within update.
+func (l *appendLog) compact() error {
+	if l.file == nil {
+		return nil
+	}
+	w, err := NewDurableWriter(l.path())
+	if err != nil {
+		return err
+	}
+	bw := bufio.NewWriter(w)
+	l.state.writeLog(bw)
+	if err := bw.Flush(); err != nil {
+		w.Abort()
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	// Reread it, as other processes will.
+	l.file.Close()
+	l.file = nil
+	return l.catchUp()
+}
+
+// close closes the log file.
+func (l *appendLog) close() error {
+	if l.file == nil {
+		return nil
+	}
+	err := l.file.Close()
+	l.file = nil
+	return err
+}
@@ -0,0 +1,613 @@
+package store
+
+import (
+	"container/list"
+	"crypto/sha512"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/Avalanche-io/c4"
+)
+
+var _ Store = &Cache{}
+
+// Cache puts a fast store, such as a local SSD, in front of a slow one,
+// such as S3. Reads are served from the fast store when it has the
+// content; otherwise they stream from the slow store, and the content is
+// copied into the fast store once it has been read in full and verified.
+// Writes go to both.
+//
+// The fast store is kept under a byte budget by evicting the least
+// recently used objects. Pinned objects are never evicted. A cache opened
+// with OpenCache records accesses, evictions and pins in an append-only
+// index beside the fast store, so recency and pins survive restarts and
+// are shared by every process using the cache.
+type Cache struct {
+	fast, slow Store
+	max        int64
+	dir        string // "" for a cache whose index is kept in memory only
+
+	mu      sync.Mutex
+	lru     *list.List // of *cacheEntry, most recently used first
+	entries map[c4.ID]*list.Element
+	pinned  map[c4.ID]bool
+	size    int64
+	log     *appendLog // the index
+}
+
+type cacheEntry struct {
+	id   c4.ID
+	size int64
+}
+
+// NewCache returns a cache of slow in fast, holding at most maxBytes in
+// fast, or any amount if maxBytes is zero. Objects already in fast are
+// adopted if it can list them, oldest first in line for eviction. The
+// index is kept in memory only; see OpenCache.
+func NewCache(fast, slow Store, maxBytes int64) (*Cache, error) {
+	c := newCache(fast, slow, maxBytes)
+	if err := c.adopt(); err != nil {
+		return nil, err
+	}
+	if err := c.evict(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// OpenCache opens or creates a cache of slow in dir, with the cached
+// content in a TreeStore in dir/objects and the index in dir/index.
+func OpenCache(dir string, slow Store, maxBytes int64) (*Cache, error) {
+	fast, err := NewTreeStore(filepath.Join(dir, "objects"))
+	if err != nil {
+		return nil, err
+	}
+	c := newCache(fast, slow, maxBytes)
+	c.dir = dir
+	c.log = newAppendLog(dir, "index", c)
+	_, err = os.Stat(c.indexPath())
+	fresh := os.IsNotExist(err)
+
+	err = c.update(func() error {
+		if fresh {
+			// A lost index, or content placed in dir by hand.
+			if err := c.adopt(); err != nil {
+				return err
+			}
+			for e := c.lru.Back(); e != nil; e = e.Prev() {
+				ce := e.Value.(*cacheEntry)
+				if err := c.record('a', ce.id, ce.size); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func newCache(fast, slow Store, maxBytes int64) *Cache {
+	c := &Cache{
+		fast:    fast,
+		slow:    slow,
+		max:     maxBytes,
+		lru:     list.New(),
+		entries: make(map[c4.ID]*list.Element),
+		pinned:  make(map[c4.ID]bool),
+	}
+	c.log = newAppendLog("", "", c)
+	return c
+}
+
+// Close closes the index.
+func (c *Cache) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.log.close()
+}
+
+// Size returns the bytes and number of objects the cache holds.
+func (c *Cache) Size() (int64, int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.size, c.lru.Len()
+}
+
+func (c *Cache) indexPath() string { return filepath.Join(c.dir, "index") }
+
+// adopt adds the objects in the fast store to the index, by modification
+// time.
+func (c *Cache) adopt() error {
+	var objs []Object
+	err := List(c.fast, func(o Object) bool {
+		if _, ok := c.entries[o.ID]; !ok {
+			objs = append(objs, o)
+		}
+		return true
+	})
+	if err != nil && err != ErrNotImplemented {
+		return err
+	}
+	sort.Slice(objs, func(i, j int) bool { return objs[i].ModTime.Before(objs[j].ModTime) })
+	for _, o := range objs {
+		c.apply('a', o.ID, o.Size)
+	}
+	return nil
+}
+
+// apply applies one index operation: 'a' for an access or insertion, 'e'
+// for an eviction or removal, 'p' to pin and 'u' to unpin.
+func (c *Cache) apply(op byte, id c4.ID, size int64) {
+	switch op {
+	case 'a':
+		if e, ok := c.entries[id]; ok {
+			ce := e.Value.(*cacheEntry)
+			c.size += size - ce.size
+			ce.size = size
+			c.lru.MoveToFront(e)
+			return
+		}
+		c.entries[id] = c.lru.PushFront(&cacheEntry{id, size})
+		c.size += size
+	case 'e':
+		if e, ok := c.entries[id]; ok {
+			c.size -= e.Value.(*cacheEntry).size
+			c.lru.Remove(e)
+			delete(c.entries, id)
+		}
+	case 'p':
+		c.pinned[id] = true
+	case 'u':
+		delete(c.pinned, id)
+	}
+}
+
+// update runs fn with the cache locked. For a persistent cache it also
+// holds the index's lock file, and first applies what other processes
+// have appended to the index.
+func (c *Cache) update(fn func() error) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.log.update(fn)
+}
+
+// record applies an index operation and, for a persistent cache, appends
+// it to the index. It requires update's locks.
+func (c *Cache) record(op byte, id c4.ID, size int64) error {
+	line := string(op) + " " + id.String()
+	if op == 'a' {
+		line += " " + strconv.FormatInt(size, 10)
+	}
+	return c.log.record(line)
+}
+
+func (c *Cache) resetLog() {
+	c.lru.Init()
+	c.entries = make(map[c4.ID]*list.Element)
+	c.pinned = make(map[c4.ID]bool)
+	c.size = 0
+}
+
+func (c *Cache) applyLine(line string) {
+	if op, id, size, ok := parseCacheLine(line); ok {
+		c.apply(op, id, size)
+	}
+}
+
+func parseCacheLine(line string) (byte, c4.ID, int64, bool) {
+	f := strings.Fields(line)
+	if len(f) < 2 || len(f[0]) != 1 {
+		return 0, c4.ID{}, 0, false
+	}
+	id, err := c4.Parse(f[1])
+	if err != nil {
+		return 0, c4.ID{}, 0, false
+	}
+	var size int64
+	if f[0] == "a" {
+		if len(f) != 3 {
+			return 0, c4.ID{}, 0, false
+		}
+		if size, err = strconv.ParseInt(f[2], 10, 64); err != nil || size < 0 {
+			return 0, c4.ID{}, 0, false
+		}
+	}
+	return f[0][0], id, size, true
+}
+
+func (c *Cache) logLines() int { return c.lru.Len() + len(c.pinned) }
+
+// writeLog writes one line per cached object, least recently used first,
+// and one per pin.
+func (c *Cache) writeLog(w io.Writer) {
+	for e := c.lru.Back(); e != nil; e = e.Prev() {
+		ce := e.Value.(*cacheEntry)
+		fmt.Fprintf(w, "a %s %d\n", ce.id, ce.size)
+	}
+	for id := range c.pinned {
+		fmt.Fprintf(w, "p %s\n", id)
+	}
+}
+
+// evict removes least recently used objects from the fast store until the
+// cache is within its budget. It requires update's locks.
+func (c *Cache) evict() error {
+	if c.max <= 0 {
+		return nil
+	}
+	e := c.lru.Back()
+	for c.size > c.max && e != nil {
+		ce := e.Value.(*cacheEntry)
+		e = e.Prev()
+		if c.pinned[ce.id] {
+			continue
+		}
+		if err := c.fast.Remove(ce.id); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		if err := c.record('e', ce.id, 0); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// touch records an access to id.
+func (c *Cache) touch(id c4.ID, size int64) error {
+	return c.update(func() error {
+		if e, ok := c.entries[id]; ok && size < 0 {
+			size = e.Value.(*cacheEntry).size
+		}
+		if size < 0 {
+			return nil
+		}
+		if err := c.record('a', id, size); err != nil {
+			return err
+		}
+		return c.evict()
+	})
+}
+
+// fill copies the content of id, spooled in tmp and already checked
+// against id, into the fast store. It is stored with Put, which keeps what
+// the fast store already has, so nothing is ever removed to fill it.
+func (c *Cache) fill(id c4.ID, tmp *os.File, size int64) error {
+	if !c.fast.Has(id) {
+		if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		// A plain reader, so that a fast store that links files does not
+		// take the spool itself.
+		got, err := c.fast.Put(struct{ io.Reader }{tmp})
+		if err != nil {
+			return err
+		}
+		if got != id {
+			return ErrInvalidID
+		}
+	}
+	return c.touch(id, size)
+}
+
+func (c *Cache) spool() (*os.File, error) {
+	return os.CreateTemp(c.dir, ".fill.*")
+}
+
+// Has reports whether either store has id.
+func (c *Cache) Has(id c4.ID) bool {
+	return c.fast.Has(id) || c.slow.Has(id)
+}
+
+// Open returns the content of id from the fast store, or streams it from
+// the slow store, caching it once it has been read to the end. Content
+// from the slow store that does not match id fails with ErrInvalidID and
+// is not cached.
+func (c *Cache) Open(id c4.ID) (io.ReadCloser, error) {
+	if rc, err := c.fast.Open(id); err == nil {
+		c.mu.Lock()
+		_, known := c.entries[id]
+		c.mu.Unlock()
+		if known {
+			c.touch(id, -1)
+			return rc, nil
+		}
+		// Not indexed yet: learn its size by reading it.
+		return &cacheReader{c: c, id: id, r: rc}, nil
+	}
+	rc, err := c.slow.Open(id)
+	if err != nil {
+		return nil, err
+	}
+	tmp, err := c.spool()
+	if err != nil {
+		return rc, nil // served, but not cached
+	}
+	return &cacheReader{c: c, id: id, r: rc, tmp: tmp, h: sha512.New()}, nil
+}
+
+// OpenRange reads part of id from the fast store if it has it, and
+// otherwise from the slow store. Partial reads are not cached.
+func (c *Cache) OpenRange(id c4.ID, off, n int64) (io.ReadCloser, error) {
+	if c.fast.Has(id) {
+		rc, err := OpenRange(c.fast, id, off, n)
+		if err == nil {
+			c.touch(id, -1)
+			return rc, nil
+		}
+	}
+	return OpenRange(c.slow, id, off, n)
+}
+
+// cacheReader reads an object that is not yet in the index, recording it
+// when the end is reached. With a spool, the content is coming from the
+// slow store, and is verified and copied into the fast store.
+type cacheReader struct {
+	c   *Cache
+	id  c4.ID
+	r   io.ReadCloser
+	tmp *os.File
+	h   hash.Hash
+	n   int64
+	err error
+}
+
+func (r *cacheReader) Read(p []byte) (int, error) {
+	if r.err != nil {
+		return 0, r.err
+	}
+	n, err := r.r.Read(p)
+	r.n += int64(n)
+	if r.tmp != nil && n > 0 {
+		r.h.Write(p[:n])
+		if _, werr := r.tmp.Write(p[:n]); werr != nil {
+			r.discard() // keep serving, but give up on caching
+		}
+	}
+	if err != io.EOF {
+		return n, err
+	}
+	if r.tmp == nil {
+		if r.h == nil {
+			r.c.touch(r.id, r.n)
+		}
+		r.err = io.EOF
+		return n, io.EOF
+	}
+	var got c4.ID
+	copy(got[:], r.h.Sum(nil))
+	if got != r.id {
+		r.discard()
+		r.err = ErrInvalidID
+		return n, r.err
+	}
+	r.c.fill(r.id, r.tmp, r.n)
+	r.discard()
+	r.err = io.EOF
+	return n, io.EOF
+}
+
+func (r *cacheReader) discard() {
+	if r.tmp != nil {
+		r.tmp.Close()
+		os.Remove(r.tmp.Name())
+		r.tmp = nil
+	}
+}
+
+func (r *cacheReader) Close() error {
+	r.discard()
+	return r.r.Close()
+}
+
+// Put stores the content of r in the slow store and caches it.
+func (c *Cache) Put(r io.Reader) (c4.ID, error) {
+	tmp, err := c.spool()
+	if err != nil {
+		return c4.ID{}, err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	cr := &countingReader{r: io.TeeReader(r, tmp)}
+	id, err := c.slow.Put(cr)
+	if err != nil {
+		return id, err
+	}
+	return id, c.fill(id, tmp, cr.n)
+}
+
+// Create returns a writer that stores the content of id in the slow store
+// and caches it on Close.
+func (c *Cache) Create(id c4.ID) (io.WriteCloser, error) {
+	tmp, err := c.spool()
+	if err != nil {
+		return nil, err
+	}
+	w, err := c.slow.Create(id)
+	if err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return nil, err
+	}
+	return &cacheWriter{c: c, id: id, w: w, tmp: tmp, h: sha512.New()}, nil
+}
+
+type cacheWriter struct {
+	c   *Cache
+	id  c4.ID
+	w   io.WriteCloser
+	tmp *os.File
+	h   hash.Hash
+	n   int64
+}
+
+func (w *cacheWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	w.n += int64(n)
+	w.h.Write(p[:n])
+	if _, terr := w.tmp.Write(p[:n]); terr != nil && err == nil {
+		err = terr
+	}
+	return n, err
+}
+
+// Close finishes the write to the slow store, and caches the content if
+// it matches id.
+func (w *cacheWriter) Close() error {
+	defer os.Remove(w.tmp.Name())
+	defer w.tmp.Close()
+	if err := w.w.Close(); err != nil {
+		return err
+	}
+	var got c4.ID
+	copy(got[:], w.h.Sum(nil))
+	if got != w.id {
+		return ErrInvalidID
+	}
+	return w.c.fill(w.id, w.tmp, w.n)
+}
+
+// Remove removes id from both stores, and unpins it.
+func (c *Cache) Remove(id c4.ID) error {
+	fastErr := c.fast.Remove(id)
+	slowErr := c.slow.Remove(id)
+	if err := c.update(func() error {
+		if err := c.record('e', id, 0); err != nil {
+			return err
+		}
+		if c.pinned[id] {
+			return c.record('u', id, 0)
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+	if slowErr == nil || fastErr == nil {
+		return nil
+	}
+	return slowErr
+}
+
+// List reports the objects in the slow store, then any in the fast store
+// that the slow store does not have.
+func (c *Cache) List(fn func(Object) bool) error {
+	seen := make(map[c4.ID]bool)
+	stopped := false
+	err := List(c.slow, func(o Object) bool {
+		seen[o.ID] = true
+		if !fn(o) {
+			stopped = true
+			return false
+		}
+		return true
+	})
+	if err != nil && err != ErrNotImplemented || stopped {
+		return err
+	}
+	ferr := List(c.fast, func(o Object) bool {
+		if seen[o.ID] {
+			return true
+		}
+		return fn(o)
+	})
+	if err == ErrNotImplemented && ferr == ErrNotImplemented {
+		return err
+	}
+	if ferr == ErrNotImplemented {
+		return nil
+	}
+	return ferr
+}
+
+// PinReport summarizes a Pin.
+type PinReport struct {
+	Pinned  int     // IDs pinned
+	Fetched int     // objects copied into the fast store
+	Bytes   int64   // bytes copied
+	Missing []c4.ID // IDs neither store has
+}
+
+// Pin pins ids so they are never evicted, and fetches those not yet in the
+// fast store.
+func (c *Cache) Pin(ids []c4.ID) (*PinReport, error) {
+	rep := &PinReport{}
+	err := c.update(func() error {
+		for _, id := range ids {
+			if c.pinned[id] {
+				continue
+			}
+			if err := c.record('p', id, 0); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	rep.Pinned = len(ids)
+	for _, id := range ids {
+		if c.fast.Has(id) {
+			continue
+		}
+		if !c.slow.Has(id) {
+			rep.Missing = append(rep.Missing, id)
+			continue
+		}
+		rc, err := c.Open(id)
+		if err != nil {
+			return rep, err
+		}
+		n, err := io.Copy(io.Discard, rc)
+		rc.Close()
+		if err != nil {
+			return rep, fmt.Errorf("fetching %s: %w", id, err)
+		}
+		rep.Fetched++
+		rep.Bytes += n
+	}
+	return rep, nil
+}
+
+// Unpin makes ids evictable again.
+func (c *Cache) Unpin(ids []c4.ID) error {
+	return c.update(func() error {
+		for _, id := range ids {
+			if !c.pinned[id] {
+				continue
+			}
+			if err := c.record('u', id, 0); err != nil {
+				return err
+			}
+		}
+		return c.evict()
+	})
+}
+
+// Caches returns the Caches in s, looking through wrappers and the members
+// of a MultiStore.
+func Caches(s Store) []*Cache {
+	var caches []*Cache
+	walkStores(s, func(s Store) {
+		if c, ok := s.(*Cache); ok {
+			caches = append(caches, c)
+		}
+	})
+	return caches
+}
+
+// Pinned reports whether id is pinned.
+func (c *Cache) Pinned(id c4.ID) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.pinned[id]
+}
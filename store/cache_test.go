@@ -0,0 +1,270 @@
+package store
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Avalanche-io/c4"
+)
+
+// putN stores n objects of size bytes in s and returns their IDs.
+func putN(t *testing.T, s Store, n, size int) []c4.ID {
+	t.Helper()
+	var ids []c4.ID
+	for i := 0; i < n; i++ {
+		id, err := s.Put(bytes.NewReader(randomBytes(int64(i), size)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+func TestCacheReadThrough(t *testing.T) {
+	fast, slow := NewRAM(), NewRAM()
+	ids := putN(t, slow, 3, 100)
+	c, err := NewCache(fast, slow, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rc, err := c.Open(ids[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.CopyN(io.Discard, rc, 10)
+	rc.Close()
+	if fast.Has(ids[0]) {
+		t.Error("partly read object was cached")
+	}
+
+	if got := readAll(t, c, ids[0]); !bytes.Equal(got, randomBytes(0, 100)) {
+		t.Fatal("content did not read through")
+	}
+	if !fast.Has(ids[0]) {
+		t.Fatal("object not cached after a full read")
+	}
+	if size, n := c.Size(); size != 100 || n != 1 {
+		t.Errorf("Size = %d bytes, %d objects; want 100, 1", size, n)
+	}
+
+	// Writes go to both stores.
+	id, err := c.Put(strings.NewReader("written"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !fast.Has(id) || !slow.Has(id) {
+		t.Error("Put did not write through to both stores")
+	}
+}
+
+func TestCacheVerifies(t *testing.T) {
+	fast, slow := NewRAM(), NewRAM()
+	id := c4.Identify(strings.NewReader("genuine"))
+	w, _ := slow.Create(id)
+	io.WriteString(w, "forgery")
+	w.Close()
+
+	c, _ := NewCache(fast, slow, 0)
+	rc, err := c.Open(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = io.ReadAll(rc)
+	rc.Close()
+	if err != ErrInvalidID {
+		t.Errorf("reading mismatched content: got %v, want ErrInvalidID", err)
+	}
+	if fast.Has(id) {
+		t.Error("mismatched content was cached")
+	}
+
+	// Mismatched content written through the cache is not cached either,
+	// and filling never removes from the fast store.
+	c, _ = NewCache(noRemove{fast, t}, NewRAM(), 0)
+	w, _ = c.Create(id)
+	io.WriteString(w, "forgery")
+	if err := w.Close(); err != ErrInvalidID {
+		t.Errorf("writing mismatched content: got %v, want ErrInvalidID", err)
+	}
+	if fast.Has(id) {
+		t.Error("mismatched write was cached")
+	}
+	c, _ = NewCache(noRemove{fast, t}, NewRAM(), 0)
+	w, err = c.Create(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(w, "genuine")
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if !fast.Has(id) {
+		t.Error("write was not cached")
+	}
+}
+
+func TestCacheEviction(t *testing.T) {
+	fast, slow := NewRAM(), NewRAM()
+	ids := putN(t, slow, 5, 100)
+	c, _ := NewCache(fast, slow, 300)
+
+	for _, id := range ids[:3] {
+		readAll(t, c, id)
+	}
+	readAll(t, c, ids[0]) // now most recently used
+	readAll(t, c, ids[3])
+	if fast.Has(ids[1]) {
+		t.Error("least recently used object not evicted")
+	}
+	for _, id := range []c4.ID{ids[0], ids[2], ids[3]} {
+		if !fast.Has(id) {
+			t.Errorf("%s evicted out of order", id)
+		}
+	}
+	if size, _ := c.Size(); size > 300 {
+		t.Errorf("cache holds %d bytes, over its 300 byte budget", size)
+	}
+
+	if _, err := c.Pin(ids[:1]); err != nil {
+		t.Fatal(err)
+	}
+	readAll(t, c, ids[4])
+	readAll(t, c, ids[1])
+	readAll(t, c, ids[2])
+	if !fast.Has(ids[0]) {
+		t.Error("pinned object evicted")
+	}
+	if !c.Has(ids[3]) {
+		t.Error("evicted object missing from the cache as a whole")
+	}
+}
+
+func TestCachePin(t *testing.T) {
+	fast, slow := NewRAM(), NewRAM()
+	ids := putN(t, slow, 3, 100)
+	c, _ := NewCache(fast, slow, 150)
+	missing := c4.Identify(strings.NewReader("nowhere"))
+
+	rep, err := c.Pin(append(ids[:2:2], missing))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rep.Pinned != 3 || rep.Fetched != 2 || rep.Bytes != 200 || len(rep.Missing) != 1 {
+		t.Errorf("Pin report %+v", rep)
+	}
+	// Pins may exceed the budget; only unpinned objects are evicted.
+	if !fast.Has(ids[0]) || !fast.Has(ids[1]) {
+		t.Error("pinned objects not fetched")
+	}
+	if err := c.Unpin(ids[:1]); err != nil {
+		t.Fatal(err)
+	}
+	if fast.Has(ids[0]) || !fast.Has(ids[1]) {
+		t.Error("unpinning did not bring the cache back within budget")
+	}
+}
+
+func TestOpenCache(t *testing.T) {
+	dir := t.TempDir()
+	slow := NewRAM()
+	ids := putN(t, slow, 4, 100)
+
+	c, err := OpenCache(dir, slow, 300)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range ids[:3] {
+		readAll(t, c, id)
+	}
+	readAll(t, c, ids[0])
+	c.Pin(ids[1:2])
+	c.Close()
+
+	// Recency and pins survive a restart: ids[2] is least recently used
+	// apart from the pinned ids[1].
+	c2, err := OpenCache(dir, slow, 300)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c2.Close()
+	if size, n := c2.Size(); size != 300 || n != 3 {
+		t.Fatalf("reopened cache holds %d bytes in %d objects", size, n)
+	}
+	if !c2.Pinned(ids[1]) {
+		t.Fatal("pin lost on reopening")
+	}
+	readAll(t, c2, ids[3])
+	if c2.fast.Has(ids[2]) || !c2.fast.Has(ids[0]) || !c2.fast.Has(ids[1]) {
+		t.Error("eviction after reopening ignored the recorded access order")
+	}
+
+	// Another instance sees accesses recorded by this one.
+	c3, _ := OpenCache(dir, slow, 300)
+	defer c3.Close()
+	readAll(t, c2, ids[0])
+	readAll(t, c3, ids[2])
+	if c3.fast.Has(ids[3]) || !c3.fast.Has(ids[0]) {
+		t.Error("eviction ignored an access by another instance")
+	}
+
+	// A lost index is rebuilt from the cached objects.
+	os.Remove(filepath.Join(dir, "index"))
+	rebuilt, err := OpenCache(dir, slow, 300)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rebuilt.Close()
+	if size, n := rebuilt.Size(); size != 300 || n != 3 {
+		t.Errorf("rebuilt index has %d bytes in %d objects", size, n)
+	}
+}
+
+func TestCacheIndexCompaction(t *testing.T) {
+	dir := t.TempDir()
+	slow := NewRAM()
+	ids := putN(t, slow, 2, 10)
+	c, _ := OpenCache(dir, slow, 0)
+	defer c.Close()
+	for i := 0; i < 3000; i++ {
+		readAll(t, c, ids[i%2])
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "index"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := bytes.Count(data, []byte("\n")); lines > 2000 {
+		t.Errorf("index not compacted: %d lines", lines)
+	}
+	c2, _ := OpenCache(dir, slow, 0)
+	defer c2.Close()
+	if size, n := c2.Size(); size != 20 || n != 2 {
+		t.Errorf("compacted index has %d bytes in %d objects", size, n)
+	}
+}
+
+func TestOpenCacheConfigured(t *testing.T) {
+	dir := t.TempDir()
+	uri := fmt.Sprintf("cache://%s?size=1K,%s", filepath.Join(dir, "cache"), filepath.Join(dir, "tree"))
+	s, err := OpenURI(uri)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caches := Caches(s)
+	if len(caches) != 1 || caches[0].max != 1<<10 {
+		t.Fatalf("OpenURI did not open a 1K cache: %#v", s)
+	}
+	defer caches[0].Close()
+	if _, ok := caches[0].slow.(*TreeStore); !ok {
+		t.Errorf("cached store is %T, want *TreeStore", caches[0].slow)
+	}
+	if _, err := OpenURI("cache://" + filepath.Join(dir, "cache")); err == nil {
+		t.Error("cache with nothing to cache accepted")
+	}
+}
@@ -0,0 +1,396 @@
+package store
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha512"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"io"
+
+	"github.com/Avalanche-io/c4"
+)
+
+var _ Store = &Chunking{}
+
+// DefaultChunkSize is the average chunk size of NewChunking.
+const DefaultChunkSize = 256 << 10
+
+// Chunking wraps another store and splits content into variable-sized
+// chunks at boundaries chosen by a rolling hash of the content (FastCDC),
+// so an edit only changes the chunks around it. Each chunk is stored under
+// its own C4 ID, and the object's ID maps to a recipe listing them. Content
+// that fits in one chunk is stored whole.
+//
+// Open reassembles the original bytes and verifies them against the
+// object's ID. Recipes carry a header, so chunked and whole objects can
+// share a store; GC, fsck and stat recognize them. Chunking should be the
+// outermost wrapper, so that chunks, not ciphertext or compressed streams,
+// are what deduplicates.
+type Chunking struct {
+	s             Store
+	min, avg, max int
+	maskS, maskL  uint64
+}
+
+// NewChunking wraps s with DefaultChunkSize chunks.
+func NewChunking(s Store) *Chunking {
+	c, _ := NewChunkingSize(s, DefaultChunkSize)
+	return c
+}
+
+// NewChunkingSize wraps s with chunks averaging avg bytes, which must be a
+// power of two of at least 1 KiB. Chunks range from avg/4 to avg*4 bytes.
+func NewChunkingSize(s Store, avg int) (*Chunking, error) {
+	if avg < 1<<10 || avg&(avg-1) != 0 {
+		return nil, fmt.Errorf("chunk size %d is not a power of two >= 1024", avg)
+	}
+	bits := 0
+	for 1<<bits < avg {
+		bits++
+	}
+	// Normalized chunking: a stricter mask before the average size and a
+	// looser one after pulls chunk sizes towards the average. The masks
+	// test the high bits of the hash, which depend on the last 64 bytes.
+	return &Chunking{
+		s:     s,
+		min:   avg / 4,
+		avg:   avg,
+		max:   avg * 4,
+		maskS: highBits(bits + 2),
+		maskL: highBits(bits - 2),
+	}, nil
+}
+
+func highBits(n int) uint64 {
+	return ^uint64(0) << (64 - n)
+}
+
+// gear is the table of the rolling hash. It is generated from a fixed seed,
+// since chunk boundaries, and so deduplication, depend on it.
+var gear = func() (t [256]uint64) {
+	x := uint64(0xc4c4c4c4c4c4c4c4)
+	for i := range t {
+		// splitmix64
+		x += 0x9e3779b97f4a7c15
+		z := x
+		z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+		z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+		t[i] = z ^ (z >> 31)
+	}
+	return
+}()
+
+// cut returns the length of the chunk at the start of b. Unless b holds
+// the end of the content, it must be at least c.max bytes long.
+func (c *Chunking) cut(b []byte) int {
+	n := len(b)
+	if n <= c.min {
+		return n
+	}
+	normal, limit := c.avg, c.max
+	if n < normal {
+		normal = n
+	}
+	if n < limit {
+		limit = n
+	}
+	var fp uint64
+	i := c.min
+	for ; i < normal; i++ {
+		fp = fp<<1 + gear[b[i]]
+		if fp&c.maskS == 0 {
+			return i + 1
+		}
+	}
+	for ; i < limit; i++ {
+		fp = fp<<1 + gear[b[i]]
+		if fp&c.maskL == 0 {
+			return i + 1
+		}
+	}
+	return limit
+}
+
+// Chunk is one piece of a chunked object.
+type Chunk struct {
+	ID   c4.ID
+	Size int64
+}
+
+// A recipe is recipeMagic, a version byte and the chunk count as a
+// big-endian uint64, followed by each chunk's ID digest and size.
+const (
+	recipeMagic   = "\x89C4R"
+	recipeVersion = 1
+)
+
+func encodeRecipe(chunks []Chunk) []byte {
+	var buf bytes.Buffer
+	buf.WriteString(recipeMagic)
+	buf.WriteByte(recipeVersion)
+	binary.Write(&buf, binary.BigEndian, uint64(len(chunks)))
+	for _, ch := range chunks {
+		buf.Write(ch.ID[:])
+		binary.Write(&buf, binary.BigEndian, uint64(ch.Size))
+	}
+	return buf.Bytes()
+}
+
+// readRecipe parses a recipe from r, returning false if r does not begin
+// with a recipe header.
+func readRecipe(r *bufio.Reader) ([]Chunk, bool, error) {
+	head, err := r.Peek(len(recipeMagic) + 1)
+	if err != nil && err != io.EOF {
+		return nil, false, err
+	}
+	if len(head) <= len(recipeMagic) || string(head[:len(recipeMagic)]) != recipeMagic {
+		return nil, false, nil
+	}
+	if v := head[len(recipeMagic)]; v != recipeVersion {
+		return nil, true, fmt.Errorf("unknown recipe version %d", v)
+	}
+	r.Discard(len(head))
+	var count uint64
+	if err := binary.Read(r, binary.BigEndian, &count); err != nil {
+		return nil, true, fmt.Errorf("reading recipe: %w", err)
+	}
+	var chunks []Chunk
+	for i := uint64(0); i < count; i++ {
+		var ch Chunk
+		var size uint64
+		if _, err := io.ReadFull(r, ch.ID[:]); err != nil {
+			return nil, true, fmt.Errorf("reading recipe: %w", err)
+		}
+		if err := binary.Read(r, binary.BigEndian, &size); err != nil {
+			return nil, true, fmt.Errorf("reading recipe: %w", err)
+		}
+		ch.Size = int64(size)
+		chunks = append(chunks, ch)
+	}
+	return chunks, true, nil
+}
+
+// Chunks returns the chunks the object id in s is assembled from, or nil
+// if it is stored whole. s may be a Chunking store or the store beneath one.
+func Chunks(s Store, id c4.ID) ([]Chunk, error) {
+	if c, ok := s.(*Chunking); ok {
+		s = c.s
+	}
+	rc, err := s.Open(id)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	chunks, _, err := readRecipe(bufio.NewReader(rc))
+	return chunks, err
+}
+
+// Open returns the content of id, reassembled from its chunks if it was
+// chunked. A read fails with ErrInvalidID at the end of the content if it
+// does not match id.
+func (c *Chunking) Open(id c4.ID) (io.ReadCloser, error) {
+	rc, err := c.s.Open(id)
+	if err != nil {
+		return nil, err
+	}
+	r, err := reassemble(c.s, bufio.NewReader(rc))
+	if err != nil {
+		rc.Close()
+		return nil, fmt.Errorf("%s: %w", id, err)
+	}
+	return &validatingReader{sha512.New(), id, chunkCloser{r, rc}}, nil
+}
+
+// OpenRange reads only the chunks that hold the range.
+func (c *Chunking) OpenRange(id c4.ID, off, n int64) (io.ReadCloser, error) {
+	if off < 0 {
+		return nil, fmt.Errorf("open range of %s: negative offset %d", id, off)
+	}
+	hrc, err := OpenRange(c.s, id, 0, int64(len(recipeMagic)))
+	if err != nil {
+		return nil, err
+	}
+	head, err := io.ReadAll(hrc)
+	hrc.Close()
+	if err != nil {
+		return nil, err
+	}
+	if string(head) != recipeMagic {
+		return OpenRange(c.s, id, off, n) // stored whole
+	}
+	chunks, err := Chunks(c.s, id)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", id, err)
+	}
+	var start int64
+	for len(chunks) > 0 && start+chunks[0].Size <= off {
+		start += chunks[0].Size
+		chunks = chunks[1:]
+	}
+	if len(chunks) == 0 {
+		return io.NopCloser(bytes.NewReader(nil)), nil // past the end
+	}
+	rc, err := OpenRange(c.s, chunks[0].ID, off-start, -1)
+	if err != nil {
+		return nil, fmt.Errorf("chunk %s: %w", chunks[0].ID, err)
+	}
+	r := &chunkReader{src: c.s, chunks: chunks[1:], cur: rc}
+	return limitRange(chunkCloser{r, io.NopCloser(nil)}, n), nil
+}
+
+// chunkCloser closes the chunk being read along with the recipe.
+type chunkCloser struct {
+	io.Reader
+	c io.Closer
+}
+
+func (c chunkCloser) Close() error {
+	if cr, ok := c.Reader.(*chunkReader); ok && cr.cur != nil {
+		cr.cur.Close()
+	}
+	return c.c.Close()
+}
+
+// reassemble returns the content of the object read from r: the chunks
+// from src if it is a recipe, otherwise r itself.
+func reassemble(src Store, r *bufio.Reader) (io.Reader, error) {
+	chunks, ok, err := readRecipe(r)
+	if err != nil || !ok {
+		return r, err
+	}
+	return &chunkReader{src: src, chunks: chunks}, nil
+}
+
+type chunkReader struct {
+	src    Store
+	chunks []Chunk
+	cur    io.ReadCloser
+}
+
+func (r *chunkReader) Read(p []byte) (int, error) {
+	for {
+		if r.cur == nil {
+			if len(r.chunks) == 0 {
+				return 0, io.EOF
+			}
+			rc, err := r.src.Open(r.chunks[0].ID)
+			if err != nil {
+				return 0, fmt.Errorf("chunk %s: %w", r.chunks[0].ID, err)
+			}
+			r.cur = rc
+			r.chunks = r.chunks[1:]
+		}
+		n, err := r.cur.Read(p)
+		if err == io.EOF {
+			r.cur.Close()
+			r.cur = nil
+			if n == 0 {
+				continue
+			}
+			err = nil
+		}
+		return n, err
+	}
+}
+
+// Create returns a writer that chunks the content of id into the wrapped
+// store. Close fails if the content does not match id.
+func (c *Chunking) Create(id c4.ID) (io.WriteCloser, error) {
+	return &chunkWriter{c: c, id: id, h: sha512.New()}, nil
+}
+
+// Put chunks the content of r into the wrapped store and returns its ID.
+func (c *Chunking) Put(r io.Reader) (c4.ID, error) {
+	w := &chunkWriter{c: c, h: sha512.New()}
+	if _, err := io.Copy(w, r); err != nil {
+		return c4.ID{}, err
+	}
+	if err := w.Close(); err != nil {
+		return c4.ID{}, err
+	}
+	return w.id, nil
+}
+
+func (c *Chunking) Has(id c4.ID) bool { return c.s.Has(id) }
+
+// Remove removes the object's recipe or whole content. Its chunks, which
+// other objects may share, are left for garbage collection.
+func (c *Chunking) Remove(id c4.ID) error { return c.s.Remove(id) }
+
+// List calls List on the wrapped Store, which reports chunks and recipes
+// as objects.
+func (c *Chunking) List(fn func(Object) bool) error {
+	return List(c.s, fn)
+}
+
+type chunkWriter struct {
+	c      *Chunking
+	id     c4.ID // expected, or nil for Put
+	h      hash.Hash
+	buf    []byte
+	chunks []Chunk
+	err    error
+}
+
+func (w *chunkWriter) Write(p []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+	w.h.Write(p)
+	w.buf = append(w.buf, p...)
+	for len(w.buf) >= w.c.max {
+		if w.err = w.emit(w.c.cut(w.buf)); w.err != nil {
+			return 0, w.err
+		}
+	}
+	return len(p), nil
+}
+
+// emit stores the first n bytes of the buffer as a chunk.
+func (w *chunkWriter) emit(n int) error {
+	if n > 1 && bytes.HasPrefix(w.buf, []byte(recipeMagic)) {
+		// Stored as is, this chunk would read as a recipe.
+		if err := w.emit(1); err != nil {
+			return err
+		}
+		n--
+	}
+	id, err := w.c.s.Put(bytes.NewReader(w.buf[:n]))
+	if err != nil {
+		return err
+	}
+	w.chunks = append(w.chunks, Chunk{id, int64(n)})
+	w.buf = append(w.buf[:0], w.buf[n:]...)
+	return nil
+}
+
+func (w *chunkWriter) Close() error {
+	for w.err == nil && (len(w.buf) > 0 || len(w.chunks) == 0) {
+		w.err = w.emit(w.c.cut(w.buf))
+	}
+	if w.err != nil {
+		return w.err
+	}
+	var id c4.ID
+	copy(id[:], w.h.Sum(nil))
+	if !w.id.IsNil() && id != w.id {
+		return ErrInvalidID
+	}
+	w.id = id
+	if len(w.chunks) == 1 || w.c.s.Has(id) {
+		// A single chunk is the whole content, stored under its own ID.
+		return nil
+	}
+	rw, err := w.c.s.Create(id)
+	if err != nil {
+		return err
+	}
+	if _, err := rw.Write(encodeRecipe(w.chunks)); err != nil {
+		rw.Close()
+		w.c.s.Remove(id)
+		return err
+	}
+	return rw.Close()
+}
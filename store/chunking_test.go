@@ -0,0 +1,136 @@
+package store
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math/rand"
+	"testing"
+
+	"github.com/Avalanche-io/c4"
+)
+
+func randomBytes(seed int64, n int) []byte {
+	b := make([]byte, n)
+	rand.New(rand.NewSource(seed)).Read(b)
+	return b
+}
+
+func newTestChunking(t *testing.T) (*Chunking, *RAM) {
+	t.Helper()
+	ram := NewRAM()
+	c, err := NewChunkingSize(ram, 1<<10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c, ram
+}
+
+func TestChunkingRoundTrip(t *testing.T) {
+	c, ram := newTestChunking(t)
+	for _, data := range [][]byte{
+		nil,
+		[]byte("small"),
+		randomBytes(1, 100<<10),
+		append([]byte(recipeMagic+"\x01"), randomBytes(2, 10)...),
+		append([]byte(recipeMagic+"\x01"), randomBytes(3, 20<<10)...),
+	} {
+		id, err := c.Put(bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := c4.Identify(bytes.NewReader(data)); id != want {
+			t.Errorf("%d bytes: Put returned %s, want %s", len(data), id, want)
+		}
+		if got := readAll(t, c, id); !bytes.Equal(got, data) {
+			t.Errorf("%d bytes: content did not round-trip", len(data))
+		}
+	}
+
+	data := randomBytes(4, 100<<10)
+	id, _ := c.Put(bytes.NewReader(data))
+	chunks, err := Chunks(c, id)
+	if err != nil || len(chunks) < 2 {
+		t.Fatalf("Chunks: %d chunks, %v", len(chunks), err)
+	}
+	var total int64
+	for _, ch := range chunks {
+		if ch.Size < int64(c.min) && ch != chunks[len(chunks)-1] || ch.Size > int64(c.max) {
+			t.Errorf("chunk of %d bytes outside [%d, %d]", ch.Size, c.min, c.max)
+		}
+		total += ch.Size
+	}
+	if total != int64(len(data)) {
+		t.Errorf("chunks total %d bytes, want %d", total, len(data))
+	}
+	if whole, _ := Chunks(ram, c4.Identify(bytes.NewReader([]byte("small")))); whole != nil {
+		t.Error("small content should be stored whole")
+	}
+}
+
+func TestChunkingDedup(t *testing.T) {
+	c, ram := newTestChunking(t)
+	v1 := randomBytes(5, 400<<10)
+	v2 := append(append(append([]byte(nil), v1[:200<<10]...), "an inserted edit"...), v1[200<<10:]...)
+
+	count := func() int { return len(listedIDs(t, ram, nil)) }
+	c.Put(bytes.NewReader(v1))
+	n1 := count()
+	c.Put(bytes.NewReader(v2))
+	added := count() - n1
+	// A recipe plus the chunk or two around the edit.
+	if added > 5 {
+		t.Errorf("an edit added %d objects, want at most 5 (first version: %d)", added, n1)
+	}
+}
+
+func TestChunkingVerifies(t *testing.T) {
+	c, ram := newTestChunking(t)
+	data := randomBytes(6, 50<<10)
+	id, _ := c.Put(bytes.NewReader(data))
+	chunks, _ := Chunks(ram, id)
+
+	// Swap a chunk's content for another of the same length.
+	ram.data[chunks[1].ID] = randomBytes(7, int(chunks[1].Size))
+	rc, err := c.Open(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadAll(rc); !errors.Is(err, ErrInvalidID) {
+		t.Errorf("read of damaged object: %v, want ErrInvalidID", err)
+	}
+
+	w, _ := c.Create(id)
+	w.Write([]byte("something else"))
+	if err := w.Close(); !errors.Is(err, ErrInvalidID) {
+		t.Errorf("Create with mismatched content: %v", err)
+	}
+
+	if _, err := NewChunkingSize(ram, 1000); err == nil {
+		t.Error("chunk size that is not a power of two accepted")
+	}
+}
+
+func TestFsckChunked(t *testing.T) {
+	ts, err := NewTreeStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, _ := NewChunkingSize(ts, 1<<10)
+	id, _ := c.Put(bytes.NewReader(randomBytes(8, 20<<10)))
+	chunks, _ := Chunks(c, id)
+
+	rep, err := Fsck(c, FsckOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rep.Problems) != 0 || rep.Checked != len(chunks)+1 {
+		t.Fatalf("checked %d, problems %v", rep.Checked, rep.Problems)
+	}
+
+	ts.Remove(chunks[0].ID)
+	rep, _ = Fsck(ts, FsckOptions{})
+	if len(rep.Problems) != 1 || rep.Problems[0].ID != id {
+		t.Errorf("missing chunk: problems %v", rep.Problems)
+	}
+}
@@ -0,0 +1,282 @@
+package store
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha512"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+
+	"github.com/Avalanche-io/c4"
+)
+
+var _ Store = &Compressing{}
+
+// Compressing wraps another store and gzips content as it is written,
+// keeping the compressed form only when it is smaller. IDs are always
+// those of the uncompressed content, and reads decompress transparently.
+//
+// Compressed objects are stored with a short header, so the wrapped store
+// can hold compressed and raw objects side by side: an object that does not
+// begin with the header is raw content. Raw content that happens to begin
+// with the header is stored behind one as well.
+type Compressing struct {
+	s     Store
+	level int
+}
+
+// NewCompressing wraps s with gzip's default compression level.
+func NewCompressing(s Store) *Compressing {
+	return &Compressing{s, gzip.DefaultCompression}
+}
+
+// NewCompressingLevel wraps s with the given gzip compression level.
+func NewCompressingLevel(s Store, level int) (*Compressing, error) {
+	if level < gzip.HuffmanOnly || level > gzip.BestCompression {
+		return nil, fmt.Errorf("invalid compression level %d", level)
+	}
+	return &Compressing{s, level}, nil
+}
+
+// The header of an encoded object is compressMagic followed by one
+// method byte.
+const compressMagic = "\x89C4Z"
+
+const (
+	methodStored byte = 0 // raw content follows
+	methodGzip   byte = 1 // a gzip stream follows
+)
+
+// Open returns the decompressed content of id.
+func (c *Compressing) Open(id c4.ID) (io.ReadCloser, error) {
+	rc, err := c.s.Open(id)
+	if err != nil {
+		return nil, err
+	}
+	r, err := decodeObject(rc)
+	if err != nil {
+		rc.Close()
+		return nil, fmt.Errorf("%s: %w", id, err)
+	}
+	return readCloser{r, rc}, nil
+}
+
+// OpenRange reads the range directly from objects stored uncompressed.
+// Compressed objects are decompressed from the start.
+func (c *Compressing) OpenRange(id c4.ID, off, n int64) (io.ReadCloser, error) {
+	if off < 0 {
+		return nil, fmt.Errorf("open range of %s: negative offset %d", id, off)
+	}
+	hrc, err := OpenRange(c.s, id, 0, int64(len(compressMagic)+1))
+	if err != nil {
+		return nil, err
+	}
+	head, err := io.ReadAll(hrc)
+	hrc.Close()
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case len(head) <= len(compressMagic) || string(head[:len(compressMagic)]) != compressMagic:
+		return OpenRange(c.s, id, off, n)
+	case head[len(compressMagic)] == methodStored:
+		return OpenRange(c.s, id, off+int64(len(head)), n)
+	}
+	rc, err := c.Open(id)
+	if err != nil {
+		return nil, err
+	}
+	return skipTo(rc, off, n)
+}
+
+// Create returns a writer for the uncompressed content of id. The content
+// is spooled to temp files and stored in the wrapped store on Close.
+func (c *Compressing) Create(id c4.ID) (io.WriteCloser, error) {
+	sp, err := c.newSpool()
+	if err != nil {
+		return nil, err
+	}
+	return &compressingWriter{c: c, id: id, sp: sp}, nil
+}
+
+// Put stores the content of r and returns its ID.
+func (c *Compressing) Put(r io.Reader) (c4.ID, error) {
+	sp, err := c.newSpool()
+	if err != nil {
+		return c4.ID{}, err
+	}
+	defer sp.cleanup()
+	if _, err := io.Copy(sp, r); err != nil {
+		return c4.ID{}, err
+	}
+	var id c4.ID
+	copy(id[:], sp.h.Sum(nil))
+	if c.s.Has(id) {
+		return id, nil
+	}
+	return id, c.store(id, sp)
+}
+
+func (c *Compressing) Has(id c4.ID) bool { return c.s.Has(id) }
+
+func (c *Compressing) Remove(id c4.ID) error { return c.s.Remove(id) }
+
+// List calls List on the wrapped Store. Sizes are as stored, so compressed
+// objects report their compressed size.
+func (c *Compressing) List(fn func(Object) bool) error {
+	return List(c.s, fn)
+}
+
+// store writes the smaller encoding of the spooled content to the wrapped
+// store.
+func (c *Compressing) store(id c4.ID, sp *spool) error {
+	r, err := sp.encoded()
+	if err != nil {
+		return err
+	}
+	w, err := c.s.Create(id)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(w, r); err != nil {
+		w.Close()
+		c.s.Remove(id)
+		return err
+	}
+	return w.Close()
+}
+
+type compressingWriter struct {
+	c  *Compressing
+	id c4.ID
+	sp *spool
+}
+
+func (w *compressingWriter) Write(b []byte) (int, error) {
+	return w.sp.Write(b)
+}
+
+// Close stores the content, unless it does not match the ID it was
+// created with, when it fails with ErrInvalidID.
+func (w *compressingWriter) Close() error {
+	defer w.sp.cleanup()
+	var got c4.ID
+	copy(got[:], w.sp.h.Sum(nil))
+	if got != w.id {
+		return ErrInvalidID
+	}
+	return w.c.store(w.id, w.sp)
+}
+
+// spool holds content being written in both raw and compressed form until
+// it is known which is smaller.
+type spool struct {
+	raw, packed *os.File
+	gz          *gzip.Writer
+	h           hash.Hash
+	n           int64
+	head        []byte // the first bytes, to detect content that looks encoded
+}
+
+func (c *Compressing) newSpool() (*spool, error) {
+	raw, err := os.CreateTemp("", "c4raw.*")
+	if err != nil {
+		return nil, err
+	}
+	packed, err := os.CreateTemp("", "c4gz.*")
+	if err != nil {
+		raw.Close()
+		os.Remove(raw.Name())
+		return nil, err
+	}
+	gz, _ := gzip.NewWriterLevel(packed, c.level)
+	return &spool{raw: raw, packed: packed, gz: gz, h: sha512.New()}, nil
+}
+
+func (sp *spool) Write(b []byte) (int, error) {
+	if len(sp.head) < len(compressMagic) {
+		need := len(compressMagic) - len(sp.head)
+		if need > len(b) {
+			need = len(b)
+		}
+		sp.head = append(sp.head, b[:need]...)
+	}
+	n, err := sp.raw.Write(b)
+	if err != nil {
+		return n, err
+	}
+	if _, err := sp.gz.Write(b); err != nil {
+		return 0, err
+	}
+	sp.h.Write(b)
+	sp.n += int64(n)
+	return n, nil
+}
+
+// encoded finishes compression and returns a reader for the smaller of the
+// two encodings, header included.
+func (sp *spool) encoded() (io.Reader, error) {
+	if err := sp.gz.Close(); err != nil {
+		return nil, err
+	}
+	size, err := sp.packed.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, err
+	}
+	if size+int64(len(compressMagic))+1 < sp.n {
+		if _, err := sp.packed.Seek(0, io.SeekStart); err != nil {
+			return nil, err
+		}
+		return io.MultiReader(encodingHeader(methodGzip), sp.packed), nil
+	}
+	if _, err := sp.raw.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	if string(sp.head) == compressMagic {
+		return io.MultiReader(encodingHeader(methodStored), sp.raw), nil
+	}
+	return sp.raw, nil
+}
+
+func (sp *spool) cleanup() {
+	for _, f := range []*os.File{sp.raw, sp.packed} {
+		f.Close()
+		os.Remove(f.Name())
+	}
+}
+
+func encodingHeader(method byte) io.Reader {
+	return bytes.NewReader(append([]byte(compressMagic), method))
+}
+
+// decodeObject returns the content of a stored object, decompressing it if
+// it carries a compression header.
+func decodeObject(r io.Reader) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	head, err := br.Peek(len(compressMagic) + 1)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	if len(head) <= len(compressMagic) || string(head[:len(compressMagic)]) != compressMagic {
+		return br, nil
+	}
+	method := head[len(compressMagic)]
+	br.Discard(len(head))
+	switch method {
+	case methodStored:
+		return br, nil
+	case methodGzip:
+		return gzip.NewReader(br)
+	}
+	return nil, fmt.Errorf("unknown compression method %d", method)
+}
+
+type readCloser struct {
+	io.Reader
+	c io.Closer
+}
+
+func (r readCloser) Close() error { return r.c.Close() }
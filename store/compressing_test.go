@@ -0,0 +1,156 @@
+package store
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/Avalanche-io/c4"
+)
+
+func readAll(t *testing.T, s Store, id c4.ID) []byte {
+	t.Helper()
+	rc, err := s.Open(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+	data, err := io.ReadAll(rc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestCompressing(t *testing.T) {
+	ts, err := NewTreeStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewCompressing(ts)
+
+	text := []byte(strings.Repeat("EDL line: 001 AX V C 01:00:00:00\n", 200))
+	noise := make([]byte, 4096)
+	rand.New(rand.NewSource(1)).Read(noise)
+	lookalike := append([]byte(compressMagic+"\x01"), "not really gzip"...)
+
+	for name, data := range map[string][]byte{"text": text, "noise": noise, "lookalike": lookalike, "empty": nil} {
+		id, err := s.Put(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if want := c4.Identify(bytes.NewReader(data)); id != want {
+			t.Errorf("%s: Put returned %s, want the ID of the raw content", name, id)
+		}
+		if got := readAll(t, s, id); !bytes.Equal(got, data) {
+			t.Errorf("%s: content did not round-trip", name)
+		}
+		stored, _ := os.ReadFile(ts.path(id))
+		switch name {
+		case "text":
+			if len(stored) >= len(text)/4 || !bytes.HasPrefix(stored, []byte(compressMagic)) {
+				t.Errorf("text stored in %d bytes, not compressed", len(stored))
+			}
+		case "noise":
+			if !bytes.Equal(stored, noise) {
+				t.Error("incompressible content should be stored raw")
+			}
+		case "lookalike":
+			if !bytes.HasPrefix(stored, []byte(compressMagic+"\x00")) {
+				t.Error("content resembling the header should be stored behind one")
+			}
+		}
+	}
+
+	// Raw objects written without the wrapper read back unchanged.
+	raw := putString(t, ts, "written directly")
+	if got := readAll(t, s, raw); string(got) != "written directly" {
+		t.Errorf("raw object read as %q", got)
+	}
+}
+
+func TestCompressingCreate(t *testing.T) {
+	ram := NewRAM()
+	s := NewCompressing(ram)
+	data := strings.Repeat("usda 1.0\n", 500)
+	id := c4.Identify(strings.NewReader(data))
+
+	w, err := s.Create(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(w, data)
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got := readAll(t, s, id); string(got) != data {
+		t.Error("content did not round-trip")
+	}
+	if n := len(readAll(t, ram, id)); n >= len(data) {
+		t.Errorf("stored %d bytes for %d of text", n, len(data))
+	}
+	if _, err := NewCompressingLevel(ram, 42); err == nil {
+		t.Error("invalid level accepted")
+	}
+
+	wrong := testID("something else")
+	w, err = s.Create(wrong)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(w, data)
+	if err := w.Close(); err != ErrInvalidID {
+		t.Errorf("Close of mismatched content: %v, want ErrInvalidID", err)
+	}
+	if ram.Has(wrong) {
+		t.Error("mismatched content was stored")
+	}
+}
+
+func TestFsckCompressed(t *testing.T) {
+	ts, err := NewTreeStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewCompressing(ts)
+	corrupt := putString(t, s, strings.Repeat("rotting bits ", 100))
+	truncated := putString(t, s, strings.Repeat("cut short ", 100))
+	putString(t, s, strings.Repeat("all good ", 100))
+	putString(t, ts, "raw neighbour")
+
+	data, _ := os.ReadFile(ts.path(corrupt))
+	data[len(data)/2] ^= 0xff
+	os.WriteFile(ts.path(corrupt), data, 0644)
+	data, _ = os.ReadFile(ts.path(truncated))
+	os.WriteFile(ts.path(truncated), data[:len(data)-10], 0644)
+
+	for _, target := range []Store{ts, s} {
+		rep, err := Fsck(target, FsckOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		kinds := problemKinds(rep)
+		if rep.Checked != 4 || len(rep.Problems) != 2 || kinds[ProblemCorrupt] != 1 || kinds[ProblemTruncated] != 1 {
+			t.Errorf("%T: checked %d, problems %v", target, rep.Checked, rep.Problems)
+		}
+	}
+}
+
+func TestFsckRawLooksCompressed(t *testing.T) {
+	ts, err := NewTreeStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Raw content that starts like a compressed object.
+	id := putString(t, ts, compressMagic+"\x01not really gzip")
+	rep, err := Fsck(ts, FsckOptions{Repair: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rep.Problems) != 0 || !ts.Has(id) {
+		t.Errorf("intact raw content reported: %v", rep.Problems)
+	}
+}
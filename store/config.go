@@ -6,6 +6,7 @@ import (
 	"net/url"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 )
 
@@ -27,45 +28,335 @@ func OpenConfigured() (*TreeStore, error) {
 // filesystem and S3 backends. Multiple stores can be configured —
 // writes go to the first, reads check all in order.
 //
-// C4_STORE can be a single path/URI or comma-separated list:
+// C4_STORE can be a single path/URI or comma-separated list. pack:// URIs
+// open a PackStore:
 //
 //	C4_STORE=/fast/ssd,s3://bucket/c4?region=us-west-2,/mnt/archive
+//	C4_STORE=pack:///data/c4?threshold=64K
+//
+// A cache:// URI puts a Cache in front of the stores listed after it:
+//
+//	C4_STORE=cache:///fast/ssd?size=500G,s3://bucket/c4?region=us-west-2
+//
+// An erasure:// URI spreads Reed-Solomon shards of each object across the
+// stores listed after it, data+parity of them:
+//
+//	C4_STORE=erasure://?data=4&parity=2,/vol1,/vol2,/vol3,/vol4,/vol5,/vol6
+//
+// An http:// or https:// URI opens an HTTPStore, which sends C4_TOKEN, if
+// set, as its bearer token. A ref:// URI opens a Reference store of files
+// indexed in place:
+//
+//	C4_STORE=/data/c4store,ref:///data/c4ref?verify=true
 //
 // Alternatively, ~/.c4/config can have multiple store lines:
 //
 //	store = /fast/ssd
 //	store = s3://bucket/c4?region=us-west-2
 //
+// Options after a store set how a MultiStore of several uses it: write=
+// sync, async or none for the member, and read=first, fastest or verify,
+// quorum=N, repair=true and queue=<dir> for the whole set:
+//
+//	store = /fast/ssd write=sync read=verify repair=true
+//	store = /mnt/mirror write=sync quorum=1
+//	store = s3://bucket/c4?region=us-west-2 write=async
+//
 // Returns nil, nil if no store configured.
 func OpenStore() (Store, error) {
 	endpoints := configuredEndpoints()
 	if len(endpoints) == 0 {
 		return nil, nil
 	}
+	return openEndpoints(endpoints)
+}
 
+// OpenURI opens the store named by uri, which uses the same syntax as
+// C4_STORE: a local path or s3:// URI, or a comma-separated list of them.
+func OpenURI(uri string) (Store, error) {
+	endpoints := splitEndpoints(uri)
+	if len(endpoints) == 0 {
+		return nil, fmt.Errorf("empty store URI")
+	}
+	return openEndpoints(endpoints)
+}
+
+func openEndpoints(endpoints []string) (Store, error) {
+	s, err := openBackends(endpoints)
+	if err != nil {
+		return nil, err
+	}
+	if err := ingestConfigured(s); err != nil {
+		return nil, err
+	}
+	if s, err = encryptConfigured(s); err != nil {
+		return nil, err
+	}
+	return chunkConfigured(s)
+}
+
+// openBackends opens the stores named by endpoints, combined in a
+// MultiStore if there are several. A cache:// endpoint caches the stores
+// listed after it, and an erasure:// endpoint spreads shards across them.
+func openBackends(endpoints []string) (Store, error) {
 	var stores []Store
-	for _, ep := range endpoints {
+	var uris []string
+	var opts []map[string]string
+	for i := 0; i < len(endpoints); i++ {
+		ep, o := splitStoreOptions(endpoints[i])
 		var s Store
 		var err error
-		if strings.HasPrefix(ep, "s3://") {
+		switch {
+		case strings.HasPrefix(ep, "cache://"):
+			if i == len(endpoints)-1 {
+				return nil, fmt.Errorf("store %q: nothing to cache; list the stores to cache after it", ep)
+			}
+			var slow Store
+			if slow, err = openBackends(endpoints[i+1:]); err != nil {
+				return nil, err
+			}
+			s, err = openCacheConfigured(ep, slow)
+		case strings.HasPrefix(ep, "erasure://"):
+			var used int
+			s, used, err = openErasureConfigured(ep, endpoints[i+1:])
+			i += used
+		case strings.HasPrefix(ep, "s3://"):
 			s, err = openS3Configured(ep)
-		} else {
+		case strings.HasPrefix(ep, "pack://"):
+			s, err = openPackConfigured(ep)
+		case strings.HasPrefix(ep, "http://") || strings.HasPrefix(ep, "https://"):
+			s = NewHTTPStore(ep, os.Getenv("C4_TOKEN"))
+		case strings.HasPrefix(ep, "ref://"):
+			s, err = openReferenceConfigured(ep)
+		default:
 			s, err = NewTreeStore(ep)
 		}
 		if err != nil {
 			return nil, fmt.Errorf("store %q: %w", ep, err)
 		}
 		stores = append(stores, s)
+		uris = append(uris, ep)
+		opts = append(opts, o)
+		if _, ok := s.(*Cache); ok {
+			break
+		}
 	}
-
 	if len(stores) == 1 {
 		return stores[0], nil
 	}
-	return NewMultiStore(stores...), nil
+	m := NewMultiStore(stores...)
+	if err := configureMulti(m, uris, opts); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// storeOptionKeys are the options that can follow a store on its line.
+var storeOptionKeys = map[string]bool{
+	"write": true, "read": true, "quorum": true, "repair": true, "queue": true,
+}
+
+// splitStoreOptions separates the options that follow a store, as in
+// "/mnt/archive write=async", from the store itself. Only known options
+// are split off, so a path may still contain spaces.
+func splitStoreOptions(ep string) (string, map[string]string) {
+	var opts map[string]string
+	rest := strings.TrimSpace(ep)
+	for {
+		i := strings.LastIndexAny(rest, " \t")
+		if i < 0 {
+			break
+		}
+		field := rest[i+1:]
+		eq := strings.IndexByte(field, '=')
+		if eq < 0 || !storeOptionKeys[field[:eq]] {
+			break
+		}
+		if opts == nil {
+			opts = make(map[string]string)
+		}
+		if _, ok := opts[field[:eq]]; !ok {
+			opts[field[:eq]] = field[eq+1:]
+		}
+		rest = strings.TrimSpace(rest[:i])
+	}
+	return rest, opts
+}
+
+// configureMulti applies the options of each member's line to m. The write
+// option is the member's own; read, quorum, repair and queue apply to the
+// whole MultiStore, and may be given on any one line. With members other
+// than the first receiving writes, the queue of content to copy to them is
+// kept in the user's cache directory unless queue names another.
+func configureMulti(m *MultiStore, uris []string, opts []map[string]string) error {
+	shared := make(map[string]string)
+	for i, o := range opts {
+		for k, v := range o {
+			if k == "write" {
+				mode, err := ParseWriteMode(v)
+				if err != nil {
+					return fmt.Errorf("store %q: %w", uris[i], err)
+				}
+				m.SetWrite(i, mode)
+				continue
+			}
+			if have, ok := shared[k]; ok && have != v {
+				return fmt.Errorf("store %q: %s=%s conflicts with %s=%s on another store", uris[i], k, v, k, have)
+			}
+			shared[k] = v
+		}
+	}
+	if members, _ := m.syncMembers(); len(members) == 0 {
+		return fmt.Errorf("no store is written synchronously; give one write=sync")
+	}
+	if v, ok := shared["read"]; ok {
+		p, err := ParseReadPolicy(v)
+		if err != nil {
+			return err
+		}
+		m.SetRead(p)
+	}
+	if v, ok := shared["quorum"]; ok {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return fmt.Errorf("invalid quorum %q", v)
+		}
+		m.SetQuorum(n)
+	}
+	if v, ok := shared["repair"]; ok {
+		repair, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("invalid repair %q", v)
+		}
+		m.SetRepair(repair)
+	}
+	dir := expandHome(shared["queue"])
+	if dir == "" && m.written() > 1 {
+		cache, err := os.UserCacheDir()
+		if err != nil {
+			return fmt.Errorf("no directory for the write queue; set queue=: %w", err)
+		}
+		key := hashSHA256([]byte(strings.Join(uris, "\n")))
+		dir = filepath.Join(cache, "c4", "queue", key[:16])
+	}
+	if dir != "" {
+		return m.SetQueue(dir)
+	}
+	return nil
+}
+
+// chunkConfigured wraps s in a Chunking store when C4_CHUNKING or the
+// chunking setting is "true" or an average chunk size such as "1M".
+func chunkConfigured(s Store) (Store, error) {
+	v := os.Getenv("C4_CHUNKING")
+	if v == "" {
+		v = configValue("chunking")
+	}
+	switch strings.ToLower(v) {
+	case "", "false", "no", "0":
+		return s, nil
+	case "true", "yes", "1":
+		return NewChunking(s), nil
+	}
+	size, err := parseSize(v)
+	if err != nil {
+		return nil, fmt.Errorf("invalid chunking setting: %w", err)
+	}
+	return NewChunkingSize(s, int(size))
+}
+
+// parseSize parses a size in bytes with an optional K, M, G or T suffix.
+func parseSize(v string) (int64, error) {
+	mult := int64(1)
+	if v != "" {
+		switch strings.ToUpper(v[len(v)-1:]) {
+		case "K":
+			mult = 1 << 10
+		case "M":
+			mult = 1 << 20
+		case "G":
+			mult = 1 << 30
+		case "T":
+			mult = 1 << 40
+		}
+		if mult > 1 {
+			v = v[:len(v)-1]
+		}
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid size %q", v)
+	}
+	return n * mult, nil
+}
+
+// ingestConfigured sets the ingest mode of the TreeStores in s from
+// C4_INGEST or the ingest setting.
+func ingestConfigured(s Store) error {
+	v := os.Getenv("C4_INGEST")
+	if v == "" {
+		v = configValue("ingest")
+	}
+	if v == "" {
+		return nil
+	}
+	mode, err := ParseIngestMode(v)
+	if err != nil {
+		return err
+	}
+	for _, ts := range TreeStores(s) {
+		ts.SetIngest(mode)
+	}
+	return nil
+}
+
+// encryptConfigured wraps s in an Encrypting store when a key is
+// configured, from C4_KEY (hex), C4_KEY_FILE or the key_file setting.
+// C4_ENCRYPTION or the encryption setting selects "convergent" mode.
+func encryptConfigured(s Store) (Store, error) {
+	var key []byte
+	var err error
+	switch {
+	case os.Getenv("C4_KEY") != "":
+		key, err = ParseKey(os.Getenv("C4_KEY"))
+	case os.Getenv("C4_KEY_FILE") != "":
+		key, err = LoadKeyFile(os.Getenv("C4_KEY_FILE"))
+	case configValue("key_file") != "":
+		key, err = LoadKeyFile(expandHome(configValue("key_file")))
+	default:
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	mode := os.Getenv("C4_ENCRYPTION")
+	if mode == "" {
+		mode = configValue("encryption")
+	}
+	switch mode {
+	case "", "store":
+		return NewEncrypting(s, key)
+	case "convergent":
+		return NewConvergentEncrypting(s, key)
+	}
+	return nil, fmt.Errorf("unknown encryption mode %q (want store or convergent)", mode)
+}
+
+// expandHome replaces a leading ~/ with the user's home directory.
+func expandHome(path string) string {
+	if !strings.HasPrefix(path, "~/") {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return path
+	}
+	return filepath.Join(home, path[2:])
 }
 
 // openS3Configured parses an s3:// URI and returns an S3Store.
-// Format: s3://bucket/prefix?region=X&endpoint=Y
+// Format: s3://bucket/prefix?region=X&endpoint=Y&partsize=16M&concurrency=8&checksum=md5
 // Credentials come from AWS_ACCESS_KEY_ID / AWS_SECRET_ACCESS_KEY env vars.
 func openS3Configured(raw string) (*S3Store, error) {
 	u, err := url.Parse(raw)
@@ -94,7 +385,174 @@ func openS3Configured(raw string) (*S3Store, error) {
 		return nil, fmt.Errorf("AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY must be set for S3 store")
 	}
 
-	return NewS3Store(bucket, prefix, region, endpoint, accessKey, secretKey), nil
+	s := NewS3Store(bucket, prefix, region, endpoint, accessKey, secretKey)
+	if v := u.Query().Get("partsize"); v != "" {
+		n, err := parseSize(v)
+		if err != nil || n < 5<<20 {
+			return nil, fmt.Errorf("S3 URI: invalid part size %q (at least 5M)", v)
+		}
+		s.SetPartSize(n)
+	}
+	if v := u.Query().Get("concurrency"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("S3 URI: invalid concurrency %q", v)
+		}
+		s.SetConcurrency(n)
+	}
+	if v := u.Query().Get("checksum"); v != "" {
+		c, err := ParseS3Checksum(v)
+		if err != nil {
+			return nil, fmt.Errorf("S3 URI: %w", err)
+		}
+		s.SetChecksum(c)
+	}
+	return s, nil
+}
+
+// openPackConfigured parses a pack:// URI and returns a PackStore.
+// Format: pack:///path?threshold=64K&large=/other/store
+// Objects of threshold bytes or more go to the large store, a local path or
+// s3:// URI, which defaults to a TreeStore in the pack directory's "large"
+// subdirectory.
+func openPackConfigured(raw string) (*PackStore, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("parse pack URI: %w", err)
+	}
+	dir := u.Path
+	if u.Host != "" {
+		dir = u.Host + u.Path // pack://relative/dir
+	}
+	if dir == "" {
+		return nil, fmt.Errorf("pack URI missing path: %s", raw)
+	}
+	dir = expandHome(dir)
+
+	threshold := int64(DefaultPackThreshold)
+	if v := u.Query().Get("threshold"); v != "" {
+		n, err := parseSize(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pack threshold: %w", err)
+		}
+		threshold = n
+	}
+
+	var large Store
+	switch v := u.Query().Get("large"); {
+	case v == "":
+		large, err = NewTreeStore(filepath.Join(dir, "large"))
+	case strings.HasPrefix(v, "s3://"):
+		large, err = openS3Configured(v)
+	default:
+		large, err = NewTreeStore(expandHome(v))
+	}
+	if err != nil {
+		return nil, err
+	}
+	return NewPackStore(dir, large, threshold)
+}
+
+// openCacheConfigured parses a cache:// URI and returns a Cache of slow.
+// Format: cache:///path?size=50G
+// Without a size the cache is unbounded.
+func openCacheConfigured(raw string, slow Store) (*Cache, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("parse cache URI: %w", err)
+	}
+	dir := u.Path
+	if u.Host != "" {
+		dir = u.Host + u.Path
+	}
+	if dir == "" {
+		return nil, fmt.Errorf("cache URI missing path: %s", raw)
+	}
+	var size int64
+	if v := u.Query().Get("size"); v != "" {
+		if size, err = parseSize(v); err != nil {
+			return nil, fmt.Errorf("invalid cache size: %w", err)
+		}
+	}
+	return OpenCache(expandHome(dir), slow, size)
+}
+
+// openReferenceConfigured parses a ref:// URI and opens the Reference
+// store indexed there. Format: ref:///path?verify=true
+func openReferenceConfigured(raw string) (*Reference, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("parse ref URI: %w", err)
+	}
+	dir := u.Path
+	if u.Host != "" {
+		dir = u.Host + u.Path
+	}
+	if dir == "" {
+		return nil, fmt.Errorf("ref URI missing path: %s", raw)
+	}
+	var verify bool
+	if v := u.Query().Get("verify"); v != "" {
+		if verify, err = strconv.ParseBool(v); err != nil {
+			return nil, fmt.Errorf("invalid ref verify: %q", v)
+		}
+	}
+	r, err := OpenReference(expandHome(dir))
+	if err != nil {
+		return nil, err
+	}
+	r.SetVerify(verify)
+	return r, nil
+}
+
+// openErasureConfigured parses an erasure:// URI and returns an Erasure
+// store on the backends listed after it, and how many it used.
+// Format: erasure://?data=4&parity=2&block=256K
+// A stripe of data blocks holds at most maxErasureStripe bytes. With
+// parity, data+parity backends are used, and any after them are further
+// stores; without it, every backend after it is used.
+func openErasureConfigured(raw string, rest []string) (*Erasure, int, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, 0, fmt.Errorf("parse erasure URI: %w", err)
+	}
+	q := u.Query()
+	data, err := strconv.Atoi(q.Get("data"))
+	if err != nil || data < 1 {
+		return nil, 0, fmt.Errorf("erasure URI: invalid data shards %q", q.Get("data"))
+	}
+	n := len(rest)
+	if v := q.Get("parity"); v != "" {
+		parity, err := strconv.Atoi(v)
+		if err != nil || parity < 1 {
+			return nil, 0, fmt.Errorf("erasure URI: invalid parity shards %q", v)
+		}
+		n = data + parity
+	}
+	if n <= data || n > len(rest) {
+		return nil, 0, fmt.Errorf("erasure URI: %d backends listed after it, want %d data and at least one parity", len(rest), data)
+	}
+	backends := make([]Store, n)
+	for i, ep := range rest[:n] {
+		if backends[i], err = openBackends([]string{ep}); err != nil {
+			return nil, 0, err
+		}
+	}
+	e, err := NewErasure(data, backends...)
+	if err != nil {
+		return nil, 0, err
+	}
+	if v := q.Get("block"); v != "" {
+		size, err := parseSize(v)
+		if err != nil {
+			return nil, 0, fmt.Errorf("erasure URI: invalid block size %q", v)
+		}
+		if size > maxErasureStripe/int64(data) {
+			return nil, 0, fmt.Errorf("erasure URI: block size %q makes %d-block stripes over %d MiB", v, data, maxErasureStripe>>20)
+		}
+		e.blockSize = int(size)
+	}
+	return e, n, nil
 }
 
 // IsConfigured reports whether a content store is configured.
@@ -164,18 +622,23 @@ func SetupDefaultStore() (*TreeStore, error) {
 // C4_STORE can be comma-separated. Config file can have multiple store lines.
 func configuredEndpoints() []string {
 	if v := os.Getenv("C4_STORE"); v != "" {
-		var endpoints []string
-		for _, ep := range strings.Split(v, ",") {
-			ep = strings.TrimSpace(ep)
-			if ep != "" {
-				endpoints = append(endpoints, ep)
-			}
-		}
-		return endpoints
+		return splitEndpoints(v)
 	}
 	return configValues("store")
 }
 
+// splitEndpoints splits a comma-separated store list.
+func splitEndpoints(v string) []string {
+	var endpoints []string
+	for _, ep := range strings.Split(v, ",") {
+		ep = strings.TrimSpace(ep)
+		if ep != "" {
+			endpoints = append(endpoints, ep)
+		}
+	}
+	return endpoints
+}
+
 // configuredRaw returns the first configured store value.
 // Returns "" if no store is configured.
 func configuredRaw() string {
@@ -189,8 +652,8 @@ func configuredRaw() string {
 // configuredPath returns the local filesystem path for the store, or ""
 // if no local store is configured (e.g., S3 URIs return "").
 func configuredPath() string {
-	raw := configuredRaw()
-	if strings.HasPrefix(raw, "s3://") {
+	raw, _ := splitStoreOptions(configuredRaw())
+	if strings.Contains(raw, "://") {
 		return ""
 	}
 	return raw
@@ -0,0 +1,315 @@
+package store
+
+import (
+	"context"
+	"io"
+	"sync"
+
+	"github.com/Avalanche-io/c4"
+)
+
+// ContextStore is the context-aware form of Store. Every call takes a
+// context, and cancelling it aborts the call, including any reading or
+// writing of content still in progress. HasMany and OpenMany work on many
+// IDs at once, so stores with per-request latency can answer them in
+// parallel.
+//
+// Use WithContext to get a ContextStore for any Store, and WithoutContext
+// to go back.
+type ContextStore interface {
+	// OpenContext opens the content of id for reading.
+	OpenContext(ctx context.Context, id c4.ID) (io.ReadCloser, error)
+
+	// CreateContext returns a writer for the content of id.
+	CreateContext(ctx context.Context, id c4.ID) (io.WriteCloser, error)
+
+	// HasContext reports whether the store contains content for id. Unlike
+	// Has, it returns an error when it cannot tell.
+	HasContext(ctx context.Context, id c4.ID) (bool, error)
+
+	// PutContext reads all content, computes its C4 ID, stores it, and
+	// returns the ID.
+	PutContext(ctx context.Context, r io.Reader) (c4.ID, error)
+
+	// RemoveContext deletes content by ID.
+	RemoveContext(ctx context.Context, id c4.ID) error
+
+	// HasMany reports, for each of ids, whether the store contains it.
+	HasMany(ctx context.Context, ids []c4.ID) ([]bool, error)
+
+	// OpenMany opens each of ids and calls fn with its content, or with the
+	// error opening it, in the order of ids. Calls to fn are not
+	// concurrent, and the reader is closed when fn returns. An error
+	// returned by fn stops OpenMany and is returned by it.
+	OpenMany(ctx context.Context, ids []c4.ID, fn func(id c4.ID, r io.Reader, err error) error) error
+}
+
+// WithContext returns s as a ContextStore. If s implements ContextStore it
+// is returned as is. Otherwise each call checks ctx before calling s, and
+// readers and writers check it before each Read and Write; HasMany and
+// OpenMany call s one ID at a time.
+func WithContext(s Store) ContextStore {
+	if cs, ok := s.(ContextStore); ok {
+		return cs
+	}
+	return contextAdapter{s}
+}
+
+// WithoutContext returns cs as a Store whose calls use
+// context.Background(). If cs also implements Store, or came from
+// WithContext, the Store is returned as is.
+func WithoutContext(cs ContextStore) Store {
+	switch s := cs.(type) {
+	case contextAdapter:
+		return s.s
+	case Store:
+		return s
+	}
+	return boundAdapter{context.Background(), cs}
+}
+
+// contextAdapter adapts a Store to ContextStore.
+type contextAdapter struct {
+	s Store
+}
+
+func (a contextAdapter) OpenContext(ctx context.Context, id c4.ID) (io.ReadCloser, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	rc, err := a.s.Open(id)
+	if err != nil {
+		return nil, err
+	}
+	return readCloser{&ctxReader{ctx, rc}, rc}, nil
+}
+
+func (a contextAdapter) CreateContext(ctx context.Context, id c4.ID) (io.WriteCloser, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	w, err := a.s.Create(id)
+	if err != nil {
+		return nil, err
+	}
+	return &ctxWriter{ctx, id, w, a.s.Remove}, nil
+}
+
+func (a contextAdapter) HasContext(ctx context.Context, id c4.ID) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+	return a.s.Has(id), nil
+}
+
+func (a contextAdapter) PutContext(ctx context.Context, r io.Reader) (c4.ID, error) {
+	if err := ctx.Err(); err != nil {
+		return c4.ID{}, err
+	}
+	return a.s.Put(&ctxReader{ctx, r})
+}
+
+func (a contextAdapter) RemoveContext(ctx context.Context, id c4.ID) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return a.s.Remove(id)
+}
+
+func (a contextAdapter) HasMany(ctx context.Context, ids []c4.ID) ([]bool, error) {
+	found := make([]bool, len(ids))
+	for i, id := range ids {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		found[i] = a.s.Has(id)
+	}
+	return found, nil
+}
+
+func (a contextAdapter) OpenMany(ctx context.Context, ids []c4.ID, fn func(id c4.ID, r io.Reader, err error) error) error {
+	return openMany(ctx, ids, 1, a.OpenContext, fn)
+}
+
+// Bind returns s as a Store whose calls use ctx, so that code written for
+// Store can be cancelled: once ctx is done, calls fail and content being
+// read or written stops.
+func Bind(ctx context.Context, s Store) Store {
+	return boundAdapter{ctx, WithContext(s)}
+}
+
+// boundAdapter adapts a ContextStore to Store, with a fixed context.
+type boundAdapter struct {
+	ctx context.Context
+	cs  ContextStore
+}
+
+func (a boundAdapter) Open(id c4.ID) (io.ReadCloser, error) {
+	return a.cs.OpenContext(a.ctx, id)
+}
+
+func (a boundAdapter) Create(id c4.ID) (io.WriteCloser, error) {
+	return a.cs.CreateContext(a.ctx, id)
+}
+
+func (a boundAdapter) Has(id c4.ID) bool {
+	ok, err := a.cs.HasContext(a.ctx, id)
+	return ok && err == nil
+}
+
+func (a boundAdapter) Put(r io.Reader) (c4.ID, error) {
+	return a.cs.PutContext(a.ctx, r)
+}
+
+func (a boundAdapter) Remove(id c4.ID) error {
+	return a.cs.RemoveContext(a.ctx, id)
+}
+
+// ctxReader fails reads once its context is done.
+type ctxReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r *ctxReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
+}
+
+// ctxWriter fails writes once its context is done. If the context is done
+// by Close, the partly written content is removed and Close returns the
+// context's error.
+type ctxWriter struct {
+	ctx    context.Context
+	id     c4.ID
+	w      io.WriteCloser
+	remove func(id c4.ID) error
+}
+
+func (w *ctxWriter) Write(p []byte) (int, error) {
+	if err := w.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return w.w.Write(p)
+}
+
+func (w *ctxWriter) Close() error {
+	err := w.w.Close()
+	if cerr := w.ctx.Err(); cerr != nil {
+		w.remove(w.id)
+		return cerr
+	}
+	return err
+}
+
+// fanOut calls fn for each index below n, at most limit at a time. The
+// first error cancels the context passed to the remaining calls and is
+// returned.
+func fanOut(ctx context.Context, n, limit int, fn func(ctx context.Context, i int) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		wg    sync.WaitGroup
+		once  sync.Once
+		first error
+	)
+	sem := make(chan struct{}, limit)
+	for i := 0; i < n; i++ {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			if err := fn(ctx, i); err != nil {
+				once.Do(func() {
+					first = err
+					cancel()
+				})
+			}
+		}(i)
+	}
+	wg.Wait()
+	if first != nil {
+		return first
+	}
+	return ctx.Err()
+}
+
+// openResult is the outcome of one open started by openMany.
+type openResult struct {
+	rc   io.ReadCloser
+	err  error
+	held bool // holds a slot of the semaphore
+}
+
+// openMany implements OpenMany with open, keeping up to limit objects
+// opened ahead of the one fn is reading.
+func openMany(ctx context.Context, ids []c4.ID, limit int, open func(context.Context, c4.ID) (io.ReadCloser, error), fn func(id c4.ID, r io.Reader, err error) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	results := make([]chan openResult, len(ids))
+	for i := range results {
+		results[i] = make(chan openResult, 1)
+	}
+	sem := make(chan struct{}, limit)
+	go func() {
+		for i, id := range ids {
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+				for _, ch := range results[i:] {
+					ch <- openResult{err: ctx.Err()}
+				}
+				return
+			}
+			go func(i int, id c4.ID) {
+				rc, err := open(ctx, id)
+				results[i] <- openResult{rc, err, true}
+			}(i, id)
+		}
+	}()
+
+	// Every result is received, even after stopping, so that no reader is
+	// left open.
+	var first error
+	for i, id := range ids {
+		res := <-results[i]
+		if res.held {
+			<-sem
+		}
+		if first == nil {
+			if first = ctx.Err(); first == nil {
+				var r io.Reader
+				if res.rc != nil {
+					r = res.rc
+				}
+				first = fn(id, r, res.err)
+			}
+			if first != nil {
+				cancel()
+			}
+		}
+		if res.rc != nil {
+			res.rc.Close()
+		}
+	}
+	return first
+}
+
+var (
+	_ ContextStore = &S3Store{}
+	_ ContextStore = &HTTPStore{}
+	_ ContextStore = &MultiStore{}
+	_ ContextStore = &Logger{}
+	_ ContextStore = &Validating{}
+)
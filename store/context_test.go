@@ -0,0 +1,330 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Avalanche-io/c4"
+)
+
+// closeCounter is a Store whose readers count how many are open.
+type closeCounter struct {
+	Store
+	open int64
+}
+
+func (c *closeCounter) Open(id c4.ID) (io.ReadCloser, error) {
+	rc, err := c.Store.Open(id)
+	if err != nil {
+		return nil, err
+	}
+	atomic.AddInt64(&c.open, 1)
+	return readCloser{rc, closerFunc(func() error {
+		atomic.AddInt64(&c.open, -1)
+		return rc.Close()
+	})}, nil
+}
+
+type closerFunc func() error
+
+func (f closerFunc) Close() error { return f() }
+
+// checkOpenMany checks that OpenMany delivers the content of ids in order,
+// with an error for the missing ID.
+func checkOpenMany(t *testing.T, name string, cs ContextStore, ids []c4.ID, missing c4.ID) {
+	t.Helper()
+	want := append(ids[:len(ids):len(ids)], missing)
+	var got []c4.ID
+	err := cs.OpenMany(context.Background(), want, func(id c4.ID, r io.Reader, err error) error {
+		got = append(got, id)
+		if id == missing {
+			if err == nil {
+				t.Errorf("%s: no error opening a missing object", name)
+			}
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if c4.Identify(r) != id {
+			t.Errorf("%s: wrong content for %s", name, id)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("%s: OpenMany: %v", name, err)
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("%s: OpenMany visited %v, want %v", name, got, want)
+	}
+}
+
+func TestWithContext(t *testing.T) {
+	ram := NewRAM()
+	cs := WithContext(ram)
+	if WithoutContext(cs) != Store(ram) {
+		t.Error("WithoutContext did not unwrap WithContext")
+	}
+
+	ctx := context.Background()
+	id, err := cs.PutContext(ctx, strings.NewReader("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	missing := c4.Identify(strings.NewReader("missing"))
+	found, err := cs.HasMany(ctx, []c4.ID{missing, id})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found[0] || !found[1] {
+		t.Errorf("HasMany = %v, want [false true]", found)
+	}
+	checkOpenMany(t, "RAM", cs, putN(t, ram, 5, 100), missing)
+
+	// A cancelled context stops calls, reads and writes.
+	cctx, cancel := context.WithCancel(ctx)
+	rc, err := cs.OpenContext(cctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	big := randomBytes(1, 1000)
+	bigID := c4.Identify(bytes.NewReader(big))
+	w, err := cs.CreateContext(cctx, bigID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write(big[:500])
+	cancel()
+	if _, err := rc.Read(make([]byte, 10)); err != context.Canceled {
+		t.Errorf("Read after cancel: got %v, want context.Canceled", err)
+	}
+	rc.Close()
+	if _, err := w.Write(big[500:]); err != context.Canceled {
+		t.Errorf("Write after cancel: got %v, want context.Canceled", err)
+	}
+	if err := w.Close(); err != context.Canceled {
+		t.Errorf("Close after cancel: got %v, want context.Canceled", err)
+	}
+	if ram.Has(bigID) {
+		t.Error("partly written content kept after cancel")
+	}
+	if _, err := cs.HasContext(cctx, id); err != context.Canceled {
+		t.Errorf("HasContext after cancel: got %v, want context.Canceled", err)
+	}
+	if _, err := cs.PutContext(cctx, strings.NewReader("late")); err != context.Canceled {
+		t.Errorf("PutContext after cancel: got %v, want context.Canceled", err)
+	}
+}
+
+func TestBind(t *testing.T) {
+	ram := NewRAM()
+	id, _ := ram.Put(strings.NewReader("bound"))
+	ctx, cancel := context.WithCancel(context.Background())
+	s := Bind(ctx, ram)
+	if !s.Has(id) || string(readAll(t, s, id)) != "bound" {
+		t.Fatal("content did not round-trip through Bind")
+	}
+	cancel()
+	if s.Has(id) {
+		t.Error("Has succeeded after cancel")
+	}
+	if _, err := s.Open(id); err != context.Canceled {
+		t.Errorf("Open after cancel: got %v, want context.Canceled", err)
+	}
+	if _, err := s.Put(strings.NewReader("late")); err != context.Canceled {
+		t.Errorf("Put after cancel: got %v, want context.Canceled", err)
+	}
+}
+
+func TestWithoutContext(t *testing.T) {
+	// A ContextStore that is not also a Store gets an adapter.
+	s := WithoutContext(struct{ ContextStore }{WithContext(NewRAM())})
+	if _, ok := s.(boundAdapter); !ok {
+		t.Fatalf("WithoutContext returned %T", s)
+	}
+	id, err := s.Put(strings.NewReader("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !s.Has(id) || string(readAll(t, s, id)) != "hello" {
+		t.Error("content did not round-trip through the adapter")
+	}
+	if err := s.Remove(id); err != nil || s.Has(id) {
+		t.Errorf("Remove: %v", err)
+	}
+}
+
+func TestOpenManyStops(t *testing.T) {
+	s := &closeCounter{Store: NewRAM()}
+	ids := putN(t, s, 50, 10)
+
+	stop := errors.New("stop")
+	n := 0
+	err := openMany(context.Background(), ids, 8, WithContext(s).OpenContext, func(id c4.ID, r io.Reader, err error) error {
+		if n++; n == 10 {
+			return stop
+		}
+		return nil
+	})
+	if err != stop {
+		t.Errorf("OpenMany returned %v, want the error from fn", err)
+	}
+	if n != 10 {
+		t.Errorf("fn called %d times after returning an error", n-10)
+	}
+	if open := atomic.LoadInt64(&s.open); open != 0 {
+		t.Errorf("%d readers left open", open)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	n = 0
+	err = openMany(ctx, ids, 8, WithContext(s).OpenContext, func(id c4.ID, r io.Reader, err error) error {
+		if n++; n == 5 {
+			cancel()
+		}
+		return nil
+	})
+	if err != context.Canceled || n != 5 {
+		t.Errorf("after cancel: OpenMany returned %v with %d calls", err, n)
+	}
+	if open := atomic.LoadInt64(&s.open); open != 0 {
+		t.Errorf("%d readers left open after cancel", open)
+	}
+}
+
+func TestS3Context(t *testing.T) {
+	f := newFakeS3(t, "bucket")
+	s := f.store("c4/")
+	ids := putN(t, s, 20, 100)
+	missing := c4.Identify(strings.NewReader("missing"))
+
+	found, err := s.HasMany(context.Background(), append(ids[:20:20], missing))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, ok := range found {
+		if ok != (i < 20) {
+			t.Errorf("HasMany[%d] = %v", i, ok)
+		}
+	}
+	checkOpenMany(t, "S3", s, ids, missing)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := s.HasContext(ctx, ids[0]); !errors.Is(err, context.Canceled) {
+		t.Errorf("HasContext with a cancelled context: %v", err)
+	}
+	if _, err := s.PutContext(ctx, strings.NewReader("late")); !errors.Is(err, context.Canceled) {
+		t.Errorf("PutContext with a cancelled context: %v", err)
+	}
+	if _, ok := f.get("c4/" + c4.Identify(strings.NewReader("late")).String()); ok {
+		t.Error("cancelled Put was uploaded")
+	}
+}
+
+func TestS3ContextCancelsRequest(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		requests int
+	)
+	// The server answers every request with a retryable error after a
+	// delay, so only cancellation ends the call early.
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests++
+		mu.Unlock()
+		select {
+		case <-r.Context().Done():
+		case <-time.After(200 * time.Millisecond):
+		}
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+	s := NewS3Store("bucket", "", "us-east-1", srv.URL, "AKID", "SECRET")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := s.OpenContext(ctx, testID("slow"))
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("OpenContext past its deadline: %v", err)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("cancelled request took %v", d)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if requests > 1 {
+		t.Errorf("request retried %d times after its context ended", requests-1)
+	}
+}
+
+func TestMultiStoreContext(t *testing.T) {
+	f := newFakeS3(t, "bucket")
+	ram, s3 := NewRAM(), f.store("c4/")
+	local := putN(t, ram, 3, 50)
+	remote := putN(t, s3, 3, 60)
+	m := NewMultiStore(ram, s3)
+	missing := c4.Identify(strings.NewReader("missing"))
+
+	ids := append(append(local[:3:3], remote...), missing)
+	found, err := m.HasMany(context.Background(), ids)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, ok := range found {
+		if ok != (i < 6) {
+			t.Errorf("HasMany[%d] = %v", i, ok)
+		}
+	}
+	checkOpenMany(t, "MultiStore", m, append(local, remote...), missing)
+
+	// A member that cannot answer does not hide what another member has.
+	f.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	if ok, err := m.HasContext(ctx, local[0]); !ok || err != nil {
+		t.Errorf("HasContext with a member down = %v, %v; want true, nil", ok, err)
+	}
+	if _, err := m.HasContext(ctx, remote[0]); err == nil {
+		t.Error("HasContext with the only possible member down reported no error")
+	}
+}
+
+func TestLoggerValidatingContext(t *testing.T) {
+	ram := NewRAM()
+	genuine := c4.Identify(strings.NewReader("genuine"))
+	w, _ := ram.Create(genuine)
+	io.WriteString(w, "forgery")
+	w.Close()
+	good, _ := ram.Put(strings.NewReader("good"))
+
+	var log bytes.Buffer
+	cs := WithContext(NewLogger(NewValidating(ram), &log, LogOpen|LogError|LogInvalidID))
+	var errs []error
+	err := cs.OpenMany(context.Background(), []c4.ID{good, genuine}, func(id c4.ID, r io.Reader, err error) error {
+		_, err = io.ReadAll(r)
+		errs = append(errs, err)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if errs[0] != nil || errs[1] != ErrInvalidID {
+		t.Errorf("read errors %v, want [<nil> %v]", errs, ErrInvalidID)
+	}
+	want := fmt.Sprintf("%s Open\n%s Open\n%s Read error %s\n", good, genuine, genuine, ErrInvalidID)
+	if log.String() != want {
+		t.Errorf("log:\n%s\nwant:\n%s", log.String(), want)
+	}
+}
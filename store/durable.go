@@ -47,3 +47,9 @@ func (w *DurableWriter) Close() error {
 	}
 	return nil
 }
+
+// Abort discards what was written, leaving the final path untouched.
+func (w *DurableWriter) Abort() error {
+	w.tmp.Close()
+	return os.Remove(w.tmp.Name())
+}
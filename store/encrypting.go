@@ -0,0 +1,485 @@
+package store
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/Avalanche-io/c4"
+)
+
+var _ Store = &Encrypting{}
+
+// KeySize is the length in bytes of an encryption key.
+const KeySize = 32
+
+// ErrDecrypt is returned when encrypted content fails authentication:
+// it was tampered with, damaged, or encrypted under a different key.
+var ErrDecrypt = errors.New("c4 store: decryption failed")
+
+// Encrypting wraps another store and encrypts content at rest with
+// AES-256-GCM, in chunks so that objects of any size can be streamed.
+// Objects are still addressed by the C4 ID of their plaintext, and each
+// chunk is bound to that ID and to its position, so ciphertext that has been
+// altered, truncated, reordered or stored under another ID fails to
+// decrypt. No unauthenticated plaintext is ever returned.
+//
+// Each object is encrypted under its own key, derived from the store key
+// and a salt kept in the object's header. By default the salt is random.
+// In convergent mode it is derived from the store key and the content's ID,
+// so identical content encrypts to identical objects: users sharing a key
+// share stored content, and the storage provider learns only which objects
+// are equal, which the ID names already reveal.
+type Encrypting struct {
+	s          Store
+	key        []byte
+	convergent bool
+}
+
+// NewEncrypting wraps s, encrypting each object under a random salt.
+func NewEncrypting(s Store, key []byte) (*Encrypting, error) {
+	if len(key) != KeySize {
+		return nil, fmt.Errorf("encryption key must be %d bytes, got %d", KeySize, len(key))
+	}
+	return &Encrypting{s: s, key: key}, nil
+}
+
+// NewConvergentEncrypting wraps s with convergent encryption, so that
+// identical content stored by anyone holding key deduplicates.
+func NewConvergentEncrypting(s Store, key []byte) (*Encrypting, error) {
+	e, err := NewEncrypting(s, key)
+	if err != nil {
+		return nil, err
+	}
+	e.convergent = true
+	return e, nil
+}
+
+// GenerateKey returns a new random encryption key.
+func GenerateKey() ([]byte, error) {
+	key := make([]byte, KeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// ParseKey decodes a hex-encoded key, as written to key files.
+func ParseKey(s string) ([]byte, error) {
+	key, err := hex.DecodeString(strings.TrimSpace(s))
+	if err != nil {
+		return nil, fmt.Errorf("encryption key is not hex: %w", err)
+	}
+	if len(key) != KeySize {
+		return nil, fmt.Errorf("encryption key must be %d bytes, got %d", KeySize, len(key))
+	}
+	return key, nil
+}
+
+// LoadKeyFile reads a hex-encoded key from path.
+func LoadKeyFile(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	key, err := ParseKey(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return key, nil
+}
+
+// An encrypted object is a header, encHeaderMagic, a version byte and a
+// salt, followed by chunks of at most encChunkSize plaintext bytes, each
+// sealed with its own nonce: the chunk number, and a flag marking the last
+// chunk. Every object has a last chunk, possibly empty, so truncation at a
+// chunk boundary is detected.
+const (
+	encHeaderMagic = "\x89C4E"
+	encVersion     = 1
+	encSaltSize    = 32
+	encHeaderSize  = len(encHeaderMagic) + 1 + encSaltSize
+	encChunkSize   = 64 << 10
+)
+
+// Open returns a reader for the plaintext of id. The header and first
+// chunk are authenticated before Open returns.
+func (e *Encrypting) Open(id c4.ID) (io.ReadCloser, error) {
+	rc, err := e.s.Open(id)
+	if err != nil {
+		return nil, err
+	}
+	r, err := e.decrypt(id, rc)
+	if err != nil {
+		rc.Close()
+		return nil, fmt.Errorf("%s: %w", id, err)
+	}
+	return readCloser{r, rc}, nil
+}
+
+// Create returns a writer that encrypts the plaintext of id. The
+// ciphertext is spooled to a temp file and stored in the wrapped store on
+// Close, if the plaintext matches id or is a Compressing encoding of
+// content that does. Otherwise Close fails with ErrInvalidID.
+func (e *Encrypting) Create(id c4.ID) (io.WriteCloser, error) {
+	return e.create(id)
+}
+
+func (e *Encrypting) create(id c4.ID) (*encryptWriter, error) {
+	var salt [encSaltSize]byte
+	if e.convergent {
+		mac := hmac.New(sha256.New, e.key)
+		mac.Write([]byte("c4 convergent salt\x00"))
+		mac.Write(id[:])
+		copy(salt[:], mac.Sum(nil))
+	} else if _, err := rand.Read(salt[:]); err != nil {
+		return nil, err
+	}
+	aead, err := e.aead(salt[:])
+	if err != nil {
+		return nil, err
+	}
+	tmp, err := os.CreateTemp("", "c4seal.*")
+	if err != nil {
+		return nil, err
+	}
+	header := append([]byte(encHeaderMagic), encVersion)
+	header = append(header, salt[:]...)
+	if _, err := tmp.Write(header); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return nil, err
+	}
+	return &encryptWriter{e: e, w: tmp, h: sha512.New(), aead: aead, id: id, buf: make([]byte, 0, encChunkSize)}, nil
+}
+
+// store writes the spooled ciphertext of id to the wrapped store.
+func (e *Encrypting) store(id c4.ID, r io.Reader) error {
+	w, err := e.s.Create(id)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(w, r); err != nil {
+		w.Close()
+		e.s.Remove(id)
+		return err
+	}
+	return w.Close()
+}
+
+// Put stores the content of r and returns its ID. The plaintext is spooled
+// to a temp file first, since its ID is needed before encryption starts.
+func (e *Encrypting) Put(r io.Reader) (c4.ID, error) {
+	tmp, err := os.CreateTemp("", "c4enc.*")
+	if err != nil {
+		return c4.ID{}, err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	h := sha512.New()
+	if _, err := io.Copy(io.MultiWriter(tmp, h), r); err != nil {
+		return c4.ID{}, err
+	}
+	var id c4.ID
+	copy(id[:], h.Sum(nil))
+	if e.s.Has(id) {
+		return id, nil
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return c4.ID{}, err
+	}
+	w, err := e.create(id)
+	if err != nil {
+		return c4.ID{}, err
+	}
+	if _, err := io.Copy(w, tmp); err != nil {
+		w.discard()
+		return c4.ID{}, err
+	}
+	return id, w.Close()
+}
+
+func (e *Encrypting) Has(id c4.ID) bool { return e.s.Has(id) }
+
+func (e *Encrypting) Remove(id c4.ID) error { return e.s.Remove(id) }
+
+// List calls List on the wrapped Store. Sizes are those of the ciphertext.
+func (e *Encrypting) List(fn func(Object) bool) error {
+	return List(e.s, fn)
+}
+
+// aead returns the cipher for the object with the given salt.
+func (e *Encrypting) aead(salt []byte) (cipher.AEAD, error) {
+	mac := hmac.New(sha256.New, e.key)
+	mac.Write([]byte("c4 object key\x00"))
+	mac.Write(salt)
+	block, err := aes.NewCipher(mac.Sum(nil))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// decrypt returns a reader for the plaintext of the encrypted object id
+// read from r.
+func (e *Encrypting) decrypt(id c4.ID, r io.Reader) (io.Reader, error) {
+	br := bufio.NewReaderSize(r, encChunkSize+64)
+	aead, err := e.readHeader(br)
+	if err != nil {
+		return nil, err
+	}
+	return decryptFrom(br, aead, id, 0)
+}
+
+// readHeader reads the header of an encrypted object and returns the
+// object's cipher.
+func (e *Encrypting) readHeader(r io.Reader) (cipher.AEAD, error) {
+	header := make([]byte, encHeaderSize)
+	if _, err := io.ReadFull(r, header); err != nil || string(header[:len(encHeaderMagic)]) != encHeaderMagic {
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return nil, err
+		}
+		return nil, fmt.Errorf("not an encrypted object: %w", ErrDecrypt)
+	}
+	if v := header[len(encHeaderMagic)]; v != encVersion {
+		return nil, fmt.Errorf("unknown encryption version %d", v)
+	}
+	return e.aead(header[len(encHeaderMagic)+1:])
+}
+
+// decryptFrom returns a reader for the plaintext of the chunks read from
+// r, the first of which is chunk n. That chunk is authenticated before
+// decryptFrom returns.
+func decryptFrom(r *bufio.Reader, aead cipher.AEAD, id c4.ID, n uint64) (*decryptReader, error) {
+	d := &decryptReader{r: r, aead: aead, id: id, n: n, chunk: make([]byte, encChunkSize+aead.Overhead())}
+	if err := d.next(); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// OpenRange decrypts only the chunks that hold the range. Each of them is
+// authenticated, but damage elsewhere in the object goes unnoticed.
+func (e *Encrypting) OpenRange(id c4.ID, off, n int64) (io.ReadCloser, error) {
+	if off < 0 {
+		return nil, fmt.Errorf("open range of %s: negative offset %d", id, off)
+	}
+	if n == 0 {
+		return io.NopCloser(strings.NewReader("")), nil
+	}
+	hrc, err := OpenRange(e.s, id, 0, int64(encHeaderSize))
+	if err != nil {
+		return nil, err
+	}
+	aead, err := e.readHeader(hrc)
+	hrc.Close()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", id, err)
+	}
+
+	first := off / encChunkSize
+	stride := int64(encChunkSize + aead.Overhead())
+	length := int64(-1)
+	if n > 0 {
+		// One byte past the last chunk needed shows it is not the last
+		// chunk of the object, if it is not.
+		last := (off + n - 1) / encChunkSize
+		length = (last-first+1)*stride + 1
+	}
+	rc, err := OpenRange(e.s, id, int64(encHeaderSize)+first*stride, length)
+	if err != nil {
+		return nil, err
+	}
+	br := bufio.NewReaderSize(rc, encChunkSize+64)
+	if _, err := br.Peek(1); err == io.EOF {
+		rc.Close()
+		return io.NopCloser(strings.NewReader("")), nil // past the end
+	}
+	d, err := decryptFrom(br, aead, id, uint64(first))
+	if err != nil {
+		rc.Close()
+		return nil, fmt.Errorf("%s: %w", id, err)
+	}
+	r, err := skipTo(readCloser{d, rc}, off-first*encChunkSize, n)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", id, err)
+	}
+	return r, nil
+}
+
+// chunkNonce returns the nonce of chunk n.
+func chunkNonce(n uint64, last bool) []byte {
+	nonce := make([]byte, 12)
+	binary.BigEndian.PutUint64(nonce[3:11], n)
+	if last {
+		nonce[11] = 1
+	}
+	return nonce
+}
+
+type encryptWriter struct {
+	e      *Encrypting
+	w      *os.File // the ciphertext spool
+	h      hash.Hash
+	head   []byte // the first plaintext bytes, to spot a compressed encoding
+	aead   cipher.AEAD
+	id     c4.ID
+	n      uint64
+	buf    []byte
+	sealed []byte
+	err    error
+}
+
+func (w *encryptWriter) Write(p []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+	w.h.Write(p)
+	if need := len(compressMagic) - len(w.head); need > 0 {
+		if need > len(p) {
+			need = len(p)
+		}
+		w.head = append(w.head, p[:need]...)
+	}
+	written := 0
+	for len(p) > 0 {
+		// A full chunk is only sealed once more data arrives, since the
+		// last chunk must be flagged as such.
+		if len(w.buf) == encChunkSize {
+			if w.err = w.seal(false); w.err != nil {
+				return written, w.err
+			}
+		}
+		n := copy(w.buf[len(w.buf):encChunkSize], p)
+		w.buf = w.buf[:len(w.buf)+n]
+		p = p[n:]
+		written += n
+	}
+	return written, nil
+}
+
+func (w *encryptWriter) seal(last bool) error {
+	w.sealed = w.aead.Seal(w.sealed[:0], chunkNonce(w.n, last), w.buf, w.id[:])
+	w.n++
+	w.buf = w.buf[:0]
+	_, err := w.w.Write(w.sealed)
+	return err
+}
+
+// Close stores the ciphertext, unless the plaintext does not match the ID
+// it was created with, when it fails with ErrInvalidID.
+func (w *encryptWriter) Close() error {
+	defer w.discard()
+	if w.err == nil {
+		w.err = w.seal(true)
+	}
+	if w.err != nil {
+		return w.err
+	}
+	var got c4.ID
+	copy(got[:], w.h.Sum(nil))
+	if got != w.id && !w.encodedMatches() {
+		return ErrInvalidID
+	}
+	if _, err := w.w.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	return w.e.store(w.id, w.w)
+}
+
+// encodedMatches reports whether the plaintext is a Compressing encoding
+// of content that matches the ID, as it is with Compressing on top.
+func (w *encryptWriter) encodedMatches() bool {
+	if string(w.head) != compressMagic {
+		return false
+	}
+	if _, err := w.w.Seek(0, io.SeekStart); err != nil {
+		return false
+	}
+	r, err := w.e.decrypt(w.id, w.w)
+	if err != nil {
+		return false
+	}
+	d, err := decodeObject(r)
+	if err != nil {
+		return false
+	}
+	h := sha512.New()
+	if _, err := io.Copy(h, d); err != nil {
+		return false
+	}
+	return bytes.Equal(h.Sum(nil), w.id[:])
+}
+
+// discard removes the spool.
+func (w *encryptWriter) discard() {
+	w.w.Close()
+	os.Remove(w.w.Name())
+}
+
+type decryptReader struct {
+	r     *bufio.Reader
+	aead  cipher.AEAD
+	id    c4.ID
+	n     uint64
+	chunk []byte
+	plain []byte
+	last  bool
+	err   error
+}
+
+func (d *decryptReader) Read(p []byte) (int, error) {
+	for len(d.plain) == 0 {
+		if d.err != nil {
+			return 0, d.err
+		}
+		if d.last {
+			return 0, io.EOF
+		}
+		d.err = d.next()
+	}
+	n := copy(p, d.plain)
+	d.plain = d.plain[n:]
+	return n, nil
+}
+
+// next reads and authenticates the next chunk.
+func (d *decryptReader) next() error {
+	n, err := io.ReadFull(d.r, d.chunk)
+	switch err {
+	case nil:
+		_, err := d.r.Peek(1)
+		if err == io.EOF {
+			d.last = true
+		} else if err != nil {
+			return err
+		}
+	case io.EOF, io.ErrUnexpectedEOF:
+		d.last = true
+	default:
+		return err
+	}
+	if n < d.aead.Overhead() {
+		return io.ErrUnexpectedEOF
+	}
+	plain, err := d.aead.Open(d.chunk[:0], chunkNonce(d.n, d.last), d.chunk[:n], d.id[:])
+	if err != nil {
+		return ErrDecrypt
+	}
+	d.n++
+	d.plain = plain
+	return nil
+}
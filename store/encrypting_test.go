@@ -0,0 +1,212 @@
+package store
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Avalanche-io/c4"
+)
+
+func testKey(t *testing.T) []byte {
+	t.Helper()
+	key, err := GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func TestEncryptingRoundTrip(t *testing.T) {
+	ram := NewRAM()
+	s, err := NewEncrypting(ram, testKey(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rng := rand.New(rand.NewSource(1))
+	for _, size := range []int{0, 1, encChunkSize - 1, encChunkSize, 2 * encChunkSize, 3*encChunkSize + 17} {
+		data := make([]byte, size)
+		rng.Read(data)
+		id, err := s.Put(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("%d bytes: %v", size, err)
+		}
+		if want := c4.Identify(bytes.NewReader(data)); id != want {
+			t.Errorf("%d bytes: Put returned %s, want the plaintext ID", size, id)
+		}
+		if got := readAll(t, s, id); !bytes.Equal(got, data) {
+			t.Errorf("%d bytes: content did not round-trip", size)
+		}
+		// A byte or two can turn up in the ciphertext by chance.
+		if size > 2 && bytes.Contains(readAll(t, ram, id), data) {
+			t.Errorf("%d bytes: plaintext stored", size)
+		}
+	}
+
+	if _, err := NewEncrypting(ram, []byte("short")); err == nil {
+		t.Error("short key accepted")
+	}
+
+	wrong := testID("something else")
+	w, err := s.Create(wrong)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(w, "plaintext")
+	if err := w.Close(); err != ErrInvalidID {
+		t.Errorf("Close of mismatched content: %v, want ErrInvalidID", err)
+	}
+	if ram.Has(wrong) {
+		t.Error("mismatched content was stored")
+	}
+}
+
+func TestEncryptingTamper(t *testing.T) {
+	ts, err := NewTreeStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := testKey(t)
+	s, _ := NewEncrypting(ts, key)
+	data := bytes.Repeat([]byte("client footage "), 3*encChunkSize/15)
+	id, err := s.Put(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	other := putString(t, s, "another object")
+	original, _ := os.ReadFile(ts.path(id))
+
+	readErr := func() error {
+		rc, err := s.Open(id)
+		if err != nil {
+			return err
+		}
+		defer rc.Close()
+		_, err = io.ReadAll(rc)
+		return err
+	}
+
+	damaged := map[string][]byte{
+		"first chunk":  flipByte(original, encHeaderSize+10),
+		"later chunk":  flipByte(original, len(original)-100),
+		"salt":         flipByte(original, encHeaderSize-1),
+		"truncated":    original[:encHeaderSize+encChunkSize+16],
+		"last removed": original[:len(original)-16-(len(data)%encChunkSize)],
+	}
+	for name, b := range damaged {
+		os.WriteFile(ts.path(id), b, 0644)
+		if err := readErr(); !errors.Is(err, ErrDecrypt) && !errors.Is(err, io.ErrUnexpectedEOF) {
+			t.Errorf("%s: read error %v, want ErrDecrypt", name, err)
+		}
+	}
+	os.WriteFile(ts.path(id), flipByte(original, encHeaderSize+10), 0644)
+	if _, err := s.Open(id); !errors.Is(err, ErrDecrypt) {
+		t.Errorf("damage in the first chunk should fail Open, got %v", err)
+	}
+
+	// Ciphertext moved under another ID.
+	swapped, _ := os.ReadFile(ts.path(other))
+	os.WriteFile(ts.path(id), swapped, 0644)
+	if err := readErr(); !errors.Is(err, ErrDecrypt) {
+		t.Errorf("swapped object: %v", err)
+	}
+
+	// The wrong key.
+	os.WriteFile(ts.path(id), original, 0644)
+	wrong, _ := NewEncrypting(ts, testKey(t))
+	if _, err := wrong.Open(id); !errors.Is(err, ErrDecrypt) {
+		t.Errorf("wrong key: %v", err)
+	}
+	if err := readErr(); err != nil {
+		t.Errorf("restored object: %v", err)
+	}
+}
+
+func flipByte(b []byte, i int) []byte {
+	b = append([]byte(nil), b...)
+	b[i] ^= 0x01
+	return b
+}
+
+func TestEncryptingConvergent(t *testing.T) {
+	key := testKey(t)
+	stored := func(convergent bool) []byte {
+		ram := NewRAM()
+		s, _ := NewEncrypting(ram, key)
+		if convergent {
+			s, _ = NewConvergentEncrypting(ram, key)
+		}
+		id := putString(t, s, "shared plate")
+		return readAll(t, ram, id)
+	}
+	if !bytes.Equal(stored(true), stored(true)) {
+		t.Error("convergent encryption should be deterministic")
+	}
+	if bytes.Equal(stored(false), stored(false)) {
+		t.Error("per-store encryption should use a fresh salt per object")
+	}
+}
+
+func TestFsckEncrypted(t *testing.T) {
+	ts, err := NewTreeStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	enc, _ := NewEncrypting(ts, testKey(t))
+	s := NewCompressing(enc)
+	corrupt := putString(t, s, strings.Repeat("secret ", 100))
+	putString(t, s, "also secret")
+
+	original, _ := os.ReadFile(ts.path(corrupt))
+	os.WriteFile(ts.path(corrupt), flipByte(original, len(original)-1), 0644)
+
+	rep, err := Fsck(s, FsckOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rep.Checked != 2 || len(rep.Problems) != 1 || rep.Problems[0].Kind != ProblemCorrupt {
+		t.Fatalf("checked %d, problems %v", rep.Checked, rep.Problems)
+	}
+	// Checked raw, every object would look corrupt.
+	if rep, _ := Fsck(ts, FsckOptions{}); len(rep.Problems) != 2 {
+		t.Errorf("raw check found %d problems, want 2", len(rep.Problems))
+	}
+}
+
+func TestEncryptConfigured(t *testing.T) {
+	dir := t.TempDir()
+	keyFile := filepath.Join(dir, "key")
+	os.WriteFile(keyFile, []byte(strings.Repeat("ab", KeySize)+"\n"), 0600)
+	os.Setenv("C4_KEY_FILE", keyFile)
+	defer os.Unsetenv("C4_KEY_FILE")
+
+	s, err := OpenURI(filepath.Join(dir, "store"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, ok := s.(*Encrypting)
+	if !ok || e.convergent {
+		t.Fatalf("opened %T, want a per-store Encrypting", s)
+	}
+
+	os.Setenv("C4_ENCRYPTION", "convergent")
+	defer os.Unsetenv("C4_ENCRYPTION")
+	if s, err := OpenURI(filepath.Join(dir, "store")); err != nil || !s.(*Encrypting).convergent {
+		t.Errorf("convergent mode not applied: %v", err)
+	}
+	os.Setenv("C4_ENCRYPTION", "rot13")
+	if _, err := OpenURI(filepath.Join(dir, "store")); err == nil {
+		t.Error("unknown mode accepted")
+	}
+
+	os.Setenv("C4_KEY", "not hex")
+	defer os.Unsetenv("C4_KEY")
+	if _, err := OpenURI(filepath.Join(dir, "store")); err == nil {
+		t.Error("bad C4_KEY accepted")
+	}
+}
@@ -0,0 +1,705 @@
+package store
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha512"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/Avalanche-io/c4"
+)
+
+var _ Store = &Erasure{}
+
+// Erasure is a store that splits each object into data shards and parity
+// shards with Reed-Solomon coding, one shard to each of its backends, so
+// that k data and m parity backends hold an object in k+m shards each 1/k
+// of its size. Any k of them are enough to read it: up to m backends may be
+// missing, unreachable or hold damaged shards.
+//
+// Every shard is stored under the object's ID, not its own, so backends
+// must be dedicated to the Erasure store and must accept content that does
+// not match its ID: TreeStore, Folder, S3Store and RAM do, but stores that
+// verify what they are given, HTTPStore and PackStore, do not. A shard has a
+// header naming its place in the coding, and a checksum for each block, so
+// damage is found and the block is rebuilt from the others; what is read
+// is also checked against the object's ID.
+//
+// A write must reach every backend. Rebuild writes the shards of a
+// replaced backend.
+//
+// Never run Fsck on a backend by itself: no shard matches its ID, so every
+// one would be reported corrupt, and removed with Repair. Check the Erasure
+// store instead, which reads each object through its shards.
+type Erasure struct {
+	stores    []Store
+	k, m      int
+	rs        *reedSolomon
+	blockSize int // bytes of each shard per stripe
+}
+
+// DefaultErasureBlock is the size of the block each shard holds of a
+// stripe of k blocks of an object.
+const DefaultErasureBlock = 256 << 10
+
+// maxErasureStripe bounds the bytes in a stripe, k blocks. A record holds
+// its stripe length in 32 bits, and each writer and reader holds a stripe
+// in memory.
+const maxErasureStripe = 256 << 20
+
+// NewErasure creates a store with the given number of data shards, and a
+// parity shard for each of the other stores.
+func NewErasure(data int, stores ...Store) (*Erasure, error) {
+	rs, err := newReedSolomon(data, len(stores)-data)
+	if err != nil {
+		return nil, err
+	}
+	return &Erasure{stores: stores, k: data, m: len(stores) - data, rs: rs, blockSize: DefaultErasureBlock}, nil
+}
+
+// Shards returns the number of data and parity shards.
+func (e *Erasure) Shards() (data, parity int) {
+	return e.k, e.m
+}
+
+// Shard format: a header, then one record per stripe of k*blockSize bytes
+// of the object. A record has the number of object bytes in the stripe,
+// the CRC-32C of that number and the block, and the block, of
+// ceil(n/k) bytes. The last record is the one with fewer than k*blockSize
+// bytes, possibly none.
+const (
+	erasureMagic      = "C4EC"
+	erasureVersion    = 1
+	erasureHeaderSize = 12 // magic, version, k, m, index, block size
+	erasureRecordHead = 8  // stripe length, CRC
+)
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// errBadShard reports a shard whose header or checksums are wrong.
+var errBadShard = fmt.Errorf("damaged erasure shard")
+
+func (e *Erasure) header(i, blockSize int) []byte {
+	h := make([]byte, erasureHeaderSize)
+	copy(h, erasureMagic)
+	h[4], h[5], h[6], h[7] = erasureVersion, byte(e.k), byte(e.m), byte(i)
+	binary.BigEndian.PutUint32(h[8:], uint32(blockSize))
+	return h
+}
+
+// writeRecord writes the record of a stripe of n bytes holding block.
+func writeRecord(w io.Writer, n int, block []byte) error {
+	var head [erasureRecordHead]byte
+	binary.BigEndian.PutUint32(head[:4], uint32(n))
+	crc := crc32.Update(crc32.Checksum(head[:4], castagnoli), castagnoli, block)
+	binary.BigEndian.PutUint32(head[4:], crc)
+	if _, err := w.Write(head[:]); err != nil {
+		return err
+	}
+	_, err := w.Write(block)
+	return err
+}
+
+// stripeData appends the n bytes of object data in the data blocks of a
+// stripe to buf.
+func stripeData(buf []byte, blocks [][]byte, k, n int) []byte {
+	start := len(buf)
+	for d := 0; d < k && len(buf)-start < n; d++ {
+		buf = append(buf, blocks[d]...)
+	}
+	return buf[:start+n]
+}
+
+// checkHeader checks that h is the header of shard i, and returns the
+// block size it was written with.
+func (e *Erasure) checkHeader(h []byte, i int) (int, error) {
+	if len(h) != erasureHeaderSize || string(h[:4]) != erasureMagic || h[4] != erasureVersion {
+		return 0, errBadShard
+	}
+	if int(h[5]) != e.k || int(h[6]) != e.m || int(h[7]) != i {
+		return 0, fmt.Errorf("%w: shard %d of %d+%d, want %d of %d+%d", errBadShard, h[7], h[5], h[6], i, e.k, e.m)
+	}
+	size := int(binary.BigEndian.Uint32(h[8:]))
+	if size <= 0 || int64(size)*int64(e.k) > maxErasureStripe {
+		return 0, errBadShard
+	}
+	return size, nil
+}
+
+// Has reports whether enough backends have a shard of id to read it.
+func (e *Erasure) Has(id c4.ID) bool {
+	return e.count(id) >= e.k
+}
+
+// count returns how many backends have a shard of id.
+func (e *Erasure) count(id c4.ID) int {
+	has := make([]bool, len(e.stores))
+	var wg sync.WaitGroup
+	for i, s := range e.stores {
+		wg.Add(1)
+		go func(i int, s Store) {
+			defer wg.Done()
+			has[i] = s.Has(id)
+		}(i, s)
+	}
+	wg.Wait()
+	n := 0
+	for _, ok := range has {
+		if ok {
+			n++
+		}
+	}
+	return n
+}
+
+// Open reads id from the data shards, reconstructing any block that is
+// missing or damaged from the parity shards. The content is checked
+// against id as it is read, and the read fails with ErrInvalidID at the
+// end if it does not match.
+func (e *Erasure) Open(id c4.ID) (io.ReadCloser, error) {
+	sr, err := e.openStripes(id, -1)
+	if err != nil {
+		return nil, err
+	}
+	return &erasureReader{s: sr, id: id, h: sha512.New()}, nil
+}
+
+// Create returns a writer that encodes the content into shards as it is
+// written. Close fails, storing nothing, unless the content matches id and
+// every backend stored its shard.
+func (e *Erasure) Create(id c4.ID) (io.WriteCloser, error) {
+	if e.Has(id) {
+		return nil, &os.PathError{Op: "create", Path: id.String(), Err: os.ErrExist}
+	}
+	w := &erasureWriter{e: e, id: id, h: sha512.New()}
+	for i, s := range e.stores {
+		sw, err := s.Create(id)
+		if os.IsExist(err) {
+			// A shard left by a write that failed part way.
+			s.Remove(id)
+			sw, err = s.Create(id)
+		}
+		if err == nil {
+			w.ws = append(w.ws, sw)
+			_, err = sw.Write(e.header(i, e.blockSize))
+		}
+		if err != nil {
+			w.abort()
+			return nil, fmt.Errorf("erasure shard %d: %w", i, err)
+		}
+	}
+	w.shards = make([][]byte, e.k+e.m)
+	for i := range w.shards {
+		w.shards[i] = make([]byte, e.blockSize)
+	}
+	w.stripe = make([]byte, 0, e.k*e.blockSize)
+	return w, nil
+}
+
+// Put reads r to a temp file to identify it, then writes its shards.
+func (e *Erasure) Put(r io.Reader) (c4.ID, error) {
+	tmp, err := os.CreateTemp("", ".c4erasure.*")
+	if err != nil {
+		return c4.ID{}, err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	h := sha512.New()
+	if _, err := io.Copy(io.MultiWriter(tmp, h), r); err != nil {
+		return c4.ID{}, fmt.Errorf("copy: %w", err)
+	}
+	var id c4.ID
+	copy(id[:], h.Sum(nil))
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return c4.ID{}, err
+	}
+	w, err := e.Create(id)
+	if os.IsExist(err) {
+		return id, nil
+	}
+	if err != nil {
+		return c4.ID{}, err
+	}
+	if _, err := io.Copy(w, tmp); err != nil {
+		w.(*erasureWriter).abort()
+		return c4.ID{}, err
+	}
+	if err := w.Close(); err != nil {
+		return c4.ID{}, err
+	}
+	return id, nil
+}
+
+// Remove removes the shards of id from every backend.
+func (e *Erasure) Remove(id c4.ID) error {
+	var lastErr error
+	found := false
+	for _, s := range e.stores {
+		if !s.Has(id) {
+			continue
+		}
+		found = true
+		if err := s.Remove(id); err != nil {
+			lastErr = err
+		}
+	}
+	if !found {
+		return &os.PathError{Op: "remove", Path: id.String(), Err: os.ErrNotExist}
+	}
+	return lastErr
+}
+
+// List reports every object of which any backend that can list holds a
+// shard, including those with too few shards left to read. Sizes are those
+// of one shard, as stored. If no backend can list, List returns
+// ErrNotImplemented.
+func (e *Erasure) List(fn func(Object) bool) error {
+	return e.listShards(-1, fn)
+}
+
+// listShards calls fn once for each object of which backends other than
+// skip hold shards, with the first shard listed.
+func (e *Erasure) listShards(skip int, fn func(Object) bool) error {
+	seen := make(map[c4.ID]bool)
+	listed, stop := false, false
+	for j, s := range e.stores {
+		if j == skip {
+			continue
+		}
+		err := List(s, func(o Object) bool {
+			if seen[o.ID] {
+				return true
+			}
+			seen[o.ID] = true
+			if !fn(o) {
+				stop = true
+				return false
+			}
+			return true
+		})
+		switch err {
+		case nil:
+			listed = true
+		case ErrNotImplemented:
+		default:
+			return fmt.Errorf("list erasure backend %d: %w", j+1, err)
+		}
+		if stop {
+			return nil
+		}
+	}
+	if !listed {
+		return ErrNotImplemented
+	}
+	return nil
+}
+
+// erasureWriter is the writer returned by Erasure.Create.
+type erasureWriter struct {
+	e      *Erasure
+	id     c4.ID
+	h      hash.Hash
+	ws     []io.WriteCloser
+	shards [][]byte
+	stripe []byte
+	err    error
+}
+
+func (w *erasureWriter) Write(p []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+	w.h.Write(p)
+	n := len(p)
+	full := cap(w.stripe)
+	for len(p) > 0 {
+		take := full - len(w.stripe)
+		if take > len(p) {
+			take = len(p)
+		}
+		w.stripe = append(w.stripe, p[:take]...)
+		p = p[take:]
+		if len(w.stripe) == full {
+			if err := w.flush(); err != nil {
+				return n - len(p), err
+			}
+		}
+	}
+	return n, nil
+}
+
+// flush encodes the buffered stripe and writes a record to each shard.
+func (w *erasureWriter) flush() error {
+	k := w.e.k
+	n := len(w.stripe)
+	block := (n + k - 1) / k
+	for d := 0; d < k; d++ {
+		b := w.shards[d][:block]
+		c := 0
+		if d*block < n {
+			c = copy(b, w.stripe[d*block:])
+		}
+		for i := c; i < block; i++ {
+			b[i] = 0
+		}
+	}
+	blocks := make([][]byte, len(w.shards))
+	for i := range blocks {
+		blocks[i] = w.shards[i][:block]
+	}
+	w.e.rs.encode(blocks)
+
+	for i, sw := range w.ws {
+		if err := writeRecord(sw, n, blocks[i]); err != nil {
+			w.err = fmt.Errorf("erasure shard %d: %w", i, err)
+			return w.err
+		}
+	}
+	w.stripe = w.stripe[:0]
+	return nil
+}
+
+func (w *erasureWriter) Close() error {
+	if w.err == nil {
+		// The last record is short, even if empty.
+		w.err = w.flush()
+	}
+	for i, sw := range w.ws {
+		if err := sw.Close(); err != nil && w.err == nil {
+			w.err = fmt.Errorf("erasure shard %d: %w", i, err)
+		}
+	}
+	if w.err == nil && !bytes.Equal(w.h.Sum(nil), w.id[:]) {
+		w.err = ErrInvalidID
+	}
+	if w.err != nil {
+		w.removeShards()
+	}
+	return w.err
+}
+
+// abort closes what has been written and removes it.
+func (w *erasureWriter) abort() {
+	for _, sw := range w.ws {
+		sw.Close()
+	}
+	w.removeShards()
+}
+
+func (w *erasureWriter) removeShards() {
+	for _, s := range w.e.stores {
+		if s.Has(w.id) {
+			s.Remove(w.id)
+		}
+	}
+}
+
+// shardReader reads the records of one shard.
+type shardReader struct {
+	rc io.ReadCloser
+	r  *bufio.Reader
+}
+
+// openShard opens shard i of id, positioned at the record of stripe, and
+// returns its block size. A shard opened part way is checked against its
+// header, and must have blockSize.
+func (e *Erasure) openShard(id c4.ID, i int, stripe int64, blockSize int) (*shardReader, int, error) {
+	s := e.stores[i]
+	if stripe == 0 {
+		rc, err := s.Open(id)
+		if err != nil {
+			return nil, 0, err
+		}
+		sr := &shardReader{rc: rc, r: bufio.NewReader(rc)}
+		h := make([]byte, erasureHeaderSize)
+		if _, err := io.ReadFull(sr.r, h); err != nil {
+			rc.Close()
+			return nil, 0, errBadShard
+		}
+		size, err := e.checkHeader(h, i)
+		if err != nil {
+			rc.Close()
+			return nil, 0, err
+		}
+		return sr, size, nil
+	}
+
+	rc, err := OpenRange(s, id, 0, erasureHeaderSize)
+	if err != nil {
+		return nil, 0, err
+	}
+	h, err := io.ReadAll(rc)
+	rc.Close()
+	if err != nil {
+		return nil, 0, err
+	}
+	size, err := e.checkHeader(h, i)
+	if err != nil {
+		return nil, 0, err
+	}
+	if size != blockSize {
+		return nil, 0, fmt.Errorf("%w: block size %d, want %d", errBadShard, size, blockSize)
+	}
+	off := erasureHeaderSize + stripe*int64(erasureRecordHead+blockSize)
+	if rc, err = OpenRange(s, id, off, -1); err != nil {
+		return nil, 0, err
+	}
+	return &shardReader{rc: rc, r: bufio.NewReader(rc)}, size, nil
+}
+
+// next reads the next record into buf, which must be big enough for a
+// full block, and returns the stripe length and the block.
+func (sr *shardReader) next(k int, buf []byte) (int, []byte, error) {
+	var head [erasureRecordHead]byte
+	if _, err := io.ReadFull(sr.r, head[:]); err != nil {
+		return 0, nil, errBadShard
+	}
+	n := int(binary.BigEndian.Uint32(head[:4]))
+	block := (n + k - 1) / k
+	if block > len(buf) {
+		return 0, nil, errBadShard
+	}
+	b := buf[:block]
+	if _, err := io.ReadFull(sr.r, b); err != nil {
+		return 0, nil, errBadShard
+	}
+	if crc32.Update(crc32.Checksum(head[:4], castagnoli), castagnoli, b) != binary.BigEndian.Uint32(head[4:]) {
+		return 0, nil, errBadShard
+	}
+	return n, b, nil
+}
+
+// stripeReader decodes an object stripe by stripe from its shards. It
+// reads the data shards, and opens parity shards as they are needed to
+// make up for shards that are missing or fail.
+type stripeReader struct {
+	e         *Erasure
+	id        c4.ID
+	blockSize int
+	stripe    int64
+	open      []*shardReader // nil if not open
+	bad       []bool         // missing, failed or excluded
+	bufs      [][]byte
+	lastErr   error
+	done      bool
+}
+
+// openStripes opens id for decoding. Shard skip, if not negative, is not
+// read, as when it is being rebuilt.
+func (e *Erasure) openStripes(id c4.ID, skip int) (*stripeReader, error) {
+	n := e.k + e.m
+	sr := &stripeReader{e: e, id: id, open: make([]*shardReader, n), bad: make([]bool, n)}
+	if skip >= 0 {
+		sr.bad[skip] = true
+	}
+
+	// Open the first k usable shards at once, preferring data shards.
+	type result struct {
+		sh   *shardReader
+		size int
+		err  error
+	}
+	results := make([]result, n)
+	next := 0
+	for opened := 0; opened < e.k; {
+		var try []int
+		for ; next < n && len(try) < e.k-opened; next++ {
+			if !sr.bad[next] {
+				try = append(try, next)
+			}
+		}
+		if len(try) == 0 {
+			break
+		}
+		var wg sync.WaitGroup
+		for _, i := range try {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				sh, size, err := e.openShard(id, i, 0, 0)
+				results[i] = result{sh, size, err}
+			}(i)
+		}
+		wg.Wait()
+		for _, i := range try {
+			r := results[i]
+			switch {
+			case r.err != nil:
+				sr.bad[i] = true
+				sr.lastErr = r.err
+			case sr.blockSize != 0 && r.size != sr.blockSize:
+				r.sh.rc.Close()
+				sr.bad[i] = true
+				sr.lastErr = errBadShard
+			default:
+				sr.blockSize = r.size
+				sr.open[i] = r.sh
+				opened++
+			}
+		}
+	}
+	if sr.opened() < e.k {
+		sr.Close()
+		if sr.notFound() {
+			return nil, &os.PathError{Op: "open", Path: id.String(), Err: os.ErrNotExist}
+		}
+		return nil, fmt.Errorf("erasure %s: fewer than %d shards readable: %w", id, e.k, sr.lastErr)
+	}
+	sr.bufs = make([][]byte, n)
+	for i := range sr.bufs {
+		sr.bufs[i] = make([]byte, sr.blockSize)
+	}
+	return sr, nil
+}
+
+func (sr *stripeReader) opened() int {
+	n := 0
+	for _, sh := range sr.open {
+		if sh != nil {
+			n++
+		}
+	}
+	return n
+}
+
+// notFound reports whether too few backends have the object at all, rather
+// than having shards that fail.
+func (sr *stripeReader) notFound() bool {
+	return sr.e.count(sr.id) < sr.e.k
+}
+
+// next decodes the next stripe, returning all k+m blocks, of which the
+// first k hold the stripe's data, and its length. It returns io.EOF after
+// the last stripe.
+func (sr *stripeReader) next() ([][]byte, int, error) {
+	if sr.done {
+		return nil, 0, io.EOF
+	}
+	e := sr.e
+	n := e.k + e.m
+	blocks := make([][]byte, n)
+	present := make([]bool, n)
+	length := -1
+	good := 0
+	for i := 0; i < n; i++ {
+		if sr.open[i] == nil && !sr.bad[i] && good+sr.unread(i) < e.k {
+			// Too few shards left open; bring in another.
+			sh, size, err := e.openShard(sr.id, i, sr.stripe, sr.blockSize)
+			if err == nil && size != sr.blockSize {
+				sh.rc.Close()
+				err = errBadShard
+			}
+			if err != nil {
+				sr.bad[i] = true
+				sr.lastErr = err
+				continue
+			}
+			sr.open[i] = sh
+		}
+		sh := sr.open[i]
+		if sh == nil {
+			continue
+		}
+		l, b, err := sh.next(e.k, sr.bufs[i])
+		if err == nil && length >= 0 && l != length {
+			err = errBadShard
+		}
+		if err != nil {
+			sh.rc.Close()
+			sr.open[i] = nil
+			sr.bad[i] = true
+			sr.lastErr = err
+			continue
+		}
+		length = l
+		blocks[i], present[i] = b, true
+		good++
+	}
+	if good < e.k {
+		return nil, 0, fmt.Errorf("erasure %s: stripe %d: fewer than %d shards readable: %w", sr.id, sr.stripe, e.k, sr.lastErr)
+	}
+	block := (length + e.k - 1) / e.k
+	for i := range blocks {
+		if !present[i] {
+			blocks[i] = sr.bufs[i][:block]
+		}
+	}
+	if good < n {
+		if err := e.rs.reconstruct(blocks, present); err != nil {
+			return nil, 0, err
+		}
+	}
+	sr.stripe++
+	if length < e.k*sr.blockSize {
+		sr.done = true
+	}
+	return blocks, length, nil
+}
+
+// unread returns how many shards after i are open and not yet read for
+// this stripe.
+func (sr *stripeReader) unread(i int) int {
+	n := 0
+	for j := i + 1; j < len(sr.open); j++ {
+		if sr.open[j] != nil {
+			n++
+		}
+	}
+	return n
+}
+
+func (sr *stripeReader) Close() error {
+	for i, sh := range sr.open {
+		if sh != nil {
+			sh.rc.Close()
+			sr.open[i] = nil
+		}
+	}
+	return nil
+}
+
+// erasureReader is the reader returned by Erasure.Open.
+type erasureReader struct {
+	s    *stripeReader
+	id   c4.ID
+	h    hash.Hash
+	data []byte // the current stripe
+	buf  []byte // what is left of it to read
+	err  error
+}
+
+func (r *erasureReader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		blocks, n, err := r.s.next()
+		if err == io.EOF {
+			r.err = io.EOF
+			if !bytes.Equal(r.h.Sum(nil), r.id[:]) {
+				r.err = ErrInvalidID
+			}
+			continue
+		}
+		if err != nil {
+			r.err = err
+			continue
+		}
+		r.data = stripeData(r.data[:0], blocks, r.s.e.k, n)
+		r.buf = r.data
+		r.h.Write(r.buf)
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+func (r *erasureReader) Close() error {
+	return r.s.Close()
+}
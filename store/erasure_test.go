@@ -0,0 +1,285 @@
+package store
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/Avalanche-io/c4"
+)
+
+// newTestErasure returns an Erasure store of k data and m parity shards on
+// RAM backends, with small blocks so objects span many stripes.
+func newTestErasure(t *testing.T, k, m int) (*Erasure, []*RAM) {
+	t.Helper()
+	rams := make([]*RAM, k+m)
+	stores := make([]Store, k+m)
+	for i := range rams {
+		rams[i] = NewRAM()
+		stores[i] = rams[i]
+	}
+	e, err := NewErasure(k, stores...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.blockSize = 16
+	return e, rams
+}
+
+// corruptShard flips a byte of the shard of id in r, at off from the end.
+func corruptShard(t *testing.T, r *RAM, id c4.ID, off int) {
+	t.Helper()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	b := r.data[id]
+	if len(b) < off {
+		t.Fatalf("shard of %d bytes", len(b))
+	}
+	b[len(b)-off] ^= 0xff
+}
+
+func TestErasureRoundTrip(t *testing.T) {
+	e, rams := newTestErasure(t, 3, 2)
+	for _, size := range []int{0, 1, 15, 47, 48, 49, 1000} {
+		data := randomBytes(int64(size), size)
+		id, err := e.Put(bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if id != c4.Identify(bytes.NewReader(data)) {
+			t.Fatalf("Put returned %s", id)
+		}
+		if !e.Has(id) {
+			t.Fatalf("Has is false after Put of %d bytes", size)
+		}
+		if got := readAll(t, e, id); !bytes.Equal(got, data) {
+			t.Errorf("read %d bytes back as %d", size, len(got))
+		}
+		// Each shard holds a third of the content, in blocks of 16 bytes
+		// with 8 bytes of framing, after a header.
+		full, rest := size/48, size%48
+		want := erasureHeaderSize + full*(8+16) + 8 + (rest+2)/3
+		for i, r := range rams {
+			if n := len(r.data[id]); n != want {
+				t.Errorf("%d bytes: shard %d is %d bytes, want %d", size, i, n, want)
+			}
+		}
+	}
+
+	// Putting it again is a no-op; creating mismatched content fails
+	// without leaving shards behind.
+	if _, err := e.Put(strings.NewReader("again")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.Put(strings.NewReader("again")); err != nil {
+		t.Errorf("second Put: %v", err)
+	}
+	id := testID("claimed")
+	w, err := e.Create(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte("something else"))
+	if err := w.Close(); err != ErrInvalidID {
+		t.Errorf("Close of mismatched content: %v, want ErrInvalidID", err)
+	}
+	for i, r := range rams {
+		if r.Has(id) {
+			t.Errorf("backend %d kept a shard of mismatched content", i)
+		}
+	}
+
+	if _, err := e.Open(testID("missing")); !os.IsNotExist(err) {
+		t.Errorf("Open of a missing object: %v", err)
+	}
+}
+
+func TestErasureMissingBackends(t *testing.T) {
+	e, rams := newTestErasure(t, 4, 2)
+	data := randomBytes(7, 5000)
+	id, err := e.Put(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Any two backends may go.
+	for a := 0; a < 6; a++ {
+		for b := a + 1; b < 6; b++ {
+			saved := [][]byte{rams[a].data[id], rams[b].data[id]}
+			delete(rams[a].data, id)
+			delete(rams[b].data, id)
+			if got := readAll(t, e, id); !bytes.Equal(got, data) {
+				t.Errorf("without backends %d and %d: wrong content", a, b)
+			}
+			rams[a].data[id], rams[b].data[id] = saved[0], saved[1]
+		}
+	}
+
+	// Three may not.
+	for _, i := range []int{0, 2, 5} {
+		delete(rams[i].data, id)
+	}
+	if e.Has(id) {
+		t.Error("Has is true with three of six shards gone")
+	}
+	if _, err := e.Open(id); !os.IsNotExist(err) {
+		t.Errorf("Open with too few shards: %v", err)
+	}
+}
+
+func TestErasureCorruptShards(t *testing.T) {
+	e, rams := newTestErasure(t, 3, 2)
+	data := randomBytes(8, 3000)
+	id, err := e.Put(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Damage to two data shards, part way through, is found by the block
+	// checksums and rebuilt from parity opened at that stripe.
+	corruptShard(t, rams[0], id, 500)
+	corruptShard(t, rams[2], id, 100)
+	if got := readAll(t, e, id); !bytes.Equal(got, data) {
+		t.Fatal("read of damaged shards returned the wrong content")
+	}
+
+	// A third damaged shard is too many.
+	corruptShard(t, rams[3], id, 300)
+	rc, err := e.Open(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = io.ReadAll(rc)
+	rc.Close()
+	if err == nil {
+		t.Error("read with three damaged shards succeeded")
+	}
+}
+
+func TestErasureRebuild(t *testing.T) {
+	e, rams := newTestErasure(t, 3, 2)
+	ids := putN(t, e, 5, 777)
+	want := make(map[c4.ID][]byte)
+	for _, id := range ids {
+		want[id] = append([]byte(nil), rams[1].data[id]...)
+	}
+
+	// Backend 1 is replaced with an empty one.
+	rams[1] = NewRAM()
+	e.stores[1] = rams[1]
+	var rebuilt []c4.ID
+	rep, err := e.Rebuild(1, RebuildOptions{OnRebuild: func(id c4.ID) { rebuilt = append(rebuilt, id) }})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rep.Rebuilt != 5 || rep.Present != 0 || len(rep.Failed) != 0 || len(rebuilt) != 5 {
+		t.Fatalf("rebuild report %+v", rep)
+	}
+	for _, id := range ids {
+		if !bytes.Equal(rams[1].data[id], want[id]) {
+			t.Errorf("rebuilt shard of %s differs from the original", id)
+		}
+	}
+
+	// Damage is only found when asked to verify.
+	corruptShard(t, rams[1], ids[2], 10)
+	if rep, _ = e.Rebuild(1, RebuildOptions{}); rep.Rebuilt != 0 || rep.Present != 5 {
+		t.Errorf("rebuild without verify: %+v", rep)
+	}
+	if rep, _ = e.Rebuild(1, RebuildOptions{Verify: true, DryRun: true}); rep.Rebuilt != 1 {
+		t.Errorf("dry run: %+v", rep)
+	}
+	if rep, _ = e.Rebuild(1, RebuildOptions{Verify: true}); rep.Rebuilt != 1 || rep.Present != 4 {
+		t.Errorf("rebuild with verify: %+v", rep)
+	}
+	if !bytes.Equal(rams[1].data[ids[2]], want[ids[2]]) {
+		t.Error("damaged shard was not rewritten")
+	}
+
+	if _, err := e.Rebuild(5, RebuildOptions{}); err == nil {
+		t.Error("rebuilt a backend that does not exist")
+	}
+	unlisted, _ := NewErasure(1, nonListing{NewRAM()}, NewRAM())
+	if _, err := unlisted.Rebuild(1, RebuildOptions{}); !errors.Is(err, ErrNotImplemented) {
+		t.Errorf("rebuild with no listing backend: %v", err)
+	}
+}
+
+func TestErasureShardHeader(t *testing.T) {
+	e, rams := newTestErasure(t, 2, 1)
+	id, _ := e.Put(strings.NewReader("swapped shards"))
+	// Shards put back on the wrong backends are noticed.
+	rams[0].data[id], rams[1].data[id] = rams[1].data[id], rams[0].data[id]
+	if _, err := e.Open(id); err == nil {
+		t.Error("opened an object with its shards swapped")
+	}
+}
+
+func TestErasureList(t *testing.T) {
+	e, rams := newTestErasure(t, 2, 1)
+	a, _ := e.Put(strings.NewReader("whole"))
+	b, _ := e.Put(strings.NewReader("partly lost"))
+	rams[0].Remove(b)
+	rams[1].Remove(b)
+
+	// Every object with a shard is listed once, even one too damaged to read.
+	objs, err := ListAll(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(objs) != 2 || objs[0].ID == objs[1].ID || objs[0].ID != a && objs[0].ID != b {
+		t.Errorf("listed %v, want %s and %s", objs, a, b)
+	}
+
+	// Fsck reads each object through its shards.
+	rep, err := Fsck(e, FsckOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rep.Checked != 2 || len(rep.Problems) != 1 || rep.Problems[0].ID != b {
+		t.Errorf("fsck: %+v", rep)
+	}
+
+	if err := List(&Erasure{stores: []Store{nonListing{NewRAM()}}}, func(Object) bool { return true }); err != ErrNotImplemented {
+		t.Errorf("List with no listing backend: %v", err)
+	}
+}
+
+func TestOpenURIErasure(t *testing.T) {
+	dir := t.TempDir()
+	vols := []string{dir + "/v1", dir + "/v2", dir + "/v3", dir + "/spare"}
+	s, err := OpenURI("erasure://?data=2&parity=1&block=4K," + strings.Join(vols, ","))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, ok := s.(*MultiStore)
+	if !ok || len(m.stores) != 2 {
+		t.Fatalf("opened %T, want an erasure store and a TreeStore", s)
+	}
+	e, ok := m.stores[0].(*Erasure)
+	if !ok || e.k != 2 || e.m != 1 || e.blockSize != 4<<10 {
+		t.Fatalf("first member %T %+v", m.stores[0], m.stores[0])
+	}
+	if got := ErasureStores(s); len(got) != 1 || got[0] != e {
+		t.Error("ErasureStores did not find it")
+	}
+	// The backends are found too, as well as the other member.
+	if got := TreeStores(s); len(got) != 4 {
+		t.Errorf("found %d TreeStores, want 4", len(got))
+	}
+
+	for _, bad := range []string{
+		"erasure://?parity=1," + strings.Join(vols, ","),
+		"erasure://?data=2&parity=3," + strings.Join(vols, ","),
+		"erasure://?data=4," + strings.Join(vols, ","),
+		"erasure://?data=2&parity=1&block=1G," + strings.Join(vols, ","),
+		"erasure://?data=2&parity=1&block=0," + strings.Join(vols, ","),
+	} {
+		if _, err := OpenURI(bad); err == nil {
+			t.Errorf("OpenURI(%q) succeeded", bad)
+		}
+	}
+}
@@ -0,0 +1,708 @@
+package store
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha512"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Avalanche-io/c4"
+)
+
+// QuarantineDir is the directory, under the root of a file store or the
+// prefix of an S3 store, that Fsck moves damaged objects into.
+const QuarantineDir = ".quarantine"
+
+// DefaultTempAge is how old a temp file must be before Fsck treats it as
+// left behind by a crashed write rather than a write in progress.
+const DefaultTempAge = time.Hour
+
+// ProblemKind classifies a damaged object.
+type ProblemKind int
+
+const (
+	// ProblemCorrupt is content that no longer hashes to its ID.
+	ProblemCorrupt ProblemKind = iota
+	// ProblemTruncated is content shorter than it should be: empty, cut
+	// off while reading, or shorter than an intact copy in another store.
+	ProblemTruncated
+	// ProblemMisnamed is a file or key in the store whose name is not a
+	// C4 ID.
+	ProblemMisnamed
+	// ProblemOrphanTemp is a temp file left by an interrupted write.
+	ProblemOrphanTemp
+	// ProblemUnreadable is an object that could not be read at all.
+	ProblemUnreadable
+	// ProblemMisplaced is a TreeStore object outside the leaf directory
+	// its ID maps to. See TreeStore.CheckLayout.
+	ProblemMisplaced
+)
+
+var problemNames = [...]string{"corrupt", "truncated", "misnamed", "orphan-temp", "unreadable", "misplaced"}
+
+func (k ProblemKind) String() string {
+	if int(k) < len(problemNames) {
+		return problemNames[k]
+	}
+	return fmt.Sprintf("ProblemKind(%d)", int(k))
+}
+
+// Problem is a damaged object found by Fsck.
+type Problem struct {
+	Kind   ProblemKind
+	Path   string // file path, or object key for S3
+	ID     c4.ID  // the ID the object is stored under (nil if misnamed or temp)
+	Actual c4.ID  // the ID of the content as read (nil if unreadable)
+	Size   int64  // bytes read
+	Err    error  // read error, or the error from a failed repair
+
+	Quarantined bool // moved to the quarantine directory
+	Removed     bool // deleted
+	Restored    bool // replaced with a verified copy, or refiled under Actual
+}
+
+func (p Problem) String() string {
+	name := p.Path
+	if !p.ID.IsNil() {
+		name = p.ID.String()
+	}
+	s := p.Kind.String() + " " + name
+	switch {
+	case p.Restored && p.Kind == ProblemMisnamed:
+		s += " (refiled as " + p.Actual.String() + ")"
+	case p.Restored && p.Kind == ProblemMisplaced:
+		s += " (relocated)"
+	case p.Restored:
+		s += " (restored)"
+	case p.Quarantined:
+		s += " (quarantined)"
+	case p.Removed:
+		s += " (removed)"
+	}
+	if p.Err != nil {
+		s += ": " + p.Err.Error()
+	}
+	return s
+}
+
+// Fixed reports whether the problem no longer affects the store.
+func (p Problem) Fixed() bool {
+	return p.Restored || ((p.Quarantined || p.Removed) && p.ID.IsNil())
+}
+
+// FsckOptions configures Fsck.
+type FsckOptions struct {
+	// Workers is the number of objects hashed in parallel. The default
+	// is the number of CPUs.
+	Workers int
+
+	// Quarantine moves damaged objects and orphan temp files into
+	// QuarantineDir instead of leaving them in place.
+	Quarantine bool
+
+	// Repair replaces corrupt and truncated objects with verified copies
+	// from other members of a MultiStore, files misnamed content under its
+	// real ID, relocates misplaced TreeStore objects, and removes orphan
+	// temp files. Objects replaced or refiled are quarantined if
+	// Quarantine is set, and removed otherwise.
+	Repair bool
+
+	// TempAge is how old a temp file must be to count as an orphan.
+	// The default is DefaultTempAge.
+	TempAge time.Duration
+
+	// OnProblem, if set, is called for each problem as it is found and
+	// handled. Calls are serialized.
+	OnProblem func(Problem)
+}
+
+// FsckReport summarizes a store check.
+type FsckReport struct {
+	Checked  int   // objects hashed
+	Bytes    int64 // bytes hashed
+	Problems []Problem
+}
+
+// Fsck re-hashes every object in s and reports damaged ones. It supports
+// TreeStore, Folder, ShardedFolder and S3Store directly, any other store
+// that implements Lister (for corruption only), and MultiStore and Cache,
+// whose members are checked in turn and repaired from each other. Compressing
+// and Encrypting wrappers are looked through: the objects underneath are
+// checked against their decoded content, and repairs are written back
+// through the wrappers. Chunked objects are checked by reassembling them.
+func Fsck(s Store, opts FsckOptions) (*FsckReport, error) {
+	return FsckContext(context.Background(), s, opts)
+}
+
+// FsckContext is Fsck with a context. Cancelling it stops the check; the
+// report covers the objects checked so far, and the context's error is
+// returned.
+func FsckContext(ctx context.Context, s Store, opts FsckOptions) (*FsckReport, error) {
+	s, enc := peelEncoding(s)
+	if opts.Workers <= 0 {
+		opts.Workers = runtime.NumCPU()
+	}
+	if opts.TempAge <= 0 {
+		opts.TempAge = DefaultTempAge
+	}
+	rep := &FsckReport{}
+
+	var members []Store
+	switch m := s.(type) {
+	case *MultiStore:
+		members = m.stores
+	case *Cache:
+		members = []Store{m.fast, m.slow}
+	}
+	if members != nil {
+		for i, member := range members {
+			var others []Store
+			others = append(others, members[:i]...)
+			others = append(others, members[i+1:]...)
+			if err := fsck(ctx, member, others, enc, opts, rep); err != nil {
+				return rep, err
+			}
+		}
+		return rep, nil
+	}
+	return rep, fsck(ctx, s, nil, enc, opts, rep)
+}
+
+func fsck(ctx context.Context, s Store, sources []Store, enc encoding, opts FsckOptions, rep *FsckReport) error {
+	target := scrubberFor(s)
+	if target == nil {
+		if _, ok := s.(Lister); !ok {
+			return fmt.Errorf("fsck: %T: %w", s, ErrNotImplemented)
+		}
+		target = listScrubber{s}
+	}
+	c := &checker{ctx: ctx, s: s, target: target, sources: sources, enc: enc, opts: opts, rep: rep}
+
+	if ts, ok := s.(*TreeStore); ok {
+		misplaced, err := ts.CheckLayout(opts.Repair)
+		for _, m := range misplaced {
+			c.report(Problem{Kind: ProblemMisplaced, Path: m.Path, ID: m.ID, Restored: opts.Repair && err == nil}, 0)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	items := make(chan scrubItem)
+	var wg sync.WaitGroup
+	for i := 0; i < opts.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for it := range items {
+				c.check(it)
+			}
+		}()
+	}
+	cutoff := time.Now().Add(-opts.TempAge)
+	err := target.scrub(func(it scrubItem) bool {
+		if it.temp && it.modTime.After(cutoff) {
+			return true // a write in progress
+		}
+		select {
+		case items <- it:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	})
+	close(items)
+	wg.Wait()
+	if err == nil {
+		err = ctx.Err()
+	}
+	return err
+}
+
+// scrubItem is one file or key in a store being checked.
+type scrubItem struct {
+	path    string
+	name    string // base name
+	id      c4.ID  // parsed from name; nil if the name is not a C4 ID
+	size    int64
+	modTime time.Time
+	temp    bool
+}
+
+// scrubber gives Fsck raw access to the objects of a store, including
+// those the store itself would not report.
+type scrubber interface {
+	scrub(fn func(scrubItem) bool) error
+	open(it scrubItem) (io.ReadCloser, error)
+	remove(it scrubItem) error
+	quarantine(it scrubItem) error
+}
+
+func scrubberFor(s Store) scrubber {
+	switch st := s.(type) {
+	case Folder:
+		return fileScrubber{root: string(st), depth: 0}
+	case ShardedFolder:
+		return fileScrubber{root: string(st), depth: 1}
+	case *TreeStore:
+		return fileScrubber{root: st.root, depth: -1}
+	case *S3Store:
+		return s3Scrubber{st}
+	}
+	return nil
+}
+
+type checker struct {
+	ctx     context.Context
+	s       Store
+	target  scrubber
+	sources []Store
+	enc     encoding
+	opts    FsckOptions
+	rep     *FsckReport
+	mu      sync.Mutex
+}
+
+func (c *checker) check(it scrubItem) {
+	if it.temp {
+		p := Problem{Kind: ProblemOrphanTemp, Path: it.path, Size: it.size}
+		c.dispose(it, &p)
+		c.report(p, 0)
+		return
+	}
+
+	actual, n, err := c.hash(it)
+	if c.ctx.Err() != nil {
+		// Cut short, not damaged.
+		return
+	}
+	p := Problem{Path: it.path, ID: it.id, Actual: actual, Size: n}
+	switch {
+	case err != nil && err != io.ErrUnexpectedEOF:
+		p.Kind, p.Err, p.Actual = ProblemUnreadable, err, c4.ID{}
+	case it.id.IsNil():
+		p.Kind = ProblemMisnamed
+	case actual == it.id && err == nil:
+		c.report(Problem{}, n)
+		return
+	case err == io.ErrUnexpectedEOF || n < it.size || (n == 0 && it.id != emptyID):
+		p.Kind = ProblemTruncated
+	default:
+		p.Kind = ProblemCorrupt
+	}
+	if _, ok := c.target.(fileScrubber); ok && p.Kind != ProblemMisnamed {
+		if info, err := os.Lstat(it.path); err == nil && linkCount(info) > 1 {
+			// The object was ingested by hardlink, and the file changed.
+			p.Err = ErrLinkModified
+		}
+	}
+
+	if c.opts.Repair {
+		c.repair(it, &p)
+	}
+	if !p.Restored && !p.Quarantined && !p.Removed && c.opts.Quarantine {
+		if err := c.target.quarantine(it); err != nil {
+			p.Err = err
+		} else {
+			p.Quarantined = true
+		}
+	}
+	c.report(p, n)
+}
+
+// repair restores p from another store, or refiles misnamed content.
+func (c *checker) repair(it scrubItem, p *Problem) {
+	switch p.Kind {
+	case ProblemMisnamed:
+		rc, err := c.target.open(it)
+		if err != nil {
+			p.Err = err
+			return
+		}
+		var r io.Reader
+		if r, err = c.enc.decode(c.s, c4.ID{}, rc, false); err == nil {
+			_, err = c.enc.wrap(c.s).Put(r)
+		}
+		rc.Close()
+		if err != nil {
+			p.Err = err
+			return
+		}
+	case ProblemCorrupt, ProblemTruncated, ProblemUnreadable:
+		tmp, size, err := c.fetchCopy(it.id)
+		if err != nil {
+			p.Err = err
+			return
+		}
+		defer os.Remove(tmp)
+		if size > p.Size && p.Kind == ProblemCorrupt {
+			p.Kind = ProblemTruncated
+		}
+		if err := c.dispose(it, p); err != nil {
+			return
+		}
+		f, err := os.Open(tmp)
+		if err != nil {
+			p.Err = err
+			return
+		}
+		_, err = c.enc.wrap(c.s).Put(f)
+		f.Close()
+		if err != nil {
+			p.Err = err
+			return
+		}
+	default:
+		return
+	}
+	if p.Kind == ProblemMisnamed {
+		if err := c.dispose(it, p); err != nil {
+			return
+		}
+	}
+	p.Restored = true
+}
+
+// dispose gets a damaged object out of the way: quarantined if requested,
+// otherwise removed. Orphan temps are only touched when quarantining or
+// repairing.
+func (c *checker) dispose(it scrubItem, p *Problem) error {
+	var err error
+	switch {
+	case c.opts.Quarantine:
+		if err = c.target.quarantine(it); err == nil {
+			p.Quarantined = true
+		}
+	case c.opts.Repair:
+		if err = c.target.remove(it); err == nil {
+			p.Removed = true
+		}
+	}
+	if err != nil {
+		p.Err = err
+	}
+	return err
+}
+
+// fetchCopy copies id from the first source holding an intact copy into a
+// temp file.
+func (c *checker) fetchCopy(id c4.ID) (string, int64, error) {
+	for _, src := range c.sources {
+		if ok, _ := WithContext(src).HasContext(c.ctx, id); !ok {
+			continue
+		}
+		for _, sniff := range c.enc.sniffs() {
+			if tmp, n, ok := c.copyFrom(src, id, sniff); ok {
+				return tmp, n, nil
+			}
+		}
+	}
+	return "", 0, fmt.Errorf("no intact copy in another store")
+}
+
+// copyFrom copies id from src into a temp file, if what it holds is intact.
+func (c *checker) copyFrom(src Store, id c4.ID, sniff bool) (string, int64, bool) {
+	rc, err := WithContext(src).OpenContext(c.ctx, id)
+	if err != nil {
+		return "", 0, false
+	}
+	defer rc.Close()
+	tmp, err := os.CreateTemp("", "c4fsck.*")
+	if err != nil {
+		return "", 0, false
+	}
+	h := sha512.New()
+	var n int64
+	r, err := c.enc.decode(src, id, rc, sniff)
+	if err == nil {
+		n, err = io.Copy(io.MultiWriter(tmp, h), r)
+	}
+	tmp.Close()
+	var got c4.ID
+	copy(got[:], h.Sum(nil))
+	if err == nil && got == id {
+		return tmp.Name(), n, true
+	}
+	os.Remove(tmp.Name())
+	return "", 0, false
+}
+
+// hash reads an object and returns the ID of its content and its stored
+// length. io.ErrUnexpectedEOF is returned if fewer bytes than the listed
+// size could be read. Objects are decoded first; content that fails to
+// decode hashes to whatever was decoded before the failure. Without
+// wrappers, content that matches its ID as it is stored is intact whatever
+// its header, so that raw content which happens to look compressed is not
+// taken for damaged.
+func (c *checker) hash(it scrubItem) (c4.ID, int64, error) {
+	var id c4.ID
+	rc, err := c.target.open(it)
+	if err != nil {
+		return id, 0, err
+	}
+	defer rc.Close()
+	cr := &countingReader{r: &ctxReader{c.ctx, rc}}
+	in := io.Reader(cr)
+	raw := sha512.New()
+	if len(c.enc.layers) == 0 {
+		in = io.TeeReader(cr, raw)
+	}
+	h := sha512.New()
+	r, err := c.enc.decode(c.s, it.id, in, true)
+	if err == nil {
+		_, err = io.Copy(h, r)
+	}
+	copy(id[:], h.Sum(nil))
+	if len(c.enc.layers) == 0 && cr.err == nil {
+		if _, rerr := io.Copy(raw, cr); rerr == nil {
+			var rawID c4.ID
+			copy(rawID[:], raw.Sum(nil))
+			if rawID == it.id {
+				return rawID, cr.n, nil
+			}
+		}
+	}
+	if err != nil && err != io.ErrUnexpectedEOF && cr.err == nil {
+		// A damaged compressed stream, not a failed read.
+		err = nil
+	}
+	if err == nil && cr.n < it.size {
+		err = io.ErrUnexpectedEOF
+	}
+	return id, cr.n, err
+}
+
+func (c *checker) report(p Problem, n int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if p.Kind != ProblemOrphanTemp && p.Kind != ProblemMisplaced {
+		c.rep.Checked++
+		c.rep.Bytes += n
+	}
+	if p.Path == "" {
+		return
+	}
+	c.rep.Problems = append(c.rep.Problems, p)
+	if c.opts.OnProblem != nil {
+		c.opts.OnProblem(p)
+	}
+}
+
+// emptyID is the C4 ID of empty content.
+var emptyID = c4.Identify(strings.NewReader(""))
+
+// fileScrubber walks a store directory. depth is the number of levels of
+// 2-character subdirectories to descend, or -1 for any number.
+type fileScrubber struct {
+	root  string
+	depth int
+}
+
+func (f fileScrubber) scrub(fn func(scrubItem) bool) error {
+	_, err := f.walk(f.root, f.depth, fn)
+	return err
+}
+
+func (f fileScrubber) walk(dir string, depth int, fn func(scrubItem) bool) (bool, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return true, nil
+		}
+		return false, err
+	}
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() {
+			if depth == 0 || len(name) != 2 {
+				continue
+			}
+			more, err := f.walk(filepath.Join(dir, name), depth-1, fn)
+			if err != nil || !more {
+				return more, err
+			}
+			continue
+		}
+		if name == treeLockName {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		it := scrubItem{
+			path:    filepath.Join(dir, name),
+			name:    name,
+			size:    info.Size(),
+			modTime: info.ModTime(),
+			temp:    isTemp(name),
+		}
+		if !it.temp {
+			it.id, _ = c4.Parse(name)
+		}
+		if !fn(it) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+func (f fileScrubber) open(it scrubItem) (io.ReadCloser, error) {
+	return os.Open(it.path)
+}
+
+func (f fileScrubber) remove(it scrubItem) error {
+	return os.Remove(it.path)
+}
+
+func (f fileScrubber) quarantine(it scrubItem) error {
+	dir := filepath.Join(f.root, QuarantineDir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	dst := filepath.Join(dir, it.name)
+	if _, err := os.Stat(dst); err == nil {
+		dst += fmt.Sprintf(".%d", time.Now().UnixNano())
+	}
+	return os.Rename(it.path, dst)
+}
+
+// s3Scrubber checks the keys under an S3Store's prefix. Keys in the
+// quarantine area are skipped.
+type s3Scrubber struct {
+	s *S3Store
+}
+
+func (s s3Scrubber) scrub(fn func(scrubItem) bool) error {
+	qprefix := s.s.prefix + QuarantineDir + "/"
+	return s.s.listKeys(func(key string, size int64, mod time.Time) bool {
+		if strings.HasPrefix(key, qprefix) {
+			return true
+		}
+		name := strings.TrimPrefix(key, s.s.prefix)
+		it := scrubItem{path: key, name: name, size: size, modTime: mod}
+		it.id, _ = c4.Parse(name)
+		return fn(it)
+	})
+}
+
+func (s s3Scrubber) open(it scrubItem) (io.ReadCloser, error) {
+	return s.s.openKey(context.Background(), it.path, it.name)
+}
+
+func (s s3Scrubber) remove(it scrubItem) error {
+	return s.s.deleteKey(context.Background(), it.path, it.name)
+}
+
+func (s s3Scrubber) quarantine(it scrubItem) error {
+	dst := s.s.prefix + QuarantineDir + "/" + strings.ReplaceAll(it.name, "/", "_")
+	if err := s.s.copyObject(context.Background(), s.s.bucket, it.path, dst, it.size); err != nil {
+		return err
+	}
+	return s.s.deleteKey(context.Background(), it.path, it.name)
+}
+
+// listScrubber checks any Lister through the Store interface. It cannot
+// see misnamed objects or temp files, and cannot quarantine.
+type listScrubber struct {
+	s Store
+}
+
+func (l listScrubber) scrub(fn func(scrubItem) bool) error {
+	return List(l.s, func(o Object) bool {
+		return fn(scrubItem{path: o.ID.String(), name: o.ID.String(), id: o.ID, size: o.Size, modTime: o.ModTime})
+	})
+}
+
+func (l listScrubber) open(it scrubItem) (io.ReadCloser, error) {
+	return l.s.Open(it.id)
+}
+
+func (l listScrubber) remove(it scrubItem) error {
+	return l.s.Remove(it.id)
+}
+
+func (l listScrubber) quarantine(it scrubItem) error {
+	return ErrNotImplemented
+}
+
+// encoding undoes what wrapper stores do to content on its way into the
+// store underneath, so that Fsck can check the objects there.
+type encoding struct {
+	layers []Store // Compressing and Encrypting wrappers, outermost first
+}
+
+// peelEncoding strips the Compressing and Encrypting wrappers from s.
+func peelEncoding(s Store) (Store, encoding) {
+	var enc encoding
+	for {
+		switch w := s.(type) {
+		case *Compressing:
+			enc.layers = append(enc.layers, w)
+			s = w.s
+		case *Encrypting:
+			enc.layers = append(enc.layers, w)
+			s = w.s
+		case *Chunking:
+			// Recipes are recognized whether or not the store is wrapped.
+			s = w.s
+		default:
+			return s, enc
+		}
+	}
+}
+
+// decode returns the content of the object id read from r, as stored in
+// src. Chunked objects are reassembled from src. With no wrappers, content
+// is returned as it is stored, unless sniff is set: then objects that a
+// Compressing store wrote are recognized by their header and decoded.
+func (e encoding) decode(src Store, id c4.ID, r io.Reader, sniff bool) (io.Reader, error) {
+	var err error
+	if len(e.layers) == 0 && sniff {
+		r, err = decodeObject(r)
+	}
+	for i := len(e.layers) - 1; i >= 0 && err == nil; i-- {
+		switch w := e.layers[i].(type) {
+		case *Compressing:
+			r, err = decodeObject(r)
+		case *Encrypting:
+			r, err = w.decrypt(id, r)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return reassemble(e.wrap(src), bufio.NewReader(r))
+}
+
+// sniffs returns the values of sniff to read objects with: without
+// wrappers, content is first taken as it is and only then as possibly
+// compressed.
+func (e encoding) sniffs() []bool {
+	if len(e.layers) == 0 {
+		return []bool{false, true}
+	}
+	return []bool{false}
+}
+
+// wrap rebuilds the wrappers around s, to store repaired content.
+func (e encoding) wrap(s Store) Store {
+	for i := len(e.layers) - 1; i >= 0; i-- {
+		switch w := e.layers[i].(type) {
+		case *Compressing:
+			s = &Compressing{s, w.level}
+		case *Encrypting:
+			layer := *w
+			layer.s = s
+			s = &layer
+		}
+	}
+	return s
+}
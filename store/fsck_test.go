@@ -0,0 +1,179 @@
+package store
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Avalanche-io/c4"
+)
+
+// damage puts four objects in a file store and damages three of them,
+// returning the IDs of the corrupt, truncated and intact objects.
+func damage(t *testing.T, s Store, path func(c4.ID) string) (corrupt, truncated, intact c4.ID) {
+	t.Helper()
+	corrupt = putString(t, s, "rotting bits")
+	truncated = putString(t, s, "cut short")
+	intact = putString(t, s, "all good")
+	os.WriteFile(path(corrupt), []byte("rotting bitz"), 0644)
+	os.WriteFile(path(truncated), nil, 0644)
+	return
+}
+
+func putString(t *testing.T, s Store, data string) c4.ID {
+	t.Helper()
+	id, err := s.Put(strings.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+func problemKinds(rep *FsckReport) map[ProblemKind]int {
+	kinds := make(map[ProblemKind]int)
+	for _, p := range rep.Problems {
+		kinds[p.Kind]++
+	}
+	return kinds
+}
+
+func TestFsckTreeStore(t *testing.T) {
+	root := t.TempDir()
+	s, err := NewTreeStore(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	corrupt, truncated, intact := damage(t, s, s.path)
+
+	// A misnamed file and an old and a fresh temp file.
+	misnamed := filepath.Join(filepath.Dir(s.path(intact)), "copy-of-something")
+	os.WriteFile(misnamed, []byte("stray content"), 0644)
+	oldTemp := filepath.Join(root, ".ingest.old")
+	os.WriteFile(oldTemp, []byte("partial"), 0644)
+	old := time.Now().Add(-2 * time.Hour)
+	os.Chtimes(oldTemp, old, old)
+	os.WriteFile(filepath.Join(root, ".ingest.new"), []byte("in progress"), 0644)
+
+	rep, err := Fsck(s, FsckOptions{Workers: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	kinds := problemKinds(rep)
+	want := map[ProblemKind]int{ProblemCorrupt: 1, ProblemTruncated: 1, ProblemMisnamed: 1, ProblemOrphanTemp: 1}
+	for k, n := range want {
+		if kinds[k] != n {
+			t.Errorf("%s: got %d, want %d (%v)", k, kinds[k], n, rep.Problems)
+		}
+	}
+	if rep.Checked != 4 {
+		t.Errorf("checked %d objects, want 4", rep.Checked)
+	}
+	for _, p := range rep.Problems {
+		if p.Kind == ProblemCorrupt && p.ID != corrupt || p.Kind == ProblemTruncated && p.ID != truncated {
+			t.Errorf("wrong ID for %s", p)
+		}
+	}
+
+	// Quarantine with repair: damaged objects move aside, misnamed
+	// content is refiled, the orphan temp goes.
+	rep, err = Fsck(s, FsckOptions{Quarantine: true, Repair: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Has(corrupt) || s.Has(truncated) || !s.Has(intact) {
+		t.Error("damaged objects should be quarantined")
+	}
+	if !s.Has(c4.Identify(strings.NewReader("stray content"))) {
+		t.Error("misnamed content not refiled")
+	}
+	q, _ := os.ReadDir(filepath.Join(root, QuarantineDir))
+	if len(q) != 4 {
+		t.Errorf("quarantine holds %d files, want 4", len(q))
+	}
+	if rep, _ := Fsck(s, FsckOptions{}); len(rep.Problems) != 0 {
+		t.Errorf("problems remain after repair: %v", rep.Problems)
+	}
+}
+
+func TestFsckMultiStoreRestore(t *testing.T) {
+	primary := Folder(t.TempDir())
+	archive := ShardedFolder(t.TempDir())
+	corrupt, truncated, _ := damage(t, primary, func(id c4.ID) string {
+		return filepath.Join(string(primary), id.String())
+	})
+	// The archive has a good copy of the corrupt object only.
+	putString(t, archive, "rotting bits")
+
+	var seen []Problem
+	rep, err := Fsck(NewMultiStore(primary, archive), FsckOptions{
+		Repair:    true,
+		OnProblem: func(p Problem) { seen = append(seen, p) },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(seen) != len(rep.Problems) || len(seen) != 2 {
+		t.Fatalf("problems: %v", seen)
+	}
+	for _, p := range seen {
+		switch p.ID {
+		case corrupt:
+			if !p.Restored || !p.Fixed() {
+				t.Errorf("corrupt object not restored: %s", p)
+			}
+		case truncated:
+			if p.Restored || p.Err == nil {
+				t.Errorf("truncated object has no source and should fail: %s", p)
+			}
+		}
+	}
+	rc, err := primary.Open(corrupt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+	if got := c4.Identify(rc); got != corrupt {
+		t.Error("restored content does not match its ID")
+	}
+}
+
+func TestFsckS3(t *testing.T) {
+	f := newFakeS3(t, "bucket")
+	s := f.store("c4/")
+	good := putString(t, s, "good")
+	bad := putString(t, s, "bad")
+	f.put("c4/"+bad.String(), []byte("bae"))
+	f.put("c4/notes.txt", []byte("hello"))
+
+	rep, err := Fsck(s, FsckOptions{Quarantine: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	kinds := problemKinds(rep)
+	if kinds[ProblemCorrupt] != 1 || kinds[ProblemMisnamed] != 1 || len(rep.Problems) != 2 {
+		t.Fatalf("problems: %v", rep.Problems)
+	}
+	if _, ok := f.get("c4/" + QuarantineDir + "/" + bad.String()); !ok {
+		t.Error("corrupt object not quarantined")
+	}
+	if s.Has(bad) || !s.Has(good) {
+		t.Error("quarantine moved the wrong objects")
+	}
+	if rep, _ := Fsck(s, FsckOptions{}); len(rep.Problems) != 0 {
+		t.Errorf("quarantined objects rechecked: %v", rep.Problems)
+	}
+}
+
+func TestFsckUnsupported(t *testing.T) {
+	if _, err := Fsck(nonListing{NewRAM()}, FsckOptions{}); err == nil {
+		t.Fatal("expected error for a store that cannot list")
+	}
+	ram := NewRAM()
+	putString(t, ram, "fine")
+	rep, err := Fsck(ram, FsckOptions{})
+	if err != nil || rep.Checked != 1 || len(rep.Problems) != 0 {
+		t.Fatalf("RAM fsck: %+v, %v", rep, err)
+	}
+}
@@ -0,0 +1,262 @@
+package store
+
+import (
+	"bufio"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Avalanche-io/c4"
+)
+
+// HTTPHandler serves a Store over HTTP, for HTTPStore clients. Objects
+// are at /c4/<id>:
+//
+//	GET    /c4/<id>  content, with Range support if the store's readers seek
+//	HEAD   /c4/<id>  200 if present, 404 if not
+//	PUT    /c4/<id>  store content, rejected unless it matches the ID
+//	DELETE /c4/<id>  remove content
+//	GET    /c4/      "<id> <size> <modified>" for each object, if the store lists
+//	POST   /c4/      store content, answering with its ID
+//	POST   /c4/has   a list of IDs, one per line, answered with those present
+//
+// The ETag of an object is its ID.
+type HTTPHandler struct {
+	s        Store
+	readOnly bool
+	token    string
+}
+
+// maxHasBatch is the most IDs a has request may ask about.
+var maxHasBatch = 10000
+
+// NewHTTPHandler returns a handler serving s, writable and without
+// authentication.
+func NewHTTPHandler(s Store) *HTTPHandler {
+	return &HTTPHandler{s: s}
+}
+
+// SetReadOnly sets whether requests that would change the store are
+// refused.
+func (h *HTTPHandler) SetReadOnly(readOnly bool) {
+	h.readOnly = readOnly
+}
+
+// SetToken sets the bearer token every request must carry. An empty token
+// turns authentication off.
+func (h *HTTPHandler) SetToken(token string) {
+	h.token = token
+}
+
+func (h *HTTPHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if h.token != "" {
+		got := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(got), []byte(h.token)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+	}
+
+	name := strings.TrimPrefix(r.URL.Path, "/c4/")
+	if name == r.URL.Path {
+		http.NotFound(w, r)
+		return
+	}
+	writes := r.Method == "PUT" || r.Method == "DELETE" || r.Method == "POST" && name == ""
+	if writes && h.readOnly {
+		http.Error(w, "store is read-only", http.StatusForbidden)
+		return
+	}
+
+	switch {
+	case name == "" && r.Method == "GET":
+		h.list(w)
+	case name == "" && r.Method == "POST":
+		h.put(w, r)
+	case name == "has" && r.Method == "POST":
+		h.has(w, r)
+	default:
+		id, err := c4.Parse(name)
+		if err != nil {
+			http.Error(w, "not a C4 ID", http.StatusBadRequest)
+			return
+		}
+		switch r.Method {
+		case "GET", "HEAD":
+			h.get(w, r, id)
+		case "PUT":
+			h.create(w, r, id)
+		case "DELETE":
+			h.remove(w, id)
+		default:
+			w.Header().Set("Allow", "GET, HEAD, PUT, DELETE")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+// get serves the content of id. Readers that seek get Range and
+// conditional request support from http.ServeContent; others are sent
+// whole.
+func (h *HTTPHandler) get(w http.ResponseWriter, r *http.Request, id c4.ID) {
+	rc, err := h.s.Open(id)
+	if err != nil {
+		httpError(w, err)
+		return
+	}
+	defer rc.Close()
+
+	etag := `"` + id.String() + `"`
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Content-Type", "application/octet-stream")
+	if rs, ok := rc.(io.ReadSeeker); ok {
+		http.ServeContent(w, r, "", time.Time{}, rs)
+		return
+	}
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	if r.Method == "GET" {
+		io.Copy(w, rc)
+	}
+}
+
+// create stores the request body as id. Content already present is not
+// read again.
+func (h *HTTPHandler) create(w http.ResponseWriter, r *http.Request, id c4.ID) {
+	if h.s.Has(id) {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	// The body is checked before it is stored, so a mismatched or cut
+	// short upload never appears under id.
+	wc, err := NewValidating(h.s).create(r.Context(), id)
+	if errors.Is(err, os.ErrExist) {
+		// Stored since Has was asked.
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if err != nil {
+		httpError(w, err)
+		return
+	}
+	if _, err := io.Copy(wc, r.Body); err != nil {
+		wc.Abort()
+		httpError(w, err)
+		return
+	}
+	if err := wc.Close(); err != nil {
+		httpError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+}
+
+// put stores the request body and answers with its ID.
+func (h *HTTPHandler) put(w http.ResponseWriter, r *http.Request) {
+	id, err := h.s.Put(r.Body)
+	if err != nil {
+		httpError(w, err)
+		return
+	}
+	w.Header().Set("Location", "/c4/"+id.String())
+	w.WriteHeader(http.StatusCreated)
+	fmt.Fprintln(w, id)
+}
+
+func (h *HTTPHandler) remove(w http.ResponseWriter, id c4.ID) {
+	if err := h.s.Remove(id); err != nil {
+		httpError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// list sends a line for each object, with "-" for an unknown
+// modification time.
+func (h *HTTPHandler) list(w http.ResponseWriter) {
+	if _, ok := h.s.(Lister); !ok {
+		httpError(w, ErrNotImplemented)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain")
+	bw := bufio.NewWriter(w)
+	err := List(h.s, func(o Object) bool {
+		mod := "-"
+		if !o.ModTime.IsZero() {
+			mod = o.ModTime.UTC().Format(time.RFC3339Nano)
+		}
+		_, err := fmt.Fprintf(bw, "%s %d %s\n", o.ID, o.Size, mod)
+		return err == nil
+	})
+	if err != nil {
+		// Too late for an error status; a line the client cannot parse
+		// tells it the listing is incomplete.
+		fmt.Fprintln(bw, "error")
+	}
+	bw.Flush()
+}
+
+// has answers which of the IDs in the request body the store has.
+func (h *HTTPHandler) has(w http.ResponseWriter, r *http.Request) {
+	var ids []c4.ID
+	sc := bufio.NewScanner(r.Body)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" {
+			continue
+		}
+		id, err := c4.Parse(line)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("not a C4 ID: %q", line), http.StatusBadRequest)
+			return
+		}
+		if ids = append(ids, id); len(ids) > maxHasBatch {
+			http.Error(w, fmt.Sprintf("more than %d IDs", maxHasBatch), http.StatusRequestEntityTooLarge)
+			return
+		}
+	}
+	if err := sc.Err(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	found, err := WithContext(h.s).HasMany(r.Context(), ids)
+	if err != nil {
+		httpError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain")
+	bw := bufio.NewWriter(w)
+	for i, id := range ids {
+		if found[i] {
+			fmt.Fprintln(bw, id)
+		}
+	}
+	bw.Flush()
+}
+
+// httpError reports a store error with the matching status. The error
+// itself is not sent, as it may name paths on the server.
+func httpError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		status = http.StatusNotFound
+	case errors.Is(err, ErrInvalidID):
+		http.Error(w, ErrInvalidID.Error(), http.StatusBadRequest)
+		return
+	case errors.Is(err, os.ErrPermission):
+		status = http.StatusForbidden
+	case errors.Is(err, ErrNotImplemented):
+		status = http.StatusNotImplemented
+	}
+	http.Error(w, http.StatusText(status), status)
+}
@@ -0,0 +1,382 @@
+package store
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Avalanche-io/c4"
+)
+
+// httpTimeout bounds how long HTTPStore waits for a server to start
+// answering a request it has sent in full. Uploads are exempt: the server
+// answers them only once its store has committed the content, which for a
+// large object can take much longer.
+var httpTimeout = time.Minute
+
+// HTTPStore is a Store served over HTTP by an HTTPHandler, as run by
+// c4 serve.
+type HTTPStore struct {
+	client *http.Client
+	upload *http.Client // for Create and Put, without httpTimeout
+	base   string       // URL of the /c4/ directory, with the trailing slash
+	token  string
+}
+
+// NewHTTPStore returns a store for the server at url, such as
+// "http://host:7474". A non-empty token is sent as a bearer token.
+func NewHTTPStore(url, token string) *HTTPStore {
+	return &HTTPStore{
+		client: &http.Client{
+			Transport: &http.Transport{
+				Proxy:                 http.ProxyFromEnvironment,
+				MaxIdleConnsPerHost:   maxConcurrentRequests,
+				ResponseHeaderTimeout: httpTimeout,
+			},
+		},
+		upload: &http.Client{
+			Transport: &http.Transport{
+				Proxy:               http.ProxyFromEnvironment,
+				MaxIdleConnsPerHost: maxConcurrentRequests,
+			},
+		},
+		base:  strings.TrimSuffix(url, "/") + "/c4/",
+		token: token,
+	}
+}
+
+// request returns a request for path under the store's URL.
+func (s *HTTPStore) request(ctx context.Context, method, path string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, s.base+path, body)
+	if err != nil {
+		return nil, err
+	}
+	if s.token != "" {
+		req.Header.Set("Authorization", "Bearer "+s.token)
+	}
+	return req, nil
+}
+
+// do sends req, and turns a response with an error status into an error
+// for id, or for the store if id is nil.
+func (s *HTTPStore) do(req *http.Request, op string, id c4.ID) (*http.Response, error) {
+	return s.send(s.client, req, op, id)
+}
+
+// send is do with the client to send req with.
+func (s *HTTPStore) send(client *http.Client, req *http.Request, op string, id c4.ID) (*http.Response, error) {
+	resp, err := client.Do(req)
+	if err != nil {
+		if ctxErr := req.Context().Err(); ctxErr != nil {
+			return nil, ctxErr
+		}
+		return nil, fmt.Errorf("http %s: %w", op, err)
+	}
+	if resp.StatusCode < 300 {
+		return resp, nil
+	}
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	resp.Body.Close()
+	name := s.base
+	if !id.IsNil() {
+		name = id.String()
+	}
+	switch resp.StatusCode {
+	case http.StatusNotFound:
+		return nil, &os.PathError{Op: op, Path: name, Err: os.ErrNotExist}
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return nil, &os.PathError{Op: op, Path: name, Err: os.ErrPermission}
+	case http.StatusNotImplemented:
+		return nil, ErrNotImplemented
+	case http.StatusBadRequest:
+		if strings.TrimSpace(string(msg)) == ErrInvalidID.Error() {
+			return nil, ErrInvalidID
+		}
+	}
+	return nil, fmt.Errorf("http %s %s: %s: %s", op, name, resp.Status, strings.TrimSpace(string(msg)))
+}
+
+// Has reports whether the server has content for id.
+func (s *HTTPStore) Has(id c4.ID) bool {
+	ok, _ := s.HasContext(context.Background(), id)
+	return ok
+}
+
+// HasContext sends a HEAD request for id. Unlike Has, it returns an error
+// when the server cannot answer.
+func (s *HTTPStore) HasContext(ctx context.Context, id c4.ID) (bool, error) {
+	req, err := s.request(ctx, "HEAD", id.String(), nil)
+	if err != nil {
+		return false, err
+	}
+	resp, err := s.do(req, "has", id)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	resp.Body.Close()
+	return true, nil
+}
+
+// HasMany asks the server about the IDs in batches.
+func (s *HTTPStore) HasMany(ctx context.Context, ids []c4.ID) ([]bool, error) {
+	found := make([]bool, len(ids))
+	for start := 0; start < len(ids); start += maxHasBatch {
+		end := start + maxHasBatch
+		if end > len(ids) {
+			end = len(ids)
+		}
+		var body bytes.Buffer
+		for _, id := range ids[start:end] {
+			fmt.Fprintln(&body, id)
+		}
+		req, err := s.request(ctx, "POST", "has", &body)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "text/plain")
+		resp, err := s.do(req, "has", c4.ID{})
+		if err != nil {
+			return nil, err
+		}
+		present := make(map[c4.ID]bool)
+		sc := bufio.NewScanner(resp.Body)
+		for sc.Scan() {
+			if id, err := c4.Parse(strings.TrimSpace(sc.Text())); err == nil {
+				present[id] = true
+			}
+		}
+		err = sc.Err()
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("http has: %w", err)
+		}
+		for i := start; i < end; i++ {
+			found[i] = present[ids[i]]
+		}
+	}
+	return found, nil
+}
+
+// List lists the server's store, if it can be listed.
+func (s *HTTPStore) List(fn func(Object) bool) error {
+	req, err := s.request(context.Background(), "GET", "", nil)
+	if err != nil {
+		return err
+	}
+	resp, err := s.do(req, "list", c4.ID{})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	sc := bufio.NewScanner(resp.Body)
+	for sc.Scan() {
+		var o Object
+		fields := strings.Fields(sc.Text())
+		if len(fields) != 3 {
+			return fmt.Errorf("http list: listing ended with %q", sc.Text())
+		}
+		if o.ID, err = c4.Parse(fields[0]); err != nil {
+			return fmt.Errorf("http list: %w", err)
+		}
+		if o.Size, err = strconv.ParseInt(fields[1], 10, 64); err != nil {
+			return fmt.Errorf("http list: %w", err)
+		}
+		if fields[2] != "-" {
+			if o.ModTime, err = time.Parse(time.RFC3339Nano, fields[2]); err != nil {
+				return fmt.Errorf("http list: %w", err)
+			}
+		}
+		if !fn(o) {
+			return nil
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return fmt.Errorf("http list: %w", err)
+	}
+	return nil
+}
+
+// Open opens the content of id for reading.
+func (s *HTTPStore) Open(id c4.ID) (io.ReadCloser, error) {
+	return s.OpenContext(context.Background(), id)
+}
+
+// OpenContext opens the content of id. Cancelling ctx also aborts reading
+// the returned body.
+func (s *HTTPStore) OpenContext(ctx context.Context, id c4.ID) (io.ReadCloser, error) {
+	req, err := s.request(ctx, "GET", id.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.do(req, "open", id)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// OpenMany sends up to maxConcurrentRequests GET requests ahead of the
+// object fn is reading.
+func (s *HTTPStore) OpenMany(ctx context.Context, ids []c4.ID, fn func(id c4.ID, r io.Reader, err error) error) error {
+	return openMany(ctx, ids, maxConcurrentRequests, s.OpenContext, fn)
+}
+
+// OpenRange fetches part of the content with an HTTP Range request.
+func (s *HTTPStore) OpenRange(id c4.ID, off, n int64) (io.ReadCloser, error) {
+	if off < 0 {
+		return nil, fmt.Errorf("open range of %s: negative offset %d", id, off)
+	}
+	if n == 0 {
+		return io.NopCloser(strings.NewReader("")), nil
+	}
+	req, err := s.request(context.Background(), "GET", id.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	if n < 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", off))
+	} else {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", off, off+n-1))
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("http open: %w", err)
+	}
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		return resp.Body, nil
+	case http.StatusRequestedRangeNotSatisfiable:
+		// The range starts past the end.
+		resp.Body.Close()
+		return io.NopCloser(strings.NewReader("")), nil
+	case http.StatusOK:
+		// The range was ignored; skip to it.
+		return skipTo(resp.Body, off, n)
+	}
+	resp.Body.Close()
+	// Ask again without the range for the error.
+	return s.Open(id)
+}
+
+// Create returns a writer that streams content to the server, which
+// checks it against id. The upload finishes on Close, which reports
+// ErrInvalidID for content that does not match.
+func (s *HTTPStore) Create(id c4.ID) (io.WriteCloser, error) {
+	return s.CreateContext(context.Background(), id)
+}
+
+// CreateContext is Create with a context that governs the upload.
+func (s *HTTPStore) CreateContext(ctx context.Context, id c4.ID) (io.WriteCloser, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	pr, pw := io.Pipe()
+	req, err := s.request(ctx, "PUT", id.String(), pr)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	w := &httpWriter{pw: pw, done: make(chan error, 1)}
+	go func() {
+		resp, err := s.send(s.upload, req, "create", id)
+		if err == nil {
+			resp.Body.Close()
+		}
+		// Unblock a writer the server stopped reading, e.g. because the
+		// content is already there.
+		pr.CloseWithError(errUploadDone)
+		w.done <- err
+	}()
+	return w, nil
+}
+
+// errUploadDone ends writes to an upload the server has answered.
+var errUploadDone = fmt.Errorf("upload finished")
+
+// httpWriter streams an upload through a pipe.
+type httpWriter struct {
+	pw   *io.PipeWriter
+	done chan error
+	err  error // the upload's result, once known
+}
+
+func (w *httpWriter) Write(p []byte) (int, error) {
+	n, err := w.pw.Write(p)
+	if err == errUploadDone {
+		// Wait for the server's answer: an error, or content already
+		// present, in which case the rest is not needed.
+		w.err = <-w.done
+		w.done = nil
+		if w.err != nil {
+			return n, w.err
+		}
+		return len(p), nil
+	}
+	return n, err
+}
+
+func (w *httpWriter) Close() error {
+	w.pw.Close()
+	if w.done != nil {
+		w.err = <-w.done
+		w.done = nil
+	}
+	return w.err
+}
+
+// Put streams r to the server, which computes and returns its ID.
+func (s *HTTPStore) Put(r io.Reader) (c4.ID, error) {
+	return s.PutContext(context.Background(), r)
+}
+
+// PutContext is Put with a context that governs the upload.
+func (s *HTTPStore) PutContext(ctx context.Context, r io.Reader) (c4.ID, error) {
+	req, err := s.request(ctx, "POST", "", r)
+	if err != nil {
+		return c4.ID{}, err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	resp, err := s.send(s.upload, req, "put", c4.ID{})
+	if err != nil {
+		return c4.ID{}, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	if err != nil {
+		return c4.ID{}, fmt.Errorf("http put: %w", err)
+	}
+	id, err := c4.Parse(strings.TrimSpace(string(body)))
+	if err != nil {
+		return c4.ID{}, fmt.Errorf("http put: bad answer from server: %w", err)
+	}
+	return id, nil
+}
+
+// Remove deletes the content of id from the server.
+func (s *HTTPStore) Remove(id c4.ID) error {
+	return s.RemoveContext(context.Background(), id)
+}
+
+// RemoveContext deletes the content of id from the server.
+func (s *HTTPStore) RemoveContext(ctx context.Context, id c4.ID) error {
+	req, err := s.request(ctx, "DELETE", id.String(), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := s.do(req, "remove", id)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
@@ -0,0 +1,336 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Avalanche-io/c4"
+)
+
+// newTestHTTP serves s and returns a client for it.
+func newTestHTTP(t *testing.T, h *HTTPHandler, token string) *HTTPStore {
+	t.Helper()
+	srv := httptest.NewServer(h)
+	t.Cleanup(srv.Close)
+	return NewHTTPStore(srv.URL, token)
+}
+
+func TestHTTPStoreRoundTrip(t *testing.T) {
+	tree, err := NewTreeStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, backing := range map[string]Store{"tree": tree, "ram": NewRAM()} {
+		t.Run(name, func(t *testing.T) {
+			s := newTestHTTP(t, NewHTTPHandler(backing), "")
+
+			data := randomBytes(1, 100<<10)
+			id, err := s.Put(bytes.NewReader(data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if id != c4.Identify(bytes.NewReader(data)) {
+				t.Fatalf("Put returned %s", id)
+			}
+			if !s.Has(id) || !backing.Has(id) {
+				t.Fatal("Has is false after Put")
+			}
+			rc, err := s.Open(id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, _ := io.ReadAll(rc)
+			rc.Close()
+			if !bytes.Equal(got, data) {
+				t.Error("Open returned the wrong content")
+			}
+
+			data2 := randomBytes(2, 5000)
+			id2 := c4.Identify(bytes.NewReader(data2))
+			w, err := s.Create(id2)
+			if err != nil {
+				t.Fatal(err)
+			}
+			w.Write(data2)
+			if err := w.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if !backing.Has(id2) {
+				t.Error("Create did not store the content")
+			}
+			// Creating content already there succeeds.
+			if w, err = s.Create(id2); err == nil {
+				w.Write(data2)
+				err = w.Close()
+			}
+			if err != nil {
+				t.Errorf("second Create: %v", err)
+			}
+
+			if err := s.Remove(id2); err != nil {
+				t.Fatal(err)
+			}
+			if s.Has(id2) {
+				t.Error("Has is true after Remove")
+			}
+			if _, err := s.Open(id2); !os.IsNotExist(err) {
+				t.Errorf("Open of a removed object: %v", err)
+			}
+		})
+	}
+}
+
+func TestHTTPStoreRejectsBadContent(t *testing.T) {
+	backing := NewRAM()
+	s := newTestHTTP(t, NewHTTPHandler(backing), "")
+
+	id := testID("claimed")
+	w, err := s.Create(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte("something else"))
+	if err := w.Close(); !errors.Is(err, ErrInvalidID) {
+		t.Errorf("Close of mismatched content: %v, want ErrInvalidID", err)
+	}
+	if backing.Has(id) {
+		t.Error("mismatched content was kept")
+	}
+}
+
+// slowCommitStore takes a while to open and to store content.
+type slowCommitStore struct {
+	Store
+	delay time.Duration
+}
+
+func (s slowCommitStore) Open(id c4.ID) (io.ReadCloser, error) {
+	time.Sleep(s.delay)
+	return s.Store.Open(id)
+}
+
+func (s slowCommitStore) Put(r io.Reader) (c4.ID, error) {
+	time.Sleep(s.delay)
+	return s.Store.Put(r)
+}
+
+// Uploads wait for the server to commit them however long that takes;
+// other requests still time out.
+func TestHTTPStoreSlowCommit(t *testing.T) {
+	defer func(d time.Duration) { httpTimeout = d }(httpTimeout)
+	httpTimeout = 50 * time.Millisecond
+	s := newTestHTTP(t, NewHTTPHandler(slowCommitStore{NewRAM(), 200 * time.Millisecond}), "")
+
+	if _, err := s.Put(strings.NewReader("put")); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	id := testID("create")
+	w, err := s.Create(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte("create"))
+	if err := w.Close(); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if _, err := s.HasContext(context.Background(), id); err == nil {
+		t.Error("Has of a slow server did not time out")
+	}
+}
+
+func TestHTTPStoreRange(t *testing.T) {
+	tree, err := NewTreeStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, backing := range map[string]Store{"tree": tree, "ram": NewRAM()} {
+		t.Run(name, func(t *testing.T) {
+			s := newTestHTTP(t, NewHTTPHandler(backing), "")
+			data := randomBytes(3, 10000)
+			id, err := backing.Put(bytes.NewReader(data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, c := range []struct{ off, n int64 }{
+				{0, 10}, {9990, -1}, {5000, 100}, {9995, 100}, {20000, 10},
+			} {
+				rc, err := s.OpenRange(id, c.off, c.n)
+				if err != nil {
+					t.Fatal(err)
+				}
+				got, _ := io.ReadAll(rc)
+				rc.Close()
+				end := int64(len(data))
+				if c.n >= 0 && c.off+c.n < end {
+					end = c.off + c.n
+				}
+				var want []byte
+				if c.off < end {
+					want = data[c.off:end]
+				}
+				if !bytes.Equal(got, want) {
+					t.Errorf("range %d+%d: got %d bytes, want %d", c.off, c.n, len(got), len(want))
+				}
+			}
+		})
+	}
+}
+
+func TestHTTPHandlerETag(t *testing.T) {
+	tree, err := NewTreeStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, backing := range map[string]Store{"tree": tree, "ram": NewRAM()} {
+		t.Run(name, func(t *testing.T) {
+			srv := httptest.NewServer(NewHTTPHandler(backing))
+			defer srv.Close()
+			id, _ := backing.Put(strings.NewReader("tagged"))
+
+			resp, err := http.Get(srv.URL + "/c4/" + id.String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			etag := resp.Header.Get("ETag")
+			if etag != `"`+id.String()+`"` {
+				t.Errorf("ETag %s, want the ID", etag)
+			}
+
+			req, _ := http.NewRequest("GET", srv.URL+"/c4/"+id.String(), nil)
+			req.Header.Set("If-None-Match", etag)
+			resp, err = http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusNotModified {
+				t.Errorf("conditional GET: status %d, want 304", resp.StatusCode)
+			}
+		})
+	}
+}
+
+func TestHTTPHandlerReadOnly(t *testing.T) {
+	backing := NewRAM()
+	id, _ := backing.Put(strings.NewReader("kept"))
+	h := NewHTTPHandler(backing)
+	h.SetReadOnly(true)
+	s := newTestHTTP(t, h, "")
+
+	if !s.Has(id) {
+		t.Error("Has is false on a read-only server")
+	}
+	if _, err := s.Put(strings.NewReader("new")); !os.IsPermission(err) {
+		t.Errorf("Put: %v, want a permission error", err)
+	}
+	if err := s.Remove(id); !os.IsPermission(err) {
+		t.Errorf("Remove: %v, want a permission error", err)
+	}
+	if !backing.Has(id) {
+		t.Error("a read-only server removed content")
+	}
+}
+
+func TestHTTPHandlerToken(t *testing.T) {
+	backing := NewRAM()
+	id, _ := backing.Put(strings.NewReader("secret"))
+	h := NewHTTPHandler(backing)
+	h.SetToken("letmein")
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	for _, token := range []string{"", "wrong"} {
+		s := NewHTTPStore(srv.URL, token)
+		if _, err := s.Open(id); !os.IsPermission(err) {
+			t.Errorf("Open with token %q: %v, want a permission error", token, err)
+		}
+		if ok, err := s.HasContext(context.Background(), id); ok || !os.IsPermission(err) {
+			t.Errorf("HasContext with token %q: %v, %v", token, ok, err)
+		}
+	}
+	if !NewHTTPStore(srv.URL, "letmein").Has(id) {
+		t.Error("Has with the right token is false")
+	}
+}
+
+func TestHTTPStoreMany(t *testing.T) {
+	defer func(n int) { maxHasBatch = n }(maxHasBatch)
+	maxHasBatch = 3
+
+	backing := NewRAM()
+	s := newTestHTTP(t, NewHTTPHandler(backing), "")
+	ids := putN(t, backing, 5, 100)
+	query := append([]c4.ID{testID("absent")}, ids...)
+
+	found, err := s.HasMany(context.Background(), query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, ok := range found {
+		if ok != (i > 0) {
+			t.Errorf("HasMany[%d] = %v", i, ok)
+		}
+	}
+
+	var n int
+	err = s.OpenMany(context.Background(), query, func(id c4.ID, r io.Reader, err error) error {
+		if id == query[0] {
+			if !os.IsNotExist(err) {
+				t.Errorf("OpenMany of a missing object: %v", err)
+			}
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if c4.Identify(r) != id {
+			t.Errorf("OpenMany read the wrong content for %s", id)
+		}
+		n++
+		return nil
+	})
+	if err != nil || n != 5 {
+		t.Errorf("OpenMany: %v, %d objects read", err, n)
+	}
+}
+
+func TestHTTPStoreList(t *testing.T) {
+	tree, err := NewTreeStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := putN(t, tree, 4, 50)
+	s := newTestHTTP(t, NewHTTPHandler(tree), "")
+
+	seen := make(map[c4.ID]bool)
+	err = List(s, func(o Object) bool {
+		seen[o.ID] = true
+		if o.Size != 50 || o.ModTime.IsZero() {
+			t.Errorf("listed %s with size %d, modified %v", o.ID, o.Size, o.ModTime)
+		}
+		return true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range ids {
+		if !seen[id] {
+			t.Errorf("%s not listed", id)
+		}
+	}
+
+	// A store that cannot list says so.
+	unlisted := newTestHTTP(t, NewHTTPHandler(struct{ Store }{NewRAM()}), "")
+	if err := List(unlisted, func(Object) bool { return true }); !errors.Is(err, ErrNotImplemented) {
+		t.Errorf("List of an unlisted store: %v", err)
+	}
+}
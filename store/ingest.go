@@ -0,0 +1,203 @@
+package store
+
+import (
+	"crypto/sha512"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+
+	"github.com/Avalanche-io/c4"
+)
+
+// IngestMode is how a TreeStore stores a file passed to Put.
+type IngestMode int
+
+const (
+	// IngestCopy copies the file into the store. It is the default.
+	IngestCopy IngestMode = iota
+	// IngestHardlink links the file into the store, so they share their
+	// content and no bytes are copied. A later change to the file changes
+	// the stored object too; reading it then fails with ErrLinkModified.
+	IngestHardlink
+	// IngestHardlinkReadOnly links the file and takes away its write
+	// permissions, so it is not changed by accident.
+	IngestHardlinkReadOnly
+	// IngestReflink clones the file, sharing its blocks until either copy
+	// is changed, on filesystems that support it (Btrfs and XFS on Linux).
+	IngestReflink
+)
+
+// ParseIngestMode parses "copy", "hardlink", "hardlink-ro" or "reflink".
+func ParseIngestMode(s string) (IngestMode, error) {
+	for m := IngestCopy; m <= IngestReflink; m++ {
+		if s == m.String() {
+			return m, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown ingest mode %q", s)
+}
+
+func (m IngestMode) String() string {
+	switch m {
+	case IngestCopy:
+		return "copy"
+	case IngestHardlink:
+		return "hardlink"
+	case IngestHardlinkReadOnly:
+		return "hardlink-ro"
+	case IngestReflink:
+		return "reflink"
+	}
+	return fmt.Sprintf("IngestMode(%d)", int(m))
+}
+
+// ErrLinkModified reports that a hardlinked object no longer matches its
+// ID, because the file it was linked from has been changed.
+var ErrLinkModified = fmt.Errorf("hardlinked file changed since it was stored")
+
+// SetIngest sets how Put stores an *os.File: by copying, hardlinking or
+// reflinking it. Where a link cannot be made, such as across filesystems,
+// Put copies instead. Other readers are always copied.
+func (s *TreeStore) SetIngest(m IngestMode) {
+	s.ingest = m
+}
+
+// putFile stores f by linking or cloning it into the store. It reports
+// false, having read nothing from f, if that is not possible and the
+// content should be copied instead.
+func (s *TreeStore) putFile(f *os.File) (c4.ID, bool, error) {
+	info, err := f.Stat()
+	if err != nil || !info.Mode().IsRegular() {
+		return c4.ID{}, false, nil
+	}
+	if off, err := f.Seek(0, io.SeekCurrent); err != nil || off != 0 {
+		return c4.ID{}, false, nil
+	}
+	tmpName, err := s.linkTemp(f)
+	if err != nil {
+		// Another filesystem, or one without links or clones.
+		return c4.ID{}, false, nil
+	}
+	defer os.Remove(tmpName) // clean up on any error path
+
+	id, err := identifyLinked(tmpName, info, s.ingest != IngestReflink)
+	if err != nil {
+		return c4.ID{}, true, err
+	}
+	if s.Has(id) {
+		return id, true, nil
+	}
+	if s.ingest == IngestHardlinkReadOnly {
+		if err := os.Chmod(tmpName, info.Mode().Perm()&^0222); err != nil {
+			return c4.ID{}, true, err
+		}
+	}
+	if err := s.place(tmpName, id); err != nil {
+		return c4.ID{}, true, err
+	}
+	return id, true, nil
+}
+
+// linkTemp links or clones f to a new temp file in the store, and returns
+// its name.
+func (s *TreeStore) linkTemp(f *os.File) (string, error) {
+	tmp, err := os.CreateTemp(s.root, ".ingest.*")
+	if err != nil {
+		return "", err
+	}
+	name := tmp.Name()
+	if s.ingest == IngestReflink {
+		err = reflink(tmp, f)
+		if err == nil {
+			err = tmp.Sync()
+		}
+		if cerr := tmp.Close(); err == nil {
+			err = cerr
+		}
+	} else {
+		// Reserve a name, then link in its place.
+		tmp.Close()
+		if err = os.Remove(name); err == nil {
+			err = os.Link(f.Name(), name)
+		}
+	}
+	if err != nil {
+		os.Remove(name)
+		return "", err
+	}
+	return name, nil
+}
+
+// identifyLinked computes the ID of a file linked or cloned from one
+// described by info. A hardlink shares the source's content, which must
+// not change while it is read.
+func identifyLinked(name string, info os.FileInfo, shared bool) (c4.ID, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return c4.ID{}, err
+	}
+	defer f.Close()
+	id := c4.Identify(f)
+	if shared {
+		after, err := f.Stat()
+		if err != nil {
+			return c4.ID{}, err
+		}
+		if !sameFileState(info, after) {
+			return c4.ID{}, fmt.Errorf("ingest %s: file changed while it was read", info.Name())
+		}
+	}
+	return id, nil
+}
+
+// linkedReader reads an object that shares its content with a file outside
+// the store, checking the content against the ID when it reaches the end.
+// Reads after a Seek are not checked.
+type linkedReader struct {
+	f      *os.File
+	id     c4.ID
+	h      hash.Hash
+	seeked bool
+}
+
+func newLinkedReader(f *os.File, id c4.ID) *linkedReader {
+	return &linkedReader{f: f, id: id, h: sha512.New()}
+}
+
+func (r *linkedReader) Read(p []byte) (int, error) {
+	n, err := r.f.Read(p)
+	if r.seeked {
+		return n, err
+	}
+	r.h.Write(p[:n])
+	if err == io.EOF {
+		var got c4.ID
+		copy(got[:], r.h.Sum(nil))
+		if got != r.id {
+			return n, &os.PathError{Op: "read", Path: r.f.Name(), Err: ErrLinkModified}
+		}
+	}
+	return n, err
+}
+
+func (r *linkedReader) Seek(off int64, whence int) (int64, error) {
+	r.seeked = true
+	return r.f.Seek(off, whence)
+}
+
+func (r *linkedReader) Close() error {
+	return r.f.Close()
+}
+
+// TreeStores returns the TreeStores in s, looking through wrappers, caches
+// and the members of a MultiStore.
+func TreeStores(s Store) []*TreeStore {
+	var trees []*TreeStore
+	walkStores(s, func(s Store) {
+		if ts, ok := s.(*TreeStore); ok {
+			trees = append(trees, ts)
+		}
+	})
+	return trees
+}
@@ -0,0 +1,12 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd
+
+package store
+
+import "os"
+
+// linkCount returns 1: link counts are not available on this platform, so
+// hardlinked objects are not detected.
+func linkCount(info os.FileInfo) uint64 {
+	return 1
+}
@@ -0,0 +1,174 @@
+package store
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/Avalanche-io/c4"
+)
+
+func TestParseIngestMode(t *testing.T) {
+	for m := IngestCopy; m <= IngestReflink; m++ {
+		if got, err := ParseIngestMode(m.String()); err != nil || got != m {
+			t.Errorf("ParseIngestMode(%q) = %v, %v", m, got, err)
+		}
+	}
+	if _, err := ParseIngestMode("symlink"); err == nil {
+		t.Error("ParseIngestMode accepted symlink")
+	}
+}
+
+// putPath stores the file at path through Put.
+func putPath(t *testing.T, s *TreeStore, path string) c4.ID {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	id, err := s.Put(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+// storedFile returns the file holding id in s.
+func storedFile(t *testing.T, s *TreeStore, id c4.ID) os.FileInfo {
+	t.Helper()
+	p, ok := s.locate(id)
+	if !ok {
+		t.Fatalf("%s not stored", id)
+	}
+	info, err := os.Stat(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return info
+}
+
+func TestTreeStoreIngestHardlink(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("link counts are not available")
+	}
+	dir := t.TempDir()
+	s, err := NewTreeStore(filepath.Join(dir, "store"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.SetIngest(IngestHardlink)
+	src := filepath.Join(dir, "A001C003.mov")
+	os.WriteFile(src, []byte("camera original"), 0644)
+
+	id := putPath(t, s, src)
+	if id != c4.Identify(strings.NewReader("camera original")) {
+		t.Fatalf("Put returned %s", id)
+	}
+	srcInfo, _ := os.Stat(src)
+	if !os.SameFile(srcInfo, storedFile(t, s, id)) {
+		t.Fatal("object is not a hardlink of the file")
+	}
+	rc, err := s.Open(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := io.ReadAll(rc)
+	rc.Close()
+	if err != nil || string(got) != "camera original" {
+		t.Fatalf("read %q, %v", got, err)
+	}
+	// Putting it again changes nothing.
+	if again := putPath(t, s, src); again != id {
+		t.Errorf("second Put returned %s", again)
+	}
+
+	// Changing the file is noticed on reading and by Fsck.
+	f, _ := os.OpenFile(src, os.O_WRONLY, 0)
+	f.WriteString("CAMERA")
+	f.Close()
+	rc, err = s.Open(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = io.ReadAll(rc)
+	rc.Close()
+	if !errors.Is(err, ErrLinkModified) {
+		t.Errorf("read of a changed link: %v, want ErrLinkModified", err)
+	}
+	rep, err := Fsck(s, FsckOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rep.Problems) != 1 || rep.Problems[0].Kind != ProblemCorrupt || !errors.Is(rep.Problems[0].Err, ErrLinkModified) {
+		t.Errorf("fsck problems %v", rep.Problems)
+	}
+}
+
+func TestTreeStoreIngestReadOnly(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("permissions differ")
+	}
+	dir := t.TempDir()
+	s, err := NewTreeStore(filepath.Join(dir, "store"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.SetIngest(IngestHardlinkReadOnly)
+	src := filepath.Join(dir, "plate.exr")
+	os.WriteFile(src, []byte("pixels"), 0664)
+
+	id := putPath(t, s, src)
+	info, _ := os.Stat(src)
+	if info.Mode().Perm() != 0444 {
+		t.Errorf("file mode %v, want read-only", info.Mode().Perm())
+	}
+	if !os.SameFile(info, storedFile(t, s, id)) {
+		t.Error("object is not a hardlink of the file")
+	}
+}
+
+func TestTreeStoreIngestFallback(t *testing.T) {
+	dir := t.TempDir()
+	s, err := NewTreeStore(filepath.Join(dir, "store"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Reflinks are not supported everywhere; either way the content is
+	// stored, and in its own file.
+	s.SetIngest(IngestReflink)
+	src := filepath.Join(dir, "clip.mov")
+	os.WriteFile(src, []byte("frames"), 0644)
+	id := putPath(t, s, src)
+	info, _ := os.Stat(src)
+	if os.SameFile(info, storedFile(t, s, id)) {
+		t.Error("reflink ingest hardlinked the file")
+	}
+	os.WriteFile(src, []byte("FRAMES"), 0644)
+	rc, err := s.Open(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, _ := io.ReadAll(rc)
+	rc.Close()
+	if string(got) != "frames" {
+		t.Errorf("a change to the file reached the clone: %q", got)
+	}
+
+	// A file read part way is copied from where it is.
+	s.SetIngest(IngestHardlink)
+	f, _ := os.Open(src)
+	defer f.Close()
+	f.Seek(2, io.SeekStart)
+	id, err = s.Put(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != c4.Identify(strings.NewReader("AMES")) {
+		t.Error("Put of a partly read file stored the wrong content")
+	}
+}
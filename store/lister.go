@@ -0,0 +1,250 @@
+package store
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/Avalanche-io/c4"
+)
+
+// Object describes one item of stored content as reported by a Lister.
+type Object struct {
+	ID      c4.ID
+	Size    int64
+	ModTime time.Time // zero if the store does not track it
+}
+
+// Lister is an optional interface for stores that can enumerate their
+// contents.
+type Lister interface {
+	// List calls fn for each object in the store, in no particular order.
+	// Listing stops without error when fn returns false. It is safe to
+	// Remove the object passed to fn from within fn.
+	List(fn func(Object) bool) error
+}
+
+// List enumerates s if it implements Lister, and returns ErrNotImplemented
+// otherwise.
+func List(s Store, fn func(Object) bool) error {
+	l, ok := s.(Lister)
+	if !ok {
+		return ErrNotImplemented
+	}
+	return l.List(fn)
+}
+
+// ListAll returns every object in s.
+func ListAll(s Store) ([]Object, error) {
+	var objs []Object
+	err := List(s, func(o Object) bool {
+		objs = append(objs, o)
+		return true
+	})
+	return objs, err
+}
+
+// listDir reports the content files directly inside dir. Names that are
+// not C4 IDs, such as in-progress temp files, are skipped. A missing
+// directory is an empty listing.
+func listDir(dir string, fn func(Object) bool) (bool, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return true, nil
+		}
+		return false, err
+	}
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+		o, ok := fileObject(dir, e)
+		if !ok {
+			continue
+		}
+		if !fn(o) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// fileObject converts a directory entry named by a C4 ID into an Object.
+func fileObject(dir string, e os.DirEntry) (Object, bool) {
+	name := e.Name()
+	if len(name) != 90 || isTemp(name) {
+		return Object{}, false
+	}
+	id, err := c4.Parse(name)
+	if err != nil {
+		return Object{}, false
+	}
+	info, err := e.Info()
+	if err != nil {
+		// Removed since the directory was read.
+		return Object{}, false
+	}
+	return Object{ID: id, Size: info.Size(), ModTime: info.ModTime()}, true
+}
+
+// List reports the content files in the folder.
+func (f Folder) List(fn func(Object) bool) error {
+	_, err := listDir(string(f), fn)
+	return err
+}
+
+// List reports content in both the sharded and the flat layout.
+func (f ShardedFolder) List(fn func(Object) bool) error {
+	entries, err := os.ReadDir(string(f))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	for _, e := range entries {
+		if !e.IsDir() {
+			if o, ok := fileObject(string(f), e); ok && !fn(o) {
+				return nil
+			}
+			continue
+		}
+		if len(e.Name()) != 2 {
+			continue
+		}
+		more, err := listDir(filepath.Join(string(f), e.Name()), fn)
+		if err != nil || !more {
+			return err
+		}
+	}
+	return nil
+}
+
+// List walks the trie, reporting the content files in every leaf.
+func (s *TreeStore) List(fn func(Object) bool) error {
+	_, err := s.listTree(s.root, fn)
+	return err
+}
+
+func (s *TreeStore) listTree(dir string, fn func(Object) bool) (bool, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			// Split away by a concurrent writer.
+			return true, nil
+		}
+		return false, err
+	}
+	for _, e := range entries {
+		if e.IsDir() {
+			if len(e.Name()) != 2 {
+				continue
+			}
+			more, err := s.listTree(filepath.Join(dir, e.Name()), fn)
+			if err != nil || !more {
+				return more, err
+			}
+			continue
+		}
+		if o, ok := fileObject(dir, e); ok && !fn(o) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// List reports each mapped ID whose file exists.
+func (s MAP) List(fn func(Object) bool) error {
+	ids := make([]c4.ID, 0, len(s))
+	for id := range s {
+		ids = append(ids, id)
+	}
+	for _, id := range ids {
+		info, err := os.Stat(s[id])
+		if err != nil {
+			continue
+		}
+		if !fn(Object{ID: id, Size: info.Size(), ModTime: info.ModTime()}) {
+			return nil
+		}
+	}
+	return nil
+}
+
+// List reports a snapshot of the stored content.
+func (s *RAM) List(fn func(Object) bool) error {
+	s.mu.RLock()
+	objs := make([]Object, 0, len(s.data))
+	for id, data := range s.data {
+		objs = append(objs, Object{ID: id, Size: int64(len(data))})
+	}
+	s.mu.RUnlock()
+	for _, o := range objs {
+		if !fn(o) {
+			return nil
+		}
+	}
+	return nil
+}
+
+// List reports the content of every member store that implements Lister.
+// Content held by several members is reported once, as seen by the first
+// member that has it. If no member can list, List returns
+// ErrNotImplemented.
+func (m *MultiStore) List(fn func(Object) bool) error {
+	seen := make(map[c4.ID]bool)
+	listed := false
+	stop := false
+	for _, s := range m.stores {
+		l, ok := s.(Lister)
+		if !ok {
+			continue
+		}
+		listed = true
+		err := l.List(func(o Object) bool {
+			if seen[o.ID] {
+				return true
+			}
+			seen[o.ID] = true
+			if !fn(o) {
+				stop = true
+				return false
+			}
+			return true
+		})
+		if err != nil {
+			return err
+		}
+		if stop {
+			return nil
+		}
+	}
+	if !listed {
+		return ErrNotImplemented
+	}
+	return nil
+}
+
+// List calls List on the wrapped Store.
+func (l *Logger) List(fn func(Object) bool) error {
+	return List(l.s, fn)
+}
+
+// List calls List on the wrapped Store.
+func (v *Validating) List(fn func(Object) bool) error {
+	return List(v.s, fn)
+}
+
+var (
+	_ Lister = Folder("")
+	_ Lister = ShardedFolder("")
+	_ Lister = (*TreeStore)(nil)
+	_ Lister = MAP{}
+	_ Lister = (*RAM)(nil)
+	_ Lister = (*MultiStore)(nil)
+	_ Lister = (*S3Store)(nil)
+	_ Lister = (*HTTPStore)(nil)
+	_ Lister = (*Reference)(nil)
+	_ Lister = (*Erasure)(nil)
+)
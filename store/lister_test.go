@@ -0,0 +1,209 @@
+package store
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/Avalanche-io/c4"
+)
+
+// putStrings stores each string in s and returns the sorted IDs.
+func putStrings(t *testing.T, s Store, data ...string) []string {
+	t.Helper()
+	var ids []string
+	for _, d := range data {
+		id, err := s.Put(strings.NewReader(d))
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id.String())
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// listedIDs lists s and returns the sorted IDs, failing on duplicates or
+// wrong sizes.
+func listedIDs(t *testing.T, s Store, sizes map[c4.ID]int64) []string {
+	t.Helper()
+	objs, err := ListAll(s)
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	seen := make(map[c4.ID]bool)
+	var ids []string
+	for _, o := range objs {
+		if seen[o.ID] {
+			t.Errorf("%s listed twice", o.ID)
+		}
+		seen[o.ID] = true
+		if want, ok := sizes[o.ID]; ok && o.Size != want {
+			t.Errorf("%s size = %d, want %d", o.ID, o.Size, want)
+		}
+		ids = append(ids, o.ID.String())
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func sameIDs(t *testing.T, got, want []string) {
+	t.Helper()
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Fatalf("listed %d IDs, want %d:\ngot  %v\nwant %v", len(got), len(want), got, want)
+	}
+}
+
+func TestListLocalStores(t *testing.T) {
+	data := []string{"alpha", "beta", "gamma", "delta", "epsilon", "zeta", "eta", "theta"}
+	sizes := make(map[c4.ID]int64)
+	for _, d := range data {
+		sizes[c4.Identify(strings.NewReader(d))] = int64(len(d))
+	}
+
+	tree, err := NewTreeStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	tree.SetSplitThreshold(2)
+
+	stores := map[string]Store{
+		"Folder":        Folder(t.TempDir()),
+		"ShardedFolder": ShardedFolder(t.TempDir()),
+		"TreeStore":     tree,
+		"RAM":           NewRAM(),
+	}
+	for name, s := range stores {
+		t.Run(name, func(t *testing.T) {
+			want := putStrings(t, s, data...)
+			sameIDs(t, listedIDs(t, s, sizes), want)
+		})
+	}
+}
+
+func TestListSkipsTempFiles(t *testing.T) {
+	dir := t.TempDir()
+	s := ShardedFolder(dir)
+	want := putStrings(t, s, "one")
+	os.WriteFile(filepath.Join(dir, ".tmp.123"), []byte("x"), 0644)
+	os.WriteFile(filepath.Join(dir, "notes.txt"), []byte("x"), 0644)
+	// Flat layout files are still listed.
+	flat := c4.Identify(strings.NewReader("flat"))
+	os.WriteFile(filepath.Join(dir, flat.String()), []byte("flat"), 0644)
+	want = append(want, flat.String())
+	sort.Strings(want)
+	sameIDs(t, listedIDs(t, s, nil), want)
+}
+
+func TestListMAP(t *testing.T) {
+	dir := t.TempDir()
+	m := NewMap(make(map[c4.ID]string))
+	var want []string
+	for _, d := range []string{"a", "b"} {
+		id := c4.Identify(strings.NewReader(d))
+		p := filepath.Join(dir, d)
+		os.WriteFile(p, []byte(d), 0644)
+		m[id] = p
+		want = append(want, id.String())
+	}
+	m[c4.Identify(strings.NewReader("gone"))] = filepath.Join(dir, "gone")
+	sort.Strings(want)
+	sameIDs(t, listedIDs(t, m, nil), want)
+}
+
+func TestListStopsEarly(t *testing.T) {
+	s := NewRAM()
+	putStrings(t, s, "a", "b", "c")
+	n := 0
+	if err := s.List(func(Object) bool { n++; return false }); err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Fatalf("fn called %d times after returning false", n)
+	}
+}
+
+func TestListMultiStoreDedup(t *testing.T) {
+	a, b := NewRAM(), NewRAM()
+	wantA := putStrings(t, a, "shared", "only-a")
+	wantB := putStrings(t, b, "shared", "only-b")
+	multi := NewMultiStore(a, b, nonListing{NewRAM()})
+
+	got := listedIDs(t, multi, nil)
+	seen := make(map[string]bool)
+	var want []string
+	for _, id := range append(wantA, wantB...) {
+		if !seen[id] {
+			seen[id] = true
+			want = append(want, id)
+		}
+	}
+	sort.Strings(want)
+	sameIDs(t, got, want)
+
+	if err := List(NewMultiStore(nonListing{NewRAM()}), func(Object) bool { return true }); err != ErrNotImplemented {
+		t.Fatalf("expected ErrNotImplemented, got %v", err)
+	}
+	if err := List(NewLogger(nonListing{NewRAM()}, os.Stderr, 0), func(Object) bool { return true }); err != ErrNotImplemented {
+		t.Fatalf("Logger over non-lister: expected ErrNotImplemented, got %v", err)
+	}
+}
+
+// nonListing hides the List method of the store it wraps.
+type nonListing struct{ Store }
+
+func TestS3List(t *testing.T) {
+	var ids []c4.ID
+	for i := 0; i < 5; i++ {
+		ids = append(ids, testID(fmt.Sprintf("obj%d", i)))
+	}
+	pages := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if r.Method != "GET" || q.Get("list-type") != "2" || q.Get("prefix") != "c4/" {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL)
+		}
+		pages++
+		// Two objects per page; the token is the index of the next object.
+		start := 0
+		fmt.Sscan(q.Get("continuation-token"), &start)
+		end := start + 2
+		if end > len(ids) {
+			end = len(ids)
+		}
+		fmt.Fprint(w, `<ListBucketResult>`)
+		for _, id := range ids[start:end] {
+			fmt.Fprintf(w, `<Contents><Key>c4/%s</Key><Size>7</Size><LastModified>2024-01-02T03:04:05.000Z</LastModified></Contents>`, id)
+		}
+		if start == 0 {
+			fmt.Fprint(w, `<Contents><Key>c4/not-an-id</Key><Size>1</Size></Contents>`)
+		}
+		if end < len(ids) {
+			fmt.Fprintf(w, `<IsTruncated>true</IsTruncated><NextContinuationToken>%d</NextContinuationToken>`, end)
+		}
+		fmt.Fprint(w, `</ListBucketResult>`)
+	}))
+	defer srv.Close()
+
+	s := NewS3Store("testbucket", "c4/", "us-east-1", srv.URL, "AKID", "SECRET")
+	objs, err := ListAll(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pages != 3 {
+		t.Errorf("fetched %d pages, want 3", pages)
+	}
+	if len(objs) != len(ids) {
+		t.Fatalf("listed %d objects, want %d", len(objs), len(ids))
+	}
+	for i, o := range objs {
+		if o.ID != ids[i] || o.Size != 7 || o.ModTime.Year() != 2024 {
+			t.Errorf("object %d = %+v", i, o)
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package store
 
 import (
+	"context"
 	"fmt"
 	"io"
 
@@ -50,6 +51,11 @@ func NewLogger(s Store, log io.Writer, flags LoggerFlags) *Logger {
 
 // Open logs and calls the Open method of the contained Store.
 func (l *Logger) Open(id c4.ID) (io.ReadCloser, error) {
+	return l.OpenContext(context.Background(), id)
+}
+
+// OpenContext logs and calls OpenContext on the contained Store.
+func (l *Logger) OpenContext(ctx context.Context, id c4.ID) (io.ReadCloser, error) {
 	fname := "Open"
 	idstr := id.String()
 	// only log if logging is enabled for this method.
@@ -58,7 +64,7 @@ func (l *Logger) Open(id c4.ID) (io.ReadCloser, error) {
 	}
 
 	// call the wrapped Stor's Open
-	r, err := l.s.Open(id)
+	r, err := WithContext(l.s).OpenContext(ctx, id)
 	if err != nil {
 		// only log the error if error logging is enabled.
 		if l.flags&LogError != 0 {
@@ -73,6 +79,11 @@ func (l *Logger) Open(id c4.ID) (io.ReadCloser, error) {
 
 // Create logs and calls the Create method of the contained Store.
 func (l *Logger) Create(id c4.ID) (io.WriteCloser, error) {
+	return l.CreateContext(context.Background(), id)
+}
+
+// CreateContext logs and calls CreateContext on the contained Store.
+func (l *Logger) CreateContext(ctx context.Context, id c4.ID) (io.WriteCloser, error) {
 	fname := "Create"
 	idstr := id.String()
 	// only log if logging is enabled for this method.
@@ -81,7 +92,7 @@ func (l *Logger) Create(id c4.ID) (io.WriteCloser, error) {
 	}
 
 	// call the wrapped Stor's Create
-	w, err := l.s.Create(id)
+	w, err := WithContext(l.s).CreateContext(ctx, id)
 	if err != nil {
 		// only log the error if error logging is enabled.
 		if l.flags&LogError != 0 {
@@ -98,8 +109,44 @@ func (l *Logger) Has(id c4.ID) bool { return l.s.Has(id) }
 
 func (l *Logger) Put(r io.Reader) (c4.ID, error) { return l.s.Put(r) }
 
+func (l *Logger) HasContext(ctx context.Context, id c4.ID) (bool, error) {
+	return WithContext(l.s).HasContext(ctx, id)
+}
+
+func (l *Logger) PutContext(ctx context.Context, r io.Reader) (c4.ID, error) {
+	return WithContext(l.s).PutContext(ctx, r)
+}
+
+func (l *Logger) HasMany(ctx context.Context, ids []c4.ID) ([]bool, error) {
+	return WithContext(l.s).HasMany(ctx, ids)
+}
+
+// OpenMany calls OpenMany on the contained Store, logging each object
+// opened as Open does.
+func (l *Logger) OpenMany(ctx context.Context, ids []c4.ID, fn func(id c4.ID, r io.Reader, err error) error) error {
+	fname := "Open"
+	return WithContext(l.s).OpenMany(ctx, ids, func(id c4.ID, r io.Reader, err error) error {
+		idstr := id.String()
+		if l.flags&LogOpen != 0 {
+			fmt.Fprintf(l.logout, "%s %s\n", idstr, fname)
+		}
+		if err != nil {
+			if l.flags&LogError != 0 {
+				fmt.Fprintf(l.logout, "%s %s error %s\n", idstr, fname, err)
+			}
+			return fn(id, nil, err)
+		}
+		return fn(id, &loggingReader{io.NopCloser(r), l.logout, idstr, l.flags}, nil)
+	})
+}
+
 // Remove logs and calls the Remove method of the contained Store.
 func (l *Logger) Remove(id c4.ID) error {
+	return l.RemoveContext(context.Background(), id)
+}
+
+// RemoveContext logs and calls RemoveContext on the contained Store.
+func (l *Logger) RemoveContext(ctx context.Context, id c4.ID) error {
 	fname := "Remove"
 	idstr := id.String()
 	// only log if logging is enabled for this method.
@@ -108,7 +155,7 @@ func (l *Logger) Remove(id c4.ID) error {
 	}
 
 	// call the wrapped Store's Remove
-	err := l.s.Remove(id)
+	err := WithContext(l.s).RemoveContext(ctx, id)
 	if err != nil {
 		// only log the error if error logging is enabled.
 		if l.flags&LogError != 0 {
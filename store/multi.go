@@ -1,23 +1,185 @@
 package store
 
 import (
+	"context"
+	"crypto/sha512"
+	"errors"
 	"fmt"
 	"io"
+	"os"
+	"sync"
+	"time"
 
 	"github.com/Avalanche-io/c4"
 )
 
-// MultiStore combines multiple stores into one. Writes go to the first
-// store. Reads check all stores in order, returning the first hit.
-// Has returns true if any store has the content.
+// MultiStore combines multiple stores into one. By default writes go to
+// the first store, and reads check all stores in order, returning the
+// first hit. Has returns true if any store has the content.
+//
+// SetWrite makes other members receive writes too: synchronously, with
+// SetQuorum saying how many must succeed, or asynchronously, copied in the
+// background from a queue that SetQueue makes persistent. SetRead chooses
+// how reads pick a member, and SetRepair rewrites a member's copy that
+// fails to read from one that reads correctly.
 type MultiStore struct {
 	stores []Store
+	write  []WriteMode
+	quorum int
+	read   ReadPolicy
+	repair bool
+
+	queue   *writeQueue
+	drainMu sync.Mutex // one Drain at a time
+	mu      sync.Mutex // guards the fields below
+	running bool       // whether the background worker is running
+	closed  chan struct{}
+	wg      sync.WaitGroup
 }
 
 // NewMultiStore creates a store that writes to the first store and reads
 // from all stores in order.
 func NewMultiStore(stores ...Store) *MultiStore {
-	return &MultiStore{stores: stores}
+	write := make([]WriteMode, len(stores))
+	if len(write) > 0 {
+		write[0] = WriteSync
+	}
+	return &MultiStore{
+		stores: stores,
+		write:  write,
+		queue:  newWriteQueue(),
+		closed: make(chan struct{}),
+	}
+}
+
+// WriteMode is how a member of a MultiStore receives new content.
+type WriteMode int
+
+const (
+	// WriteNone members are only read from. It is the default for every
+	// member but the first.
+	WriteNone WriteMode = iota
+	// WriteSync members are written before Put or Close returns.
+	WriteSync
+	// WriteAsync members are copied to in the background, from a member
+	// written synchronously.
+	WriteAsync
+)
+
+// ParseWriteMode parses "none", "sync" or "async".
+func ParseWriteMode(s string) (WriteMode, error) {
+	for w := WriteNone; w <= WriteAsync; w++ {
+		if s == w.String() {
+			return w, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown write mode %q", s)
+}
+
+func (w WriteMode) String() string {
+	switch w {
+	case WriteNone:
+		return "none"
+	case WriteSync:
+		return "sync"
+	case WriteAsync:
+		return "async"
+	}
+	return fmt.Sprintf("WriteMode(%d)", int(w))
+}
+
+// ReadPolicy is how a MultiStore chooses the member to read from.
+type ReadPolicy int
+
+const (
+	// ReadFirst reads from the first member that has the content, trying
+	// the next if opening it fails. It is the default.
+	ReadFirst ReadPolicy = iota
+	// ReadFastest opens the content on every member that has it at once,
+	// and reads from whichever answers first.
+	ReadFastest
+	// ReadVerify reads the whole content from the first member that has
+	// it, checking it against its ID, before serving any of it. Content
+	// that fails the check is read from the next member instead.
+	ReadVerify
+)
+
+// ParseReadPolicy parses "first", "fastest" or "verify".
+func ParseReadPolicy(s string) (ReadPolicy, error) {
+	for p := ReadFirst; p <= ReadVerify; p++ {
+		if s == p.String() {
+			return p, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown read policy %q", s)
+}
+
+func (p ReadPolicy) String() string {
+	switch p {
+	case ReadFirst:
+		return "first"
+	case ReadFastest:
+		return "fastest"
+	case ReadVerify:
+		return "verify"
+	}
+	return fmt.Sprintf("ReadPolicy(%d)", int(p))
+}
+
+// SetWrite sets how member i, counting from zero, receives writes.
+func (m *MultiStore) SetWrite(i int, mode WriteMode) {
+	m.write[i] = mode
+}
+
+// SetQuorum sets how many WriteSync members must store content for a
+// write to succeed. Members that fail are queued to be copied to later,
+// like WriteAsync members. Zero, the default, means all of them.
+func (m *MultiStore) SetQuorum(n int) {
+	m.quorum = n
+}
+
+// SetRead sets how reads choose a member.
+func (m *MultiStore) SetRead(p ReadPolicy) {
+	m.read = p
+}
+
+// SetRepair sets whether a member whose copy of some content fails to
+// open or to verify is given a good copy, read from another member.
+// Repairing needs the good copy verified first, so a read that finds a
+// bad copy is served as with ReadVerify.
+func (m *MultiStore) SetRepair(repair bool) {
+	m.repair = repair
+}
+
+// SetQueue keeps the queue of content waiting to be copied to members in
+// dir, so that what a process did not finish copying is copied by the next
+// to use the queue. Without it the queue is lost when the process exits.
+// Any content already queued in dir is copied in the background.
+func (m *MultiStore) SetQueue(dir string) error {
+	q, err := openWriteQueue(dir)
+	if err != nil {
+		return err
+	}
+	m.queue = q
+	if q.len() > 0 {
+		m.kick()
+	}
+	return nil
+}
+
+// Close stops copying queued content in the background, waiting for the
+// object being copied, and closes the queue. What is left in a persistent
+// queue is copied by the next MultiStore to open it.
+func (m *MultiStore) Close() error {
+	m.mu.Lock()
+	select {
+	case <-m.closed:
+	default:
+		close(m.closed)
+	}
+	m.mu.Unlock()
+	m.wg.Wait()
+	return m.queue.Close()
 }
 
 func (m *MultiStore) Has(id c4.ID) bool {
@@ -30,15 +192,169 @@ func (m *MultiStore) Has(id c4.ID) bool {
 }
 
 func (m *MultiStore) Open(id c4.ID) (io.ReadCloser, error) {
+	return m.OpenContext(context.Background(), id)
+}
+
+func (m *MultiStore) Create(id c4.ID) (io.WriteCloser, error) {
+	return m.CreateContext(context.Background(), id)
+}
+
+func (m *MultiStore) Put(r io.Reader) (c4.ID, error) {
+	return m.PutContext(context.Background(), r)
+}
+
+func (m *MultiStore) Remove(id c4.ID) error {
+	// Remove from all stores that have it.
 	var lastErr error
 	for _, s := range m.stores {
 		if s.Has(id) {
-			rc, err := s.Open(id)
-			if err == nil {
-				return rc, nil
+			if err := s.Remove(id); err != nil {
+				lastErr = err
 			}
+		}
+	}
+	return lastErr
+}
+
+// HasContext asks every member store at once.
+func (m *MultiStore) HasContext(ctx context.Context, id c4.ID) (bool, error) {
+	found, err := m.HasMany(ctx, []c4.ID{id})
+	if err != nil {
+		return false, err
+	}
+	return found[0], nil
+}
+
+// HasMany asks every member store at once. An error from one member is
+// only returned for an ID that no other member has.
+func (m *MultiStore) HasMany(ctx context.Context, ids []c4.ID) ([]bool, error) {
+	where, err := m.locate(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+	found := make([]bool, len(ids))
+	for i := range ids {
+		found[i] = where[i] >= 0
+	}
+	return found, nil
+}
+
+// locate returns, for each of ids, the index of the first member store that
+// has it, or -1.
+func (m *MultiStore) locate(ctx context.Context, ids []c4.ID) ([]int, error) {
+	has, errs := m.ask(ctx, ids)
+	where := make([]int, len(ids))
+	for j := range ids {
+		where[j] = -1
+		for i := range m.stores {
+			if errs[i] == nil && has[i][j] {
+				where[j] = i
+				break
+			}
+		}
+		if where[j] >= 0 {
+			continue
+		}
+		for _, err := range errs {
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return where, nil
+}
+
+// holders returns the indexes of the member stores that have id, in order.
+func (m *MultiStore) holders(ctx context.Context, id c4.ID) ([]int, error) {
+	has, errs := m.ask(ctx, []c4.ID{id})
+	var found []int
+	for i := range m.stores {
+		if errs[i] == nil && has[i][0] {
+			found = append(found, i)
+		}
+	}
+	if len(found) > 0 {
+		return found, nil
+	}
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	return nil, nil
+}
+
+// ask asks every member store at once which of ids it has.
+func (m *MultiStore) ask(ctx context.Context, ids []c4.ID) ([][]bool, []error) {
+	has := make([][]bool, len(m.stores))
+	errs := make([]error, len(m.stores))
+	var wg sync.WaitGroup
+	for i, s := range m.stores {
+		wg.Add(1)
+		go func(i int, s Store) {
+			defer wg.Done()
+			has[i], errs[i] = WithContext(s).HasMany(ctx, ids)
+		}(i, s)
+	}
+	wg.Wait()
+	return has, errs
+}
+
+// OpenContext opens id from a member store chosen by the read policy.
+func (m *MultiStore) OpenContext(ctx context.Context, id c4.ID) (io.ReadCloser, error) {
+	if m.read == ReadFirst {
+		return m.openFirst(ctx, id)
+	}
+	from, err := m.holders(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if len(from) == 0 {
+		return nil, fmt.Errorf("c4 id %s not found in any store", id)
+	}
+	if m.read == ReadVerify {
+		return m.openVerified(ctx, id, from, nil)
+	}
+	rc, failed, err := m.openFastest(ctx, id, from)
+	if err != nil || len(failed) == 0 || !m.repair {
+		return rc, err
+	}
+	rc.Close()
+	return m.openVerified(ctx, id, without(from, failed), failed)
+}
+
+// openFirst opens id from the first member that has it and opens it,
+// asking the members one at a time, so a hit on the first costs nothing
+// from the rest. If a member that has id fails to open it and SetRepair is
+// on, the content is served as with ReadVerify.
+func (m *MultiStore) openFirst(ctx context.Context, id c4.ID) (io.ReadCloser, error) {
+	failed := make(map[int]error)
+	var lastErr error
+	for i, s := range m.stores {
+		cs := WithContext(s)
+		ok, err := cs.HasContext(ctx, id)
+		if err != nil {
 			lastErr = err
+			continue
+		}
+		if !ok {
+			continue
+		}
+		rc, err := cs.OpenContext(ctx, id)
+		if err != nil {
+			failed[i] = err
+			lastErr = err
+			continue
+		}
+		if len(failed) > 0 && m.repair {
+			rc.Close()
+			from, err := m.holders(ctx, id)
+			if err != nil {
+				return nil, err
+			}
+			return m.openVerified(ctx, id, without(from, failed), failed)
 		}
+		return rc, nil
 	}
 	if lastErr != nil {
 		return nil, lastErr
@@ -46,29 +362,575 @@ func (m *MultiStore) Open(id c4.ID) (io.ReadCloser, error) {
 	return nil, fmt.Errorf("c4 id %s not found in any store", id)
 }
 
-func (m *MultiStore) Create(id c4.ID) (io.WriteCloser, error) {
-	if len(m.stores) == 0 {
+// openFastest opens id on each of the members from at once, returning the
+// first to open and closing the others. It also returns the members that
+// failed before one succeeded, with their errors.
+func (m *MultiStore) openFastest(ctx context.Context, id c4.ID, from []int) (io.ReadCloser, map[int]error, error) {
+	type result struct {
+		i   int
+		rc  io.ReadCloser
+		err error
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	results := make(chan result, len(from))
+	for _, i := range from {
+		go func(i int) {
+			rc, err := WithContext(m.stores[i]).OpenContext(ctx, id)
+			results <- result{i, rc, err}
+		}(i)
+	}
+	failed := make(map[int]error)
+	var lastErr error
+	for n := range from {
+		r := <-results
+		if r.err != nil {
+			failed[r.i] = r.err
+			lastErr = r.err
+			continue
+		}
+		// Close the losers as they arrive, leaving ctx to the winner.
+		go func(left int) {
+			for ; left > 0; left-- {
+				if r := <-results; r.err == nil {
+					r.rc.Close()
+				}
+			}
+		}(len(from) - n - 1)
+		return &cancelReader{r.rc, cancel}, failed, nil
+	}
+	cancel()
+	return nil, failed, lastErr
+}
+
+// cancelReader cancels the context of a read when it is closed.
+type cancelReader struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (r *cancelReader) Close() error {
+	err := r.ReadCloser.Close()
+	r.cancel()
+	return err
+}
+
+// openVerified reads id from the first of the members from whose copy
+// matches its ID, into a temp file that is served once the whole content
+// is checked. The members in failed, and any of from whose copy fails,
+// are repaired from it if SetRepair is on and their copy is known to be
+// bad.
+func (m *MultiStore) openVerified(ctx context.Context, id c4.ID, from []int, failed map[int]error) (io.ReadCloser, error) {
+	if failed == nil {
+		failed = make(map[int]error)
+	}
+	var lastErr error
+	for _, i := range from {
+		f, err := spoolVerified(ctx, m.stores[i], id)
+		if err != nil {
+			failed[i] = err
+			lastErr = err
+			continue
+		}
+		if m.repair {
+			for bad, cause := range failed {
+				m.repairFrom(ctx, m.stores[bad], id, f, cause)
+			}
+		}
+		return &tempReader{f}, nil
+	}
+	return nil, lastErr
+}
+
+// spoolVerified copies id from s into a temp file, failing with
+// ErrInvalidID if the content does not match it. The file is left at the
+// start of the content.
+func spoolVerified(ctx context.Context, s Store, id c4.ID) (*os.File, error) {
+	rc, err := WithContext(s).OpenContext(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	f, err := os.CreateTemp("", ".c4verify.*")
+	if err != nil {
+		return nil, err
+	}
+	h := sha512.New()
+	_, err = io.Copy(io.MultiWriter(f, h), rc)
+	if err == nil {
+		var got c4.ID
+		copy(got[:], h.Sum(nil))
+		if got != id {
+			err = ErrInvalidID
+		}
+	}
+	if err == nil {
+		_, err = f.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, err
+	}
+	return f, nil
+}
+
+// tempReader reads a temp file, removing it when closed.
+type tempReader struct {
+	*os.File
+}
+
+func (r *tempReader) Close() error {
+	err := r.File.Close()
+	os.Remove(r.File.Name())
+	return err
+}
+
+// repairFrom replaces the copy of id in s with the verified content in f,
+// if cause, the error reading it, shows the copy to be missing or bad.
+// Other errors, such as a member that is unreachable for now, say nothing
+// about the copy, and it is left alone. The good copy is written first; a
+// bad one that a member keeps in its place is then removed and written
+// again. It is best effort: a member that cannot be written keeps its bad
+// copy.
+func (m *MultiStore) repairFrom(ctx context.Context, s Store, id c4.ID, f *os.File, cause error) {
+	if !badCopy(cause) {
+		return
+	}
+	info, err := f.Stat()
+	if err != nil {
+		return
+	}
+	cs := WithContext(s)
+	put := func() error {
+		got, err := cs.PutContext(ctx, io.NewSectionReader(f, 0, info.Size()))
+		if err == nil && got != id {
+			cs.RemoveContext(ctx, got)
+			err = ErrInvalidID
+		}
+		return err
+	}
+	if put() != nil || errors.Is(cause, os.ErrNotExist) {
+		return
+	}
+	g, err := spoolVerified(ctx, s, id)
+	if err == nil {
+		g.Close()
+		os.Remove(g.Name())
+		return
+	}
+	if !badCopy(err) || errors.Is(err, os.ErrNotExist) {
+		return
+	}
+	cs.RemoveContext(ctx, id)
+	put()
+}
+
+// badCopy reports whether err, from reading an object, shows the stored
+// copy to be missing or damaged.
+func badCopy(err error) bool {
+	return errors.Is(err, os.ErrNotExist) || errors.Is(err, ErrInvalidID) || errors.Is(err, errBadShard)
+}
+
+// without returns the elements of a not in b.
+func without(a []int, b map[int]error) []int {
+	var out []int
+	for _, x := range a {
+		if _, ok := b[x]; !ok {
+			out = append(out, x)
+		}
+	}
+	return out
+}
+
+// OpenMany finds which member store has each ID with one HasMany per
+// member, then opens each from the first member that has it. With a read
+// policy other than ReadFirst, or with repair, each is opened as by
+// OpenContext.
+func (m *MultiStore) OpenMany(ctx context.Context, ids []c4.ID, fn func(id c4.ID, r io.Reader, err error) error) error {
+	if m.read != ReadFirst || m.repair {
+		return openMany(ctx, ids, maxConcurrentRequests, m.OpenContext, fn)
+	}
+	where, err := m.locate(ctx, ids)
+	if err != nil {
+		return err
+	}
+	member := make(map[c4.ID]int, len(ids))
+	for i, id := range ids {
+		member[id] = where[i]
+	}
+	open := func(ctx context.Context, id c4.ID) (io.ReadCloser, error) {
+		i := member[id]
+		if i < 0 {
+			return nil, fmt.Errorf("c4 id %s not found in any store", id)
+		}
+		return WithContext(m.stores[i]).OpenContext(ctx, id)
+	}
+	return openMany(ctx, ids, maxConcurrentRequests, open, fn)
+}
+
+// syncMembers returns the indexes of the WriteSync members, and how many
+// of them must succeed.
+func (m *MultiStore) syncMembers() ([]int, int) {
+	var members []int
+	for i, w := range m.write {
+		if w == WriteSync {
+			members = append(members, i)
+		}
+	}
+	need := m.quorum
+	if need <= 0 || need > len(members) {
+		need = len(members)
+	}
+	return members, need
+}
+
+// written returns how many members receive writes.
+func (m *MultiStore) written() int {
+	n := 0
+	for _, w := range m.write {
+		if w != WriteNone {
+			n++
+		}
+	}
+	return n
+}
+
+// queued queues id to be copied to the members that lack it, if fewer
+// than all the members that receive writes have stored it.
+func (m *MultiStore) queued(id c4.ID, stored int) error {
+	if stored >= m.written() {
+		return nil
+	}
+	if err := m.queue.add(id); err != nil {
+		return err
+	}
+	m.kick()
+	return nil
+}
+
+// CreateContext creates id on every WriteSync member. Close succeeds if
+// the quorum of them stored it.
+func (m *MultiStore) CreateContext(ctx context.Context, id c4.ID) (io.WriteCloser, error) {
+	members, need := m.syncMembers()
+	if len(members) == 0 {
 		return nil, ErrNotImplemented
 	}
-	return m.stores[0].Create(id)
+	if len(members) == 1 && m.written() == 1 {
+		return WithContext(m.stores[members[0]]).CreateContext(ctx, id)
+	}
+	w := &multiWriter{m: m, id: id, need: need}
+	for _, i := range members {
+		cw, err := WithContext(m.stores[i]).CreateContext(ctx, id)
+		switch {
+		case err == nil:
+			w.ws = append(w.ws, cw)
+		case os.IsExist(err):
+			w.present++
+		default:
+			w.failed++
+			w.err = err
+		}
+	}
+	if len(w.ws) == 0 {
+		if w.present < need {
+			return nil, w.err
+		}
+		// Already stored where it is needed; say so as a single store would.
+		if err := m.queued(id, w.present); err != nil {
+			return nil, err
+		}
+		return nil, &os.PathError{Op: "create", Path: id.String(), Err: os.ErrExist}
+	}
+	w.errs = make([]error, len(w.ws))
+	return w, nil
 }
 
-func (m *MultiStore) Put(r io.Reader) (c4.ID, error) {
-	if len(m.stores) == 0 {
+// multiWriter is the writer returned by MultiStore.CreateContext. A member
+// whose write fails is dropped; the rest carry on.
+type multiWriter struct {
+	m       *MultiStore
+	id      c4.ID
+	need    int
+	ws      []io.WriteCloser
+	errs    []error
+	present int // members that already had the content
+	failed  int // members that could not create it
+	err     error
+}
+
+func (w *multiWriter) Write(p []byte) (int, error) {
+	ok := false
+	for k, cw := range w.ws {
+		if w.errs[k] != nil {
+			continue
+		}
+		if _, err := cw.Write(p); err != nil {
+			w.errs[k] = err
+			continue
+		}
+		ok = true
+	}
+	if !ok {
+		return 0, w.firstErr()
+	}
+	return len(p), nil
+}
+
+func (w *multiWriter) firstErr() error {
+	for _, err := range w.errs {
+		if err != nil {
+			return err
+		}
+	}
+	return w.err
+}
+
+func (w *multiWriter) Close() error {
+	stored := w.present
+	for k, cw := range w.ws {
+		err := cw.Close()
+		if w.errs[k] == nil {
+			w.errs[k] = err
+		}
+		if w.errs[k] == nil {
+			stored++
+		}
+	}
+	if stored < w.need {
+		total := w.present + w.failed + len(w.ws)
+		return fmt.Errorf("stored on %d of %d stores, %d needed: %w", stored, total, w.need, w.firstErr())
+	}
+	return w.m.queued(w.id, stored)
+}
+
+// PutContext stores the content of r on every WriteSync member at once.
+// It succeeds if the quorum of them stored it.
+func (m *MultiStore) PutContext(ctx context.Context, r io.Reader) (c4.ID, error) {
+	members, need := m.syncMembers()
+	if len(members) == 0 {
 		return c4.ID{}, ErrNotImplemented
 	}
-	return m.stores[0].Put(r)
+	if len(members) == 1 {
+		id, err := WithContext(m.stores[members[0]]).PutContext(ctx, r)
+		if err != nil {
+			return c4.ID{}, err
+		}
+		return id, m.queued(id, 1)
+	}
+
+	// Each member reads its own pipe; a member that fails closes its pipe,
+	// which drops it from the copy.
+	ids := make([]c4.ID, len(members))
+	errs := make([]error, len(members))
+	pws := make([]*io.PipeWriter, len(members))
+	ws := make([]io.Writer, len(members))
+	var wg sync.WaitGroup
+	for k, i := range members {
+		pr, pw := io.Pipe()
+		pws[k], ws[k] = pw, pw
+		wg.Add(1)
+		go func(k int, s ContextStore) {
+			defer wg.Done()
+			ids[k], errs[k] = s.PutContext(ctx, pr)
+			if errs[k] != nil {
+				pr.CloseWithError(errs[k])
+			} else {
+				pr.Close()
+			}
+		}(k, WithContext(m.stores[i]))
+	}
+	_, err := io.Copy(&teeWriter{ws: ws, errs: make([]error, len(ws))}, r)
+	for _, pw := range pws {
+		pw.CloseWithError(err)
+	}
+	wg.Wait()
+	if err != nil {
+		return c4.ID{}, err
+	}
+
+	var id c4.ID
+	stored := 0
+	var firstErr error
+	for k := range members {
+		switch {
+		case errs[k] != nil:
+			if firstErr == nil {
+				firstErr = errs[k]
+			}
+		case stored > 0 && ids[k] != id:
+			return c4.ID{}, fmt.Errorf("stores disagree on the ID of the content: %s and %s", id, ids[k])
+		default:
+			id = ids[k]
+			stored++
+		}
+	}
+	if stored < need {
+		return c4.ID{}, fmt.Errorf("stored on %d of %d stores, %d needed: %w", stored, len(members), need, firstErr)
+	}
+	return id, m.queued(id, stored)
 }
 
-func (m *MultiStore) Remove(id c4.ID) error {
-	// Remove from all stores that have it.
+// teeWriter writes to each of ws, dropping any that fail. It fails only
+// when all of them have.
+type teeWriter struct {
+	ws   []io.Writer
+	errs []error
+}
+
+func (t *teeWriter) Write(p []byte) (int, error) {
+	var last error
+	for k, w := range t.ws {
+		if t.errs[k] != nil {
+			last = t.errs[k]
+			continue
+		}
+		if _, err := w.Write(p); err != nil {
+			t.errs[k] = err
+			last = err
+		}
+	}
+	for _, err := range t.errs {
+		if err == nil {
+			return len(p), nil
+		}
+	}
+	return 0, last
+}
+
+// RemoveContext removes id from every member store that has it.
+func (m *MultiStore) RemoveContext(ctx context.Context, id c4.ID) error {
 	var lastErr error
 	for _, s := range m.stores {
-		if s.Has(id) {
-			if err := s.Remove(id); err != nil {
+		cs := WithContext(s)
+		has, err := cs.HasContext(ctx, id)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if has {
+			if err := cs.RemoveContext(ctx, id); err != nil {
 				lastErr = err
 			}
 		}
 	}
 	return lastErr
 }
+
+// replicationRetry is how long the background worker waits after failing
+// to copy queued content before trying again.
+var replicationRetry = 30 * time.Second
+
+// kick starts the background worker if it is not running.
+func (m *MultiStore) kick() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	select {
+	case <-m.closed:
+		return
+	default:
+	}
+	if m.running {
+		return
+	}
+	m.running = true
+	m.wg.Add(1)
+	go m.work()
+}
+
+// work drains the queue until it is empty or the store is closed.
+func (m *MultiStore) work() {
+	defer m.wg.Done()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-m.closed:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	for {
+		err := m.Drain(ctx)
+		m.mu.Lock()
+		if err == nil && m.queue.len() == 0 || ctx.Err() != nil {
+			m.running = false
+			m.mu.Unlock()
+			return
+		}
+		m.mu.Unlock()
+		if err != nil {
+			select {
+			case <-time.After(replicationRetry):
+			case <-ctx.Done():
+			}
+		}
+	}
+}
+
+// Drain copies each queued object to the members that should have it and
+// lack it, from a member that has it, and takes it off the queue. Objects
+// that fail to copy stay queued, and the first error is returned. An
+// object no member has, as after Remove, is dropped from the queue.
+func (m *MultiStore) Drain(ctx context.Context) error {
+	m.drainMu.Lock()
+	defer m.drainMu.Unlock()
+	var firstErr error
+	for _, id := range m.queue.pending() {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := m.copyQueued(ctx, id); err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		if err := m.queue.done(id); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// Queued returns how many objects are waiting to be copied to members.
+func (m *MultiStore) Queued() int {
+	return m.queue.len()
+}
+
+// MultiStores returns the MultiStores in s, looking through wrappers and
+// caches, and the MultiStores among the members of each.
+func MultiStores(s Store) []*MultiStore {
+	var stores []*MultiStore
+	walkStores(s, func(s Store) {
+		if m, ok := s.(*MultiStore); ok {
+			stores = append(stores, m)
+		}
+	})
+	return stores
+}
+
+// copyQueued copies id to every written member that lacks it.
+func (m *MultiStore) copyQueued(ctx context.Context, id c4.ID) error {
+	src := -1
+	var lacking []int
+	for i, s := range m.stores {
+		switch {
+		case s.Has(id):
+			if src < 0 {
+				src = i
+			}
+		case m.write[i] != WriteNone:
+			lacking = append(lacking, i)
+		}
+	}
+	if src < 0 {
+		return nil
+	}
+	for _, i := range lacking {
+		r := replicateOne(ctx, m.stores[src], m.stores[i], id, ReplicateOptions{})
+		if r.err != nil {
+			return fmt.Errorf("copy %s to store %d: %w", id, i+1, r.err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,407 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Avalanche-io/c4"
+)
+
+var errBroken = errors.New("store is broken")
+
+// brokenStore fails every write while broken is set.
+type brokenStore struct {
+	Store
+	broken int32
+}
+
+func (b *brokenStore) Create(id c4.ID) (io.WriteCloser, error) {
+	if atomic.LoadInt32(&b.broken) != 0 {
+		return nil, errBroken
+	}
+	return b.Store.Create(id)
+}
+
+func (b *brokenStore) Put(r io.Reader) (c4.ID, error) {
+	if atomic.LoadInt32(&b.broken) != 0 {
+		return c4.ID{}, errBroken
+	}
+	return b.Store.Put(r)
+}
+
+// slowStore delays every Open.
+type slowStore struct {
+	Store
+	delay time.Duration
+}
+
+func (s slowStore) Open(id c4.ID) (io.ReadCloser, error) {
+	time.Sleep(s.delay)
+	return s.Store.Open(id)
+}
+
+func TestParseMultiPolicies(t *testing.T) {
+	for w := WriteNone; w <= WriteAsync; w++ {
+		if got, err := ParseWriteMode(w.String()); err != nil || got != w {
+			t.Errorf("ParseWriteMode(%q) = %v, %v", w, got, err)
+		}
+	}
+	for p := ReadFirst; p <= ReadVerify; p++ {
+		if got, err := ParseReadPolicy(p.String()); err != nil || got != p {
+			t.Errorf("ParseReadPolicy(%q) = %v, %v", p, got, err)
+		}
+	}
+	if _, err := ParseWriteMode("all"); err == nil {
+		t.Error("ParseWriteMode accepted all")
+	}
+	if _, err := ParseReadPolicy("random"); err == nil {
+		t.Error("ParseReadPolicy accepted random")
+	}
+}
+
+func TestMultiStoreWritesFirst(t *testing.T) {
+	a, b := NewRAM(), NewRAM()
+	m := NewMultiStore(a, b)
+	id, err := m.Put(strings.NewReader("only the first"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !a.Has(id) || b.Has(id) {
+		t.Error("by default only the first store is written")
+	}
+}
+
+func TestMultiStoreWriteAll(t *testing.T) {
+	members := []*RAM{NewRAM(), NewRAM(), NewRAM()}
+	m := NewMultiStore(members[0], members[1], members[2])
+	for i := range members {
+		m.SetWrite(i, WriteSync)
+	}
+
+	data := randomBytes(1, 200<<10)
+	id, err := m.Put(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != c4.Identify(bytes.NewReader(data)) {
+		t.Fatalf("Put returned %s", id)
+	}
+	id2 := testID("created")
+	w, err := m.Create(id2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte("created"))
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	for i, s := range members {
+		if !s.Has(id) || !s.Has(id2) {
+			t.Errorf("store %d was not written", i)
+		}
+	}
+	// Creating what every member has is refused, as by a single store.
+	if _, err := m.Create(id2); !os.IsExist(err) {
+		t.Errorf("Create of stored content: %v, want ErrExist", err)
+	}
+}
+
+func TestMultiStoreQuorum(t *testing.T) {
+	a, c := NewRAM(), NewRAM()
+	b := &brokenStore{Store: NewRAM(), broken: 1}
+	m := NewMultiStore(a, b, c)
+	for i := 0; i < 3; i++ {
+		m.SetWrite(i, WriteSync)
+	}
+	defer m.Close()
+
+	// All three are needed by default.
+	if _, err := m.Put(strings.NewReader("all or nothing")); !errors.Is(err, errBroken) {
+		t.Errorf("Put with a broken member: %v, want errBroken", err)
+	}
+
+	defer func(d time.Duration) { replicationRetry = d }(replicationRetry)
+	replicationRetry = time.Hour
+	m.SetQuorum(2)
+	id, err := m.Put(strings.NewReader("two of three"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := m.Create(testID("made"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte("made"))
+	if err := w.Close(); err != nil {
+		t.Fatalf("Create with a quorum: %v", err)
+	}
+	if !a.Has(id) || !c.Has(id) || b.Has(id) {
+		t.Fatal("quorum write went to the wrong stores")
+	}
+
+	// The member that failed is caught up later.
+	atomic.StoreInt32(&b.broken, 0)
+	if err := m.Drain(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if !b.Has(id) || !b.Has(testID("made")) {
+		t.Error("Drain did not copy to the member that failed")
+	}
+	if n := m.queue.len(); n != 0 {
+		t.Errorf("%d objects still queued", n)
+	}
+
+	m.SetQuorum(3)
+	atomic.StoreInt32(&b.broken, 1)
+	if _, err := m.Put(strings.NewReader("three of three")); err == nil {
+		t.Error("Put succeeded short of its quorum")
+	}
+}
+
+func TestMultiStoreAsync(t *testing.T) {
+	defer func(d time.Duration) { replicationRetry = d }(replicationRetry)
+	replicationRetry = time.Hour
+	dir := t.TempDir()
+
+	primary := NewRAM()
+	archive := &brokenStore{Store: NewRAM(), broken: 1}
+	m := NewMultiStore(primary, archive)
+	m.SetWrite(1, WriteAsync)
+	if err := m.SetQueue(dir); err != nil {
+		t.Fatal(err)
+	}
+	ids := putN(t, m, 3, 1000)
+	for _, id := range ids {
+		if !primary.Has(id) {
+			t.Fatal("async write did not store to the sync member")
+		}
+	}
+	if err := m.Drain(context.Background()); !errors.Is(err, errBroken) {
+		t.Fatalf("Drain to a broken member: %v", err)
+	}
+	if err := m.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Another process picks up what was left queued.
+	atomic.StoreInt32(&archive.broken, 0)
+	m2 := NewMultiStore(primary, archive)
+	m2.SetWrite(1, WriteAsync)
+	if err := m2.SetQueue(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer m2.Close()
+	if n := m2.queue.len(); n != 3 {
+		t.Fatalf("reopened queue has %d objects, want 3", n)
+	}
+	if err := m2.Drain(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range ids {
+		if !archive.Has(id) {
+			t.Errorf("%s was not copied to the async member", id)
+		}
+	}
+
+	// The background worker copies new content without a Drain.
+	id, err := m2.Put(strings.NewReader("in the background"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for !archive.Has(id) {
+		if time.Now().After(deadline) {
+			t.Fatal("background worker did not copy new content")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestMultiStoreReadVerifyRepair(t *testing.T) {
+	bad, good := NewRAM(), NewRAM()
+	id, _ := good.Put(strings.NewReader("the real thing"))
+	w, _ := bad.Create(id)
+	w.Write([]byte("bit rot"))
+	w.Close()
+
+	m := NewMultiStore(bad, good)
+	if got := string(readAll(t, m, id)); got != "bit rot" {
+		t.Fatalf("first-hit read %q", got)
+	}
+
+	m.SetRead(ReadVerify)
+	if got := string(readAll(t, m, id)); got != "the real thing" {
+		t.Errorf("verified read %q", got)
+	}
+	if got := string(readAll(t, bad, id)); got != "bit rot" {
+		t.Error("a bad copy was repaired without SetRepair")
+	}
+
+	m.SetRepair(true)
+	readAll(t, m, id)
+	if got := string(readAll(t, bad, id)); got != "the real thing" {
+		t.Errorf("repaired copy reads %q", got)
+	}
+
+	// Content no member has intact fails.
+	w, _ = good.Create(testID("lost"))
+	w.Write([]byte("garbled"))
+	w.Close()
+	if _, err := m.Open(testID("lost")); err != ErrInvalidID {
+		t.Errorf("Open of content with no good copy: %v, want ErrInvalidID", err)
+	}
+}
+
+// failingOpen fails to open anything.
+type failingOpen struct{ Store }
+
+func (failingOpen) Open(id c4.ID) (io.ReadCloser, error) {
+	return nil, fmt.Errorf("disk error reading %s", id)
+}
+
+func TestMultiStoreRepairOnOpenFailure(t *testing.T) {
+	backing, good := NewRAM(), NewRAM()
+	id, _ := good.Put(strings.NewReader("replicated"))
+	w, _ := backing.Create(id)
+	w.Write([]byte("unreadable"))
+	w.Close()
+
+	m := NewMultiStore(failingOpen{noRemove{backing, t}}, good)
+	m.SetRepair(true)
+	if got := string(readAll(t, m, id)); got != "replicated" {
+		t.Errorf("read %q after a failed open", got)
+	}
+	// A disk error says nothing about the copy, so it is left alone.
+	if got := string(readAll(t, backing, id)); got != "unreadable" {
+		t.Errorf("member that failed to open was rewritten to %q", got)
+	}
+
+	// A member found to be missing the content is written to, and nothing
+	// is removed from it.
+	lost := NewRAM()
+	missing := &os.PathError{Op: "open", Path: id.String(), Err: os.ErrNotExist}
+	m.repairFrom(context.Background(), noRemove{lost, t}, id, spoolTest(t, good, id), missing)
+	if got := string(readAll(t, lost, id)); got != "replicated" {
+		t.Errorf("missing copy repaired to %q", got)
+	}
+}
+
+// spoolTest returns the verified content of id in s in a temp file.
+func spoolTest(t *testing.T, s Store, id c4.ID) *os.File {
+	t.Helper()
+	f, err := spoolVerified(context.Background(), s, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		f.Close()
+		os.Remove(f.Name())
+	})
+	return f
+}
+
+// countingHas counts the calls to Has.
+type countingHas struct {
+	Store
+	calls int32
+}
+
+func (c *countingHas) Has(id c4.ID) bool {
+	atomic.AddInt32(&c.calls, 1)
+	return c.Store.Has(id)
+}
+
+// With ReadFirst, a member after the one that has the content is not asked.
+func TestMultiStoreReadFirstAsksInOrder(t *testing.T) {
+	first, second := NewRAM(), &countingHas{Store: NewRAM()}
+	id, _ := first.Put(strings.NewReader("local"))
+
+	m := NewMultiStore(first, second)
+	if got := string(readAll(t, m, id)); got != "local" {
+		t.Errorf("read %q", got)
+	}
+	if n := atomic.LoadInt32(&second.calls); n != 0 {
+		t.Errorf("second member asked %d times for content the first has", n)
+	}
+	if _, err := m.Open(testID("missing")); err == nil {
+		t.Error("Open of missing content succeeded")
+	}
+}
+
+func TestMultiStoreReadFastest(t *testing.T) {
+	slow, fast := NewRAM(), NewRAM()
+	id, _ := slow.Put(strings.NewReader("either"))
+	fast.Put(strings.NewReader("either"))
+
+	m := NewMultiStore(slowStore{slow, time.Second}, fast)
+	m.SetRead(ReadFastest)
+	start := time.Now()
+	if got := string(readAll(t, m, id)); got != "either" {
+		t.Errorf("read %q", got)
+	}
+	if d := time.Since(start); d >= time.Second {
+		t.Errorf("read took %v, waiting for the slow member", d)
+	}
+	checkOpenMany(t, "fastest", m, []c4.ID{id}, testID("missing"))
+}
+
+func TestSplitStoreOptions(t *testing.T) {
+	for _, c := range []struct {
+		in, uri string
+		opts    map[string]string
+	}{
+		{"/data/c4", "/data/c4", nil},
+		{"/Volumes/My Drive/c4", "/Volumes/My Drive/c4", nil},
+		{"/mnt/archive write=async", "/mnt/archive", map[string]string{"write": "async"}},
+		{"s3://b/c4?region=x  write=sync quorum=2", "s3://b/c4?region=x", map[string]string{"write": "sync", "quorum": "2"}},
+		{"/My Drive/c4 read=verify", "/My Drive/c4", map[string]string{"read": "verify"}},
+		{"/a b=c", "/a b=c", nil},
+	} {
+		uri, opts := splitStoreOptions(c.in)
+		if uri != c.uri || fmt.Sprint(opts) != fmt.Sprint(c.opts) {
+			t.Errorf("splitStoreOptions(%q) = %q, %v; want %q, %v", c.in, uri, opts, c.uri, c.opts)
+		}
+	}
+}
+
+func TestOpenURIMultiOptions(t *testing.T) {
+	dir := t.TempDir()
+	a, b := filepath.Join(dir, "a"), filepath.Join(dir, "b")
+	queue := filepath.Join(dir, "queue")
+	s, err := OpenURI(a + " read=verify quorum=1," + b + " write=async queue=" + queue)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, ok := s.(*MultiStore)
+	if !ok {
+		t.Fatalf("opened a %T", s)
+	}
+	defer m.Close()
+	if m.write[0] != WriteSync || m.write[1] != WriteAsync || m.read != ReadVerify || m.quorum != 1 {
+		t.Errorf("options not applied: write %v, read %v, quorum %d", m.write, m.read, m.quorum)
+	}
+	if _, err := os.Stat(filepath.Join(queue, "queue")); err != nil {
+		t.Errorf("queue not kept in the given directory: %v", err)
+	}
+
+	for _, bad := range []string{
+		a + " read=verify," + b + " read=fastest",
+		a + " write=none," + b,
+		a + " write=always," + b,
+		a + " quorum=0," + b,
+	} {
+		if s, err := OpenURI(bad); err == nil {
+			s.(*MultiStore).Close()
+			t.Errorf("OpenURI(%q) succeeded", bad)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package store
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/Avalanche-io/c4"
+)
+
+// writeQueue is the set of objects a MultiStore has yet to copy to all the
+// members that receive writes. A persistent queue is kept in dir/queue, an
+// append-only file of "+ <id>" lines for queued objects and "- <id>" lines
+// for those copied, kept by an appendLog. Without a dir it is only kept in
+// memory.
+type writeQueue struct {
+	mu   sync.Mutex
+	ids  map[c4.ID]int // queued IDs, in the order they were queued
+	next int
+	log  *appendLog
+}
+
+func newWriteQueue() *writeQueue {
+	q := &writeQueue{ids: make(map[c4.ID]int)}
+	q.log = newAppendLog("", "", q)
+	return q
+}
+
+// openWriteQueue opens or creates the persistent queue in dir.
+func openWriteQueue(dir string) (*writeQueue, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("create write queue: %w", err)
+	}
+	q := newWriteQueue()
+	q.log = newAppendLog(dir, "queue", q)
+	if err := q.update(func() error { return nil }); err != nil {
+		return nil, err
+	}
+	return q, nil
+}
+
+// add queues id.
+func (q *writeQueue) add(id c4.ID) error {
+	return q.update(func() error {
+		if _, ok := q.ids[id]; ok {
+			return nil
+		}
+		return q.log.record("+ " + id.String())
+	})
+}
+
+// done takes id off the queue.
+func (q *writeQueue) done(id c4.ID) error {
+	return q.update(func() error {
+		if _, ok := q.ids[id]; !ok {
+			return nil
+		}
+		return q.log.record("- " + id.String())
+	})
+}
+
+// pending returns the queued IDs, oldest first, including those queued by
+// other processes.
+func (q *writeQueue) pending() []c4.ID {
+	var ids []c4.ID
+	q.update(func() error {
+		ids = q.queued()
+		return nil
+	})
+	return ids
+}
+
+// len returns how many IDs are queued, as of the last time the queue was
+// read.
+func (q *writeQueue) len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.ids)
+}
+
+// Close closes the queue file.
+func (q *writeQueue) Close() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.log.close()
+}
+
+// update runs fn with the queue locked, after applying what other
+// processes have appended to it.
+func (q *writeQueue) update(fn func() error) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.log.update(fn)
+}
+
+// queued returns the queued IDs, oldest first.
+func (q *writeQueue) queued() []c4.ID {
+	ids := make([]c4.ID, 0, len(q.ids))
+	for id := range q.ids {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return q.ids[ids[i]] < q.ids[ids[j]] })
+	return ids
+}
+
+func (q *writeQueue) resetLog() { q.ids = make(map[c4.ID]int) }
+
+// applyLine applies one queue line. Lines it cannot parse are ignored.
+func (q *writeQueue) applyLine(line string) {
+	if len(line) < 2 || line[1] != ' ' {
+		return
+	}
+	id, err := c4.Parse(line[2:])
+	if err != nil {
+		return
+	}
+	switch line[0] {
+	case '+':
+		if _, ok := q.ids[id]; !ok {
+			q.ids[id] = q.next
+			q.next++
+		}
+	case '-':
+		delete(q.ids, id)
+	}
+}
+
+func (q *writeQueue) logLines() int { return len(q.ids) }
+
+// writeLog writes one line per queued ID, oldest first.
+func (q *writeQueue) writeLog(w io.Writer) {
+	for _, id := range q.queued() {
+		fmt.Fprintf(w, "+ %s\n", id)
+	}
+}
@@ -0,0 +1,884 @@
+package store
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Avalanche-io/c4"
+)
+
+var _ Store = &PackStore{}
+
+const (
+	// DefaultPackThreshold is the size below which a PackStore packs
+	// objects rather than passing them to its large-object store.
+	DefaultPackThreshold = 64 << 10
+
+	// DefaultPackSize is the size at which a pack file is sealed and a new
+	// one started.
+	DefaultPackSize = 256 << 20
+)
+
+// PackStore keeps small objects in large append-only pack files, each with
+// an on-disk index sorted by ID, instead of one file per object. Objects at
+// or above a size threshold go to a separate store, typically a TreeStore
+// or S3.
+//
+// New objects are appended to the open pack and synced before Put returns.
+// When the open pack reaches its size limit it is sealed: its index is
+// written to a temp file and renamed into place. Until then its records
+// are found by scanning it, so a crash loses at most a partly written last
+// record, which is truncated away by the next writer. Removal appends a
+// tombstone, and Repack rewrites the live objects into fresh packs.
+//
+// Several processes may share a PackStore; writes are serialized with a
+// lock file, and each process picks up the others' packs as it needs them.
+type PackStore struct {
+	root      string
+	large     Store
+	threshold int64
+	packSize  int64
+
+	mu    sync.RWMutex
+	packs []*pack // ascending sequence order; the last unsealed pack is open for appends
+
+	// The modification time of the directory at the last refresh, and when
+	// it was read, to tell whether another refresh could find anything.
+	dirMod, dirRead time.Time
+}
+
+// packDirSlack is how recent a change to the directory can be for its
+// modification time not to be trusted to show the next one, on file
+// systems that keep coarse times.
+const packDirSlack = 2 * time.Second
+
+// NewPackStore opens or creates a PackStore in dir. Objects of threshold
+// bytes or more go to large; if large is nil, every object is packed.
+func NewPackStore(dir string, large Store, threshold int64) (*PackStore, error) {
+	if threshold <= 0 {
+		threshold = DefaultPackThreshold
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	s := &PackStore{root: dir, large: large, threshold: threshold, packSize: DefaultPackSize}
+	unlock := s.lock(false)
+	defer unlock()
+	if err := s.refresh(false); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Close closes the pack files.
+func (s *PackStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, p := range s.packs {
+		p.close()
+	}
+	s.packs = nil
+	return nil
+}
+
+// Pack files are named by sequence number. A pack is a header followed by
+// records: an ID digest, a big-endian int64 length (-1 for a tombstone)
+// and the content. An index is a header, a fanout table counting the
+// entries whose first ID byte is at most each value, and entries of an ID
+// digest, offset and length, sorted by ID.
+const (
+	packHeader     = "C4PK\x01\x00\x00\x00"
+	packIdxHeader  = "C4PI\x01\x00\x00\x00"
+	packRecordHead = 64 + 8
+	packIdxEntry   = 64 + 8 + 8
+	packFanoutSize = 256 * 4
+)
+
+type packEntry struct {
+	off  int64 // of the content
+	size int64 // -1 for a tombstone
+}
+
+type pack struct {
+	seq  int
+	data *os.File
+	mod  time.Time
+
+	// Sealed packs are searched through their index.
+	idx    *os.File
+	fanout [256]uint32
+
+	// Unsealed packs are scanned into memory.
+	entries map[c4.ID]packEntry
+	scanned int64
+	size    int64 // of the data file when last scanned
+}
+
+func (p *pack) sealed() bool { return p.idx != nil }
+
+func (p *pack) close() {
+	p.data.Close()
+	if p.idx != nil {
+		p.idx.Close()
+	}
+}
+
+func (s *PackStore) packPath(seq int, ext string) string {
+	return filepath.Join(s.root, fmt.Sprintf("pack-%08d%s", seq, ext))
+}
+
+// lock takes the in-process lock and the lock file, shared or exclusive.
+// The in-process lock is always exclusive, since even a shared refresh
+// updates the pack list.
+func (s *PackStore) lock(exclusive bool) (unlock func()) {
+	s.mu.Lock()
+	f, err := lockFile(filepath.Join(s.root, treeLockName), exclusive)
+	return func() {
+		if err == nil {
+			f.Close()
+		}
+		s.mu.Unlock()
+	}
+}
+
+// refresh brings the pack list up to date with the directory: packs sealed
+// or written by other processes are opened, repacked ones dropped, and
+// records appended to unsealed packs scanned. With repair, which needs the
+// exclusive lock, a torn last record is truncated and stray indexes are
+// removed.
+func (s *PackStore) refresh(repair bool) error {
+	dir, err := os.Stat(s.root)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	names, err := os.ReadDir(s.root)
+	if err != nil {
+		return err
+	}
+	onDisk := make(map[int]bool) // sequence -> has index
+	for _, e := range names {
+		name := e.Name()
+		if !strings.HasPrefix(name, "pack-") {
+			continue
+		}
+		ext := filepath.Ext(name)
+		seq, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(name, "pack-"), ext))
+		if err != nil {
+			continue
+		}
+		switch ext {
+		case ".pack":
+			if _, ok := onDisk[seq]; !ok {
+				onDisk[seq] = false
+			}
+		case ".idx":
+			onDisk[seq] = true
+		}
+	}
+
+	known := make(map[int]*pack)
+	for _, p := range s.packs {
+		known[p.seq] = p
+	}
+	var packs []*pack
+	for seq, hasIdx := range onDisk {
+		if hasIdx {
+			if _, err := os.Stat(s.packPath(seq, ".pack")); err != nil {
+				// An index whose pack was removed by an interrupted repack.
+				if repair {
+					os.Remove(s.packPath(seq, ".idx"))
+				}
+				continue
+			}
+		}
+		p := known[seq]
+		delete(known, seq)
+		if p == nil || (hasIdx && !p.sealed()) {
+			if p != nil {
+				p.close()
+			}
+			if p, err = s.openPack(seq, hasIdx); err != nil {
+				return err
+			}
+		}
+		packs = append(packs, p)
+	}
+	for _, p := range known {
+		p.close()
+	}
+	sort.Slice(packs, func(i, j int) bool { return packs[i].seq < packs[j].seq })
+	s.packs = packs
+
+	for _, p := range s.packs {
+		if !p.sealed() {
+			if err := s.scan(p, repair); err != nil {
+				return err
+			}
+		}
+	}
+	s.dirMod, s.dirRead = dir.ModTime(), now
+	return nil
+}
+
+// changed reports whether the directory or an unsealed pack may have
+// changed since the last refresh: packs are added, sealed and removed in
+// the directory, and records appended to unsealed packs. It requires mu,
+// shared or exclusive.
+func (s *PackStore) changed() bool {
+	dir, err := os.Stat(s.root)
+	if err != nil || !dir.ModTime().Equal(s.dirMod) || s.dirRead.Sub(s.dirMod) < packDirSlack {
+		return true
+	}
+	for _, p := range s.packs {
+		if p.sealed() {
+			continue
+		}
+		if info, err := p.data.Stat(); err != nil || info.Size() != p.size {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *PackStore) openPack(seq int, hasIdx bool) (*pack, error) {
+	data, err := os.OpenFile(s.packPath(seq, ".pack"), os.O_RDWR, 0)
+	if os.IsPermission(err) {
+		data, err = os.Open(s.packPath(seq, ".pack"))
+	}
+	if err != nil {
+		return nil, err
+	}
+	p := &pack{seq: seq, data: data, scanned: int64(len(packHeader))}
+	if info, err := data.Stat(); err == nil {
+		p.mod = info.ModTime()
+	}
+	if !hasIdx {
+		p.entries = make(map[c4.ID]packEntry)
+		return p, nil
+	}
+	idx, err := os.Open(s.packPath(seq, ".idx"))
+	if err != nil {
+		data.Close()
+		return nil, err
+	}
+	head := make([]byte, len(packIdxHeader)+packFanoutSize)
+	if _, err := io.ReadFull(idx, head); err != nil || string(head[:len(packIdxHeader)]) != packIdxHeader {
+		data.Close()
+		idx.Close()
+		return nil, fmt.Errorf("%s: bad pack index", idx.Name())
+	}
+	for i := range p.fanout {
+		p.fanout[i] = binary.BigEndian.Uint32(head[len(packIdxHeader)+4*i:])
+	}
+	p.idx = idx
+	return p, nil
+}
+
+// scan reads the records of an unsealed pack written since the last scan.
+// Records whose content does not match their ID are skipped. A record cut
+// short by a crash ends the scan, and with repair is truncated away.
+func (s *PackStore) scan(p *pack, repair bool) error {
+	info, err := p.data.Stat()
+	if err != nil {
+		return err
+	}
+	end := info.Size()
+	p.mod, p.size = info.ModTime(), end
+	if p.scanned == int64(len(packHeader)) && end < p.scanned {
+		if repair {
+			// Created but its header never written.
+			_, err := p.data.WriteAt([]byte(packHeader), 0)
+			return err
+		}
+		return nil
+	}
+	r := bufio.NewReaderSize(io.NewSectionReader(p.data, p.scanned, end-p.scanned), 1<<20)
+	head := make([]byte, packRecordHead)
+	var buf []byte
+	for p.scanned < end {
+		if _, err := io.ReadFull(r, head); err != nil {
+			break
+		}
+		var id c4.ID
+		copy(id[:], head)
+		size := int64(binary.BigEndian.Uint64(head[64:]))
+		off := p.scanned + packRecordHead
+		if size < -1 || off+size > end {
+			break
+		}
+		if size >= 0 {
+			if int64(cap(buf)) < size {
+				buf = make([]byte, size)
+			}
+			buf = buf[:size]
+			if _, err := io.ReadFull(r, buf); err != nil {
+				break
+			}
+			if c4.Identify(bytes.NewReader(buf)) == id {
+				p.entries[id] = packEntry{off, size}
+			}
+			p.scanned = off + size
+			continue
+		}
+		p.entries[id] = packEntry{off, -1}
+		p.scanned = off
+	}
+	if p.scanned < end && repair {
+		return p.data.Truncate(p.scanned)
+	}
+	return nil
+}
+
+// find looks id up in a single pack.
+func (p *pack) find(id c4.ID) (packEntry, bool, error) {
+	if !p.sealed() {
+		e, ok := p.entries[id]
+		return e, ok, nil
+	}
+	lo := 0
+	if id[0] > 0 {
+		lo = int(p.fanout[id[0]-1])
+	}
+	hi := int(p.fanout[id[0]])
+	entry := make([]byte, packIdxEntry)
+	base := int64(len(packIdxHeader) + packFanoutSize)
+	for lo < hi {
+		mid := (lo + hi) / 2
+		if _, err := p.idx.ReadAt(entry, base+int64(mid)*packIdxEntry); err != nil {
+			return packEntry{}, false, err
+		}
+		switch c := bytes.Compare(entry[:64], id[:]); {
+		case c == 0:
+			return packEntry{
+				off:  int64(binary.BigEndian.Uint64(entry[64:])),
+				size: int64(binary.BigEndian.Uint64(entry[72:])),
+			}, true, nil
+		case c < 0:
+			lo = mid + 1
+		default:
+			hi = mid
+		}
+	}
+	return packEntry{}, false, nil
+}
+
+// lookup finds the newest record of id, which may be a tombstone.
+func (s *PackStore) lookup(id c4.ID) (*pack, packEntry, bool) {
+	for i := len(s.packs) - 1; i >= 0; i-- {
+		e, ok, err := s.packs[i].find(id)
+		if err == nil && ok {
+			return s.packs[i], e, true
+		}
+	}
+	return nil, packEntry{}, false
+}
+
+// read finds the live record of id, picking up other processes' writes if
+// no record is known yet, and returns its content if content is set. The
+// content is read under the lock, since a repack closes the packs it
+// replaces.
+func (s *PackStore) read(id c4.ID, content bool) (data []byte, live bool, err error) {
+	get := func() (known bool) {
+		p, e, ok := s.lookup(id)
+		if !ok || e.size < 0 {
+			return ok
+		}
+		live = true
+		if content {
+			data = make([]byte, e.size)
+			_, err = p.data.ReadAt(data, e.off)
+		}
+		return true
+	}
+	s.mu.RLock()
+	known := get()
+	stale := !known && s.changed()
+	s.mu.RUnlock()
+	if stale {
+		unlock := s.lock(false)
+		if err = s.refresh(false); err == nil {
+			get()
+		}
+		unlock()
+	}
+	return data, live, err
+}
+
+// Has reports whether id is in a pack or in the large-object store.
+func (s *PackStore) Has(id c4.ID) bool {
+	if _, live, _ := s.read(id, false); live {
+		return true
+	}
+	return s.large != nil && s.large.Has(id)
+}
+
+// Open returns the content of id.
+func (s *PackStore) Open(id c4.ID) (io.ReadCloser, error) {
+	data, live, err := s.read(id, true)
+	if err != nil {
+		return nil, err
+	}
+	if live {
+		return io.NopCloser(bytes.NewReader(data)), nil
+	}
+	if s.large != nil {
+		return s.large.Open(id)
+	}
+	return nil, &os.PathError{Op: "open", Path: id.String(), Err: os.ErrNotExist}
+}
+
+// OpenRange returns part of the content of id. Packed objects are small,
+// so they are read whole; ranges of large objects are passed on.
+func (s *PackStore) OpenRange(id c4.ID, off, n int64) (io.ReadCloser, error) {
+	if off < 0 {
+		return nil, fmt.Errorf("open range of %s: negative offset %d", id, off)
+	}
+	data, live, err := s.read(id, true)
+	if err != nil {
+		return nil, err
+	}
+	if live {
+		return io.NopCloser(bytes.NewReader(sliceRange(data, off, n))), nil
+	}
+	if s.large != nil {
+		return OpenRange(s.large, id, off, n)
+	}
+	return nil, &os.PathError{Op: "open", Path: id.String(), Err: os.ErrNotExist}
+}
+
+// Put stores the content of r, packing it if it is under the threshold.
+func (s *PackStore) Put(r io.Reader) (c4.ID, error) {
+	var data []byte
+	if s.large == nil {
+		var err error
+		if data, err = io.ReadAll(r); err != nil {
+			return c4.ID{}, err
+		}
+	} else {
+		buf := make([]byte, s.threshold)
+		n, err := io.ReadFull(r, buf)
+		switch err {
+		case nil:
+			return s.large.Put(io.MultiReader(bytes.NewReader(buf), r))
+		case io.EOF, io.ErrUnexpectedEOF:
+			data = buf[:n]
+		default:
+			return c4.ID{}, err
+		}
+	}
+	id := c4.Identify(bytes.NewReader(data))
+	return id, s.append(id, data)
+}
+
+// Create returns a writer for id. Content under the threshold is packed on
+// Close; larger content is streamed to the large-object store.
+func (s *PackStore) Create(id c4.ID) (io.WriteCloser, error) {
+	return &packWriter{s: s, id: id}, nil
+}
+
+// Remove appends a tombstone for a packed id and removes it from the
+// large-object store.
+func (s *PackStore) Remove(id c4.ID) error {
+	_, packed, err := s.read(id, false)
+	if err != nil {
+		return err
+	}
+	if packed {
+		if err := s.append(id, nil); err != nil {
+			return err
+		}
+	}
+	if s.large != nil {
+		err := s.large.Remove(id)
+		if err == nil || packed {
+			return nil
+		}
+		return err
+	}
+	if !packed {
+		return &os.PathError{Op: "remove", Path: id.String(), Err: os.ErrNotExist}
+	}
+	return nil
+}
+
+// append writes a record for id, a tombstone if data is nil, to the open
+// pack, sealing it first if it is full.
+func (s *PackStore) append(id c4.ID, data []byte) error {
+	unlock := s.lock(true)
+	defer unlock()
+	if err := s.refresh(true); err != nil {
+		return err
+	}
+	if _, e, ok := s.lookup(id); ok && (e.size >= 0) == (data != nil) {
+		return nil
+	}
+
+	size := int64(len(data))
+	if data == nil {
+		size = -1
+	}
+	p, err := s.openForAppend(packRecordHead + int64(len(data)))
+	if err != nil {
+		return err
+	}
+	rec := make([]byte, packRecordHead, packRecordHead+len(data))
+	copy(rec, id[:])
+	binary.BigEndian.PutUint64(rec[64:], uint64(size))
+	rec = append(rec, data...)
+	if _, err := p.data.WriteAt(rec, p.scanned); err != nil {
+		p.data.Truncate(p.scanned)
+		return err
+	}
+	if err := p.data.Sync(); err != nil {
+		return err
+	}
+	p.entries[id] = packEntry{p.scanned + packRecordHead, size}
+	p.scanned += int64(len(rec))
+	return nil
+}
+
+// openForAppend returns the unsealed pack to append n bytes to, sealing
+// full packs and starting a new one as needed. It requires the exclusive
+// lock.
+func (s *PackStore) openForAppend(n int64) (*pack, error) {
+	var open *pack
+	for _, p := range s.packs {
+		if p.sealed() {
+			continue
+		}
+		if open != nil {
+			// Left unsealed by an interrupted seal.
+			if err := s.seal(open); err != nil {
+				return nil, err
+			}
+		}
+		open = p
+	}
+	if open != nil && open.scanned+n <= s.packSize {
+		return open, nil
+	}
+	if open != nil && len(open.entries) > 0 {
+		if err := s.seal(open); err != nil {
+			return nil, err
+		}
+	} else if open != nil {
+		return open, nil
+	}
+	return s.newPack()
+}
+
+func (s *PackStore) newPack() (*pack, error) {
+	seq := 1
+	if len(s.packs) > 0 {
+		seq = s.packs[len(s.packs)-1].seq + 1
+	}
+	f, err := os.OpenFile(s.packPath(seq, ".pack"), os.O_RDWR|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := f.Write([]byte(packHeader)); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, err
+	}
+	p := &pack{seq: seq, data: f, mod: time.Now(), entries: make(map[c4.ID]packEntry), scanned: int64(len(packHeader))}
+	s.packs = append(s.packs, p)
+	return p, nil
+}
+
+// seal writes the index of an unsealed pack, making it read-only.
+func (s *PackStore) seal(p *pack) error {
+	ids := make([]c4.ID, 0, len(p.entries))
+	for id := range p.entries {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return bytes.Compare(ids[i][:], ids[j][:]) < 0 })
+
+	w, err := NewDurableWriter(s.packPath(p.seq, ".idx"))
+	if err != nil {
+		return err
+	}
+	bw := bufio.NewWriter(w)
+	bw.WriteString(packIdxHeader)
+	var fanout [256]uint32
+	for _, id := range ids {
+		for b := int(id[0]); b < 256; b++ {
+			fanout[b]++
+		}
+	}
+	for _, n := range fanout {
+		binary.Write(bw, binary.BigEndian, n)
+	}
+	for _, id := range ids {
+		e := p.entries[id]
+		bw.Write(id[:])
+		binary.Write(bw, binary.BigEndian, e.off)
+		binary.Write(bw, binary.BigEndian, e.size)
+	}
+	if err := bw.Flush(); err != nil {
+		w.Close()
+		return err
+	}
+	if err := p.data.Sync(); err != nil {
+		w.Close()
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	idx, err := os.Open(s.packPath(p.seq, ".idx"))
+	if err != nil {
+		return err
+	}
+	p.idx, p.fanout, p.entries = idx, fanout, nil
+	return nil
+}
+
+// each calls fn with the newest record of every ID in the packs, newest
+// pack first.
+func (s *PackStore) each(fn func(p *pack, id c4.ID, e packEntry) bool) error {
+	seen := make(map[c4.ID]bool)
+	visit := func(p *pack, id c4.ID, e packEntry) bool {
+		if seen[id] {
+			return true
+		}
+		seen[id] = true
+		return fn(p, id, e)
+	}
+	for i := len(s.packs) - 1; i >= 0; i-- {
+		p := s.packs[i]
+		if !p.sealed() {
+			for id, e := range p.entries {
+				if !visit(p, id, e) {
+					return nil
+				}
+			}
+			continue
+		}
+		r := bufio.NewReader(io.NewSectionReader(p.idx, int64(len(packIdxHeader)+packFanoutSize), int64(p.fanout[255])*packIdxEntry))
+		entry := make([]byte, packIdxEntry)
+		for n := uint32(0); n < p.fanout[255]; n++ {
+			if _, err := io.ReadFull(r, entry); err != nil {
+				return fmt.Errorf("%s: %w", p.idx.Name(), err)
+			}
+			var id c4.ID
+			copy(id[:], entry)
+			e := packEntry{int64(binary.BigEndian.Uint64(entry[64:])), int64(binary.BigEndian.Uint64(entry[72:]))}
+			if !visit(p, id, e) {
+				return nil
+			}
+		}
+	}
+	return nil
+}
+
+// List reports the packed objects and the contents of the large-object
+// store. Packed objects carry their pack's modification time.
+func (s *PackStore) List(fn func(Object) bool) error {
+	unlock := s.lock(false)
+	if err := s.refresh(false); err != nil {
+		unlock()
+		return err
+	}
+	var objs []Object
+	err := s.each(func(p *pack, id c4.ID, e packEntry) bool {
+		if e.size >= 0 {
+			objs = append(objs, Object{ID: id, Size: e.size, ModTime: p.mod})
+		}
+		return true
+	})
+	unlock()
+	if err != nil {
+		return err
+	}
+	seen := make(map[c4.ID]bool, len(objs))
+	for _, o := range objs {
+		seen[o.ID] = true
+		if !fn(o) {
+			return nil
+		}
+	}
+	if s.large == nil {
+		return nil
+	}
+	err = List(s.large, func(o Object) bool {
+		if seen[o.ID] {
+			return true
+		}
+		return fn(o)
+	})
+	if err == ErrNotImplemented {
+		return nil
+	}
+	return err
+}
+
+// RepackReport summarizes a Repack.
+type RepackReport struct {
+	PacksBefore int
+	PacksAfter  int
+	Objects     int   // live objects rewritten
+	Bytes       int64 // bytes of live content
+	Reclaimed   int64 // bytes of pack files freed
+}
+
+// Repack rewrites the live packed objects into new, full packs and removes
+// the old ones, dropping removed objects, tombstones and duplicates. The
+// old packs are only removed once the new ones are sealed, so an
+// interrupted repack leaves duplicates, never losses.
+func (s *PackStore) Repack() (*RepackReport, error) {
+	unlock := s.lock(true)
+	defer unlock()
+	if err := s.refresh(true); err != nil {
+		return nil, err
+	}
+	old := s.packs
+	rep := &RepackReport{PacksBefore: len(old)}
+	for _, p := range old {
+		if info, err := p.data.Stat(); err == nil {
+			rep.Reclaimed += info.Size()
+		}
+		if p.sealed() {
+			if info, err := p.idx.Stat(); err == nil {
+				rep.Reclaimed += info.Size()
+			}
+		}
+	}
+
+	type live struct {
+		p  *pack
+		id c4.ID
+		e  packEntry
+	}
+	var objs []live
+	if err := s.each(func(p *pack, id c4.ID, e packEntry) bool {
+		if e.size >= 0 {
+			objs = append(objs, live{p, id, e})
+		}
+		return true
+	}); err != nil {
+		return nil, err
+	}
+	sort.Slice(objs, func(i, j int) bool { return bytes.Compare(objs[i].id[:], objs[j].id[:]) < 0 })
+
+	// New packs are numbered after the old ones, so they win lookups.
+	var fresh []*pack
+	var cur *pack
+	for _, o := range objs {
+		data := make([]byte, o.e.size)
+		if _, err := o.p.data.ReadAt(data, o.e.off); err != nil {
+			return nil, fmt.Errorf("reading %s: %w", o.id, err)
+		}
+		if cur == nil || cur.scanned+packRecordHead+o.e.size > s.packSize && len(cur.entries) > 0 {
+			if cur != nil {
+				if err := s.seal(cur); err != nil {
+					return nil, err
+				}
+			}
+			var err error
+			if cur, err = s.newPack(); err != nil {
+				return nil, err
+			}
+			fresh = append(fresh, cur)
+		}
+		rec := make([]byte, packRecordHead, packRecordHead+len(data))
+		copy(rec, o.id[:])
+		binary.BigEndian.PutUint64(rec[64:], uint64(o.e.size))
+		rec = append(rec, data...)
+		if _, err := cur.data.WriteAt(rec, cur.scanned); err != nil {
+			return nil, err
+		}
+		cur.entries[o.id] = packEntry{cur.scanned + packRecordHead, o.e.size}
+		cur.scanned += int64(len(rec))
+		rep.Objects++
+		rep.Bytes += o.e.size
+	}
+	if cur != nil {
+		if err := s.seal(cur); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, p := range old {
+		os.Remove(s.packPath(p.seq, ".idx"))
+		os.Remove(s.packPath(p.seq, ".pack"))
+		p.close()
+	}
+	s.packs = fresh
+	rep.PacksAfter = len(fresh)
+	for _, p := range fresh {
+		if info, err := p.data.Stat(); err == nil {
+			rep.Reclaimed -= info.Size()
+		}
+		if info, err := p.idx.Stat(); err == nil {
+			rep.Reclaimed -= info.Size()
+		}
+	}
+	if rep.Reclaimed < 0 {
+		rep.Reclaimed = 0
+	}
+	return rep, nil
+}
+
+type packWriter struct {
+	s     *PackStore
+	id    c4.ID
+	buf   []byte
+	large io.WriteCloser
+}
+
+func (w *packWriter) Write(b []byte) (int, error) {
+	if w.large != nil {
+		return w.large.Write(b)
+	}
+	w.buf = append(w.buf, b...)
+	if w.s.large == nil || int64(len(w.buf)) < w.s.threshold {
+		return len(b), nil
+	}
+	lw, err := w.s.large.Create(w.id)
+	if err != nil {
+		return 0, err
+	}
+	w.large = lw
+	if _, err := lw.Write(w.buf); err != nil {
+		return 0, err
+	}
+	w.buf = nil
+	return len(b), nil
+}
+
+func (w *packWriter) Close() error {
+	if w.large != nil {
+		return w.large.Close()
+	}
+	if c4.Identify(bytes.NewReader(w.buf)) != w.id {
+		return ErrInvalidID
+	}
+	if w.buf == nil {
+		w.buf = []byte{} // empty content, not a tombstone
+	}
+	return w.s.append(w.id, w.buf)
+}
+
+// PackStores returns the PackStores in s, looking through wrappers, caches
+// and the members of a MultiStore.
+func PackStores(s Store) []*PackStore {
+	var packs []*PackStore
+	walkStores(s, func(s Store) {
+		if ps, ok := s.(*PackStore); ok {
+			packs = append(packs, ps)
+		}
+	})
+	return packs
+}
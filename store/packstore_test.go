@@ -0,0 +1,340 @@
+package store
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Avalanche-io/c4"
+)
+
+func newTestPackStore(t *testing.T, dir string) (*PackStore, *RAM) {
+	t.Helper()
+	large := NewRAM()
+	s, err := NewPackStore(dir, large, 1<<10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.packSize = 4 << 10
+	t.Cleanup(func() { s.Close() })
+	return s, large
+}
+
+func packFiles(t *testing.T, dir, ext string) []string {
+	t.Helper()
+	names, err := filepath.Glob(filepath.Join(dir, "pack-*"+ext))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return names
+}
+
+func TestPackStoreRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	s, large := newTestPackStore(t, dir)
+
+	var ids []c4.ID
+	for i := 0; i < 100; i++ {
+		id, err := s.Put(strings.NewReader(fmt.Sprintf("object %d", i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+	empty, err := s.Put(bytes.NewReader(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	big := randomBytes(1, 4<<10)
+	bigID, err := s.Put(bytes.NewReader(big))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !large.Has(bigID) {
+		t.Error("object over the threshold was not stored in the large store")
+	}
+	if large.Has(ids[0]) {
+		t.Error("small object was stored in the large store")
+	}
+	if len(packFiles(t, dir, ".idx")) == 0 {
+		t.Error("no pack was sealed")
+	}
+
+	// A fresh instance reads sealed and unsealed packs alike.
+	s2, _ := NewPackStore(dir, large, 1<<10)
+	defer s2.Close()
+	for i, id := range ids {
+		if got := string(readAll(t, s2, id)); got != fmt.Sprintf("object %d", i) {
+			t.Fatalf("object %d read back as %q", i, got)
+		}
+	}
+	if got := readAll(t, s2, empty); len(got) != 0 {
+		t.Errorf("empty object read back as %q", got)
+	}
+	if got := readAll(t, s2, bigID); !bytes.Equal(got, big) {
+		t.Error("large object did not round-trip")
+	}
+
+	objs, err := ListAll(s2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(objs) != len(ids)+2 {
+		t.Errorf("List reported %d objects, want %d", len(objs), len(ids)+2)
+	}
+}
+
+func TestPackStoreCreate(t *testing.T) {
+	s, large := newTestPackStore(t, t.TempDir())
+	for _, data := range [][]byte{nil, []byte("small"), randomBytes(2, 3<<10)} {
+		id := c4.Identify(bytes.NewReader(data))
+		w, err := s.Create(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write(data)
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if !s.Has(id) {
+			t.Fatalf("%d bytes: missing after Create", len(data))
+		}
+		if got := readAll(t, s, id); !bytes.Equal(got, data) {
+			t.Errorf("%d bytes: content did not round-trip", len(data))
+		}
+		if inLarge := len(data) >= 1<<10; large.Has(id) != inLarge {
+			t.Errorf("%d bytes: in large store %v, want %v", len(data), !inLarge, inLarge)
+		}
+	}
+
+	id := c4.Identify(strings.NewReader("expected"))
+	w, _ := s.Create(id)
+	io.WriteString(w, "something else")
+	if err := w.Close(); err != ErrInvalidID {
+		t.Errorf("Create with wrong content: got %v, want ErrInvalidID", err)
+	}
+	if s.Has(id) {
+		t.Error("mismatched content was stored")
+	}
+}
+
+func TestPackStoreRemove(t *testing.T) {
+	dir := t.TempDir()
+	s, _ := newTestPackStore(t, dir)
+	id, _ := s.Put(strings.NewReader("doomed"))
+	keep, _ := s.Put(strings.NewReader("kept"))
+	if err := s.Remove(id); err != nil {
+		t.Fatal(err)
+	}
+	if s.Has(id) {
+		t.Fatal("object present after Remove")
+	}
+	if _, err := s.Open(id); !os.IsNotExist(err) {
+		t.Errorf("Open after Remove: got %v, want not exist", err)
+	}
+	if err := s.Remove(id); !os.IsNotExist(err) {
+		t.Errorf("second Remove: got %v, want not exist", err)
+	}
+
+	// The tombstone persists, and storing the content again revives it.
+	s2, _ := NewPackStore(dir, NewRAM(), 1<<10)
+	defer s2.Close()
+	if s2.Has(id) || !s2.Has(keep) {
+		t.Fatal("tombstone not honored after reopening")
+	}
+	if _, err := s2.Put(strings.NewReader("doomed")); err != nil {
+		t.Fatal(err)
+	}
+	if !s2.Has(id) {
+		t.Error("object missing after being stored again")
+	}
+}
+
+func TestPackStoreTornWrite(t *testing.T) {
+	dir := t.TempDir()
+	s, _ := newTestPackStore(t, dir)
+	a, _ := s.Put(strings.NewReader("first"))
+	b, _ := s.Put(strings.NewReader("second"))
+	s.Close()
+
+	// Simulate a crash partway through appending a third record.
+	packs := packFiles(t, dir, ".pack")
+	name := packs[len(packs)-1]
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := c4.Identify(strings.NewReader("third"))
+	f.Write(c[:])
+	f.Write([]byte{0, 0, 0, 0, 0, 0, 0, 5, 't', 'h'})
+	f.Close()
+	info, _ := os.Stat(name)
+	torn := info.Size()
+
+	s2, _ := newTestPackStore(t, dir)
+	if !s2.Has(a) || !s2.Has(b) {
+		t.Fatal("records before the torn write were lost")
+	}
+	if s2.Has(c) {
+		t.Fatal("torn record reported as present")
+	}
+	d, err := s2.Put(strings.NewReader("fourth"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info, _ := os.Stat(name); info.Size() >= torn+packRecordHead {
+		t.Errorf("torn record was not truncated before appending")
+	}
+
+	s3, _ := newTestPackStore(t, dir)
+	for _, id := range []c4.ID{a, b, d} {
+		if !s3.Has(id) {
+			t.Errorf("%s lost after recovery", id)
+		}
+	}
+}
+
+func TestPackStoreRepack(t *testing.T) {
+	dir := t.TempDir()
+	s, _ := newTestPackStore(t, dir)
+	var ids []c4.ID
+	for i := 0; i < 200; i++ {
+		id, _ := s.Put(strings.NewReader(fmt.Sprintf("object %d %s", i, strings.Repeat("x", 100))))
+		ids = append(ids, id)
+	}
+	for _, id := range ids[:150] {
+		if err := s.Remove(id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	before := len(packFiles(t, dir, ".pack"))
+
+	rep, err := s.Repack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rep.Objects != 50 || rep.PacksBefore != before || rep.Reclaimed <= 0 {
+		t.Errorf("report %+v, want 50 objects from %d packs with space reclaimed", rep, before)
+	}
+	if after := len(packFiles(t, dir, ".pack")); after != rep.PacksAfter || after >= before {
+		t.Errorf("%d packs after repack (report says %d), had %d", after, rep.PacksAfter, before)
+	}
+	if idx := len(packFiles(t, dir, ".idx")); idx != rep.PacksAfter {
+		t.Errorf("%d indexes for %d packs", idx, rep.PacksAfter)
+	}
+
+	s2, _ := newTestPackStore(t, dir)
+	for i, id := range ids {
+		if s2.Has(id) != (i >= 150) {
+			t.Fatalf("object %d: Has %v after repack", i, s2.Has(id))
+		}
+	}
+	if got := string(readAll(t, s2, ids[199])); !strings.HasPrefix(got, "object 199 ") {
+		t.Errorf("content after repack: %q", got)
+	}
+	// The instance that did not repack drops the removed packs on refresh.
+	if _, err := s.Put(strings.NewReader("after repack")); err != nil {
+		t.Fatal(err)
+	}
+	if !s2.Has(c4.Identify(strings.NewReader("after repack"))) {
+		t.Error("write after repack not visible to another instance")
+	}
+}
+
+func TestPackStoreConcurrentInstances(t *testing.T) {
+	if runtime.GOOS == "windows" || runtime.GOOS == "plan9" {
+		t.Skip("no file locking on this platform")
+	}
+	dir := t.TempDir()
+
+	// Separate instances share nothing in memory, like separate processes.
+	const writers, each = 4, 100
+	var wg sync.WaitGroup
+	errs := make(chan error, writers)
+	for w := 0; w < writers; w++ {
+		s, _ := newTestPackStore(t, dir)
+		wg.Add(1)
+		go func(w int, s *PackStore) {
+			defer wg.Done()
+			for i := 0; i < each; i++ {
+				id, err := s.Put(strings.NewReader(fmt.Sprintf("writer %d item %d", w, i)))
+				if err != nil {
+					errs <- err
+					return
+				}
+				if !s.Has(id) {
+					errs <- fmt.Errorf("%s missing right after Put", id)
+					return
+				}
+			}
+		}(w, s)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+
+	s, _ := newTestPackStore(t, dir)
+	for w := 0; w < writers; w++ {
+		for i := 0; i < each; i++ {
+			id := c4.Identify(strings.NewReader(fmt.Sprintf("writer %d item %d", w, i)))
+			if !s.Has(id) {
+				t.Fatalf("writer %d item %d lost", w, i)
+			}
+		}
+	}
+}
+
+func TestPackStoreRefreshOnlyOnChange(t *testing.T) {
+	dir := t.TempDir()
+	a, _ := newTestPackStore(t, dir)
+	b, _ := newTestPackStore(t, dir)
+	first := putString(t, a, "first")
+
+	// With the directory and packs as last read, a miss rereads nothing.
+	old := time.Now().Add(-time.Hour)
+	os.Chtimes(dir, old, old)
+	b.Has(testID("missing"))
+	b.mu.RLock()
+	changed := b.changed()
+	b.mu.RUnlock()
+	if changed {
+		t.Fatal("unchanged store reported as changed")
+	}
+
+	// Records appended by another instance are still found.
+	second := putString(t, a, "second")
+	if !b.Has(first) || !b.Has(second) {
+		t.Error("objects written by another instance not found")
+	}
+}
+
+func TestOpenPackConfigured(t *testing.T) {
+	dir := t.TempDir()
+	s, err := OpenURI("pack://" + dir + "?threshold=1K")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ps := PackStores(s)
+	if len(ps) != 1 || ps[0].threshold != 1<<10 {
+		t.Fatalf("OpenURI did not open a PackStore with a 1K threshold: %#v", s)
+	}
+	id, _ := s.Put(bytes.NewReader(randomBytes(3, 2<<10)))
+	if _, err := os.Stat(filepath.Join(dir, "large")); err != nil {
+		t.Errorf("default large store not created: %v", err)
+	}
+	if !ps[0].large.Has(id) {
+		t.Error("large object not in the default large store")
+	}
+	if _, err := OpenURI("pack://" + dir + "?threshold=lots"); err == nil {
+		t.Error("invalid threshold accepted")
+	}
+}
@@ -0,0 +1,207 @@
+package store
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/Avalanche-io/c4"
+)
+
+// RangeSource is an optional interface for stores that can read part of an
+// object without reading everything before it.
+type RangeSource interface {
+	// OpenRange returns a reader for at most n bytes of the content of id,
+	// starting at offset off, or for the rest of the content if n is
+	// negative. Reading past the end of the content is not an error; the
+	// reader just ends. Partial content cannot be checked against its ID,
+	// so range reads are not verified.
+	OpenRange(id c4.ID, off, n int64) (io.ReadCloser, error)
+}
+
+// OpenRange reads part of id from s. If s does not implement RangeSource,
+// the whole object is opened and skipped to off, by seeking if the reader
+// allows it and by reading otherwise.
+func OpenRange(s Source, id c4.ID, off, n int64) (io.ReadCloser, error) {
+	if off < 0 {
+		return nil, fmt.Errorf("open range of %s: negative offset %d", id, off)
+	}
+	if rs, ok := s.(RangeSource); ok {
+		return rs.OpenRange(id, off, n)
+	}
+	rc, err := s.Open(id)
+	if err != nil {
+		return nil, err
+	}
+	return skipTo(rc, off, n)
+}
+
+// skipTo advances rc to off and limits it to n bytes.
+func skipTo(rc io.ReadCloser, off, n int64) (io.ReadCloser, error) {
+	if off > 0 {
+		var err error
+		if sk, ok := rc.(io.Seeker); ok {
+			_, err = sk.Seek(off, io.SeekStart)
+		} else {
+			_, err = io.CopyN(io.Discard, rc, off)
+			if err == io.EOF {
+				err = nil
+			}
+		}
+		if err != nil {
+			rc.Close()
+			return nil, err
+		}
+	}
+	return limitRange(rc, n), nil
+}
+
+// limitRange limits rc to n bytes, or not at all if n is negative.
+func limitRange(rc io.ReadCloser, n int64) io.ReadCloser {
+	if n < 0 {
+		return rc
+	}
+	return readCloser{io.LimitReader(rc, n), rc}
+}
+
+// sliceRange returns the range of data.
+func sliceRange(data []byte, off, n int64) []byte {
+	if off > int64(len(data)) {
+		off = int64(len(data))
+	}
+	data = data[off:]
+	if n >= 0 && n < int64(len(data)) {
+		data = data[:n]
+	}
+	return data
+}
+
+// NewReaderAt returns an io.ReaderAt for the content of id in s. Each call
+// to ReadAt opens the range it reads.
+func NewReaderAt(s Source, id c4.ID) io.ReaderAt {
+	return &rangeReaderAt{s, id}
+}
+
+type rangeReaderAt struct {
+	s  Source
+	id c4.ID
+}
+
+func (r *rangeReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	rc, err := OpenRange(r.s, r.id, off, int64(len(p)))
+	if err != nil {
+		return 0, err
+	}
+	defer rc.Close()
+	n, err := io.ReadFull(rc, p)
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+	return n, err
+}
+
+// OpenRange seeks within the object's file.
+func (f Folder) OpenRange(id c4.ID, off, n int64) (io.ReadCloser, error) {
+	return openFileRange(f, id, off, n)
+}
+
+// OpenRange seeks within the object's file.
+func (f ShardedFolder) OpenRange(id c4.ID, off, n int64) (io.ReadCloser, error) {
+	return openFileRange(f, id, off, n)
+}
+
+// OpenRange seeks within the object's file.
+func (s *TreeStore) OpenRange(id c4.ID, off, n int64) (io.ReadCloser, error) {
+	if off < 0 {
+		return nil, fmt.Errorf("open range of %s: negative offset %d", id, off)
+	}
+	f, err := s.openFile(id)
+	if err != nil {
+		return nil, err
+	}
+	return skipTo(f, off, n)
+}
+
+// OpenRange seeks within the mapped file.
+func (s MAP) OpenRange(id c4.ID, off, n int64) (io.ReadCloser, error) {
+	return openFileRange(s, id, off, n)
+}
+
+func openFileRange(s Source, id c4.ID, off, n int64) (io.ReadCloser, error) {
+	if off < 0 {
+		return nil, fmt.Errorf("open range of %s: negative offset %d", id, off)
+	}
+	rc, err := s.Open(id)
+	if err != nil {
+		return nil, err
+	}
+	return skipTo(rc, off, n)
+}
+
+// OpenRange returns a copy of part of the stored content.
+func (s *RAM) OpenRange(id c4.ID, off, n int64) (io.ReadCloser, error) {
+	if off < 0 {
+		return nil, fmt.Errorf("open range of %s: negative offset %d", id, off)
+	}
+	s.mu.RLock()
+	data, ok := s.data[id]
+	s.mu.RUnlock()
+	if !ok {
+		return nil, &os.PathError{Op: "open", Path: id.String(), Err: os.ErrNotExist}
+	}
+	// Copy so reads don't alias the stored slice
+	cp := append([]byte(nil), sliceRange(data, off, n)...)
+	return io.NopCloser(bytes.NewReader(cp)), nil
+}
+
+// OpenRange reads the range from the first member store that has id.
+func (m *MultiStore) OpenRange(id c4.ID, off, n int64) (io.ReadCloser, error) {
+	var lastErr error
+	for _, s := range m.stores {
+		if s.Has(id) {
+			rc, err := OpenRange(s, id, off, n)
+			if err == nil {
+				return rc, nil
+			}
+			lastErr = err
+		}
+	}
+	if lastErr != nil {
+		return nil, lastErr
+	}
+	return nil, fmt.Errorf("c4 id %s not found in any store", id)
+}
+
+// OpenRange calls OpenRange on the wrapped Store.
+func (l *Logger) OpenRange(id c4.ID, off, n int64) (io.ReadCloser, error) {
+	return OpenRange(l.s, id, off, n)
+}
+
+// OpenRange reads part of id from the wrapped store. Only a read of the
+// whole content, from offset zero to the end, is verified.
+func (v *Validating) OpenRange(id c4.ID, off, n int64) (io.ReadCloser, error) {
+	if off == 0 && n < 0 {
+		return v.Open(id)
+	}
+	return OpenRange(v.s, id, off, n)
+}
+
+var (
+	_ RangeSource = Folder("")
+	_ RangeSource = ShardedFolder("")
+	_ RangeSource = &TreeStore{}
+	_ RangeSource = MAP{}
+	_ RangeSource = &RAM{}
+	_ RangeSource = &MultiStore{}
+	_ RangeSource = &Logger{}
+	_ RangeSource = &Validating{}
+	_ RangeSource = &S3Store{}
+	_ RangeSource = &HTTPStore{}
+	_ RangeSource = &Reference{}
+	_ RangeSource = &PackStore{}
+	_ RangeSource = &Cache{}
+	_ RangeSource = &Compressing{}
+	_ RangeSource = &Encrypting{}
+	_ RangeSource = &Chunking{}
+)
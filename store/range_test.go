@@ -0,0 +1,192 @@
+package store
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/Avalanche-io/c4"
+)
+
+// checkRanges reads assorted ranges of id from s and compares them with
+// data.
+func checkRanges(t *testing.T, name string, s Source, id c4.ID, data []byte) {
+	t.Helper()
+	size := int64(len(data))
+	for _, r := range [][2]int64{
+		{0, -1}, {0, 10}, {1, 1}, {size / 2, -1}, {size / 3, size / 3},
+		{size - 10, 10}, {size - 10, 100}, {size, -1}, {size + 5, 10}, {7, 0},
+		{encChunkSize - 3, 6}, {encChunkSize, encChunkSize},
+	} {
+		off, n := r[0], r[1]
+		if off < 0 {
+			continue
+		}
+		rc, err := OpenRange(s, id, off, n)
+		if err != nil {
+			t.Errorf("%s: OpenRange(%d, %d): %v", name, off, n, err)
+			continue
+		}
+		got, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Errorf("%s: reading range (%d, %d): %v", name, off, n, err)
+			continue
+		}
+		if want := sliceRange(data, off, n); !bytes.Equal(got, want) {
+			t.Errorf("%s: range (%d, %d) read %d bytes, want %d", name, off, n, len(got), len(want))
+		}
+	}
+}
+
+func TestOpenRange(t *testing.T) {
+	data := randomBytes(1, 3*encChunkSize+1234)
+	id := c4.Identify(bytes.NewReader(data))
+	key, _ := GenerateKey()
+
+	tree, _ := NewTreeStore(t.TempDir())
+	pack, err := NewPackStore(t.TempDir(), NewRAM(), 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pack.Close()
+	enc, _ := NewEncrypting(NewRAM(), key)
+	chunked, _ := NewChunkingSize(NewRAM(), 4<<10)
+	cache, _ := NewCache(NewRAM(), NewRAM(), 0)
+	stores := map[string]Store{
+		"RAM":            NewRAM(),
+		"Folder":         Folder(t.TempDir()),
+		"ShardedFolder":  ShardedFolder(t.TempDir()),
+		"TreeStore":      tree,
+		"PackStore":      pack,
+		"Encrypting":     enc,
+		"Chunking":       chunked,
+		"Compressing":    NewCompressing(NewRAM()),
+		"Validating":     NewValidating(NewRAM()),
+		"MultiStore":     NewMultiStore(NewRAM(), NewRAM()),
+		"Cache":          cache,
+		"no RangeSource": struct{ Store }{NewRAM()},
+	}
+	for name, s := range stores {
+		if _, err := s.Put(bytes.NewReader(data)); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		checkRanges(t, name, s, id, data)
+	}
+
+	// Ranges of compressible content stored compressed.
+	text := []byte(strings.Repeat("all work and no play ", 10000))
+	textID := c4.Identify(bytes.NewReader(text))
+	comp := NewCompressing(NewRAM())
+	comp.Put(bytes.NewReader(text))
+	checkRanges(t, "Compressing gzip", comp, textID, text)
+
+	if _, err := OpenRange(NewRAM(), id, -1, 10); err == nil {
+		t.Error("negative offset accepted")
+	}
+	if _, err := OpenRange(NewRAM(), id, 0, 10); err == nil {
+		t.Error("range of a missing object opened")
+	}
+}
+
+func TestOpenRangeS3(t *testing.T) {
+	f := newFakeS3(t, "bucket")
+	s := f.store("c4/")
+	data := randomBytes(2, 100000)
+	id, err := s.Put(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkRanges(t, "S3Store", s, id, data)
+
+	f.ranges = nil
+	rc, err := s.OpenRange(id, 99990, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, _ := io.ReadAll(rc)
+	rc.Close()
+	if !bytes.Equal(got, data[99990:]) {
+		t.Error("wrong content for the last 10 bytes")
+	}
+	if len(f.ranges) != 1 || f.ranges[0] != "bytes=99990-99999" {
+		t.Errorf("requests sent Range %q, want one for bytes=99990-99999", f.ranges)
+	}
+}
+
+func TestValidatingRange(t *testing.T) {
+	ram := NewRAM()
+	id := c4.Identify(strings.NewReader("the real content"))
+	w, _ := ram.Create(id)
+	io.WriteString(w, "the fake content")
+	w.Close()
+	v := NewValidating(ram)
+
+	// Part of the content cannot be verified, so it is returned as is.
+	rc, err := OpenRange(v, id, 4, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := io.ReadAll(rc)
+	rc.Close()
+	if err != nil || string(got) != "fake" {
+		t.Errorf("partial read: %q, %v", got, err)
+	}
+
+	// All of it can.
+	rc, _ = OpenRange(v, id, 0, -1)
+	_, err = io.ReadAll(rc)
+	rc.Close()
+	if err != ErrInvalidID {
+		t.Errorf("whole read: got %v, want ErrInvalidID", err)
+	}
+}
+
+func TestEncryptingRangeTamper(t *testing.T) {
+	ram := NewRAM()
+	key, _ := GenerateKey()
+	e, _ := NewEncrypting(ram, key)
+	data := randomBytes(3, 2*encChunkSize+100)
+	id, _ := e.Put(bytes.NewReader(data))
+
+	// Damage the second chunk.
+	ram.mu.Lock()
+	ram.data[id][encHeaderSize+encChunkSize+16+5] ^= 1
+	ram.mu.Unlock()
+
+	// Ranges within the first chunk still read.
+	for _, r := range [][2]int64{{0, 100}, {100, encChunkSize - 100}} {
+		rc, err := OpenRange(e, id, r[0], r[1])
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil || !bytes.Equal(got, sliceRange(data, r[0], r[1])) {
+			t.Errorf("range (%d, %d) in an intact chunk: %v", r[0], r[1], err)
+		}
+	}
+	if _, err := OpenRange(e, id, encChunkSize+10, 10); err == nil {
+		t.Error("range in a damaged chunk decrypted")
+	}
+}
+
+func TestReaderAt(t *testing.T) {
+	ram := NewRAM()
+	data := randomBytes(4, 1000)
+	id, _ := ram.Put(bytes.NewReader(data))
+	ra := NewReaderAt(ram, id)
+
+	buf := make([]byte, 100)
+	if n, err := ra.ReadAt(buf, 450); n != 100 || err != nil || !bytes.Equal(buf, data[450:550]) {
+		t.Errorf("ReadAt middle: %d, %v", n, err)
+	}
+	if n, err := ra.ReadAt(buf, 950); n != 50 || err != io.EOF || !bytes.Equal(buf[:n], data[950:]) {
+		t.Errorf("ReadAt end: %d, %v", n, err)
+	}
+	sr := io.NewSectionReader(ra, 0, int64(len(data)))
+	if got, _ := io.ReadAll(sr); !bytes.Equal(got, data) {
+		t.Error("SectionReader over ReaderAt did not read the content")
+	}
+}
@@ -0,0 +1,163 @@
+package store
+
+import (
+	"bytes"
+	"crypto/sha512"
+	"fmt"
+	"io"
+
+	"github.com/Avalanche-io/c4"
+)
+
+// RebuildOptions configures Erasure.Rebuild.
+type RebuildOptions struct {
+	// Verify reads the shards the backend has, rewriting those that are
+	// damaged, rather than only writing those it lacks.
+	Verify bool
+
+	// DryRun reports what would be rebuilt without writing anything.
+	DryRun bool
+
+	// OnRebuild, if set, is called after each shard is rebuilt (or, in a
+	// dry run, for each that would be).
+	OnRebuild func(id c4.ID)
+}
+
+// RebuildReport summarizes a rebuild.
+type RebuildReport struct {
+	Rebuilt int // shards written
+	Present int // shards the backend already had
+	Failed  map[c4.ID]error
+}
+
+// Rebuild writes the shard that backend i, counting from zero, should hold
+// of every object, reconstructing it from the other backends. Use it after
+// replacing a failed backend with an empty one. The objects are found by
+// listing the other backends, at least one of which must be a Lister.
+// Each object rebuilt is checked against its ID.
+func (e *Erasure) Rebuild(i int, opts RebuildOptions) (*RebuildReport, error) {
+	if i < 0 || i >= len(e.stores) {
+		return nil, fmt.Errorf("no erasure backend %d", i+1)
+	}
+	ids, err := e.listOthers(i)
+	if err != nil {
+		return nil, err
+	}
+	rep := &RebuildReport{Failed: make(map[c4.ID]error)}
+	for _, id := range ids {
+		s := e.stores[i]
+		if s.Has(id) && (!opts.Verify || e.checkShard(id, i) == nil) {
+			rep.Present++
+			continue
+		}
+		if !opts.DryRun {
+			if err := e.rebuildShard(id, i); err != nil {
+				rep.Failed[id] = err
+				continue
+			}
+		}
+		rep.Rebuilt++
+		if opts.OnRebuild != nil {
+			opts.OnRebuild(id)
+		}
+	}
+	return rep, nil
+}
+
+// listOthers returns the IDs of the objects of which backends other than
+// i hold shards.
+func (e *Erasure) listOthers(i int) ([]c4.ID, error) {
+	var ids []c4.ID
+	err := e.listShards(i, func(o Object) bool {
+		ids = append(ids, o.ID)
+		return true
+	})
+	if err == ErrNotImplemented {
+		return nil, fmt.Errorf("rebuild: no other backend can list its shards: %w", err)
+	}
+	return ids, err
+}
+
+// checkShard reads shard i of id through, checking its header and the
+// checksum of every record.
+func (e *Erasure) checkShard(id c4.ID, i int) error {
+	sh, size, err := e.openShard(id, i, 0, 0)
+	if err != nil {
+		return err
+	}
+	defer sh.rc.Close()
+	buf := make([]byte, size)
+	for {
+		n, _, err := sh.next(e.k, buf)
+		if err != nil {
+			return err
+		}
+		if n < e.k*size {
+			return nil
+		}
+	}
+}
+
+// rebuildShard writes shard i of id to backend i, replacing any it has,
+// from the shards of the other backends.
+func (e *Erasure) rebuildShard(id c4.ID, i int) error {
+	sr, err := e.openStripes(id, i)
+	if err != nil {
+		return err
+	}
+	defer sr.Close()
+
+	s := e.stores[i]
+	if s.Has(id) {
+		if err := s.Remove(id); err != nil {
+			return err
+		}
+	}
+	w, err := s.Create(id)
+	if err != nil {
+		return err
+	}
+	h := sha512.New()
+	var data []byte
+	err = func() error {
+		if _, err := w.Write(e.header(i, sr.blockSize)); err != nil {
+			return err
+		}
+		for {
+			blocks, n, err := sr.next()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			data = stripeData(data[:0], blocks, e.k, n)
+			h.Write(data)
+			if err := writeRecord(w, n, blocks[i]); err != nil {
+				return err
+			}
+		}
+	}()
+	if cerr := w.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil && !bytes.Equal(h.Sum(nil), id[:]) {
+		err = ErrInvalidID
+	}
+	if err != nil {
+		s.Remove(id)
+	}
+	return err
+}
+
+// ErasureStores returns the Erasure stores in s, looking through wrappers,
+// caches and the members of a MultiStore.
+func ErasureStores(s Store) []*Erasure {
+	var stores []*Erasure
+	walkStores(s, func(s Store) {
+		if e, ok := s.(*Erasure); ok {
+			stores = append(stores, e)
+		}
+	})
+	return stores
+}
@@ -0,0 +1,226 @@
+package store
+
+import "fmt"
+
+// Reed-Solomon coding over GF(2^8), for the Erasure store.
+//
+// The encoding matrix is systematic: its first k rows are the identity,
+// so the first k shards are the data itself, and the other m rows are
+// parity. It is made from a Vandermonde matrix, any k rows of which are
+// independent, multiplied by the inverse of its top k rows, which keeps
+// that property. So any k of the k+m shards determine the rest.
+
+// gfExp and gfLog are the exponent and logarithm tables of GF(2^8) with
+// the polynomial x^8 + x^4 + x^3 + x^2 + 1 (0x11d) and generator 2.
+var gfExp, gfLog = gfTables()
+
+func gfTables() (exp [512]byte, log [256]byte) {
+	x := 1
+	for i := 0; i < 255; i++ {
+		exp[i] = byte(x)
+		log[x] = byte(i)
+		x <<= 1
+		if x&0x100 != 0 {
+			x ^= 0x11d
+		}
+	}
+	// Doubled so that exp[log a + log b] needs no reduction.
+	for i := 255; i < 512; i++ {
+		exp[i] = exp[i-255]
+	}
+	return exp, log
+}
+
+func gfMul(a, b byte) byte {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	return gfExp[int(gfLog[a])+int(gfLog[b])]
+}
+
+func gfInv(a byte) byte {
+	return gfExp[255-int(gfLog[a])]
+}
+
+// gfPow returns a to the power n.
+func gfPow(a byte, n int) byte {
+	if n == 0 {
+		return 1
+	}
+	if a == 0 {
+		return 0
+	}
+	return gfExp[(int(gfLog[a])*n)%255]
+}
+
+// mulAddSlice sets out[i] ^= c * in[i].
+func mulAddSlice(c byte, in, out []byte) {
+	switch c {
+	case 0:
+		return
+	case 1:
+		for i, b := range in {
+			out[i] ^= b
+		}
+		return
+	}
+	lc := int(gfLog[c])
+	for i, b := range in {
+		if b != 0 {
+			out[i] ^= gfExp[lc+int(gfLog[b])]
+		}
+	}
+}
+
+// gfMatrix is a matrix of GF(2^8) elements, by rows.
+type gfMatrix [][]byte
+
+func newGFMatrix(rows, cols int) gfMatrix {
+	m := make(gfMatrix, rows)
+	for r := range m {
+		m[r] = make([]byte, cols)
+	}
+	return m
+}
+
+func (a gfMatrix) mul(b gfMatrix) gfMatrix {
+	out := newGFMatrix(len(a), len(b[0]))
+	for r := range a {
+		for c := range b[0] {
+			var v byte
+			for i := range b {
+				v ^= gfMul(a[r][i], b[i][c])
+			}
+			out[r][c] = v
+		}
+	}
+	return out
+}
+
+// invert returns the inverse of the square matrix a, by Gauss-Jordan
+// elimination.
+func (a gfMatrix) invert() (gfMatrix, error) {
+	n := len(a)
+	// Work on [a | I].
+	w := newGFMatrix(n, 2*n)
+	for r := range a {
+		copy(w[r], a[r])
+		w[r][n+r] = 1
+	}
+	for c := 0; c < n; c++ {
+		p := c
+		for p < n && w[p][c] == 0 {
+			p++
+		}
+		if p == n {
+			return nil, fmt.Errorf("singular matrix")
+		}
+		w[c], w[p] = w[p], w[c]
+		if inv := gfInv(w[c][c]); inv != 1 {
+			for i := range w[c] {
+				w[c][i] = gfMul(w[c][i], inv)
+			}
+		}
+		for r := 0; r < n; r++ {
+			if r != c && w[r][c] != 0 {
+				f := w[r][c]
+				for i := range w[r] {
+					w[r][i] ^= gfMul(f, w[c][i])
+				}
+			}
+		}
+	}
+	out := newGFMatrix(n, n)
+	for r := range out {
+		copy(out[r], w[r][n:])
+	}
+	return out, nil
+}
+
+// reedSolomon encodes k data shards into k+m shards, and reconstructs
+// missing shards from any k of them.
+type reedSolomon struct {
+	k, m int
+	enc  gfMatrix // (k+m) x k
+}
+
+func newReedSolomon(k, m int) (*reedSolomon, error) {
+	if k < 1 || m < 0 || k+m > 256 {
+		return nil, fmt.Errorf("invalid erasure coding: %d data and %d parity shards (at most 256 in all)", k, m)
+	}
+	n := k + m
+	vand := newGFMatrix(n, k)
+	for r := range vand {
+		for c := range vand[r] {
+			vand[r][c] = gfPow(byte(r), c)
+		}
+	}
+	top, err := vand[:k].invert()
+	if err != nil {
+		return nil, err
+	}
+	return &reedSolomon{k: k, m: m, enc: vand.mul(top)}, nil
+}
+
+// encode computes the parity shards, shards[k:], from the data shards.
+// All shards have the same length.
+func (rs *reedSolomon) encode(shards [][]byte) {
+	for p := rs.k; p < rs.k+rs.m; p++ {
+		out := shards[p]
+		for i := range out {
+			out[i] = 0
+		}
+		for d := 0; d < rs.k; d++ {
+			mulAddSlice(rs.enc[p][d], shards[d], out)
+		}
+	}
+}
+
+// reconstruct fills in the shards that are not present from those that
+// are, of which there must be at least k. Every shard has the same length,
+// including the buffers of those to be filled in.
+func (rs *reedSolomon) reconstruct(shards [][]byte, present []bool) error {
+	var have []int
+	for i := range shards {
+		if present[i] && len(have) < rs.k {
+			have = append(have, i)
+		}
+	}
+	if len(have) < rs.k {
+		return fmt.Errorf("%d shards left, %d needed", len(have), rs.k)
+	}
+	sub := make(gfMatrix, rs.k)
+	for r, i := range have {
+		sub[r] = rs.enc[i]
+	}
+	dec, err := sub.invert()
+	if err != nil {
+		return err
+	}
+	// Recover the missing data shards, then recompute missing parity.
+	for d := 0; d < rs.k; d++ {
+		if present[d] {
+			continue
+		}
+		out := shards[d]
+		for i := range out {
+			out[i] = 0
+		}
+		for c, i := range have {
+			mulAddSlice(dec[d][c], shards[i], out)
+		}
+	}
+	for p := rs.k; p < rs.k+rs.m; p++ {
+		if present[p] {
+			continue
+		}
+		out := shards[p]
+		for i := range out {
+			out[i] = 0
+		}
+		for d := 0; d < rs.k; d++ {
+			mulAddSlice(rs.enc[p][d], shards[d], out)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+package store
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestReedSolomonReconstruct(t *testing.T) {
+	const k, m, size = 4, 3, 100
+	rs, err := newReedSolomon(k, m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	orig := make([][]byte, k+m)
+	for i := range orig {
+		orig[i] = make([]byte, size)
+		if i < k {
+			copy(orig[i], randomBytes(int64(i), size))
+		}
+	}
+	rs.encode(orig)
+	for d := 0; d < k; d++ {
+		if !bytes.Equal(orig[d], randomBytes(int64(d), size)) {
+			t.Fatal("encoding changed a data shard")
+		}
+	}
+
+	// Every way of losing m shards is recoverable.
+	for lost := 0; lost < 1<<(k+m); lost++ {
+		if popcount(lost) != m {
+			continue
+		}
+		shards := make([][]byte, k+m)
+		present := make([]bool, k+m)
+		for i := range shards {
+			shards[i] = make([]byte, size)
+			if lost&(1<<i) == 0 {
+				copy(shards[i], orig[i])
+				present[i] = true
+			}
+		}
+		if err := rs.reconstruct(shards, present); err != nil {
+			t.Fatalf("lost %b: %v", lost, err)
+		}
+		for i := range shards {
+			if !bytes.Equal(shards[i], orig[i]) {
+				t.Fatalf("lost %b: shard %d reconstructed wrongly", lost, i)
+			}
+		}
+	}
+
+	present := make([]bool, k+m)
+	for i := 0; i < k-1; i++ {
+		present[i] = true
+	}
+	if err := rs.reconstruct(orig, present); err == nil {
+		t.Error("reconstructed from fewer than k shards")
+	}
+}
+
+func popcount(x int) int {
+	n := 0
+	for ; x != 0; x &= x - 1 {
+		n++
+	}
+	return n
+}
+
+func TestReedSolomonLimits(t *testing.T) {
+	if _, err := newReedSolomon(0, 2); err == nil {
+		t.Error("accepted no data shards")
+	}
+	if _, err := newReedSolomon(200, 57); err == nil {
+		t.Error("accepted more than 256 shards")
+	}
+	if _, err := newReedSolomon(250, 6); err != nil {
+		t.Errorf("256 shards: %v", err)
+	}
+}
@@ -0,0 +1,367 @@
+package store
+
+import (
+	"crypto/sha512"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Avalanche-io/c4"
+)
+
+var _ Store = &Reference{}
+
+// ErrStale reports that a file has changed since a Reference indexed it.
+var ErrStale = fmt.Errorf("file changed since it was indexed")
+
+// Reference is a store of files left where they are, such as on read-only
+// media or a NAS share. It keeps an index from IDs to paths, with the size
+// and modification time of each file when it was indexed, and serves the
+// files themselves. A file whose size or modification time has changed is
+// stale: Has ignores it and Open fails with ErrStale. With SetVerify, the
+// content is also checked against its ID as it is read.
+//
+// The index is in dir/index, an append-only file shared by every process
+// using the store. Content cannot be written to a Reference, only indexed
+// with Add or Index; Remove forgets an object without touching its files.
+type Reference struct {
+	dir    string
+	verify bool
+
+	mu   sync.Mutex
+	refs map[c4.ID][]fileRef
+	log  *appendLog // the index
+}
+
+// fileRef is a file holding an object, as it was when indexed.
+type fileRef struct {
+	path    string
+	size    int64
+	modTime int64 // Unix nanoseconds
+}
+
+// OpenReference opens or creates the reference store indexed in dir.
+func OpenReference(dir string) (*Reference, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("create reference store: %w", err)
+	}
+	r := &Reference{dir: dir}
+	r.log = newAppendLog(dir, "index", r)
+	if err := r.update(func() error { return nil }); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// SetVerify sets whether Open checks content against its ID as it is read,
+// failing with ErrInvalidID at the end of content that does not match.
+// Otherwise only the size and modification time are checked.
+func (r *Reference) SetVerify(verify bool) {
+	r.verify = verify
+}
+
+// Close closes the index.
+func (r *Reference) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.log.close()
+}
+
+// Add records that the file at path, described by info, holds the content
+// of id. The caller is responsible for id being right; Index computes it.
+func (r *Reference) Add(path string, id c4.ID, info os.FileInfo) error {
+	if !info.Mode().IsRegular() {
+		return fmt.Errorf("reference %s: not a regular file", path)
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	ref := fileRef{abs, info.Size(), info.ModTime().UnixNano()}
+	return r.update(func() error {
+		for _, have := range r.refs[id] {
+			if have == ref {
+				return nil
+			}
+		}
+		return r.record(fmt.Sprintf("+ %s %d %d %s", id, ref.size, ref.modTime, ref.path))
+	})
+}
+
+// Index identifies the file at path and adds it, returning its ID.
+func (r *Reference) Index(path string) (c4.ID, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return c4.ID{}, err
+	}
+	defer f.Close()
+	before, err := f.Stat()
+	if err != nil {
+		return c4.ID{}, err
+	}
+	id := c4.Identify(f)
+	after, err := f.Stat()
+	if err != nil {
+		return c4.ID{}, err
+	}
+	if !sameFileState(before, after) {
+		return c4.ID{}, &os.PathError{Op: "index", Path: path, Err: ErrStale}
+	}
+	return id, r.Add(path, id, after)
+}
+
+// sameFileState reports whether a and b describe a file with the same
+// size and modification time.
+func sameFileState(a, b os.FileInfo) bool {
+	return a.Size() == b.Size() && a.ModTime().Equal(b.ModTime())
+}
+
+// update runs fn with the index locked, after applying what other
+// processes have appended to it.
+func (r *Reference) update(fn func() error) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.log.update(fn)
+}
+
+// refsOf returns the files indexed for id. If there are none, the index
+// is reread first, in case another process has added some.
+func (r *Reference) refsOf(id c4.ID) []fileRef {
+	r.mu.Lock()
+	refs := r.refs[id]
+	r.mu.Unlock()
+	if refs != nil {
+		return refs
+	}
+	var found []fileRef
+	r.update(func() error {
+		found = r.refs[id]
+		return nil
+	})
+	return found
+}
+
+func (r *Reference) resetLog() { r.refs = make(map[c4.ID][]fileRef) }
+
+// applyLine applies one index line: "+ <id> <size> <mtime> <path>" adds a
+// file, and "- <id>" forgets every file of id. Lines it cannot parse are
+// ignored.
+func (r *Reference) applyLine(line string) {
+	if len(line) < 2 || line[1] != ' ' {
+		return
+	}
+	switch line[0] {
+	case '+':
+		f := strings.SplitN(line[2:], " ", 4)
+		if len(f) != 4 {
+			return
+		}
+		id, err := c4.Parse(f[0])
+		if err != nil {
+			return
+		}
+		size, err1 := strconv.ParseInt(f[1], 10, 64)
+		mod, err2 := strconv.ParseInt(f[2], 10, 64)
+		if err1 != nil || err2 != nil || size < 0 || f[3] == "" {
+			return
+		}
+		// Slices handed out by refsOf are never changed, so build a new one.
+		ref := fileRef{f[3], size, mod}
+		refs := []fileRef{ref}
+		for _, have := range r.refs[id] {
+			if have.path != ref.path {
+				refs = append(refs, have)
+			}
+		}
+		r.refs[id] = refs
+	case '-':
+		if id, err := c4.Parse(line[2:]); err == nil {
+			delete(r.refs, id)
+		}
+	}
+}
+
+// record applies an index line and appends it to the index. It requires
+// update's locks.
+func (r *Reference) record(line string) error {
+	if strings.ContainsAny(line, "\n\r") {
+		return fmt.Errorf("reference: path contains a newline: %q", line)
+	}
+	return r.log.record(line)
+}
+
+func (r *Reference) logLines() int {
+	n := 0
+	for _, refs := range r.refs {
+		n += len(refs)
+	}
+	return n
+}
+
+// writeLog writes one line per indexed file.
+func (r *Reference) writeLog(w io.Writer) {
+	for id, refs := range r.refs {
+		for _, ref := range refs {
+			fmt.Fprintf(w, "+ %s %d %d %s\n", id, ref.size, ref.modTime, ref.path)
+		}
+	}
+}
+
+// fresh reports whether the file of ref is as it was when indexed.
+func (ref fileRef) fresh(info os.FileInfo) bool {
+	return info.Mode().IsRegular() && info.Size() == ref.size && info.ModTime().UnixNano() == ref.modTime
+}
+
+// openFile opens the first file of id that has not changed since it was
+// indexed.
+func (r *Reference) openFile(id c4.ID) (*os.File, error) {
+	refs := r.refsOf(id)
+	if len(refs) == 0 {
+		return nil, &os.PathError{Op: "open", Path: id.String(), Err: os.ErrNotExist}
+	}
+	var err error
+	for _, ref := range refs {
+		var f *os.File
+		if f, err = os.Open(ref.path); err != nil {
+			continue
+		}
+		info, serr := f.Stat()
+		if serr == nil && ref.fresh(info) {
+			return f, nil
+		}
+		f.Close()
+		err = &os.PathError{Op: "open", Path: ref.path, Err: ErrStale}
+	}
+	return nil, err
+}
+
+// Has reports whether a file of id is indexed and unchanged.
+func (r *Reference) Has(id c4.ID) bool {
+	for _, ref := range r.refsOf(id) {
+		if info, err := os.Stat(ref.path); err == nil && ref.fresh(info) {
+			return true
+		}
+	}
+	return false
+}
+
+// Open opens the first unchanged file of id. If every file of id has
+// changed, the error is ErrStale.
+func (r *Reference) Open(id c4.ID) (io.ReadCloser, error) {
+	f, err := r.openFile(id)
+	if err != nil {
+		return nil, err
+	}
+	if r.verify {
+		return &validatingReader{sha512.New(), id, f}, nil
+	}
+	return f, nil
+}
+
+// OpenRange seeks within the file of id. Ranges are not verified.
+func (r *Reference) OpenRange(id c4.ID, off, n int64) (io.ReadCloser, error) {
+	if off < 0 {
+		return nil, fmt.Errorf("open range of %s: negative offset %d", id, off)
+	}
+	f, err := r.openFile(id)
+	if err != nil {
+		return nil, err
+	}
+	return skipTo(f, off, n)
+}
+
+// Create fails: a Reference only indexes files that exist.
+func (r *Reference) Create(id c4.ID) (io.WriteCloser, error) {
+	return nil, ErrNotImplemented
+}
+
+// Put fails: a Reference only indexes files that exist.
+func (r *Reference) Put(rd io.Reader) (c4.ID, error) {
+	return c4.ID{}, ErrNotImplemented
+}
+
+// Remove forgets the files of id. The files are not removed.
+func (r *Reference) Remove(id c4.ID) error {
+	return r.update(func() error {
+		if _, ok := r.refs[id]; !ok {
+			return &os.PathError{Op: "remove", Path: id.String(), Err: os.ErrNotExist}
+		}
+		return r.record("- " + id.String())
+	})
+}
+
+// List lists the objects with an unchanged file, with the size and
+// modification time of the file when it was indexed.
+func (r *Reference) List(fn func(Object) bool) error {
+	var objs []Object
+	err := r.update(func() error {
+		for id := range r.refs {
+			objs = append(objs, Object{ID: id})
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	sort.Slice(objs, func(i, j int) bool { return objs[i].ID.Less(objs[j].ID) })
+	for _, o := range objs {
+		for _, ref := range r.refsOf(o.ID) {
+			if info, err := os.Stat(ref.path); err == nil && ref.fresh(info) {
+				o.Size, o.ModTime = ref.size, time.Unix(0, ref.modTime)
+				if !fn(o) {
+					return nil
+				}
+				break
+			}
+		}
+	}
+	return nil
+}
+
+// Prune forgets the files that have changed or gone since they were
+// indexed, and returns how many it forgot.
+func (r *Reference) Prune() (int, error) {
+	var pruned int
+	err := r.update(func() error {
+		for id, refs := range r.refs {
+			var keep []fileRef
+			for _, ref := range refs {
+				if info, err := os.Stat(ref.path); err == nil && ref.fresh(info) {
+					keep = append(keep, ref)
+				}
+			}
+			if len(keep) == len(refs) {
+				continue
+			}
+			pruned += len(refs) - len(keep)
+			if len(keep) == 0 {
+				delete(r.refs, id)
+			} else {
+				r.refs[id] = keep
+			}
+		}
+		if pruned == 0 {
+			return nil
+		}
+		return r.log.compact()
+	})
+	return pruned, err
+}
+
+// References returns the Reference stores in s, looking through wrappers,
+// caches and the members of a MultiStore.
+func References(s Store) []*Reference {
+	var refs []*Reference
+	walkStores(s, func(s Store) {
+		if r, ok := s.(*Reference); ok {
+			refs = append(refs, r)
+		}
+	})
+	return refs
+}
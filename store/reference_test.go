@@ -0,0 +1,237 @@
+package store
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Avalanche-io/c4"
+)
+
+func TestReference(t *testing.T) {
+	files := t.TempDir()
+	path := filepath.Join(files, "plate 0001.exr")
+	os.WriteFile(path, []byte("pixels"), 0644)
+
+	r, err := OpenReference(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	id, err := r.Index(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != c4.Identify(strings.NewReader("pixels")) {
+		t.Fatalf("Index returned %s", id)
+	}
+	if !r.Has(id) {
+		t.Fatal("Has is false after Index")
+	}
+	rc, err := r.Open(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, _ := io.ReadAll(rc)
+	rc.Close()
+	if string(got) != "pixels" {
+		t.Errorf("Open read %q", got)
+	}
+	rc, err = r.OpenRange(id, 2, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, _ = io.ReadAll(rc)
+	rc.Close()
+	if string(got) != "xel" {
+		t.Errorf("OpenRange read %q", got)
+	}
+
+	if _, err := r.Put(strings.NewReader("new")); err != ErrNotImplemented {
+		t.Errorf("Put: %v, want ErrNotImplemented", err)
+	}
+
+	// Removing forgets the file without touching it.
+	if err := r.Remove(id); err != nil {
+		t.Fatal(err)
+	}
+	if r.Has(id) {
+		t.Error("Has is true after Remove")
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("Remove touched the file: %v", err)
+	}
+}
+
+func TestReferenceStale(t *testing.T) {
+	files := t.TempDir()
+	a, b := filepath.Join(files, "a"), filepath.Join(files, "b")
+	os.WriteFile(a, []byte("same"), 0644)
+	os.WriteFile(b, []byte("same"), 0644)
+
+	r, err := OpenReference(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	id, _ := r.Index(a)
+	r.Index(b)
+
+	// A changed copy is skipped for one that is unchanged.
+	os.WriteFile(a, []byte("different"), 0644)
+	if !r.Has(id) {
+		t.Fatal("Has is false with one copy unchanged")
+	}
+	rc, err := r.Open(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, _ := io.ReadAll(rc)
+	rc.Close()
+	if string(got) != "same" {
+		t.Errorf("Open read %q from the changed copy", got)
+	}
+
+	// Same size and content, but touched.
+	future := time.Now().Add(time.Hour)
+	os.Chtimes(b, future, future)
+	if r.Has(id) {
+		t.Error("Has is true with every copy changed")
+	}
+	if _, err := r.Open(id); !errors.Is(err, ErrStale) {
+		t.Errorf("Open of changed files: %v, want ErrStale", err)
+	}
+
+	n, err := r.Prune()
+	if err != nil || n != 2 {
+		t.Errorf("Prune: %d, %v; want 2", n, err)
+	}
+	if _, err := r.Open(id); !os.IsNotExist(err) {
+		t.Errorf("Open after Prune: %v", err)
+	}
+}
+
+// List describes the unchanged file it found, not the newest indexed.
+func TestReferenceListSize(t *testing.T) {
+	files := t.TempDir()
+	a, b := filepath.Join(files, "a"), filepath.Join(files, "b")
+	os.WriteFile(a, []byte("same"), 0644)
+	os.WriteFile(b, []byte("longer content"), 0644)
+
+	r, err := OpenReference(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	id, _ := r.Index(a)
+	info, _ := os.Stat(b)
+	if err := r.Add(b, id, info); err != nil {
+		t.Fatal(err)
+	}
+	future := time.Now().Add(time.Hour)
+	os.Chtimes(b, future, future)
+
+	var objs []Object
+	if err := r.List(func(o Object) bool { objs = append(objs, o); return true }); err != nil {
+		t.Fatal(err)
+	}
+	if len(objs) != 1 || objs[0].Size != 4 {
+		t.Errorf("List gave %v, want one object of 4 bytes", objs)
+	}
+}
+
+func TestReferenceVerify(t *testing.T) {
+	files := t.TempDir()
+	path := filepath.Join(files, "f")
+	os.WriteFile(path, []byte("original"), 0644)
+	info, _ := os.Stat(path)
+
+	r, err := OpenReference(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	// Recorded under the wrong ID, as if the file had been rewritten in
+	// place without its size or time changing.
+	wrong := testID("not the content")
+	if err := r.Add(path, wrong, info); err != nil {
+		t.Fatal(err)
+	}
+
+	r.SetVerify(true)
+	rc, err := r.Open(wrong)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = io.ReadAll(rc)
+	rc.Close()
+	if err != ErrInvalidID {
+		t.Errorf("verified read: %v, want ErrInvalidID", err)
+	}
+}
+
+func TestReferenceShared(t *testing.T) {
+	files, dir := t.TempDir(), t.TempDir()
+	var ids []c4.ID
+	for _, name := range []string{"a", "b", "c"} {
+		os.WriteFile(filepath.Join(files, name), []byte(name), 0644)
+	}
+
+	r1, err := OpenReference(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r1.Close()
+	r2, err := OpenReference(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r2.Close()
+
+	for _, name := range []string{"a", "b", "c"} {
+		id, err := r1.Index(filepath.Join(files, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+	// Another process sees what was indexed, and what was forgotten.
+	if !r2.Has(ids[0]) {
+		t.Error("a second Reference does not see an indexed file")
+	}
+	r1.Remove(ids[1])
+	var listed []c4.ID
+	if err := r2.List(func(o Object) bool {
+		listed = append(listed, o.ID)
+		return true
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if len(listed) != 2 {
+		t.Errorf("listed %d objects, want 2", len(listed))
+	}
+
+	// A reopened store reads the index back, even after a torn write.
+	f, _ := os.OpenFile(filepath.Join(dir, "index"), os.O_APPEND|os.O_WRONLY, 0)
+	f.WriteString("+ " + ids[1].String() + " 1")
+	f.Close()
+	os.WriteFile(filepath.Join(files, "d"), []byte("d"), 0644)
+	if _, err := r1.Index(filepath.Join(files, "d")); err != nil {
+		t.Fatal(err)
+	}
+	r3, err := OpenReference(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r3.Close()
+	if !r3.Has(ids[0]) || r3.Has(ids[1]) || !r3.Has(ids[2]) {
+		t.Error("reopened index is wrong")
+	}
+	if !r3.Has(c4.Identify(strings.NewReader("d"))) {
+		t.Error("a file indexed after a torn line is lost")
+	}
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+// +build !linux
+
+package store
+
+import (
+	"os"
+	"syscall"
+)
+
+// reflink fails: cloning files is only supported on Linux.
+func reflink(dst, src *os.File) error {
+	return &os.LinkError{Op: "reflink", Old: src.Name(), New: dst.Name(), Err: syscall.ENOTSUP}
+}
@@ -0,0 +1,251 @@
+package store
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"sync"
+
+	"github.com/Avalanche-io/c4"
+)
+
+// ReplicateOptions configures Replicate.
+type ReplicateOptions struct {
+	// Workers is the number of objects copied in parallel. The default is
+	// twice the number of CPUs, since copies are usually I/O bound.
+	Workers int
+
+	// Log, if set, is a file recording each ID known to be in the
+	// destination, one per line. IDs already in the log are skipped
+	// without asking the destination, so an interrupted run can be
+	// resumed by running it again with the same log.
+	Log string
+
+	// DryRun reports what would be copied without copying anything.
+	DryRun bool
+
+	// ServerCopy has S3 copy objects itself, with CopyTo, when src and dst
+	// are S3Stores on the same service. Such copies do not pass through
+	// this process and are not re-hashed on arrival.
+	ServerCopy bool
+
+	// OnCopy, if set, is called after each object is copied (or, in a
+	// dry run, for each object that would be, with a size of zero). Calls
+	// are serialized.
+	OnCopy func(id c4.ID, size int64)
+}
+
+// ReplicateReport summarizes a replication.
+type ReplicateReport struct {
+	Copied  int   // objects copied
+	Bytes   int64 // bytes copied
+	Present int   // objects already in the destination
+	Resumed int   // objects skipped because the log lists them
+	Missing []c4.ID
+	Failed  map[c4.ID]error
+}
+
+// Replicate copies each of ids that the destination lacks from src to dst.
+// Every copy is verified: the destination must compute the same ID for
+// what it stored, unless S3 made the copy with ServerCopy. IDs the source
+// does not have are reported as missing.
+func Replicate(src, dst Store, ids []c4.ID, opts ReplicateOptions) (*ReplicateReport, error) {
+	return ReplicateContext(context.Background(), src, dst, ids, opts)
+}
+
+// ReplicateContext is Replicate with a context. Cancelling it stops the
+// copies in progress and starts no more; the report covers what was done,
+// and the context's error is returned.
+func ReplicateContext(ctx context.Context, src, dst Store, ids []c4.ID, opts ReplicateOptions) (*ReplicateReport, error) {
+	if opts.Workers <= 0 {
+		opts.Workers = 2 * runtime.NumCPU()
+	}
+	rep := &ReplicateReport{Failed: make(map[c4.ID]error)}
+
+	var log *replicationLog
+	if opts.Log != "" {
+		var err error
+		if log, err = openReplicationLog(opts.Log); err != nil {
+			return nil, err
+		}
+		defer log.Close()
+	}
+
+	var mu sync.Mutex
+	work := make(chan c4.ID)
+	var wg sync.WaitGroup
+	for i := 0; i < opts.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for id := range work {
+				r := replicateOne(ctx, src, dst, id, opts)
+				mu.Lock()
+				switch {
+				case r.err != nil:
+					rep.Failed[id] = r.err
+				case r.missing:
+					rep.Missing = append(rep.Missing, id)
+				case r.present:
+					rep.Present++
+				default:
+					rep.Copied++
+					rep.Bytes += r.size
+					if opts.OnCopy != nil {
+						opts.OnCopy(id, r.size)
+					}
+				}
+				if log != nil && !opts.DryRun && r.err == nil && !r.missing {
+					if err := log.add(id); err != nil {
+						rep.Failed[id] = err
+					}
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	seen := make(map[c4.ID]bool, len(ids))
+	for _, id := range ids {
+		if id.IsNil() || seen[id] {
+			continue
+		}
+		seen[id] = true
+		if log != nil && log.done[id] {
+			rep.Resumed++
+			continue
+		}
+		select {
+		case work <- id:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	close(work)
+	wg.Wait()
+	return rep, ctx.Err()
+}
+
+type replicateResult struct {
+	size    int64
+	present bool
+	missing bool
+	err     error
+}
+
+func replicateOne(ctx context.Context, src, dst Store, id c4.ID, opts ReplicateOptions) replicateResult {
+	if ok, _ := WithContext(dst).HasContext(ctx, id); ok {
+		return replicateResult{present: true}
+	}
+	ok, _ := WithContext(src).HasContext(ctx, id)
+	if err := ctx.Err(); err != nil {
+		return replicateResult{err: err}
+	}
+	if !ok {
+		return replicateResult{missing: true}
+	}
+	if opts.DryRun {
+		return replicateResult{}
+	}
+	if from, to, ok := serverCopyPair(src, dst); ok && opts.ServerCopy {
+		size, err := from.copyTo(ctx, to, id)
+		return replicateResult{size: size, err: err}
+	}
+	rc, err := WithContext(src).OpenContext(ctx, id)
+	if err != nil {
+		return replicateResult{err: err}
+	}
+	defer rc.Close()
+	// Checked before it is stored, so that damaged or partial content from
+	// the source is never stored, under id or any other.
+	w, err := NewValidating(dst).create(ctx, id)
+	if os.IsExist(err) {
+		return replicateResult{present: true}
+	}
+	if err != nil {
+		return replicateResult{err: err}
+	}
+	cr := &countingReader{r: rc}
+	if _, err := io.Copy(w, cr); err != nil {
+		w.Abort()
+		return replicateResult{err: err}
+	}
+	if err := w.Close(); err != nil {
+		return replicateResult{err: fmt.Errorf("copy of %s: %w", id, err)}
+	}
+	return replicateResult{size: cr.n}
+}
+
+// serverCopyPair returns src and dst as S3Stores if S3 can copy between
+// them.
+func serverCopyPair(src, dst Store) (*S3Store, *S3Store, bool) {
+	from, ok := src.(*S3Store)
+	if !ok {
+		return nil, nil, false
+	}
+	to, ok := dst.(*S3Store)
+	if !ok || !from.sameService(to) {
+		return nil, nil, false
+	}
+	return from, to, true
+}
+
+// replicationLog is the resumable progress log of Replicate.
+type replicationLog struct {
+	f    *os.File
+	done map[c4.ID]bool
+}
+
+func openReplicationLog(path string) (*replicationLog, error) {
+	l := &replicationLog{done: make(map[c4.ID]bool)}
+	if f, err := os.Open(path); err == nil {
+		sc := bufio.NewScanner(f)
+		for sc.Scan() {
+			// A line cut short by a crash fails to parse and is ignored.
+			if id, err := c4.Parse(sc.Text()); err == nil {
+				l.done[id] = true
+			}
+		}
+		f.Close()
+		if err := sc.Err(); err != nil {
+			return nil, fmt.Errorf("read replication log: %w", err)
+		}
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	l.f = f
+	return l, nil
+}
+
+func (l *replicationLog) add(id c4.ID) error {
+	_, err := l.f.WriteString(id.String() + "\n")
+	return err
+}
+
+func (l *replicationLog) Close() error {
+	return l.f.Close()
+}
+
+// countingReader counts the bytes read through it, and remembers the
+// first error other than io.EOF.
+type countingReader struct {
+	r   io.Reader
+	n   int64
+	err error
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	if err != nil && err != io.EOF && c.err == nil {
+		c.err = err
+	}
+	return n, err
+}
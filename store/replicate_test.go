@@ -0,0 +1,153 @@
+package store
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Avalanche-io/c4"
+)
+
+func TestReplicate(t *testing.T) {
+	src, dst := NewRAM(), NewRAM()
+	a := putString(t, src, "alpha")
+	b := putString(t, src, "beta")
+	c := putString(t, src, "gamma")
+	putString(t, dst, "beta")
+	absent := c4.Identify(strings.NewReader("nowhere"))
+
+	var copied []c4.ID
+	rep, err := Replicate(src, dst, []c4.ID{a, b, c, a, absent}, ReplicateOptions{
+		Workers: 2,
+		OnCopy:  func(id c4.ID, size int64) { copied = append(copied, id) },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rep.Copied != 2 || rep.Bytes != int64(len("alpha")+len("gamma")) || rep.Present != 1 {
+		t.Errorf("report = %+v", rep)
+	}
+	if len(rep.Missing) != 1 || rep.Missing[0] != absent {
+		t.Errorf("missing = %v, want [%s]", rep.Missing, absent)
+	}
+	if len(copied) != 2 {
+		t.Errorf("OnCopy called %d times, want 2", len(copied))
+	}
+	for _, id := range []c4.ID{a, b, c} {
+		if !dst.Has(id) {
+			t.Errorf("%s not replicated", id)
+		}
+	}
+}
+
+func TestReplicateDryRun(t *testing.T) {
+	src, dst := NewRAM(), NewRAM()
+	id := putString(t, src, "alpha")
+
+	rep, err := Replicate(src, dst, []c4.ID{id}, ReplicateOptions{DryRun: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rep.Copied != 1 || dst.Has(id) {
+		t.Errorf("dry run: copied %d, dst has it: %v", rep.Copied, dst.Has(id))
+	}
+}
+
+func TestReplicateResume(t *testing.T) {
+	src := NewRAM()
+	ids := []c4.ID{putString(t, src, "one"), putString(t, src, "two")}
+	log := filepath.Join(t.TempDir(), "progress.log")
+
+	rep, err := Replicate(src, NewRAM(), ids, ReplicateOptions{Log: log})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rep.Copied != 2 {
+		t.Fatalf("copied %d, want 2", rep.Copied)
+	}
+
+	// A crash can leave a partial last line; it must not stop a resume.
+	f, err := os.OpenFile(log, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(ids[0].String()[:20])
+	f.Close()
+
+	third := putString(t, src, "three")
+	dst := NewRAM()
+	rep, err = Replicate(src, dst, append(ids, third), ReplicateOptions{Log: log})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rep.Resumed != 2 || rep.Copied != 1 || !dst.Has(third) || dst.Has(ids[0]) {
+		t.Errorf("resume: %+v", rep)
+	}
+}
+
+func TestReplicateVerifies(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "liar")
+	os.WriteFile(path, []byte("not what you asked for"), 0644)
+	want := c4.Identify(strings.NewReader("the real content"))
+	src := MAP{want: path}
+	dst := NewRAM()
+
+	rep, err := Replicate(src, dst, []c4.ID{want}, ReplicateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rep.Failed[want] == nil || rep.Copied != 0 {
+		t.Fatalf("damaged source copy not rejected: %+v", rep)
+	}
+	if n := len(listedIDs(t, dst, nil)); n != 0 {
+		t.Errorf("destination holds %d objects after a rejected copy", n)
+	}
+
+	// Content the destination already holds under the ID of what arrived
+	// is left alone.
+	held, _ := dst.Put(strings.NewReader("not what you asked for"))
+	if rep, _ := Replicate(src, dst, []c4.ID{want}, ReplicateOptions{}); rep.Failed[want] == nil {
+		t.Fatalf("damaged source copy not rejected: %+v", rep)
+	}
+	if !dst.Has(held) || dst.Has(want) {
+		t.Error("a rejected copy removed content the destination held")
+	}
+}
+
+func TestReplicateCancelled(t *testing.T) {
+	src, dst := NewRAM(), NewRAM()
+	ids := putN(t, src, 10, 100)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	rep, err := ReplicateContext(ctx, src, dst, ids, ReplicateOptions{})
+	if err != context.Canceled {
+		t.Fatalf("ReplicateContext after cancel: got %v, want context.Canceled", err)
+	}
+	if rep.Copied != 0 || len(listedIDs(t, dst, nil)) != 0 {
+		t.Errorf("cancelled replication copied %d objects", rep.Copied)
+	}
+}
+
+func TestOpenURI(t *testing.T) {
+	dir := t.TempDir()
+	s, err := OpenURI(filepath.Join(dir, "a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := s.(*TreeStore); !ok {
+		t.Errorf("single path opened as %T", s)
+	}
+	s, err = OpenURI(filepath.Join(dir, "a") + ", " + filepath.Join(dir, "b"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := s.(*MultiStore); !ok {
+		t.Errorf("list opened as %T", s)
+	}
+	if _, err := OpenURI(" , "); err == nil {
+		t.Error("empty URI should fail")
+	}
+}
@@ -0,0 +1,135 @@
+package store
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"hash"
+	"net/http"
+)
+
+// S3Checksum is a checksum S3Store sends with each upload, so that S3
+// rejects content damaged on the way.
+type S3Checksum int
+
+const (
+	// ChecksumSHA256 sends x-amz-checksum-sha256, which S3 keeps with the
+	// object. It is the default.
+	ChecksumSHA256 S3Checksum = iota
+	// ChecksumMD5 sends Content-MD5, for services without SHA-256
+	// checksums.
+	ChecksumMD5
+	// ChecksumNone sends no checksum.
+	ChecksumNone
+)
+
+// ParseS3Checksum parses "sha256", "md5" or "none".
+func ParseS3Checksum(s string) (S3Checksum, error) {
+	for c := ChecksumSHA256; c <= ChecksumNone; c++ {
+		if s == c.String() {
+			return c, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown S3 checksum %q", s)
+}
+
+func (c S3Checksum) String() string {
+	switch c {
+	case ChecksumSHA256:
+		return "sha256"
+	case ChecksumMD5:
+		return "md5"
+	case ChecksumNone:
+		return "none"
+	}
+	return fmt.Sprintf("S3Checksum(%d)", int(c))
+}
+
+// partSums computes the checksums of content in parts of partSize bytes,
+// as it is written: the SHA-256 of each part, which signs its upload, and
+// its MD5 too for ChecksumMD5. Content of one part or less is sent in a
+// single upload, whose checksums are those of its one part.
+type partSums struct {
+	checksum S3Checksum
+	partSize int64
+	sha, md5 hash.Hash // of the current part; md5 is nil unless needed
+	n        int64     // bytes in the current part
+	parts    []partSum
+}
+
+// partSum is the checksums of one part.
+type partSum struct {
+	sha256, md5 []byte
+}
+
+func newPartSums(c S3Checksum, partSize int64) *partSums {
+	ps := &partSums{checksum: c, partSize: partSize, sha: sha256.New()}
+	if c == ChecksumMD5 {
+		ps.md5 = md5.New()
+	}
+	return ps
+}
+
+func (ps *partSums) Write(b []byte) (int, error) {
+	n := len(b)
+	for len(b) > 0 {
+		if ps.n == ps.partSize {
+			ps.endPart()
+		}
+		chunk := b
+		if room := ps.partSize - ps.n; int64(len(chunk)) > room {
+			chunk = chunk[:room]
+		}
+		ps.sha.Write(chunk)
+		if ps.md5 != nil {
+			ps.md5.Write(chunk)
+		}
+		ps.n += int64(len(chunk))
+		b = b[len(chunk):]
+	}
+	return n, nil
+}
+
+func (ps *partSums) endPart() {
+	p := partSum{sha256: ps.sha.Sum(nil)}
+	ps.sha.Reset()
+	if ps.md5 != nil {
+		p.md5 = ps.md5.Sum(nil)
+		ps.md5.Reset()
+	}
+	ps.parts = append(ps.parts, p)
+	ps.n = 0
+}
+
+// finish ends the last part and returns the checksums of every part.
+func (ps *partSums) finish() []partSum {
+	if ps.n > 0 || len(ps.parts) == 0 {
+		ps.endPart()
+	}
+	return ps.parts
+}
+
+// sum returns the checksum S3 is sent for p, or nil for ChecksumNone.
+func (c S3Checksum) sum(p partSum) []byte {
+	switch c {
+	case ChecksumSHA256:
+		return p.sha256
+	case ChecksumMD5:
+		return p.md5
+	}
+	return nil
+}
+
+// setHeader sets the header carrying sum, if there is one.
+func (c S3Checksum) setHeader(h http.Header, sum []byte) {
+	if sum == nil {
+		return
+	}
+	switch c {
+	case ChecksumSHA256:
+		h.Set("x-amz-checksum-sha256", base64.StdEncoding.EncodeToString(sum))
+	case ChecksumMD5:
+		h.Set("Content-MD5", base64.StdEncoding.EncodeToString(sum))
+	}
+}
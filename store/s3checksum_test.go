@@ -0,0 +1,136 @@
+package store
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/Avalanche-io/c4"
+)
+
+func TestS3Checksums(t *testing.T) {
+	for _, c := range []S3Checksum{ChecksumSHA256, ChecksumMD5, ChecksumNone} {
+		f := newFakeS3(t, "bucket")
+		s := newMultipartStore(t, f)
+		s.SetChecksum(c)
+		want := c.String()
+		if c == ChecksumNone {
+			want = ""
+		}
+
+		for i, size := range []int{100, 3<<10 + 10} {
+			data := randomBytes(int64(i), size)
+			id, err := s.Put(bytes.NewReader(data))
+			if err != nil {
+				t.Fatalf("%s: Put of %d bytes: %v", c, size, err)
+			}
+			if got, _ := f.get("c4/" + id.String()); !bytes.Equal(got, data) {
+				t.Errorf("%s: %d bytes did not round-trip", c, size)
+			}
+		}
+		// A Create is checksummed as it is written.
+		data := randomBytes(3, 200)
+		w, err := s.Create(c4.Identify(bytes.NewReader(data)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write(data[:50])
+		w.Write(data[50:])
+		if err := w.Close(); err != nil {
+			t.Fatalf("%s: Create: %v", c, err)
+		}
+
+		// One object, four parts and one created object.
+		if len(f.checksums) != 6 {
+			t.Fatalf("%s: %d uploads, want 6", c, len(f.checksums))
+		}
+		for _, got := range f.checksums {
+			if got != want {
+				t.Errorf("%s: upload sent checksum %q", c, got)
+			}
+		}
+	}
+}
+
+func TestPartSums(t *testing.T) {
+	data := randomBytes(5, 2500)
+	ps := newPartSums(ChecksumMD5, 1000)
+	for _, n := range []int{1, 999, 1000, 7, 493} {
+		ps.Write(data[:n])
+		data = data[n:]
+	}
+	data = randomBytes(5, 2500)
+	parts := ps.finish()
+	if len(parts) != 3 {
+		t.Fatalf("%d parts, want 3", len(parts))
+	}
+	for i, p := range parts {
+		end := (i + 1) * 1000
+		if end > len(data) {
+			end = len(data)
+		}
+		sha := sha256.Sum256(data[i*1000 : end])
+		sum := md5.Sum(data[i*1000 : end])
+		if !bytes.Equal(p.sha256, sha[:]) || !bytes.Equal(p.md5, sum[:]) {
+			t.Errorf("part %d: wrong checksums", i+1)
+		}
+	}
+
+	// Empty content is one empty part.
+	if parts := newPartSums(ChecksumSHA256, 1000).finish(); len(parts) != 1 || parts[0].md5 != nil {
+		t.Errorf("empty content: %d parts", len(parts))
+	}
+}
+
+func TestS3ChecksumCatchesDamage(t *testing.T) {
+	for _, c := range []S3Checksum{ChecksumSHA256, ChecksumMD5, ChecksumNone} {
+		f := newFakeS3(t, "bucket")
+		s := newMultipartStore(t, f)
+		s.SetChecksum(c)
+		f.damage = func(data []byte) bool { return true }
+
+		for i, size := range []int{100, 2 << 10} {
+			data := randomBytes(int64(10+i), size)
+			id, err := s.Put(bytes.NewReader(data))
+			if c == ChecksumNone {
+				if err != nil {
+					t.Errorf("none: Put: %v", err)
+				}
+				continue
+			}
+			if err == nil {
+				t.Errorf("%s: damaged upload of %d bytes accepted", c, size)
+			}
+			if _, ok := f.get("c4/" + id.String()); ok {
+				t.Errorf("%s: damaged upload of %d bytes stored", c, size)
+			}
+		}
+	}
+}
+
+func TestOpenS3ConfiguredChecksum(t *testing.T) {
+	for _, k := range []string{"AWS_ACCESS_KEY_ID", "AWS_SECRET_ACCESS_KEY"} {
+		old, ok := os.LookupEnv(k)
+		os.Setenv(k, "x")
+		if ok {
+			defer os.Setenv(k, old)
+		} else {
+			defer os.Unsetenv(k)
+		}
+	}
+	for _, c := range []S3Checksum{ChecksumSHA256, ChecksumMD5, ChecksumNone} {
+		s, err := openS3Configured(fmt.Sprintf("s3://bucket/c4?checksum=%s", c))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s.checksum != c {
+			t.Errorf("checksum=%s opened a store with %s", c, s.checksum)
+		}
+	}
+	if _, err := openS3Configured("s3://bucket/c4?checksum=crc32"); err == nil {
+		t.Error("checksum=crc32 accepted")
+	}
+}
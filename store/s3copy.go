@@ -0,0 +1,179 @@
+package store
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+
+	"github.com/Avalanche-io/c4"
+)
+
+var (
+	// maxCopySize is the largest object S3 copies in one request. Larger
+	// objects are copied in parts.
+	maxCopySize int64 = 5 << 30
+	// copyPartSize is the size of the parts of a multipart copy.
+	copyPartSize int64 = 512 << 20
+)
+
+// CopyTo copies the content of id to dest with a server-side copy, so the
+// content does not pass through this process. The stores must be on the
+// same service, and dest's credentials must be able to read from s's
+// bucket. Nothing is copied if dest already has the content.
+func (s *S3Store) CopyTo(dest *S3Store, id c4.ID) error {
+	ctx := context.Background()
+	if ok, err := dest.HasContext(ctx, id); err != nil {
+		return fmt.Errorf("s3 copy %s: %w", id, err)
+	} else if ok {
+		return nil
+	}
+	_, err := s.copyTo(ctx, dest, id)
+	return err
+}
+
+// copyTo copies the content of id to dest whether or not dest has it, and
+// returns the number of bytes copied.
+func (s *S3Store) copyTo(ctx context.Context, dest *S3Store, id c4.ID) (int64, error) {
+	if !s.sameService(dest) {
+		return 0, fmt.Errorf("s3 copy %s: the stores are on different services", id)
+	}
+	key := s.objectKey(id)
+	size, err := s.sizeOf(ctx, key, id.String())
+	if err != nil {
+		return 0, err
+	}
+	if err := dest.copyObject(ctx, s.bucket, key, dest.objectKey(id), size); err != nil {
+		return 0, fmt.Errorf("s3 copy %s: %w", id, err)
+	}
+	return size, nil
+}
+
+// sameService reports whether s and other are on the same S3 service, so
+// that objects can be copied between them server-side.
+func (s *S3Store) sameService(other *S3Store) bool {
+	return s.endpoint == other.endpoint
+}
+
+// sizeOf returns the size of an object from a HEAD request. name
+// identifies it in errors.
+func (s *S3Store) sizeOf(ctx context.Context, key, name string) (int64, error) {
+	req, err := http.NewRequestWithContext(ctx, "HEAD", s.objectURL(key), nil)
+	if err != nil {
+		return 0, fmt.Errorf("s3 stat: %w", err)
+	}
+	s.signRequest(req, "UNSIGNED-PAYLOAD")
+
+	resp, err := s.doWithRetry(req)
+	if err != nil {
+		return 0, fmt.Errorf("s3 stat: %w", err)
+	}
+	resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return resp.ContentLength, nil
+	case http.StatusNotFound:
+		return 0, &os.PathError{Op: "s3 stat", Path: name, Err: os.ErrNotExist}
+	}
+	return 0, fmt.Errorf("s3 stat %s: unexpected status %d", name, resp.StatusCode)
+}
+
+// copyObject copies size bytes from srcKey in srcBucket to dstKey in the
+// store's bucket, in parts if S3 cannot copy that much at once.
+func (s *S3Store) copyObject(ctx context.Context, srcBucket, srcKey, dstKey string, size int64) error {
+	source := "/" + srcBucket + "/" + uriEncodePath(srcKey)
+	if size > maxCopySize {
+		return s.multipartCopy(ctx, source, dstKey, size)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "PUT", s.objectURL(dstKey), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("x-amz-copy-source", source)
+	if s.checksum == ChecksumSHA256 {
+		req.Header.Set("x-amz-checksum-algorithm", "SHA256")
+	}
+	s.signRequest(req, hashSHA256(nil))
+
+	resp, err := s.doWithRetry(req)
+	if err != nil {
+		return err
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	// A copy can fail after a 200 status, with an error in the body.
+	var e s3Error
+	if resp.StatusCode != http.StatusOK || xml.Unmarshal(body, &e) == nil && e.Code != "" {
+		return fmt.Errorf("copy: %s", parseS3Error(body, resp.StatusCode))
+	}
+	return nil
+}
+
+// multipartCopy copies source to key in parts of copyPartSize, copying
+// s.concurrency parts at a time. A failed copy is aborted rather than
+// resumed; no content has to be sent again.
+func (s *S3Store) multipartCopy(ctx context.Context, source, key string, size int64) error {
+	uploadID, err := s.initiateMultipart(ctx, key)
+	if err != nil {
+		return fmt.Errorf("initiate multipart: %w", err)
+	}
+	parts := make([]completedPart, int((size+copyPartSize-1)/copyPartSize))
+	err = fanOut(ctx, len(parts), s.concurrency, func(ctx context.Context, i int) error {
+		first := int64(i) * copyPartSize
+		last := first + copyPartSize - 1
+		if last >= size {
+			last = size - 1
+		}
+		var err error
+		parts[i], err = s.copyPart(ctx, source, key, uploadID, i+1, first, last)
+		return err
+	})
+	if err == nil {
+		err = s.completeMultipart(ctx, key, uploadID, parts)
+	}
+	if err != nil {
+		s.abortMultipart(context.Background(), key, uploadID)
+		return err
+	}
+	return nil
+}
+
+// copyPart copies bytes first through last of source into a part.
+func (s *S3Store) copyPart(ctx context.Context, source, key, uploadID string, partNum int, first, last int64) (completedPart, error) {
+	params := map[string]string{
+		"partNumber": strconv.Itoa(partNum),
+		"uploadId":   uploadID,
+	}
+	req, err := http.NewRequestWithContext(ctx, "PUT", s.bucketURL(key, params), nil)
+	if err != nil {
+		return completedPart{}, err
+	}
+	req.Header.Set("x-amz-copy-source", source)
+	req.Header.Set("x-amz-copy-source-range", fmt.Sprintf("bytes=%d-%d", first, last))
+	s.signRequest(req, hashSHA256(nil))
+
+	resp, err := s.doWithRetry(req)
+	if err != nil {
+		return completedPart{}, err
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	var result struct {
+		ETag           string `xml:"ETag"`
+		ChecksumSHA256 string `xml:"ChecksumSHA256"`
+	}
+	var e s3Error
+	if resp.StatusCode != http.StatusOK || xml.Unmarshal(body, &e) == nil && e.Code != "" {
+		return completedPart{}, fmt.Errorf("copy part %d: %s", partNum, parseS3Error(body, resp.StatusCode))
+	}
+	if err := xml.Unmarshal(body, &result); err != nil {
+		return completedPart{}, fmt.Errorf("parse copy part response: %w", err)
+	}
+	return completedPart{num: partNum, etag: result.ETag, checksum: result.ChecksumSHA256}, nil
+}
@@ -0,0 +1,90 @@
+package store
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"testing"
+)
+
+func TestS3CopyTo(t *testing.T) {
+	f := newFakeS3(t, "bucket")
+	src, dst := f.store("c4/"), f.store("mirror/")
+	data := randomBytes(1, 1000)
+	id, err := src.Put(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := src.CopyTo(dst, id); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := f.get("mirror/" + id.String()); !bytes.Equal(got, data) {
+		t.Error("copy has the wrong content")
+	}
+	if len(f.copies) != 1 || f.copies[0] != "/bucket/c4/"+id.String() {
+		t.Errorf("copy requests %v, want one from the source key", f.copies)
+	}
+
+	// Content already there is not copied again.
+	if err := src.CopyTo(dst, id); err != nil || len(f.copies) != 1 {
+		t.Errorf("second CopyTo: %v, %d copy requests", err, len(f.copies))
+	}
+
+	missing := testID("missing")
+	if err := src.CopyTo(dst, missing); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("CopyTo of a missing object: %v", err)
+	}
+	id2, _ := src.Put(bytes.NewReader(randomBytes(2, 1000)))
+	other := NewS3Store("bucket", "c4/", "us-east-1", "http://elsewhere.invalid", "AKID", "SECRET")
+	if err := other.CopyTo(dst, id2); err == nil || dst.Has(id2) {
+		t.Errorf("CopyTo between services: %v", err)
+	}
+}
+
+func TestS3CopyToMultipart(t *testing.T) {
+	defer func(size, part int64) { maxCopySize, copyPartSize = size, part }(maxCopySize, copyPartSize)
+	maxCopySize, copyPartSize = 2<<10, 1<<10
+
+	f := newFakeS3(t, "bucket")
+	src, dst := f.store("c4/"), f.store("mirror/")
+	data := randomBytes(3, 3<<10+500)
+	id, err := src.Put(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := src.CopyTo(dst, id); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := f.get("mirror/" + id.String()); !bytes.Equal(got, data) {
+		t.Error("multipart copy has the wrong content")
+	}
+	if len(f.copies) != 4 {
+		t.Errorf("%d parts copied, want 4", len(f.copies))
+	}
+	if f.uploadCount() != 0 {
+		t.Error("multipart copy left an upload incomplete")
+	}
+}
+
+func TestReplicateServerCopy(t *testing.T) {
+	f := newFakeS3(t, "bucket")
+	src, dst := f.store("c4/"), f.store("mirror/")
+	ids := putN(t, src, 5, 100)
+
+	rep, err := Replicate(src, dst, ids, ReplicateOptions{ServerCopy: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rep.Copied != 5 || rep.Bytes != 500 {
+		t.Errorf("copied %d objects, %d bytes; want 5, 500", rep.Copied, rep.Bytes)
+	}
+	if len(f.copies) != 5 {
+		t.Errorf("%d server-side copies, want 5", len(f.copies))
+	}
+	for _, id := range ids {
+		if !dst.Has(id) {
+			t.Errorf("%s not replicated", id)
+		}
+	}
+}
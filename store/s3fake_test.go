@@ -0,0 +1,370 @@
+package store
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeS3 is an in-memory S3 bucket serving the subset of the API that
+// S3Store uses.
+type fakeS3 struct {
+	*httptest.Server
+	bucket   string
+	pageSize int
+
+	mu      sync.Mutex
+	objects map[string][]byte
+	modTime map[string]time.Time
+	ranges  []string // Range header of each GET
+
+	uploads    map[string]*fakeUpload // by upload ID
+	nextUpload int
+	partPuts   []int // part number of each part uploaded
+	inFlight   int   // part uploads in progress
+	maxFlight  int   // most part uploads in progress at once
+	partDelay  time.Duration
+	failPart   func(num int) bool // parts to reject
+
+	checksums []string               // checksum sent with each upload or part
+	damage    func(data []byte) bool // uploads to damage in transit
+	copies    []string               // x-amz-copy-source of each copy request
+}
+
+// fakeUpload is an incomplete multipart upload.
+type fakeUpload struct {
+	key       string
+	initiated time.Time
+	checksum  string // x-amz-checksum-algorithm
+	parts     map[int][]byte
+}
+
+// newFakeS3 starts a fake S3 server. The server is closed when the test
+// ends.
+func newFakeS3(t *testing.T, bucket string) *fakeS3 {
+	f := &fakeS3{
+		bucket:   bucket,
+		pageSize: 1000,
+		objects:  make(map[string][]byte),
+		modTime:  make(map[string]time.Time),
+		uploads:  make(map[string]*fakeUpload),
+	}
+	f.Server = httptest.NewServer(http.HandlerFunc(f.serve))
+	t.Cleanup(f.Close)
+	return f
+}
+
+// store returns an S3Store for the fake bucket.
+func (f *fakeS3) store(prefix string) *S3Store {
+	return NewS3Store(f.bucket, prefix, "us-east-1", f.URL, "AKID", "SECRET")
+}
+
+func (f *fakeS3) put(key string, data []byte) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.objects[key] = data
+	f.modTime[key] = time.Now()
+}
+
+func (f *fakeS3) get(key string) ([]byte, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	data, ok := f.objects[key]
+	return data, ok
+}
+
+// setFailPart sets the parts to reject. Part uploads of a failed Put may
+// still be in flight, so it takes the lock.
+func (f *fakeS3) setFailPart(fn func(num int) bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.failPart = fn
+}
+
+// uploadCount returns the number of multipart uploads in progress.
+func (f *fakeS3) uploadCount() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.uploads)
+}
+
+func (f *fakeS3) keys() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var keys []string
+	for k := range f.objects {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (f *fakeS3) serve(w http.ResponseWriter, r *http.Request) {
+	key := strings.TrimPrefix(r.URL.Path, "/"+f.bucket)
+	key = strings.TrimPrefix(key, "/")
+	q := r.URL.Query()
+
+	_, uploads := q["uploads"]
+	uploadID := q.Get("uploadId")
+	switch {
+	case r.Method == "POST" && uploads:
+		f.mu.Lock()
+		f.nextUpload++
+		id := fmt.Sprintf("upload-%d", f.nextUpload)
+		f.uploads[id] = &fakeUpload{
+			key:       key,
+			initiated: time.Now(),
+			checksum:  r.Header.Get("x-amz-checksum-algorithm"),
+			parts:     make(map[int][]byte),
+		}
+		f.mu.Unlock()
+		fmt.Fprintf(w, `<InitiateMultipartUploadResult><UploadId>%s</UploadId></InitiateMultipartUploadResult>`, id)
+	case r.Method == "PUT" && uploadID != "":
+		f.uploadPart(w, r, uploadID)
+	case r.Method == "POST" && uploadID != "":
+		f.completeUpload(w, r, key, uploadID)
+	case r.Method == "DELETE" && uploadID != "":
+		f.mu.Lock()
+		_, ok := f.uploads[uploadID]
+		delete(f.uploads, uploadID)
+		f.mu.Unlock()
+		if !ok {
+			noSuchUpload(w)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == "GET" && key == "" && uploads:
+		f.listUploads(w, q.Get("prefix"))
+	case r.Method == "GET" && key == "" && q.Get("list-type") == "2":
+		f.list(w, q.Get("prefix"), q.Get("continuation-token"))
+	case r.Method == "HEAD" || r.Method == "GET":
+		data, ok := f.get(key)
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `<Error><Code>NoSuchKey</Code><Message>not found</Message></Error>`)
+			return
+		}
+		if r.Method == "GET" {
+			f.mu.Lock()
+			f.ranges = append(f.ranges, r.Header.Get("Range"))
+			f.mu.Unlock()
+			http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(data))
+			return
+		}
+		w.Header().Set("Content-Length", fmt.Sprint(len(data)))
+	case r.Method == "PUT":
+		if r.Header.Get("x-amz-copy-source") != "" {
+			data, ok := f.copySource(w, r)
+			if ok {
+				f.put(key, data)
+				fmt.Fprint(w, `<CopyObjectResult></CopyObjectResult>`)
+			}
+			return
+		}
+		data, ok := f.receive(w, r)
+		if ok {
+			f.put(key, data)
+		}
+	case r.Method == "DELETE":
+		f.mu.Lock()
+		delete(f.objects, key)
+		f.mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusNotImplemented)
+	}
+}
+
+// receive reads the body of an upload, damaging it if f.damage says so, and
+// checks it against the checksum sent with it as S3 does.
+func (f *fakeS3) receive(w http.ResponseWriter, r *http.Request) ([]byte, bool) {
+	data, _ := io.ReadAll(r.Body)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.damage != nil && len(data) > 0 && f.damage(data) {
+		data[0] ^= 1
+	}
+	var ok bool
+	switch {
+	case r.Header.Get("x-amz-checksum-sha256") != "":
+		f.checksums = append(f.checksums, "sha256")
+		sum := sha256.Sum256(data)
+		ok = r.Header.Get("x-amz-checksum-sha256") == base64.StdEncoding.EncodeToString(sum[:])
+	case r.Header.Get("Content-MD5") != "":
+		f.checksums = append(f.checksums, "md5")
+		sum := md5.Sum(data)
+		ok = r.Header.Get("Content-MD5") == base64.StdEncoding.EncodeToString(sum[:])
+	default:
+		f.checksums = append(f.checksums, "")
+		ok = true
+	}
+	if !ok {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, `<Error><Code>BadDigest</Code><Message>checksum mismatch</Message></Error>`)
+	}
+	return data, ok
+}
+
+// copySource returns the object, or the range of it, that a copy request
+// names.
+func (f *fakeS3) copySource(w http.ResponseWriter, r *http.Request) ([]byte, bool) {
+	src := r.Header.Get("x-amz-copy-source")
+	f.mu.Lock()
+	f.copies = append(f.copies, src)
+	f.mu.Unlock()
+	data, ok := f.get(strings.TrimPrefix(src, "/"+f.bucket+"/"))
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, `<Error><Code>NoSuchKey</Code><Message>not found</Message></Error>`)
+		return nil, false
+	}
+	if rng := r.Header.Get("x-amz-copy-source-range"); rng != "" {
+		var first, last int
+		fmt.Sscanf(rng, "bytes=%d-%d", &first, &last)
+		data = data[first : last+1]
+	}
+	return data, true
+}
+
+func (f *fakeS3) list(w http.ResponseWriter, prefix, token string) {
+	var keys []string
+	for _, k := range f.keys() {
+		if strings.HasPrefix(k, prefix) && k > token {
+			keys = append(keys, k)
+		}
+	}
+	truncated := len(keys) > f.pageSize
+	if truncated {
+		keys = keys[:f.pageSize]
+	}
+	fmt.Fprint(w, `<ListBucketResult>`)
+	f.mu.Lock()
+	for _, k := range keys {
+		fmt.Fprintf(w, `<Contents><Key>%s</Key><Size>%d</Size><LastModified>%s</LastModified></Contents>`,
+			k, len(f.objects[k]), f.modTime[k].UTC().Format(time.RFC3339))
+	}
+	f.mu.Unlock()
+	if truncated {
+		fmt.Fprintf(w, `<IsTruncated>true</IsTruncated><NextContinuationToken>%s</NextContinuationToken>`, keys[len(keys)-1])
+	}
+	fmt.Fprint(w, `</ListBucketResult>`)
+}
+
+func noSuchUpload(w http.ResponseWriter) {
+	w.WriteHeader(http.StatusNotFound)
+	fmt.Fprint(w, `<Error><Code>NoSuchUpload</Code><Message>no such upload</Message></Error>`)
+}
+
+func (f *fakeS3) uploadPart(w http.ResponseWriter, r *http.Request, uploadID string) {
+	num, _ := strconv.Atoi(r.URL.Query().Get("partNumber"))
+	var data []byte
+	var received bool
+	if r.Header.Get("x-amz-copy-source") != "" {
+		data, received = f.copySource(w, r)
+	} else {
+		data, received = f.receive(w, r)
+	}
+	if !received {
+		return
+	}
+
+	f.mu.Lock()
+	u, ok := f.uploads[uploadID]
+	fail := f.failPart != nil && f.failPart(num)
+	f.inFlight++
+	if f.inFlight > f.maxFlight {
+		f.maxFlight = f.inFlight
+	}
+	delay := f.partDelay
+	f.mu.Unlock()
+	time.Sleep(delay)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.inFlight--
+
+	switch {
+	case !ok:
+		noSuchUpload(w)
+	case fail:
+		w.WriteHeader(http.StatusForbidden)
+		fmt.Fprint(w, `<Error><Code>AccessDenied</Code><Message>part rejected</Message></Error>`)
+	case r.Header.Get("x-amz-copy-source") != "":
+		u.parts[num] = data
+		sum := sha256.Sum256(data)
+		fmt.Fprintf(w, `<CopyPartResult><ETag>"%x"</ETag><ChecksumSHA256>%s</ChecksumSHA256></CopyPartResult>`,
+			md5.Sum(data), base64.StdEncoding.EncodeToString(sum[:]))
+	default:
+		u.parts[num] = data
+		f.partPuts = append(f.partPuts, num)
+		w.Header().Set("ETag", fmt.Sprintf(`"%x"`, md5.Sum(data)))
+	}
+}
+
+func (f *fakeS3) completeUpload(w http.ResponseWriter, r *http.Request, key, uploadID string) {
+	var req struct {
+		Parts []struct {
+			PartNumber     int
+			ETag           string
+			ChecksumSHA256 string
+		} `xml:"Part"`
+	}
+	if err := xml.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	u, ok := f.uploads[uploadID]
+	if !ok || u.key != key {
+		noSuchUpload(w)
+		return
+	}
+	var data []byte
+	for i, p := range req.Parts {
+		part, ok := u.parts[p.PartNumber]
+		sum := sha256.Sum256(part)
+		badSum := u.checksum == "SHA256" && p.ChecksumSHA256 != base64.StdEncoding.EncodeToString(sum[:])
+		if p.PartNumber != i+1 || !ok || p.ETag != fmt.Sprintf(`"%x"`, md5.Sum(part)) || badSum {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `<Error><Code>InvalidPart</Code><Message>bad part list</Message></Error>`)
+			return
+		}
+		data = append(data, part...)
+	}
+	delete(f.uploads, uploadID)
+	f.objects[key] = data
+	f.modTime[key] = time.Now()
+	fmt.Fprint(w, `<CompleteMultipartUploadResult></CompleteMultipartUploadResult>`)
+}
+
+func (f *fakeS3) listUploads(w http.ResponseWriter, prefix string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var ids []string
+	for id, u := range f.uploads {
+		if strings.HasPrefix(u.key, prefix) {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	fmt.Fprint(w, `<ListMultipartUploadsResult>`)
+	for _, id := range ids {
+		u := f.uploads[id]
+		fmt.Fprintf(w, `<Upload><Key>%s</Key><UploadId>%s</UploadId><Initiated>%s</Initiated></Upload>`,
+			u.key, id, u.initiated.UTC().Format(time.RFC3339))
+	}
+	fmt.Fprint(w, `</ListMultipartUploadsResult>`)
+}
@@ -4,13 +4,21 @@ import (
 	"crypto/hmac"
 	"crypto/sha256"
 	"encoding/hex"
+	"fmt"
 	"net/http"
+	"net/url"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
+
+	"github.com/Avalanche-io/c4"
 )
 
+// MaxPresignTTL is the longest a presigned URL can stay valid.
+const MaxPresignTTL = 7 * 24 * time.Hour
+
 // signingKeyCache caches the derived signing key for a given date so that
 // the four-step HMAC chain is computed at most once per calendar day.
 type signingKeyCache struct {
@@ -67,6 +75,63 @@ func (s *S3Store) signRequest(req *http.Request, payloadHash string) {
 	req.Header.Set("Authorization", auth)
 }
 
+// PresignGet returns a URL that downloads the content of id without
+// credentials until ttl has passed. The URL does not check that the object
+// exists.
+func (s *S3Store) PresignGet(id c4.ID, ttl time.Duration) (string, error) {
+	return s.presign("GET", id, ttl)
+}
+
+// PresignPut returns a URL that uploads the content of id without
+// credentials until ttl has passed. S3 cannot check that what is uploaded
+// matches id; Validating and c4 fsck do when it is read.
+func (s *S3Store) PresignPut(id c4.ID, ttl time.Duration) (string, error) {
+	return s.presign("PUT", id, ttl)
+}
+
+func (s *S3Store) presign(method string, id c4.ID, ttl time.Duration) (string, error) {
+	if ttl < time.Second || ttl > MaxPresignTTL {
+		return "", fmt.Errorf("presign %s: expiry %s is not between 1s and %s", id, ttl, MaxPresignTTL)
+	}
+	u, err := url.Parse(s.objectURL(s.objectKey(id)))
+	if err != nil {
+		return "", fmt.Errorf("presign %s: %w", id, err)
+	}
+	return s.presignURL(method, u, ttl, time.Now()), nil
+}
+
+// presignURL signs u for method with AWS Signature V4 query parameters,
+// valid for ttl from now. Only the host header is signed, and the payload
+// is unsigned.
+func (s *S3Store) presignURL(method string, u *url.URL, ttl time.Duration, now time.Time) string {
+	now = now.UTC()
+	dateStamp := now.Format("20060102")
+	amzDate := now.Format("20060102T150405Z")
+	scope := dateStamp + "/" + s.region + "/s3/aws4_request"
+
+	q := u.Query()
+	q.Set("X-Amz-Algorithm", "AWS4-HMAC-SHA256")
+	q.Set("X-Amz-Credential", s.accessKey+"/"+scope)
+	q.Set("X-Amz-Date", amzDate)
+	q.Set("X-Amz-Expires", strconv.Itoa(int(ttl/time.Second)))
+	q.Set("X-Amz-SignedHeaders", "host")
+	u.RawQuery = canonicalQuery(q)
+
+	canonical := strings.Join([]string{
+		method,
+		uriEncodePath(u.Path),
+		u.RawQuery,
+		"host:" + u.Host + "\n",
+		"host",
+		"UNSIGNED-PAYLOAD",
+	}, "\n")
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hashSHA256([]byte(canonical))
+	signature := hex.EncodeToString(hmacSHA256(s.deriveKey(dateStamp), []byte(stringToSign)))
+
+	u.RawQuery += "&X-Amz-Signature=" + signature
+	return u.String()
+}
+
 // deriveKey returns the signing key for the given date, using a cache to
 // avoid recomputation on every request.
 func (s *S3Store) deriveKey(dateStamp string) []byte {
@@ -124,7 +189,12 @@ func canonicalHeaderString(req *http.Request) (string, string) {
 // canonicalQueryString returns the query parameters sorted by key name,
 // each key and value URI-encoded.
 func canonicalQueryString(req *http.Request) string {
-	query := req.URL.Query()
+	return canonicalQuery(req.URL.Query())
+}
+
+// canonicalQuery returns query sorted by key name, each key and value
+// URI-encoded.
+func canonicalQuery(query url.Values) string {
 	if len(query) == 0 {
 		return ""
 	}
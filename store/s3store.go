@@ -2,7 +2,10 @@ package store
 
 import (
 	"bytes"
+	"context"
 	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/xml"
 	"fmt"
 	"io"
@@ -12,23 +15,23 @@ import (
 	"os"
 	"strconv"
 	"strings"
-	"sync"
 	"time"
 
 	"github.com/Avalanche-io/c4"
 )
 
 const (
-	// multipartThreshold is the size above which uploads use multipart.
-	multipartThreshold = 8 * 1024 * 1024 // 8 MB
-	// partSize is the size of each multipart upload part.
+	// partSize is the default size of each multipart upload part.
 	partSize = 8 * 1024 * 1024 // 8 MB
 	// maxRetries is the maximum number of retries for transient errors.
 	maxRetries = 5
 	// initialBackoff is the starting delay for exponential backoff.
 	initialBackoff = 100 * time.Millisecond
-	// maxConcurrentParts limits the number of concurrent part uploads.
+	// maxConcurrentParts is the default number of concurrent part uploads.
 	maxConcurrentParts = 4
+	// maxConcurrentRequests limits the requests HasMany and OpenMany make
+	// at once.
+	maxConcurrentRequests = 16
 )
 
 // Verify S3Store satisfies the Store interface at compile time.
@@ -45,13 +48,18 @@ type S3Store struct {
 	accessKey string
 	secretKey string
 	keyCache  signingKeyCache
+
+	partSize    int64      // size of multipart upload parts
+	concurrency int        // parts uploaded at once
+	uploadDir   string     // where multipart upload progress is saved
+	checksum    S3Checksum // sent with each upload
 }
 
-// completedPart holds the result of uploading a single multipart part.
+// completedPart is an uploaded part of a multipart upload.
 type completedPart struct {
-	num  int
-	etag string
-	err  error
+	num      int
+	etag     string
+	checksum string // base64 SHA-256, with ChecksumSHA256
 }
 
 // NewS3Store creates a new S3Store. For AWS S3, leave endpoint empty.
@@ -66,13 +74,44 @@ func NewS3Store(bucket, prefix, region, endpoint, accessKey, secretKey string) *
 			Transport: transport,
 			Timeout:   5 * time.Minute,
 		},
-		bucket:    bucket,
-		prefix:    prefix,
-		region:    region,
-		endpoint:  endpoint,
-		accessKey: accessKey,
-		secretKey: secretKey,
+		bucket:      bucket,
+		prefix:      prefix,
+		region:      region,
+		endpoint:    endpoint,
+		accessKey:   accessKey,
+		secretKey:   secretKey,
+		partSize:    partSize,
+		concurrency: maxConcurrentParts,
+	}
+}
+
+// SetPartSize sets the size of the parts of multipart uploads, which are
+// used for objects larger than one part. S3 requires parts of at least
+// 5 MiB. The default is 8 MiB.
+func (s *S3Store) SetPartSize(n int64) {
+	s.partSize = n
+}
+
+// SetConcurrency sets how many parts of a multipart upload are uploaded at
+// once. The default is 4.
+func (s *S3Store) SetConcurrency(n int) {
+	if n < 1 {
+		n = 1
 	}
+	s.concurrency = n
+}
+
+// SetUploadDir sets the directory where the progress of multipart uploads
+// is saved so that an interrupted upload can resume. The default is a
+// directory in the user's cache directory.
+func (s *S3Store) SetUploadDir(dir string) {
+	s.uploadDir = dir
+}
+
+// SetChecksum sets the checksum sent with each upload and each part of a
+// multipart upload. The default is ChecksumSHA256.
+func (s *S3Store) SetChecksum(c S3Checksum) {
+	s.checksum = c
 }
 
 // objectKey returns the S3 object key for the given C4 ID.
@@ -117,29 +156,69 @@ func (s *S3Store) bucketURL(key string, params map[string]string) string {
 
 // Has reports whether the store contains content for the given ID.
 func (s *S3Store) Has(id c4.ID) bool {
-	key := s.objectKey(id)
-	reqURL := s.objectURL(key)
+	ok, _ := s.HasContext(context.Background(), id)
+	return ok
+}
 
-	req, err := http.NewRequest("HEAD", reqURL, nil)
+// HasContext sends a HEAD request for the object. Unlike Has, it returns
+// an error when the answer is neither found nor not found.
+func (s *S3Store) HasContext(ctx context.Context, id c4.ID) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, "HEAD", s.objectURL(s.objectKey(id)), nil)
 	if err != nil {
-		return false
+		return false, fmt.Errorf("s3 has: %w", err)
 	}
 	s.signRequest(req, "UNSIGNED-PAYLOAD")
 
 	resp, err := s.doWithRetry(req)
 	if err != nil {
-		return false
+		return false, fmt.Errorf("s3 has: %w", err)
 	}
 	resp.Body.Close()
-	return resp.StatusCode == http.StatusOK
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	}
+	return false, fmt.Errorf("s3 has %s: unexpected status %d", id, resp.StatusCode)
+}
+
+// HasMany sends HEAD requests for the IDs in parallel.
+func (s *S3Store) HasMany(ctx context.Context, ids []c4.ID) ([]bool, error) {
+	found := make([]bool, len(ids))
+	err := fanOut(ctx, len(ids), maxConcurrentRequests, func(ctx context.Context, i int) error {
+		var err error
+		found[i], err = s.HasContext(ctx, ids[i])
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return found, nil
 }
 
 // Open opens the content for reading.
 func (s *S3Store) Open(id c4.ID) (io.ReadCloser, error) {
-	key := s.objectKey(id)
+	return s.OpenContext(context.Background(), id)
+}
+
+// OpenContext opens the content for reading. Cancelling ctx also aborts
+// reading the returned body.
+func (s *S3Store) OpenContext(ctx context.Context, id c4.ID) (io.ReadCloser, error) {
+	return s.openKey(ctx, s.objectKey(id), id.String())
+}
+
+// OpenMany sends up to maxConcurrentRequests GET requests ahead of the
+// object fn is reading.
+func (s *S3Store) OpenMany(ctx context.Context, ids []c4.ID, fn func(id c4.ID, r io.Reader, err error) error) error {
+	return openMany(ctx, ids, maxConcurrentRequests, s.OpenContext, fn)
+}
+
+// openKey opens an object by key. name identifies it in errors.
+func (s *S3Store) openKey(ctx context.Context, key, name string) (io.ReadCloser, error) {
 	reqURL := s.objectURL(key)
 
-	req, err := http.NewRequest("GET", reqURL, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", reqURL, nil)
 	if err != nil {
 		return nil, fmt.Errorf("s3 open: %w", err)
 	}
@@ -152,28 +231,83 @@ func (s *S3Store) Open(id c4.ID) (io.ReadCloser, error) {
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
 		resp.Body.Close()
-		return nil, fmt.Errorf("s3 open %s: %s", id, parseS3Error(body, resp.StatusCode))
+		return nil, fmt.Errorf("s3 open %s: %s", name, parseS3Error(body, resp.StatusCode))
 	}
 	return resp.Body, nil
 }
 
+// OpenRange fetches part of the content with an HTTP Range request.
+func (s *S3Store) OpenRange(id c4.ID, off, n int64) (io.ReadCloser, error) {
+	if off < 0 {
+		return nil, fmt.Errorf("open range of %s: negative offset %d", id, off)
+	}
+	if n == 0 {
+		return io.NopCloser(strings.NewReader("")), nil
+	}
+	req, err := http.NewRequest("GET", s.objectURL(s.objectKey(id)), nil)
+	if err != nil {
+		return nil, fmt.Errorf("s3 open: %w", err)
+	}
+	if n < 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", off))
+	} else {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", off, off+n-1))
+	}
+	s.signRequest(req, "UNSIGNED-PAYLOAD")
+
+	resp, err := s.doWithRetry(req)
+	if err != nil {
+		return nil, fmt.Errorf("s3 open: %w", err)
+	}
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		return resp.Body, nil
+	case http.StatusOK:
+		// The range was ignored; skip to it.
+		return skipTo(resp.Body, off, n)
+	case http.StatusRequestedRangeNotSatisfiable:
+		// The offset is at or past the end.
+		resp.Body.Close()
+		return io.NopCloser(strings.NewReader("")), nil
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	return nil, fmt.Errorf("s3 open %s: %s", id, parseS3Error(body, resp.StatusCode))
+}
+
 // Create returns a writer that buffers content to a temp file and uploads
 // to S3 on Close. The caller must know the C4 ID in advance.
 func (s *S3Store) Create(id c4.ID) (io.WriteCloser, error) {
+	return s.CreateContext(context.Background(), id)
+}
+
+// CreateContext is Create with a context that governs the upload on Close.
+func (s *S3Store) CreateContext(ctx context.Context, id c4.ID) (io.WriteCloser, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 	tmp, err := os.CreateTemp("", "c4-s3-create-*")
 	if err != nil {
 		return nil, fmt.Errorf("s3 create temp: %w", err)
 	}
 	return &s3Writer{
+		ctx:   ctx,
 		store: s,
 		id:    id,
 		tmp:   tmp,
+		sums:  newPartSums(s.checksum, s.partSize),
 	}, nil
 }
 
 // Put reads all content from r, computes its C4 ID, stores it, and returns
 // the ID. If the content already exists the upload is skipped.
 func (s *S3Store) Put(r io.Reader) (c4.ID, error) {
+	return s.PutContext(context.Background(), r)
+}
+
+// PutContext is Put with a context that governs both reading r and the
+// upload.
+func (s *S3Store) PutContext(ctx context.Context, r io.Reader) (c4.ID, error) {
 	tmp, err := os.CreateTemp("", "c4-s3-put-*")
 	if err != nil {
 		return c4.ID{}, fmt.Errorf("s3 put temp: %w", err)
@@ -181,9 +315,11 @@ func (s *S3Store) Put(r io.Reader) (c4.ID, error) {
 	tmpName := tmp.Name()
 	defer os.Remove(tmpName)
 
+	// The checksums are computed in the same pass as the ID.
 	h := sha512.New()
-	w := io.MultiWriter(tmp, h)
-	if _, err := io.Copy(w, r); err != nil {
+	sums := newPartSums(s.checksum, s.partSize)
+	w := io.MultiWriter(tmp, h, sums)
+	if _, err := io.Copy(w, &ctxReader{ctx, r}); err != nil {
 		tmp.Close()
 		return c4.ID{}, fmt.Errorf("s3 put copy: %w", err)
 	}
@@ -194,11 +330,11 @@ func (s *S3Store) Put(r io.Reader) (c4.ID, error) {
 	var id c4.ID
 	copy(id[:], h.Sum(nil))
 
-	if s.Has(id) {
+	if ok, _ := s.HasContext(ctx, id); ok {
 		return id, nil
 	}
 
-	if err := s.uploadFile(tmpName, s.objectKey(id)); err != nil {
+	if err := s.uploadFile(ctx, tmpName, s.objectKey(id), sums); err != nil {
 		return c4.ID{}, fmt.Errorf("s3 put upload: %w", err)
 	}
 	return id, nil
@@ -206,10 +342,19 @@ func (s *S3Store) Put(r io.Reader) (c4.ID, error) {
 
 // Remove deletes the content for the given ID.
 func (s *S3Store) Remove(id c4.ID) error {
-	key := s.objectKey(id)
+	return s.RemoveContext(context.Background(), id)
+}
+
+// RemoveContext deletes the content for the given ID.
+func (s *S3Store) RemoveContext(ctx context.Context, id c4.ID) error {
+	return s.deleteKey(ctx, s.objectKey(id), id.String())
+}
+
+// deleteKey deletes an object by key. name identifies it in errors.
+func (s *S3Store) deleteKey(ctx context.Context, key, name string) error {
 	reqURL := s.objectURL(key)
 
-	req, err := http.NewRequest("DELETE", reqURL, nil)
+	req, err := http.NewRequestWithContext(ctx, "DELETE", reqURL, nil)
 	if err != nil {
 		return fmt.Errorf("s3 remove: %w", err)
 	}
@@ -221,122 +366,200 @@ func (s *S3Store) Remove(id c4.ID) error {
 	}
 	resp.Body.Close()
 	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("s3 remove %s: unexpected status %d", id, resp.StatusCode)
+		return fmt.Errorf("s3 remove %s: unexpected status %d", name, resp.StatusCode)
 	}
 	return nil
 }
 
-// uploadFile uploads a local file to the given S3 key. Files larger than
-// multipartThreshold use multipart upload.
-func (s *S3Store) uploadFile(path, key string) error {
+// List enumerates the objects under the store's prefix with
+// ListObjectsV2, following continuation tokens across pages. Keys that are
+// not C4 IDs are skipped.
+func (s *S3Store) List(fn func(Object) bool) error {
+	return s.listKeys(func(key string, size int64, mod time.Time) bool {
+		id, err := c4.Parse(strings.TrimPrefix(key, s.prefix))
+		if err != nil {
+			return true
+		}
+		return fn(Object{ID: id, Size: size, ModTime: mod})
+	})
+}
+
+// listKeys calls fn for every key under the store's prefix.
+func (s *S3Store) listKeys(fn func(key string, size int64, mod time.Time) bool) error {
+	token := ""
+	for {
+		params := map[string]string{
+			"list-type": "2",
+			"prefix":    s.prefix,
+		}
+		if token != "" {
+			params["continuation-token"] = token
+		}
+		req, err := http.NewRequest("GET", s.bucketURL("", params), nil)
+		if err != nil {
+			return fmt.Errorf("s3 list: %w", err)
+		}
+		s.signRequest(req, "UNSIGNED-PAYLOAD")
+
+		resp, err := s.doWithRetry(req)
+		if err != nil {
+			return fmt.Errorf("s3 list: %w", err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			body, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			return fmt.Errorf("s3 list: %s", parseS3Error(body, resp.StatusCode))
+		}
+		var page listBucketResult
+		err = xml.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return fmt.Errorf("parse list response: %w", err)
+		}
+
+		for _, obj := range page.Contents {
+			if !fn(obj.Key, obj.Size, obj.LastModified) {
+				return nil
+			}
+		}
+		if !page.IsTruncated || page.NextContinuationToken == "" {
+			return nil
+		}
+		token = page.NextContinuationToken
+	}
+}
+
+// listBucketResult is the ListObjectsV2 response body.
+type listBucketResult struct {
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+	Contents              []struct {
+		Key          string    `xml:"Key"`
+		Size         int64     `xml:"Size"`
+		LastModified time.Time `xml:"LastModified"`
+	} `xml:"Contents"`
+}
+
+// uploadFile uploads a local file to the given S3 key. sums are the
+// checksums of its parts, computed as it was written. Files larger than one
+// part use multipart upload, with a checksum for each part.
+func (s *S3Store) uploadFile(ctx context.Context, path, key string, sums *partSums) error {
 	info, err := os.Stat(path)
 	if err != nil {
 		return err
 	}
-	if info.Size() > multipartThreshold {
-		return s.multipartUpload(path, key, info.Size())
+	parts := sums.finish()
+	if info.Size() > sums.partSize {
+		return s.multipartUpload(ctx, path, key, info.Size(), sums.partSize, parts)
 	}
-	return s.singleUpload(path, key)
+	return s.singleUpload(ctx, path, key, parts[0])
 }
 
 // singleUpload performs a simple PUT upload.
-func (s *S3Store) singleUpload(path, key string) error {
+func (s *S3Store) singleUpload(ctx context.Context, path, key string, sum partSum) error {
 	data, err := os.ReadFile(path)
 	if err != nil {
 		return err
 	}
 
-	payloadHash := hashSHA256(data)
 	reqURL := s.objectURL(key)
 
-	req, err := http.NewRequest("PUT", reqURL, bytes.NewReader(data))
+	req, err := http.NewRequestWithContext(ctx, "PUT", reqURL, bytes.NewReader(data))
 	if err != nil {
 		return err
 	}
 	req.Header.Set("Content-Type", "application/octet-stream")
 	req.Header.Set("Content-Length", strconv.Itoa(len(data)))
-	s.signRequest(req, payloadHash)
+	s.checksum.setHeader(req.Header, s.checksum.sum(sum))
+	s.signRequest(req, hex.EncodeToString(sum.sha256))
 
 	resp, err := s.doWithRetry(req)
 	if err != nil {
 		return err
 	}
+	body, _ := io.ReadAll(resp.Body)
 	resp.Body.Close()
 	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("s3 put: unexpected status %d", resp.StatusCode)
+		return fmt.Errorf("s3 put: %s", parseS3Error(body, resp.StatusCode))
 	}
 	return nil
 }
 
-// multipartUpload performs a multipart upload for large files.
-func (s *S3Store) multipartUpload(path, key string, size int64) error {
-	uploadID, err := s.initiateMultipart(key)
-	if err != nil {
-		return fmt.Errorf("initiate multipart: %w", err)
+// multipartUpload performs a multipart upload for large files, uploading
+// s.concurrency parts at a time. Progress is saved after each part, so an
+// upload of key that was interrupted resumes from the parts it completed.
+func (s *S3Store) multipartUpload(ctx context.Context, path, key string, size, partSize int64, sums []partSum) error {
+	for {
+		st, err := s.openUpload(ctx, key, size, partSize, sums)
+		if err != nil {
+			return fmt.Errorf("initiate multipart: %w", err)
+		}
+		err = s.uploadParts(ctx, st, path, size)
+		if err == nil {
+			err = s.completeMultipart(ctx, key, st.uploadID, st.completed())
+		}
+		if err == errNoSuchUpload && st.resumed {
+			// The saved upload was aborted or has expired; start over.
+			st.remove()
+			continue
+		}
+		if err != nil {
+			// Keep the upload and its progress for the next attempt.
+			st.close()
+			return err
+		}
+		st.remove()
+		return nil
 	}
+}
 
-	numParts := int((size + partSize - 1) / partSize)
-	parts := make([]completedPart, numParts)
-
-	var wg sync.WaitGroup
-	sem := make(chan struct{}, maxConcurrentParts)
-
-	for i := 0; i < numParts; i++ {
-		wg.Add(1)
-		sem <- struct{}{}
-		go func(partNum int) {
-			defer wg.Done()
-			defer func() { <-sem }()
-
-			offset := int64(partNum) * partSize
-			length := partSize
-			if offset+int64(length) > size {
-				length = int(size - offset)
-			}
-
-			f, ferr := os.Open(path)
-			if ferr != nil {
-				parts[partNum] = completedPart{num: partNum + 1, err: ferr}
-				return
-			}
-			defer f.Close()
-
-			if _, ferr = f.Seek(offset, io.SeekStart); ferr != nil {
-				parts[partNum] = completedPart{num: partNum + 1, err: ferr}
-				return
-			}
-
-			data := make([]byte, length)
-			if _, ferr = io.ReadFull(f, data); ferr != nil {
-				parts[partNum] = completedPart{num: partNum + 1, err: ferr}
-				return
-			}
-
-			etag, ferr := s.uploadPart(key, uploadID, partNum+1, data)
-			parts[partNum] = completedPart{num: partNum + 1, etag: etag, err: ferr}
-		}(i)
+// uploadParts uploads the parts of path that st does not have yet.
+func (s *S3Store) uploadParts(ctx context.Context, st *uploadState, path string, size int64) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
 	}
-	wg.Wait()
+	defer f.Close()
 
-	for _, p := range parts {
-		if p.err != nil {
-			s.abortMultipart(key, uploadID)
-			return fmt.Errorf("upload part %d: %w", p.num, p.err)
+	numParts := int((size + st.partSize - 1) / st.partSize)
+	return fanOut(ctx, numParts, s.concurrency, func(ctx context.Context, i int) error {
+		num := i + 1
+		if st.has(num) {
+			return nil
 		}
-	}
-
-	return s.completeMultipart(key, uploadID, parts)
+		offset := int64(i) * st.partSize
+		length := st.partSize
+		if offset+length > size {
+			length = size - offset
+		}
+		data := make([]byte, length)
+		if _, err := f.ReadAt(data, offset); err != nil && err != io.EOF {
+			return fmt.Errorf("upload part %d: %w", num, err)
+		}
+		part, err := s.uploadPart(ctx, st.key, st.uploadID, num, data, st.sums[i])
+		if err != nil {
+			if err == errNoSuchUpload {
+				return err
+			}
+			return fmt.Errorf("upload part %d: %w", num, err)
+		}
+		return st.add(part)
+	})
 }
 
 // initiateMultipart starts a multipart upload and returns the upload ID.
-func (s *S3Store) initiateMultipart(key string) (string, error) {
+// With ChecksumSHA256, S3 requires a checksum for every part.
+func (s *S3Store) initiateMultipart(ctx context.Context, key string) (string, error) {
 	reqURL := s.bucketURL(key, map[string]string{"uploads": ""})
 
-	req, err := http.NewRequest("POST", reqURL, nil)
+	req, err := http.NewRequestWithContext(ctx, "POST", reqURL, nil)
 	if err != nil {
 		return "", err
 	}
 	req.Header.Set("Content-Type", "application/octet-stream")
+	if s.checksum == ChecksumSHA256 {
+		req.Header.Set("x-amz-checksum-algorithm", "SHA256")
+	}
 	s.signRequest(req, hashSHA256(nil))
 
 	resp, err := s.doWithRetry(req)
@@ -359,37 +582,47 @@ func (s *S3Store) initiateMultipart(key string) (string, error) {
 	return result.UploadId, nil
 }
 
-// uploadPart uploads a single part and returns its ETag.
-func (s *S3Store) uploadPart(key, uploadID string, partNum int, data []byte) (string, error) {
+// uploadPart uploads a single part with its checksums.
+func (s *S3Store) uploadPart(ctx context.Context, key, uploadID string, partNum int, data []byte, sums partSum) (completedPart, error) {
 	params := map[string]string{
 		"partNumber": strconv.Itoa(partNum),
 		"uploadId":   uploadID,
 	}
 	reqURL := s.bucketURL(key, params)
-	payloadHash := hashSHA256(data)
+	sum := s.checksum.sum(sums)
 
-	req, err := http.NewRequest("PUT", reqURL, bytes.NewReader(data))
+	req, err := http.NewRequestWithContext(ctx, "PUT", reqURL, bytes.NewReader(data))
 	if err != nil {
-		return "", err
+		return completedPart{}, err
 	}
 	req.Header.Set("Content-Type", "application/octet-stream")
 	req.Header.Set("Content-Length", strconv.Itoa(len(data)))
-	s.signRequest(req, payloadHash)
+	s.checksum.setHeader(req.Header, sum)
+	s.signRequest(req, hex.EncodeToString(sums.sha256))
 
 	resp, err := s.doWithRetry(req)
 	if err != nil {
-		return "", err
+		return completedPart{}, err
 	}
+	body, _ := io.ReadAll(resp.Body)
 	resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("upload part %d: unexpected status %d", partNum, resp.StatusCode)
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return completedPart{}, errNoSuchUpload
+	default:
+		return completedPart{}, fmt.Errorf("upload part %d: %s", partNum, parseS3Error(body, resp.StatusCode))
+	}
+	part := completedPart{num: partNum, etag: resp.Header.Get("ETag")}
+	if s.checksum == ChecksumSHA256 {
+		part.checksum = base64.StdEncoding.EncodeToString(sum)
 	}
-	return resp.Header.Get("ETag"), nil
+	return part, nil
 }
 
 // completeMultipart finishes the multipart upload by sending the part list.
-func (s *S3Store) completeMultipart(key, uploadID string, parts []completedPart) error {
+func (s *S3Store) completeMultipart(ctx context.Context, key, uploadID string, parts []completedPart) error {
 	var buf bytes.Buffer
 	buf.WriteString("<CompleteMultipartUpload>")
 	for _, p := range parts {
@@ -397,7 +630,13 @@ func (s *S3Store) completeMultipart(key, uploadID string, parts []completedPart)
 		buf.WriteString(strconv.Itoa(p.num))
 		buf.WriteString("</PartNumber><ETag>")
 		buf.WriteString(p.etag)
-		buf.WriteString("</ETag></Part>")
+		buf.WriteString("</ETag>")
+		if p.checksum != "" {
+			buf.WriteString("<ChecksumSHA256>")
+			buf.WriteString(p.checksum)
+			buf.WriteString("</ChecksumSHA256>")
+		}
+		buf.WriteString("</Part>")
 	}
 	buf.WriteString("</CompleteMultipartUpload>")
 
@@ -406,7 +645,7 @@ func (s *S3Store) completeMultipart(key, uploadID string, parts []completedPart)
 	params := map[string]string{"uploadId": uploadID}
 	reqURL := s.bucketURL(key, params)
 
-	req, err := http.NewRequest("POST", reqURL, bytes.NewReader(data))
+	req, err := http.NewRequestWithContext(ctx, "POST", reqURL, bytes.NewReader(data))
 	if err != nil {
 		return err
 	}
@@ -420,29 +659,40 @@ func (s *S3Store) completeMultipart(key, uploadID string, parts []completedPart)
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
+	// Completing can fail after a 200 status, with an error in the body.
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode == http.StatusNotFound {
+		return errNoSuchUpload
+	}
+	var e s3Error
+	if resp.StatusCode != http.StatusOK || xml.Unmarshal(body, &e) == nil && e.Code != "" {
 		return fmt.Errorf("complete multipart: %s", parseS3Error(body, resp.StatusCode))
 	}
 	return nil
 }
 
 // abortMultipart cancels a multipart upload.
-func (s *S3Store) abortMultipart(key, uploadID string) {
+func (s *S3Store) abortMultipart(ctx context.Context, key, uploadID string) error {
 	params := map[string]string{"uploadId": uploadID}
 	reqURL := s.bucketURL(key, params)
 
-	req, err := http.NewRequest("DELETE", reqURL, nil)
+	req, err := http.NewRequestWithContext(ctx, "DELETE", reqURL, nil)
 	if err != nil {
-		return
+		return err
 	}
 	s.signRequest(req, "UNSIGNED-PAYLOAD")
 
-	resp, err := s.client.Do(req)
+	resp, err := s.doWithRetry(req)
 	if err != nil {
-		return
+		return err
 	}
+	body, _ := io.ReadAll(resp.Body)
 	resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusNoContent, http.StatusOK, http.StatusNotFound:
+		return nil
+	}
+	return fmt.Errorf("abort multipart: %s", parseS3Error(body, resp.StatusCode))
 }
 
 // doWithRetry executes an HTTP request with exponential backoff retry
@@ -451,12 +701,26 @@ func (s *S3Store) doWithRetry(req *http.Request) (*http.Response, error) {
 	backoff := initialBackoff
 	var lastErr error
 
+	ctx := req.Context()
 	for attempt := 0; attempt <= maxRetries; attempt++ {
 		if attempt > 0 {
 			jitter := time.Duration(float64(backoff) * (0.75 + rand.Float64()*0.5))
-			time.Sleep(jitter)
+			select {
+			case <-time.After(jitter):
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
 			backoff *= 2
 
+			// Rewind the body, which the last attempt consumed.
+			if req.GetBody != nil {
+				body, err := req.GetBody()
+				if err != nil {
+					return nil, err
+				}
+				req.Body = body
+			}
+
 			// Re-sign on retry (timestamp changes).
 			payloadHash := req.Header.Get("x-amz-content-sha256")
 			s.signRequest(req, payloadHash)
@@ -464,6 +728,9 @@ func (s *S3Store) doWithRetry(req *http.Request) (*http.Response, error) {
 
 		resp, err := s.client.Do(req)
 		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
 			lastErr = err
 			continue
 		}
@@ -482,13 +749,17 @@ func (s *S3Store) doWithRetry(req *http.Request) (*http.Response, error) {
 
 // s3Writer buffers writes to a temp file and uploads to S3 on Close.
 type s3Writer struct {
+	ctx   context.Context
 	store *S3Store
 	id    c4.ID
 	tmp   *os.File
+	sums  *partSums // checksums of what is written
 }
 
 func (w *s3Writer) Write(b []byte) (int, error) {
-	return w.tmp.Write(b)
+	n, err := w.tmp.Write(b)
+	w.sums.Write(b[:n])
+	return n, err
 }
 
 func (w *s3Writer) Close() error {
@@ -500,7 +771,7 @@ func (w *s3Writer) Close() error {
 	}
 
 	key := w.store.objectKey(w.id)
-	if err := w.store.uploadFile(tmpName, key); err != nil {
+	if err := w.store.uploadFile(w.ctx, tmpName, key, w.sums); err != nil {
 		return fmt.Errorf("s3 writer upload: %w", err)
 	}
 	return nil
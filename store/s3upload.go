@@ -0,0 +1,354 @@
+package store
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// errNoSuchUpload is returned for a multipart upload that S3 no longer
+// has, because it was completed, aborted or has expired.
+var errNoSuchUpload = errors.New("s3: no such multipart upload")
+
+// uploadState is the progress of a multipart upload. It is saved in a
+// file in the upload directory named for the bucket and key, as a header
+// line
+//
+//	upload <upload ID> <size> <part size> <checksum>
+//
+// followed by a line "part <number> <ETag> [<SHA-256>]" appended as each
+// part is uploaded. A line cut short by a crash is ignored.
+type uploadState struct {
+	key      string
+	uploadID string
+	partSize int64
+	checksum S3Checksum
+	resumed  bool      // the upload was started by an earlier attempt
+	sums     []partSum // of every part, from the pass that spooled the content
+	path     string
+
+	mu    sync.Mutex
+	f     *os.File // nil if progress is not being saved
+	parts map[int]completedPart
+}
+
+// uploadDirectory returns the directory for saved upload progress.
+func (s *S3Store) uploadDirectory() string {
+	if s.uploadDir != "" {
+		return s.uploadDir
+	}
+	if dir, err := os.UserCacheDir(); err == nil {
+		return filepath.Join(dir, "c4", "uploads")
+	}
+	return filepath.Join(os.TempDir(), "c4-uploads")
+}
+
+// uploadStatePath returns the file for the progress of uploading key.
+func (s *S3Store) uploadStatePath(key string) string {
+	return filepath.Join(s.uploadDirectory(), hashSHA256([]byte(s.bucket+"/"+key))+".upload")
+}
+
+// openUpload resumes the saved upload of key if there is one for content
+// of this size, part size and checksum, and otherwise starts a new one.
+// sums are the checksums of the parts of the content; a saved part whose
+// SHA-256 checksum does not match is uploaded again.
+// If the upload directory cannot be written, the upload goes ahead without
+// saving its progress.
+func (s *S3Store) openUpload(ctx context.Context, key string, size, partSize int64, sums []partSum) (*uploadState, error) {
+	path := s.uploadStatePath(key)
+	st, err := readUploadState(path)
+	if err == nil && st.size == size && st.partSize == partSize && st.checksum == s.checksum {
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+		if err == nil {
+			for num, part := range st.parts {
+				if num < 1 || num > len(sums) || part.checksum != "" && part.checksum != base64.StdEncoding.EncodeToString(sums[num-1].sha256) {
+					delete(st.parts, num)
+				}
+			}
+			st.key, st.resumed, st.sums, st.f = key, true, sums, f
+			return &st.uploadState, nil
+		}
+	} else if err == nil {
+		// Saved for other parts; that upload can't be reused.
+		s.abortMultipart(ctx, key, st.uploadID)
+	}
+
+	uploadID, err := s.initiateMultipart(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	u := &uploadState{
+		key:      key,
+		uploadID: uploadID,
+		partSize: partSize,
+		checksum: s.checksum,
+		sums:     sums,
+		path:     path,
+		parts:    make(map[int]completedPart),
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return u, nil
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return u, nil
+	}
+	if _, err := fmt.Fprintf(f, "upload %s %d %d %s\n", uploadID, size, partSize, s.checksum); err != nil {
+		f.Close()
+		os.Remove(path)
+		return u, nil
+	}
+	u.f = f
+	return u, nil
+}
+
+// savedUpload is an uploadState read back from its file.
+type savedUpload struct {
+	uploadState
+	size int64
+}
+
+func readUploadState(path string) (*savedUpload, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	sc := bufio.NewScanner(f)
+	if !sc.Scan() {
+		return nil, fmt.Errorf("%s: no upload header", path)
+	}
+	head := strings.Fields(sc.Text())
+	if len(head) != 5 || head[0] != "upload" {
+		return nil, fmt.Errorf("%s: bad upload header", path)
+	}
+	st := &savedUpload{uploadState: uploadState{
+		uploadID: head[1],
+		path:     path,
+		parts:    make(map[int]completedPart),
+	}}
+	if st.size, err = strconv.ParseInt(head[2], 10, 64); err != nil {
+		return nil, fmt.Errorf("%s: bad upload header", path)
+	}
+	if st.partSize, err = strconv.ParseInt(head[3], 10, 64); err != nil {
+		return nil, fmt.Errorf("%s: bad upload header", path)
+	}
+	if st.checksum, err = ParseS3Checksum(head[4]); err != nil {
+		return nil, fmt.Errorf("%s: bad upload header", path)
+	}
+	want := 3
+	if st.checksum == ChecksumSHA256 {
+		want = 4
+	}
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) != want || fields[0] != "part" {
+			continue
+		}
+		if num, err := strconv.Atoi(fields[1]); err == nil {
+			part := completedPart{num: num, etag: fields[2]}
+			if want == 4 {
+				part.checksum = fields[3]
+			}
+			st.parts[num] = part
+		}
+	}
+	return st, sc.Err()
+}
+
+func (st *uploadState) has(num int) bool {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	_, ok := st.parts[num]
+	return ok
+}
+
+// add records an uploaded part.
+func (st *uploadState) add(part completedPart) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.parts[part.num] = part
+	if st.f == nil {
+		return nil
+	}
+	line := fmt.Sprintf("part %d %s", part.num, part.etag)
+	if part.checksum != "" {
+		line += " " + part.checksum
+	}
+	if _, err := fmt.Fprintln(st.f, line); err != nil {
+		return fmt.Errorf("save upload progress: %w", err)
+	}
+	return nil
+}
+
+// completed returns the uploaded parts in order.
+func (st *uploadState) completed() []completedPart {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	parts := make([]completedPart, 0, len(st.parts))
+	for _, part := range st.parts {
+		parts = append(parts, part)
+	}
+	sort.Slice(parts, func(i, j int) bool { return parts[i].num < parts[j].num })
+	return parts
+}
+
+func (st *uploadState) close() {
+	if st.f != nil {
+		st.f.Close()
+	}
+}
+
+// remove closes and deletes the saved progress.
+func (st *uploadState) remove() {
+	st.close()
+	if st.f != nil {
+		os.Remove(st.path)
+	}
+}
+
+// Upload is a multipart upload that was started and neither completed nor
+// aborted.
+type Upload struct {
+	Key       string    `xml:"Key"`
+	UploadID  string    `xml:"UploadId"`
+	Initiated time.Time `xml:"Initiated"`
+}
+
+// Uploads lists the incomplete multipart uploads under the store's prefix,
+// following pagination markers across pages.
+func (s *S3Store) Uploads() ([]Upload, error) {
+	var uploads []Upload
+	keyMarker, idMarker := "", ""
+	for {
+		params := map[string]string{
+			"uploads": "",
+			"prefix":  s.prefix,
+		}
+		if keyMarker != "" {
+			params["key-marker"] = keyMarker
+			params["upload-id-marker"] = idMarker
+		}
+		req, err := http.NewRequest("GET", s.bucketURL("", params), nil)
+		if err != nil {
+			return nil, fmt.Errorf("s3 list uploads: %w", err)
+		}
+		s.signRequest(req, "UNSIGNED-PAYLOAD")
+
+		resp, err := s.doWithRetry(req)
+		if err != nil {
+			return nil, fmt.Errorf("s3 list uploads: %w", err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			body, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			return nil, fmt.Errorf("s3 list uploads: %s", parseS3Error(body, resp.StatusCode))
+		}
+		var page listUploadsResult
+		err = xml.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("parse list uploads response: %w", err)
+		}
+
+		uploads = append(uploads, page.Uploads...)
+		if !page.IsTruncated || page.NextKeyMarker == "" {
+			return uploads, nil
+		}
+		keyMarker, idMarker = page.NextKeyMarker, page.NextUploadIdMarker
+	}
+}
+
+// listUploadsResult is the ListMultipartUploads response body.
+type listUploadsResult struct {
+	IsTruncated        bool     `xml:"IsTruncated"`
+	NextKeyMarker      string   `xml:"NextKeyMarker"`
+	NextUploadIdMarker string   `xml:"NextUploadIdMarker"`
+	Uploads            []Upload `xml:"Upload"`
+}
+
+// AbortUploads aborts the incomplete multipart uploads under the store's
+// prefix that were started before the given time, and returns them. The
+// saved progress of an aborted upload is deleted; uploading the object
+// again starts over.
+func (s *S3Store) AbortUploads(before time.Time) ([]Upload, error) {
+	uploads, err := s.Uploads()
+	if err != nil {
+		return nil, err
+	}
+	var aborted []Upload
+	for _, u := range uploads {
+		if !u.Initiated.Before(before) {
+			continue
+		}
+		if err := s.abortMultipart(context.Background(), u.Key, u.UploadID); err != nil {
+			return aborted, err
+		}
+		path := s.uploadStatePath(u.Key)
+		if st, err := readUploadState(path); err == nil && st.uploadID == u.UploadID {
+			os.Remove(path)
+		}
+		aborted = append(aborted, u)
+	}
+	return aborted, nil
+}
+
+// S3Stores returns the S3 stores s is built from.
+func S3Stores(s Store) []*S3Store {
+	var stores []*S3Store
+	walkStores(s, func(s Store) {
+		if s3, ok := s.(*S3Store); ok {
+			stores = append(stores, s3)
+		}
+	})
+	return stores
+}
+
+// PlainS3Stores returns the S3 stores s is built from, which must hold
+// each object as is. It fails if chunking, encryption, compression or
+// erasure coding sits between s and any of them: such a store holds chunk
+// recipes, ciphertext, compressed bytes or shards, so a link to an object
+// would not give the content.
+func PlainS3Stores(s Store) ([]*S3Store, error) {
+	var stores []*S3Store
+	var err error
+	var walk func(s Store, layer string)
+	walk = func(s Store, layer string) {
+		switch s := s.(type) {
+		case *Chunking:
+			layer = "chunked"
+		case *Encrypting:
+			layer = "encrypted"
+		case *Compressing:
+			layer = "compressed"
+		case *Erasure:
+			layer = "erasure-coded"
+		case *S3Store:
+			if layer != "" && err == nil {
+				err = fmt.Errorf("the S3 store in bucket %s is %s, so its objects are not the content", s.bucket, layer)
+			}
+			stores = append(stores, s)
+		}
+		for _, sub := range substores(s) {
+			walk(sub, layer)
+		}
+	}
+	walk(s, "")
+	if err != nil {
+		return nil, err
+	}
+	return stores, nil
+}
@@ -0,0 +1,292 @@
+package store
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Avalanche-io/c4"
+)
+
+// newMultipartStore returns a store for f that uploads objects over 1 KiB
+// in 1 KiB parts, saving progress in a temporary directory.
+func newMultipartStore(t *testing.T, f *fakeS3) *S3Store {
+	s := f.store("c4/")
+	s.SetPartSize(1 << 10)
+	s.SetUploadDir(t.TempDir())
+	return s
+}
+
+func savedUploads(t *testing.T, s *S3Store) []string {
+	t.Helper()
+	names, err := filepath.Glob(filepath.Join(s.uploadDir, "*.upload"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return names
+}
+
+func TestS3MultipartConcurrency(t *testing.T) {
+	f := newFakeS3(t, "bucket")
+	f.partDelay = 20 * time.Millisecond
+	s := newMultipartStore(t, f)
+	s.SetConcurrency(3)
+
+	data := randomBytes(1, 10<<10+100)
+	id, err := s.Put(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := f.get("c4/" + id.String()); !bytes.Equal(got, data) {
+		t.Fatal("object did not round-trip through a multipart upload")
+	}
+	if len(f.partPuts) != 11 {
+		t.Errorf("%d parts uploaded, want 11", len(f.partPuts))
+	}
+	if f.maxFlight < 2 || f.maxFlight > 3 {
+		t.Errorf("up to %d parts uploaded at once, want 2 or 3", f.maxFlight)
+	}
+	if f.uploadCount() != 0 || len(savedUploads(t, s)) != 0 {
+		t.Error("completed upload left behind")
+	}
+}
+
+func TestS3MultipartResume(t *testing.T) {
+	f := newFakeS3(t, "bucket")
+	s := newMultipartStore(t, f)
+	s.SetConcurrency(1)
+	data := randomBytes(2, 8<<10)
+	id := c4.Identify(bytes.NewReader(data))
+
+	// The first attempt fails at part 5.
+	f.setFailPart(func(num int) bool { return num == 5 })
+	if _, err := s.Put(bytes.NewReader(data)); err == nil {
+		t.Fatal("Put succeeded with a part rejected")
+	}
+	if f.uploadCount() != 1 || len(savedUploads(t, s)) != 1 {
+		t.Fatal("interrupted upload not kept for resuming")
+	}
+
+	// A new store, as in a new process, resumes from part 5.
+	f.setFailPart(nil)
+	f.partPuts = nil
+	s2 := f.store("c4/")
+	s2.SetPartSize(1 << 10)
+	s2.SetUploadDir(s.uploadDir)
+	w, err := s2.Create(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write(data)
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	sort.Ints(f.partPuts)
+	if len(f.partPuts) != 4 || f.partPuts[0] != 5 {
+		t.Errorf("resumed upload sent parts %v, want 5 to 8", f.partPuts)
+	}
+	if got, _ := f.get("c4/" + id.String()); !bytes.Equal(got, data) {
+		t.Error("resumed upload stored the wrong content")
+	}
+	if f.uploadCount() != 0 || len(savedUploads(t, s)) != 0 {
+		t.Error("completed upload left behind")
+	}
+}
+
+// A saved part whose checksum differs from the content's is sent again.
+func TestS3MultipartResumeRechecksParts(t *testing.T) {
+	f := newFakeS3(t, "bucket")
+	s := newMultipartStore(t, f)
+	s.SetConcurrency(1)
+	data := randomBytes(4, 8<<10)
+
+	f.setFailPart(func(num int) bool { return num == 5 })
+	if _, err := s.Put(bytes.NewReader(data)); err == nil {
+		t.Fatal("Put succeeded with a part rejected")
+	}
+	saved := savedUploads(t, s)
+	if len(saved) != 1 {
+		t.Fatal("interrupted upload not kept for resuming")
+	}
+	b, err := os.ReadFile(saved[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(string(b), "\n")
+	for i, line := range lines {
+		if fields := strings.Fields(line); len(fields) == 4 && fields[1] == "2" {
+			fields[3] = base64.StdEncoding.EncodeToString(make([]byte, 32))
+			lines[i] = strings.Join(fields, " ")
+		}
+	}
+	if err := os.WriteFile(saved[0], []byte(strings.Join(lines, "\n")), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	f.setFailPart(nil)
+	f.partPuts = nil
+	id, err := s.Put(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Ints(f.partPuts)
+	if fmt.Sprint(f.partPuts) != "[2 5 6 7 8]" {
+		t.Errorf("resumed upload sent parts %v, want 2 and 5 to 8", f.partPuts)
+	}
+	if got, _ := f.get("c4/" + id.String()); !bytes.Equal(got, data) {
+		t.Error("resumed upload stored the wrong content")
+	}
+}
+
+func TestS3MultipartRestart(t *testing.T) {
+	f := newFakeS3(t, "bucket")
+	s := newMultipartStore(t, f)
+	data := randomBytes(3, 4<<10)
+
+	f.setFailPart(func(num int) bool { return num == 3 })
+	s.Put(bytes.NewReader(data))
+	f.setFailPart(nil)
+
+	// The saved upload has gone from S3, so the next attempt starts over.
+	f.mu.Lock()
+	f.uploads = make(map[string]*fakeUpload)
+	f.mu.Unlock()
+	id, err := s.Put(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := f.get("c4/" + id.String()); !bytes.Equal(got, data) {
+		t.Error("restarted upload stored the wrong content")
+	}
+
+	// So does one saved with a different part size.
+	f.setFailPart(func(num int) bool { return num == 3 })
+	more := randomBytes(4, 4<<10)
+	s.Put(bytes.NewReader(more))
+	f.setFailPart(nil)
+	s.SetPartSize(2 << 10)
+	if _, err := s.Put(bytes.NewReader(more)); err != nil {
+		t.Fatal(err)
+	}
+	if f.uploadCount() != 0 {
+		t.Errorf("%d uploads left incomplete", f.uploadCount())
+	}
+}
+
+func TestS3AbortUploads(t *testing.T) {
+	f := newFakeS3(t, "bucket")
+	s := newMultipartStore(t, f)
+	f.setFailPart(func(num int) bool { return num == 2 })
+	s.Put(bytes.NewReader(randomBytes(5, 3<<10)))
+	s.Put(bytes.NewReader(randomBytes(6, 3<<10)))
+	other := f.store("other/")
+	other.SetPartSize(1 << 10)
+	other.SetUploadDir(t.TempDir())
+	other.Put(bytes.NewReader(randomBytes(7, 3<<10)))
+
+	uploads, err := s.Uploads()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(uploads) != 2 {
+		t.Fatalf("Uploads found %d, want the 2 under the prefix", len(uploads))
+	}
+	f.mu.Lock()
+	f.uploads[uploads[0].UploadID].initiated = time.Now().Add(-48 * time.Hour)
+	f.mu.Unlock()
+
+	aborted, err := s.AbortUploads(time.Now().Add(-24 * time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(aborted) != 1 || aborted[0].UploadID != uploads[0].UploadID {
+		t.Errorf("aborted %v, want only the stale upload", aborted)
+	}
+	if f.uploadCount() != 2 {
+		t.Errorf("%d uploads left, want the recent one and the other prefix's", f.uploadCount())
+	}
+	if n := len(savedUploads(t, s)); n != 1 {
+		t.Errorf("%d saved uploads left, want 1", n)
+	}
+}
+
+func TestS3UploadDirUnwritable(t *testing.T) {
+	f := newFakeS3(t, "bucket")
+	s := newMultipartStore(t, f)
+	file := filepath.Join(t.TempDir(), "file")
+	os.WriteFile(file, nil, 0644)
+	s.SetUploadDir(filepath.Join(file, "uploads"))
+
+	data := randomBytes(8, 3<<10)
+	id, err := s.Put(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := f.get("c4/" + id.String()); !bytes.Equal(got, data) {
+		t.Error("upload without saved progress stored the wrong content")
+	}
+}
+
+func TestOpenS3ConfiguredUploads(t *testing.T) {
+	for _, k := range []string{"AWS_ACCESS_KEY_ID", "AWS_SECRET_ACCESS_KEY"} {
+		old, ok := os.LookupEnv(k)
+		os.Setenv(k, "x")
+		if ok {
+			defer os.Setenv(k, old)
+		} else {
+			defer os.Unsetenv(k)
+		}
+	}
+	s, err := openS3Configured("s3://bucket/c4?partsize=16M&concurrency=8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.partSize != 16<<20 || s.concurrency != 8 {
+		t.Errorf("part size %d, concurrency %d; want 16M, 8", s.partSize, s.concurrency)
+	}
+	for _, q := range []string{"partsize=1M", "partsize=big", "concurrency=0"} {
+		if _, err := openS3Configured("s3://bucket/c4?" + q); err == nil {
+			t.Errorf("%s accepted", q)
+		}
+	}
+}
+
+func TestPlainS3Stores(t *testing.T) {
+	f := newFakeS3(t, "bucket")
+	s3 := f.store("c4/")
+	key, err := GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	enc, err := NewEncrypting(NewMultiStore(NewRAM(), s3), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	erasure, err := NewErasure(1, NewRAM(), f.store("shards/"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := PlainS3Stores(NewMultiStore(NewRAM(), NewValidating(s3)))
+	if err != nil || len(got) != 1 || got[0] != s3 {
+		t.Errorf("plain S3 member: %v, %v", got, err)
+	}
+	for name, s := range map[string]Store{
+		"compressed member":         NewMultiStore(s3, NewCompressing(f.store("z/"))),
+		"encrypted multi":           enc,
+		"chunked S3":                NewChunking(s3),
+		"erasure backend":           erasure,
+		"compressed in a validator": NewValidating(NewCompressing(s3)),
+	} {
+		if _, err := PlainS3Stores(s); err == nil {
+			t.Errorf("%s: accepted", name)
+		}
+	}
+}
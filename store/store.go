@@ -36,3 +36,37 @@ type Store interface {
 
 // ErrNotImplemented is the error to return for unimplemented interface methods.
 var ErrNotImplemented = fmt.Errorf("not implemented")
+
+// walkStores calls fn for s and every store it is built from: the stores
+// wrapped by Chunking, Encrypting, Compressing, Validating and Logger, both
+// stores of a Cache, the members of a MultiStore and the backends of an
+// Erasure store.
+func walkStores(s Store, fn func(Store)) {
+	fn(s)
+	for _, sub := range substores(s) {
+		walkStores(sub, fn)
+	}
+}
+
+// substores returns the stores s is directly built from.
+func substores(s Store) []Store {
+	switch w := s.(type) {
+	case *MultiStore:
+		return w.stores
+	case *Cache:
+		return []Store{w.fast, w.slow}
+	case *Erasure:
+		return w.stores
+	case *Chunking:
+		return []Store{w.s}
+	case *Encrypting:
+		return []Store{w.s}
+	case *Compressing:
+		return []Store{w.s}
+	case *Validating:
+		return []Store{w.s}
+	case *Logger:
+		return []Store{w.s}
+	}
+	return nil
+}
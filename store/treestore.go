@@ -22,12 +22,24 @@ const DefaultSplitThreshold = 4096
 // Directories are either leaves (contain content files) or interior nodes
 // (contain 2-char subdirectories). When a leaf exceeds SplitThreshold
 // files, it splits into subdirectories based on the next 2 characters.
+//
+// Several processes may share a TreeStore. A split holds an exclusive lock
+// on the store's lock file, and writes hold a shared lock while they place
+// a file, so no object is filed into a directory that is being split.
+// Lookups take no lock unless an object is not where it should be.
 type TreeStore struct {
 	root           string
 	splitThreshold int
-	mu             sync.Mutex
+	ingest         IngestMode
+
+	// mu orders splits and writes within this process; the lock file
+	// does the same across processes.
+	mu sync.RWMutex
 }
 
+// treeLockName is the lock file in the root of a TreeStore.
+const treeLockName = ".c4lock"
+
 var _ Store = (*TreeStore)(nil)
 
 // NewTreeStore creates a TreeStore rooted at the given directory.
@@ -52,31 +64,81 @@ func (s *TreeStore) SetSplitThreshold(n int) {
 
 // Has reports whether the store contains content for the given ID.
 func (s *TreeStore) Has(id c4.ID) bool {
-	_, err := os.Stat(s.path(id))
-	return err == nil
+	_, ok := s.locate(id)
+	return ok
 }
 
-// Open opens the content for reading.
+// Open opens the content for reading. Content hardlinked from a file
+// outside the store is checked against its ID as it is read, and fails
+// with ErrLinkModified if the file has been changed.
 func (s *TreeStore) Open(id c4.ID) (io.ReadCloser, error) {
-	return os.Open(s.path(id))
+	f, err := s.openFile(id)
+	if err != nil {
+		return nil, err
+	}
+	if info, err := f.Stat(); err == nil && linkCount(info) > 1 {
+		return newLinkedReader(f, id), nil
+	}
+	return f, nil
+}
+
+// openFile opens the file holding id.
+func (s *TreeStore) openFile(id c4.ID) (*os.File, error) {
+	p, ok := s.locate(id)
+	if !ok {
+		return nil, &os.PathError{Op: "open", Path: s.path(id), Err: os.ErrNotExist}
+	}
+	return os.Open(p)
 }
 
 // Create creates a new entry for writing. The caller must know the ID
-// in advance. Writes go to a temp file; Close syncs and renames atomically.
+// in advance. Writes go to a temp file; Close syncs and files it under
+// the leaf for the ID at that moment.
 func (s *TreeStore) Create(id c4.ID) (io.WriteCloser, error) {
-	p := s.path(id)
-	if _, err := os.Stat(p); err == nil {
+	if p, ok := s.locate(id); ok {
 		return nil, &os.PathError{Op: "create", Path: p, Err: os.ErrExist}
 	}
-	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+	tmp, err := os.CreateTemp(s.root, ".ingest.*")
+	if err != nil {
 		return nil, err
 	}
-	return NewDurableWriter(p)
+	return &treeWriter{s: s, id: id, tmp: tmp}, nil
+}
+
+// treeWriter is the writer returned by TreeStore.Create.
+type treeWriter struct {
+	s   *TreeStore
+	id  c4.ID
+	tmp *os.File
+}
+
+func (w *treeWriter) Write(b []byte) (int, error) {
+	return w.tmp.Write(b)
+}
+
+func (w *treeWriter) Close() error {
+	name := w.tmp.Name()
+	defer os.Remove(name) // clean up on any error path
+	if err := w.tmp.Sync(); err != nil {
+		w.tmp.Close()
+		return err
+	}
+	if err := w.tmp.Close(); err != nil {
+		return err
+	}
+	return w.s.place(name, w.id)
 }
 
 // Put reads all content from r, computes its C4 ID, stores it, and returns
-// the ID. If the content already exists the write is skipped.
+// the ID. If the content already exists the write is skipped. An *os.File
+// is linked rather than copied if SetIngest asks for that.
 func (s *TreeStore) Put(r io.Reader) (c4.ID, error) {
+	if f, ok := r.(*os.File); ok && s.ingest != IngestCopy {
+		if id, linked, err := s.putFile(f); linked {
+			return id, err
+		}
+	}
+
 	// Write to a temp file while computing the C4 ID.
 	tmp, err := os.CreateTemp(s.root, ".ingest.*")
 	if err != nil {
@@ -102,28 +164,102 @@ func (s *TreeStore) Put(r io.Reader) (c4.ID, error) {
 	var id c4.ID
 	copy(id[:], h.Sum(nil))
 
-	// If content already exists, skip the rename.
-	p := s.path(id)
-	if _, err := os.Stat(p); err == nil {
-		return id, nil
+	if err := s.place(tmpName, id); err != nil {
+		return c4.ID{}, err
 	}
+	return id, nil
+}
 
+// place renames a finished temp file into the leaf for id, unless the
+// content is already stored, then splits the leaf if it has grown too big.
+func (s *TreeStore) place(tmpName string, id c4.ID) error {
+	unlock := s.lock(false)
+	p := s.path(id)
+	if _, ok := s.lookup(id); ok {
+		unlock()
+		return nil
+	}
 	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
-		return c4.ID{}, fmt.Errorf("mkdir: %w", err)
+		unlock()
+		return fmt.Errorf("mkdir: %w", err)
 	}
 	if err := os.Rename(tmpName, p); err != nil {
-		return c4.ID{}, fmt.Errorf("rename: %w", err)
+		unlock()
+		return fmt.Errorf("rename: %w", err)
 	}
+	unlock()
 
 	// Check if the leaf directory needs splitting.
 	s.maybeSplit(filepath.Dir(p), id.String())
-
-	return id, nil
+	return nil
 }
 
 // Remove deletes the content for the given ID.
 func (s *TreeStore) Remove(id c4.ID) error {
-	return os.Remove(s.path(id))
+	p, ok := s.locate(id)
+	if !ok {
+		return &os.PathError{Op: "remove", Path: s.path(id), Err: os.ErrNotExist}
+	}
+	return os.Remove(p)
+}
+
+// lock takes the store lock, shared or exclusive, in this process and in
+// the lock file, and returns the function that releases it. If the lock
+// file cannot be used (a read-only store, say) only the in-process lock
+// is held.
+func (s *TreeStore) lock(exclusive bool) (unlock func()) {
+	if exclusive {
+		s.mu.Lock()
+	} else {
+		s.mu.RLock()
+	}
+	f, err := lockFile(filepath.Join(s.root, treeLockName), exclusive)
+	return func() {
+		if err == nil {
+			f.Close()
+		}
+		if exclusive {
+			s.mu.Unlock()
+		} else {
+			s.mu.RUnlock()
+		}
+	}
+}
+
+// locate finds the file holding id. If it is not in its leaf or any
+// ancestor of it, a split may be moving it; the lookup is repeated once
+// any split in progress has finished.
+func (s *TreeStore) locate(id c4.ID) (string, bool) {
+	if p, ok := s.lookup(id); ok {
+		return p, true
+	}
+	unlock := s.lock(false)
+	defer unlock()
+	return s.lookup(id)
+}
+
+// lookup looks for id in its leaf and then in each directory above it,
+// where it is found if a split has not yet moved it down.
+func (s *TreeStore) lookup(id c4.ID) (string, bool) {
+	str := id.String()
+	dirs := []string{s.root}
+	dir := s.root
+	for i := 0; i+2 <= len(str); i += 2 {
+		sub := filepath.Join(dir, str[i:i+2])
+		info, err := os.Stat(sub)
+		if err != nil || !info.IsDir() {
+			break
+		}
+		dir = sub
+		dirs = append(dirs, dir)
+	}
+	for i := len(dirs) - 1; i >= 0; i-- {
+		p := filepath.Join(dirs[i], str)
+		if _, err := os.Stat(p); err == nil {
+			return p, true
+		}
+	}
+	return "", false
 }
 
 // path resolves the storage path for an ID by walking the trie.
@@ -146,23 +282,15 @@ func (s *TreeStore) path(id c4.ID) string {
 // exceeds the split threshold, and if so redistributes files into 2-char
 // subdirectories based on the next prefix segment.
 func (s *TreeStore) maybeSplit(dir, idStr string) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-
-	entries, err := os.ReadDir(dir)
-	if err != nil {
+	// Count without the lock first; most writes do not split.
+	if !s.overThreshold(dir) {
 		return
 	}
+	unlock := s.lock(true)
+	defer unlock()
 
-	// Count only regular files (not subdirectories or temps).
-	var fileCount int
-	for _, e := range entries {
-		if !e.IsDir() && !isTemp(e.Name()) {
-			fileCount++
-		}
-	}
-
-	if fileCount <= s.splitThreshold {
+	entries, err := os.ReadDir(dir)
+	if err != nil || countFiles(entries) <= s.splitThreshold {
 		return
 	}
 
@@ -185,6 +313,24 @@ func (s *TreeStore) maybeSplit(dir, idStr string) {
 	}
 }
 
+// overThreshold reports whether dir holds more content files than the
+// split threshold.
+func (s *TreeStore) overThreshold(dir string) bool {
+	entries, err := os.ReadDir(dir)
+	return err == nil && countFiles(entries) > s.splitThreshold
+}
+
+// countFiles counts content files, ignoring subdirectories and temps.
+func countFiles(entries []os.DirEntry) int {
+	n := 0
+	for _, e := range entries {
+		if !e.IsDir() && !isTemp(e.Name()) {
+			n++
+		}
+	}
+	return n
+}
+
 // prefixDepth returns how many characters of the ID are consumed by the
 // directory path from root to dir. Each trie level consumes 2 characters.
 func (s *TreeStore) prefixDepth(dir string) int {
@@ -222,3 +368,76 @@ func splitPath(p string) []string {
 func isTemp(name string) bool {
 	return len(name) > 0 && name[0] == '.'
 }
+
+// Misplaced is a TreeStore object outside the leaf directory its ID maps
+// to.
+type Misplaced struct {
+	ID   c4.ID
+	Path string // where the object is
+	Want string // where it belongs
+}
+
+// CheckLayout finds objects that are not in the leaf directory their ID
+// maps to, as left by a split that was interrupted or raced by a process
+// that did not lock the store. If relocate is true they are moved where
+// they belong; a stray copy of an object already in its leaf is removed.
+func (s *TreeStore) CheckLayout(relocate bool) ([]Misplaced, error) {
+	unlock := s.lock(relocate)
+	var found []Misplaced
+	err := s.walkLayout(s.root, func(id c4.ID, p string) {
+		if want := s.path(id); p != want {
+			found = append(found, Misplaced{ID: id, Path: p, Want: want})
+		}
+	})
+	if err != nil || !relocate {
+		unlock()
+		return found, err
+	}
+
+	leaves := make(map[string]bool)
+	for _, m := range found {
+		if _, err := os.Stat(m.Want); err == nil {
+			err = os.Remove(m.Path)
+		} else if err = os.MkdirAll(filepath.Dir(m.Want), 0755); err == nil {
+			err = os.Rename(m.Path, m.Want)
+			leaves[filepath.Dir(m.Want)] = true
+		}
+		if err != nil {
+			unlock()
+			return found, fmt.Errorf("relocate %s: %w", m.ID, err)
+		}
+	}
+	unlock()
+
+	for dir := range leaves {
+		s.maybeSplit(dir, "")
+	}
+	return found, nil
+}
+
+// walkLayout calls fn with the ID and path of every content file below
+// dir.
+func (s *TreeStore) walkLayout(dir string, fn func(id c4.ID, path string)) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() {
+			if len(name) == 2 {
+				if err := s.walkLayout(filepath.Join(dir, name), fn); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		if isTemp(name) {
+			continue
+		}
+		if id, err := c4.Parse(name); err == nil {
+			fn(id, filepath.Join(dir, name))
+		}
+	}
+	return nil
+}
@@ -6,7 +6,9 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"runtime"
 	"strings"
+	"sync"
 	"testing"
 
 	"github.com/Avalanche-io/c4"
@@ -334,3 +336,130 @@ func TestTreeStoreEmptyContent(t *testing.T) {
 		t.Fatalf("expected empty content, got %d bytes", len(got))
 	}
 }
+
+func TestTreeStoreStrandedObject(t *testing.T) {
+	dir := t.TempDir()
+	s, err := NewTreeStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.SetSplitThreshold(3)
+	for i := 0; i < 10; i++ {
+		if _, err := s.Put(strings.NewReader(fmt.Sprintf("content-%d", i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Strand an object one level above its leaf, as a split racing an
+	// unlocked writer would.
+	id, err := s.Put(strings.NewReader("stranded"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := s.path(id)
+	stranded := filepath.Join(filepath.Dir(filepath.Dir(want)), id.String())
+	if err := os.Rename(want, stranded); err != nil {
+		t.Fatal(err)
+	}
+
+	if !s.Has(id) {
+		t.Fatal("Has should find an object above its leaf")
+	}
+	rc, err := s.Open(id)
+	if err != nil {
+		t.Fatalf("Open stranded object: %v", err)
+	}
+	rc.Close()
+	if w, err := s.Create(id); err == nil {
+		w.Close()
+		t.Fatal("Create should see the stranded object")
+	}
+
+	misplaced, err := s.CheckLayout(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(misplaced) != 1 || misplaced[0].Path != stranded || misplaced[0].Want != want {
+		t.Fatalf("CheckLayout = %+v", misplaced)
+	}
+	if _, err := s.CheckLayout(true); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(want); err != nil {
+		t.Fatal("object not relocated to its leaf")
+	}
+	if misplaced, _ := s.CheckLayout(false); len(misplaced) != 0 {
+		t.Fatalf("still misplaced after relocation: %+v", misplaced)
+	}
+}
+
+func TestTreeStoreConcurrentInstances(t *testing.T) {
+	if runtime.GOOS == "windows" || runtime.GOOS == "plan9" {
+		t.Skip("no file locking on this platform")
+	}
+	dir := t.TempDir()
+
+	// Separate instances share nothing in memory, like separate processes.
+	const writers, each = 4, 150
+	var wg sync.WaitGroup
+	errs := make(chan error, writers)
+	for w := 0; w < writers; w++ {
+		s, err := NewTreeStore(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s.SetSplitThreshold(8)
+		wg.Add(1)
+		go func(w int, s *TreeStore) {
+			defer wg.Done()
+			for i := 0; i < each; i++ {
+				data := fmt.Sprintf("writer %d item %d", w, i)
+				if i%2 == 0 {
+					if _, err := s.Put(strings.NewReader(data)); err != nil {
+						errs <- err
+						return
+					}
+					continue
+				}
+				id := c4.Identify(strings.NewReader(data))
+				wc, err := s.Create(id)
+				if err == nil {
+					_, err = io.WriteString(wc, data)
+				}
+				if err == nil {
+					err = wc.Close()
+				}
+				if err != nil {
+					errs <- err
+					return
+				}
+				if !s.Has(id) {
+					errs <- fmt.Errorf("%s missing right after Create", id)
+					return
+				}
+			}
+		}(w, s)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+
+	s, _ := NewTreeStore(dir)
+	for w := 0; w < writers; w++ {
+		for i := 0; i < each; i++ {
+			id := c4.Identify(strings.NewReader(fmt.Sprintf("writer %d item %d", w, i)))
+			if !s.Has(id) {
+				t.Fatalf("writer %d item %d lost", w, i)
+			}
+		}
+	}
+	misplaced, err := s.CheckLayout(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(misplaced) != 0 {
+		t.Fatalf("%d objects misplaced after concurrent writes", len(misplaced))
+	}
+}
@@ -2,10 +2,12 @@ package store
 
 import (
 	"bytes"
+	"context"
 	"crypto/sha512"
 	"fmt"
 	"hash"
 	"io"
+	"os"
 
 	"github.com/Avalanche-io/c4"
 )
@@ -16,7 +18,8 @@ var _ Store = &Validating{}
 // the data that is read or written. If the data does not match the id, then
 // ErrInvalidC4ID will be returned.
 // C4 id validity is checked when `Close()` is called on the reader, or writer,
-// or when an io.EOF is encountered while reading or writing.
+// or when an io.EOF is encountered while reading. Written content is
+// spooled and only stored once it is known to match its id.
 type Validating struct {
 	s Store
 }
@@ -62,26 +65,20 @@ func (v *validatingReader) Close() error {
 	return err
 }
 
+// validatingWriter spools what is written to a temp file while hashing
+// it. Close stores the spool in the wrapped store only if it matches the
+// ID, so content that does not is never visible under it.
 type validatingWriter struct {
-	h      hash.Hash
-	id     c4.ID
-	w      io.WriteCloser
-	remove func(id c4.ID) error
+	ctx context.Context
+	s   Store
+	id  c4.ID
+	h   hash.Hash
+	tmp *os.File
 }
 
 func (v *validatingWriter) Write(b []byte) (int, error) {
-	n, err := v.w.Write(b)
+	n, err := v.tmp.Write(b)
 	v.h.Write(b[:n])
-	if err == nil {
-		return n, nil
-	}
-	if err == io.EOF {
-		if !v.isValid() {
-			v.w.Close()
-			v.remove(v.id)
-			return n, ErrInvalidID
-		}
-	}
 	return n, err
 }
 
@@ -92,19 +89,46 @@ func (v *validatingWriter) isValid() bool {
 	return false
 }
 
+// Close stores what was written under the ID, or fails with ErrInvalidID
+// without touching the wrapped store. Content already stored under the ID
+// is kept.
 func (v *validatingWriter) Close() error {
-	err := v.w.Close()
+	defer v.Abort()
 	if !v.isValid() {
-		v.remove(v.id)
 		return ErrInvalidID
 	}
-	return err
+	if _, err := v.tmp.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	// Put checks the content again as it stores it, and never replaces
+	// what is there. The spool is hidden behind a plain reader so that
+	// stores that link files do not take the temp file itself.
+	got, err := WithContext(v.s).PutContext(v.ctx, struct{ io.Reader }{v.tmp})
+	if err != nil {
+		return err
+	}
+	if got != v.id {
+		return ErrInvalidID
+	}
+	return nil
+}
+
+// Abort discards what was written, leaving the wrapped store untouched.
+func (v *validatingWriter) Abort() error {
+	v.tmp.Close()
+	return os.Remove(v.tmp.Name())
 }
 
 // Open opens a file named the given c4.ID in read-only mode from the folder. If
 // the file does not exist an error is returned.
 func (v *Validating) Open(id c4.ID) (io.ReadCloser, error) {
-	r, err := v.s.Open(id)
+	return v.OpenContext(context.Background(), id)
+}
+
+// OpenContext opens id in the wrapped store and validates its content as it
+// is read.
+func (v *Validating) OpenContext(ctx context.Context, id c4.ID) (io.ReadCloser, error) {
+	r, err := WithContext(v.s).OpenContext(ctx, id)
 	if err != nil {
 		return nil, err
 	}
@@ -115,11 +139,25 @@ func (v *Validating) Open(id c4.ID) (io.ReadCloser, error) {
 // name if the file does not already exist. If it cannot open the file or the
 // file already exists is returns an error.
 func (v *Validating) Create(id c4.ID) (io.WriteCloser, error) {
-	w, err := v.s.Create(id)
+	return v.CreateContext(context.Background(), id)
+}
+
+// CreateContext returns a writer that stores its content in the wrapped
+// store under id when closed, if the content matches id. A writer given up
+// on should be discarded with its Abort method rather than closed.
+func (v *Validating) CreateContext(ctx context.Context, id c4.ID) (io.WriteCloser, error) {
+	return v.create(ctx, id)
+}
+
+func (v *Validating) create(ctx context.Context, id c4.ID) (*validatingWriter, error) {
+	if ok, _ := WithContext(v.s).HasContext(ctx, id); ok {
+		return nil, &os.PathError{Op: "create", Path: id.String(), Err: os.ErrExist}
+	}
+	tmp, err := os.CreateTemp("", "c4valid.*")
 	if err != nil {
 		return nil, err
 	}
-	return &validatingWriter{sha512.New(), id, w, v.s.Remove}, nil
+	return &validatingWriter{ctx, v.s, id, sha512.New(), tmp}, nil
 }
 
 func (v *Validating) Has(id c4.ID) bool { return v.s.Has(id) }
@@ -129,3 +167,31 @@ func (v *Validating) Put(r io.Reader) (c4.ID, error) { return v.s.Put(r) }
 func (v *Validating) Remove(id c4.ID) error {
 	return v.s.Remove(id)
 }
+
+func (v *Validating) HasContext(ctx context.Context, id c4.ID) (bool, error) {
+	return WithContext(v.s).HasContext(ctx, id)
+}
+
+func (v *Validating) PutContext(ctx context.Context, r io.Reader) (c4.ID, error) {
+	return WithContext(v.s).PutContext(ctx, r)
+}
+
+func (v *Validating) RemoveContext(ctx context.Context, id c4.ID) error {
+	return WithContext(v.s).RemoveContext(ctx, id)
+}
+
+func (v *Validating) HasMany(ctx context.Context, ids []c4.ID) ([]bool, error) {
+	return WithContext(v.s).HasMany(ctx, ids)
+}
+
+// OpenMany calls OpenMany on the wrapped store and validates each object's
+// content as fn reads it. Reading an object to the end returns
+// ErrInvalidID if it does not match its ID.
+func (v *Validating) OpenMany(ctx context.Context, ids []c4.ID, fn func(id c4.ID, r io.Reader, err error) error) error {
+	return WithContext(v.s).OpenMany(ctx, ids, func(id c4.ID, r io.Reader, err error) error {
+		if err != nil {
+			return fn(id, nil, err)
+		}
+		return fn(id, &validatingReader{sha512.New(), id, io.NopCloser(r)}, nil)
+	})
+}
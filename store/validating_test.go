@@ -1,8 +1,10 @@
 package store
 
 import (
+	"context"
 	"fmt"
 	"math/rand"
+	"os"
 	"strings"
 	"testing"
 	"time"
@@ -123,3 +125,62 @@ func TestValidatingStore(t *testing.T) {
 	}
 
 }
+
+// noRemove fails the test if anything is removed from it.
+type noRemove struct {
+	Store
+	t *testing.T
+}
+
+func (s noRemove) Remove(id c4.ID) error {
+	s.t.Errorf("Remove(%s) called", id)
+	return s.Store.Remove(id)
+}
+
+// Content that does not match its ID, or is given up on, never reaches
+// the wrapped store, and nothing is removed from it.
+func TestValidatingCreateChecksFirst(t *testing.T) {
+	ram := NewRAM()
+	v := NewValidating(noRemove{ram, t})
+	id := c4.Identify(strings.NewReader("good"))
+
+	w, err := v.Create(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte("bad"))
+	if ram.Has(id) {
+		t.Fatal("content stored before Close")
+	}
+	if err := w.Close(); err != ErrInvalidID {
+		t.Fatalf("Close = %v, want ErrInvalidID", err)
+	}
+	if ram.Has(id) {
+		t.Fatal("mismatched content stored")
+	}
+
+	vw, err := v.create(context.Background(), id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vw.Write([]byte("go"))
+	vw.Abort()
+	if ram.Has(id) {
+		t.Fatal("aborted content stored")
+	}
+
+	w, err = v.Create(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte("good"))
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if !ram.Has(id) {
+		t.Fatal("valid content not stored")
+	}
+	if _, err := v.Create(id); !os.IsExist(err) {
+		t.Fatalf("Create of a stored ID = %v, want an exists error", err)
+	}
+}